@@ -0,0 +1,71 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// Full block, the header followed by all the transactions in the block
+type Block struct {
+	// 80 bytes - The block header
+	Header *BlockHeader
+
+	// Variable length - All the transactions in the block, the first
+	// being the coinbase transaction
+	Transactions []*tx.Transaction
+}
+
+// Parses a full block from a bytestream
+func ParseBlock(reader *bytes.Reader) (*Block, error) {
+	b := &Block{}
+
+	var err error
+	b.Header, err = ParseHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// number of transactions is a varint
+	numOfTxs := utils.ReadVarIntFromBytes(reader)
+
+	// a transaction is at least 60 bytes, so make sure the count is sane
+	// before trusting it
+	if numOfTxs > uint64(reader.Len()/60) {
+		return nil, fmt.Errorf("block claims %d transactions which do not fit the remaining data", numOfTxs)
+	}
+
+	for i := 0; i < int(numOfTxs); i++ {
+		t, err := tx.ReadTransaction(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse transaction %d because %s", i, err.Error())
+		}
+		b.Transactions = append(b.Transactions, t)
+	}
+
+	return b, nil
+}
+
+// Return the hash of the block, big endian
+func (b *Block) Hash() ([]byte, error) {
+	return b.Header.Hash()
+}
+
+// Returns the transaction hashes of the block in block order, big endian
+func (b *Block) TxHashes() [][]byte {
+	hashes := make([][]byte, 0, len(b.Transactions))
+	for _, t := range b.Transactions {
+		hashes = append(hashes, t.Hash())
+	}
+	return hashes
+}
+
+// Verifies the transactions hash to the merkle root in the header
+func (b *Block) VerifyMerkleRoot() bool {
+	if len(b.Transactions) == 0 {
+		return false
+	}
+	return b.Header.VerifyMerkleRoot(b.TxHashes())
+}
@@ -0,0 +1,53 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// testnet genesis block, the header followed by the single coinbase transaction
+const testnetGenesisBlockFull = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff001d1aa4ae180101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func parseTestBlock(t *testing.T, raw string) *Block {
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		t.Fatalf("failed to decode the block because %s", err.Error())
+	}
+
+	block, err := ParseBlock(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to parse the block because %s", err.Error())
+	}
+
+	return block
+}
+
+func TestParseBlock(t *testing.T) {
+	b := parseTestBlock(t, testnetGenesisBlockFull)
+
+	hash, _ := b.Hash()
+	if hex.EncodeToString(hash) != "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943" {
+		t.Fatalf("unexpected block hash %x", hash)
+	}
+
+	if len(b.Transactions) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(b.Transactions))
+	}
+
+	if !b.Transactions[0].IsCoinbase() {
+		t.Fatal("first transaction is not the coinbase")
+	}
+
+	if !b.VerifyMerkleRoot() {
+		t.Fatal("transactions do not hash to the merkle root")
+	}
+}
+
+func TestParseBlockTruncated(t *testing.T) {
+	b, _ := hex.DecodeString(testnetGenesisBlockFull)
+
+	if _, err := ParseBlock(bytes.NewReader(b[:len(b)-10])); err == nil {
+		t.Fatal("parsed a truncated block")
+	}
+}
@@ -1,13 +1,20 @@
 package block
 
 import (
-	"math/big"
-
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
 	"github.com/spaolacci/murmur3"
 )
 
 const BIP37_CONSTANT = 0xfba4c795
 
+// filterload flags which tell the remote peer how to update the
+// filter when it matches an output
+const (
+	BLOOM_UPDATE_NONE          byte = 0
+	BLOOM_UPDATE_ALL           byte = 1
+	BLOOM_UPDATE_P2PUBKEY_ONLY byte = 2
+)
+
 type BloomFilter struct {
 	Size          int
 	BitField      []byte
@@ -30,20 +37,21 @@ func MakeBloomFilter(size, functionCount, tweak int) *BloomFilter {
 func (b *BloomFilter) Add(item []byte) {
 	for i := 0; i < b.FunctionCount; i++ {
 		// BIP0037 spec seed is i*BIP37_CONSTANT + self.tweak
-		seed := uint32(i*BIP37_CONSTANT + b.Tweak)
+		// truncated to 32 bits
+		seed := uint32(uint64(i)*BIP37_CONSTANT + uint64(b.Tweak))
 
-		// get the murmur3 hash with the calculated seed
-		sum := murmur3.New32WithSeed(seed).Sum32()
+		// get the murmur3 hash of the item with the calculated seed
+		sum := murmur3.Sum32WithSeed(item, seed)
 
 		// set the bit at the hash mod the bitfield size (self.size*8)
-		// bit = h % (self.size * 8)
-		// need to use a big int because % is not defined for uint32
-		tmp := new(big.Int).SetInt64(int64(b.Size * 8))
-		tmp.Mod(big.NewInt(int64(sum)), tmp)
-		bit := uint32(tmp.Uint64())
-		// bit := int(sum) % (b.Size * 8)
+		bit := sum % uint32(b.Size*8)
 
 		// self.bit_field[bit] = 1
 		b.BitField[bit] = 1
 	}
 }
+
+// Returns the bit field packed into bytes as it is sent over the wire
+func (b *BloomFilter) FilterBytes() ([]byte, error) {
+	return utils.BitFieldToBytes(b.BitField)
+}
@@ -20,3 +20,19 @@ func TestAdd(t *testing.T) {
 
 	fmt.Printf("%x\n%x\n", target, filter.BitField)
 }
+
+func TestFilterBytes(t *testing.T) {
+	filter := MakeBloomFilter(10, 5, 99)
+
+	filter.Add([]byte("Hello World"))
+	filter.Add([]byte("Goodbye!"))
+
+	b, err := filter.FilterBytes()
+	if err != nil {
+		t.Fatalf("failed to pack the bit field because %s", err.Error())
+	}
+
+	if fmt.Sprintf("%x", b) != "4000600a080000010940" {
+		t.Fatalf("bloom filter bytes do not match, got %x", b)
+	}
+}
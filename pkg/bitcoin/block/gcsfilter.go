@@ -0,0 +1,347 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"sort"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// BIP158 filter types and the parameters of the basic filter
+const (
+	BASIC_FILTER_TYPE byte   = 0x00
+	BASIC_FILTER_P    uint8  = 19
+	BASIC_FILTER_M    uint64 = 784931
+)
+
+// first byte of a provably unspendable output which is left out of the basic filter
+const opReturn byte = 0x6a
+
+// Golomb-coded set as defined in BIP158. Items are hashed into the range
+// [0, N*M) with SipHash, sorted and the differences between them are golomb
+// rice coded with parameter P
+type GCSFilter struct {
+	// Number of items in the filter
+	N uint32
+
+	// Golomb-Rice coding parameter
+	P uint8
+
+	// Inverse of the false positive rate
+	M uint64
+
+	// 16 bytes - SipHash key. For block filters, the first 16 bytes of the
+	// block hash in little endian
+	Key []byte
+
+	// Golomb-Rice coded set
+	Data []byte
+}
+
+// writes bits most significant first into a byte array
+type bitWriter struct {
+	data  []byte
+	count uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.count%8 == 0 {
+		w.data = append(w.data, 0x00)
+	}
+	if bit {
+		w.data[len(w.data)-1] |= 0x80 >> (w.count % 8)
+	}
+	w.count++
+}
+
+func (w *bitWriter) writeBits(value uint64, n uint8) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.writeBit((value>>uint(i))&1 == 1)
+	}
+}
+
+// reads bits most significant first from a byte array
+type bitReader struct {
+	data  []byte
+	count uint
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if int(r.count/8) >= len(r.data) {
+		return false, io.EOF
+	}
+	bit := r.data[r.count/8]&(0x80>>(r.count%8)) != 0
+	r.count++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n uint8) (uint64, error) {
+	var value uint64
+	for i := uint8(0); i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
+
+// golomb rice encodes the value, quotient in unary then the remainder in P bits
+func golombEncode(w *bitWriter, x uint64, p uint8) {
+	for q := x >> p; q > 0; q-- {
+		w.writeBit(true)
+	}
+	w.writeBit(false)
+	w.writeBits(x, p)
+}
+
+func golombDecode(r *bitReader, p uint8) (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		q++
+	}
+
+	remainder, err := r.readBits(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return q<<p + remainder, nil
+}
+
+// splits the 16 byte key into the two little endian SipHash key halves
+func sipHashKey(key []byte) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(key[:8]), binary.LittleEndian.Uint64(key[8:16])
+}
+
+// hashes the item uniformly into the range [0, f)
+func hashToRange(item []byte, f, k0, k1 uint64) uint64 {
+	hi, _ := bits.Mul64(utils.SipHash24(k0, k1, item), f)
+	return hi
+}
+
+// hashes all the items into the range [0, f) and sorts them
+func hashedSetConstruct(items [][]byte, f, k0, k1 uint64) []uint64 {
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		values = append(values, hashToRange(item, f, k0, k1))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+// Makes a golomb coded set filter containing the items. Duplicate items are
+// only added once
+func MakeGCSFilter(p uint8, m uint64, key []byte, items [][]byte) (*GCSFilter, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("siphash key must be 16 bytes, got %d", len(key))
+	}
+	if p > 32 {
+		return nil, fmt.Errorf("golomb rice parameter %d is too large", p)
+	}
+
+	// the filter is a set, so remove duplicate items
+	seen := make(map[string]bool)
+	unique := make([][]byte, 0, len(items))
+	for _, item := range items {
+		if seen[string(item)] {
+			continue
+		}
+		seen[string(item)] = true
+		unique = append(unique, item)
+	}
+
+	f := &GCSFilter{
+		N:   uint32(len(unique)),
+		P:   p,
+		M:   m,
+		Key: key,
+	}
+
+	k0, k1 := sipHashKey(key)
+	values := hashedSetConstruct(unique, uint64(f.N)*m, k0, k1)
+
+	// encode the differences between the sorted values
+	w := &bitWriter{}
+	var last uint64
+	for _, v := range values {
+		golombEncode(w, v-last, p)
+		last = v
+	}
+	f.Data = w.data
+
+	return f, nil
+}
+
+// Parses a serialized filter, the number of items as a varint followed by the
+// golomb coded set. The parameters and key are not part of the serialization.
+func ParseGCSFilter(p uint8, m uint64, key []byte, reader *bytes.Reader) (*GCSFilter, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("siphash key must be 16 bytes, got %d", len(key))
+	}
+	if reader.Len() == 0 {
+		return nil, io.EOF
+	}
+
+	n := utils.ReadVarIntFromBytes(reader)
+	if n > 0xffffffff {
+		return nil, fmt.Errorf("filter claims %d items", n)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCSFilter{
+		N:    uint32(n),
+		P:    p,
+		M:    m,
+		Key:  key,
+		Data: data,
+	}, nil
+}
+
+// Serializes the filter as the number of items followed by the coded set
+func (f *GCSFilter) Serialize() ([]byte, error) {
+	result, err := utils.EncodeUVarInt(uint64(f.N))
+	if err != nil {
+		return nil, err
+	}
+	return append(result, f.Data...), nil
+}
+
+// Returns the hash of the serialized filter, big endian
+func (f *GCSFilter) Hash() ([]byte, error) {
+	s, err := f.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	return utils.MutableReorderBytes(utils.Hash256(s)), nil
+}
+
+// Checks if the item is in the filter. False positives happen at a rate of 1/M
+func (f *GCSFilter) Match(item []byte) (bool, error) {
+	return f.MatchAny([][]byte{item})
+}
+
+// Checks if any of the items is in the filter
+func (f *GCSFilter) MatchAny(items [][]byte) (bool, error) {
+	if f.N == 0 || len(items) == 0 {
+		return false, nil
+	}
+
+	k0, k1 := sipHashKey(f.Key)
+	targets := hashedSetConstruct(items, uint64(f.N)*f.M, k0, k1)
+
+	// walk the sorted filter values and the sorted targets together
+	r := &bitReader{data: f.Data}
+	var value uint64
+	t := 0
+	for i := uint32(0); i < f.N; i++ {
+		delta, err := golombDecode(r, f.P)
+		if err != nil {
+			return false, fmt.Errorf("filter data is truncated")
+		}
+		value += delta
+
+		for t < len(targets) && targets[t] < value {
+			t++
+		}
+		if t == len(targets) {
+			return false, nil
+		}
+		if targets[t] == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Returns the items committed to by the basic filter of the block. These are
+// the output scripts of every transaction except OP_RETURN outputs, and the
+// scripts of the outputs spent by the block, which are not part of the block
+// and need to be supplied by the caller.
+func BasicFilterItems(b *Block, prevOutScripts [][]byte) ([][]byte, error) {
+	var items [][]byte
+	for _, t := range b.Transactions {
+		for i, out := range t.Outputs {
+			s, err := out.ScriptPubkey.RawSerialize()
+			if err != nil {
+				return nil, fmt.Errorf("failed to serialize output %d of %s because %s", i, t.ID(), err.Error())
+			}
+			if len(s) == 0 || s[0] == opReturn {
+				continue
+			}
+			items = append(items, s)
+		}
+	}
+
+	for _, s := range prevOutScripts {
+		if len(s) == 0 {
+			continue
+		}
+		items = append(items, s)
+	}
+
+	return items, nil
+}
+
+// Makes the BIP158 basic filter for the block. The previous output scripts are
+// the scripts of all the outputs spent by the inputs of the block, excluding
+// the coinbase.
+func MakeBasicFilter(b *Block, prevOutScripts [][]byte) (*GCSFilter, error) {
+	hash, err := b.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := BasicFilterItems(b, prevOutScripts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key is the first 16 bytes of the block hash in little endian
+	return MakeGCSFilter(BASIC_FILTER_P, BASIC_FILTER_M, utils.MutableReorderBytes(hash)[:16], items)
+}
+
+// Parses the serialized basic filter of the block with the hash (big endian)
+func ParseBasicFilter(blockHash []byte, reader *bytes.Reader) (*GCSFilter, error) {
+	key := utils.ImmutableReorderBytes(blockHash)[:16]
+	return ParseGCSFilter(BASIC_FILTER_P, BASIC_FILTER_M, key, reader)
+}
+
+// Calculates the filter header from the filter hash and the previous filter
+// header, all big endian. The filter header of the genesis block uses a
+// previous header of all zeros.
+func FilterHeader(filterHash, previousHeader []byte) []byte {
+	h := append(utils.ImmutableReorderBytes(filterHash), utils.ImmutableReorderBytes(previousHeader)...)
+	return utils.MutableReorderBytes(utils.Hash256(h))
+}
+
+// Calculates the filter header of the filter chaining on from the previous
+// filter header, big endian
+func (f *GCSFilter) Header(previousHeader []byte) ([]byte, error) {
+	hash, err := f.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return FilterHeader(hash, previousHeader), nil
+}
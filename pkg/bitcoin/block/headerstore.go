@@ -0,0 +1,135 @@
+package block
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// In memory chain of block headers indexed by height and hash, starting at
+// the genesis block. Headers are only accepted if they extend the tip, the
+// proof of work and difficulty are the responsibility of the caller. Safe for
+// use from multiple goroutines.
+type HeaderStore struct {
+	mu sync.RWMutex
+
+	// headers indexed by height
+	headers []*BlockHeader
+
+	// hashes of the headers indexed by height, big endian
+	hashes [][]byte
+
+	// heights of the headers keyed by their hex hash
+	heights map[string]int
+}
+
+// Makes a header store holding the genesis block
+func MakeHeaderStore(genesis *BlockHeader) (*HeaderStore, error) {
+	s := &HeaderStore{heights: make(map[string]int)}
+	if err := s.append(genesis); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *HeaderStore) append(header *BlockHeader) error {
+	hash, err := header.Hash()
+	if err != nil {
+		return err
+	}
+
+	s.heights[fmt.Sprintf("%x", hash)] = len(s.headers)
+	s.headers = append(s.headers, header)
+	s.hashes = append(s.hashes, hash)
+	return nil
+}
+
+// Adds the headers to the tip of the chain. Each header must build on the one
+// before it. Headers before the first which does not are kept.
+func (s *HeaderStore) Add(headers ...*BlockHeader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, header := range headers {
+		tip := s.hashes[len(s.hashes)-1]
+		if !utils.CompareByteArrays(header.PreviousBlock, tip) {
+			return fmt.Errorf("header does not connect to the tip at height %d", len(s.headers)-1)
+		}
+		if err := s.append(header); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Height of the tip of the chain, the genesis block is at height 0
+func (s *HeaderStore) Height() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.headers) - 1
+}
+
+// Returns the header at the height, or nil if the chain is not that long
+func (s *HeaderStore) Get(height int) *BlockHeader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if height < 0 || height >= len(s.headers) {
+		return nil
+	}
+	return s.headers[height]
+}
+
+// Returns the height of the header with the hash (big endian)
+func (s *HeaderStore) HeightOf(hash []byte) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	height, ok := s.heights[fmt.Sprintf("%x", hash)]
+	return height, ok
+}
+
+// Returns a block locator for the tip of the chain, big endian. The last ten
+// hashes are included one by one, then the steps double back to the genesis
+// block which is always last.
+func (s *HeaderStore) Locator() [][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var locator [][]byte
+	step := 1
+	for height := len(s.hashes) - 1; height > 0; height -= step {
+		locator = append(locator, s.hashes[height])
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, s.hashes[0])
+}
+
+// Returns the headers following the first locator hash in the chain, up to
+// and including the stop hash or the max number of headers. A locator with no
+// known hashes starts from the genesis block.
+func (s *HeaderStore) HeadersAfter(locator [][]byte, stopHash []byte, max int) []*BlockHeader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := 0
+	for _, hash := range locator {
+		if height, ok := s.heights[fmt.Sprintf("%x", hash)]; ok {
+			start = height
+			break
+		}
+	}
+
+	var headers []*BlockHeader
+	for height := start + 1; height < len(s.headers) && len(headers) < max; height++ {
+		headers = append(headers, s.headers[height])
+		if utils.CompareByteArrays(s.hashes[height], stopHash) {
+			break
+		}
+	}
+	return headers
+}
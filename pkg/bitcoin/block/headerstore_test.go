@@ -0,0 +1,109 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// makes a header store with the chain of headers following the genesis block
+func makeTestHeaderStore(t *testing.T, length int) (*HeaderStore, []*BlockHeader) {
+	genesis, err := GetTestnetGenesisBlock()
+	if err != nil {
+		t.Fatalf("failed to get the genesis block because %s", err.Error())
+	}
+
+	store, err := MakeHeaderStore(genesis)
+	if err != nil {
+		t.Fatalf("failed to make the store because %s", err.Error())
+	}
+
+	chain := []*BlockHeader{genesis}
+	for i := 1; i < length; i++ {
+		prev, _ := chain[i-1].Hash()
+		chain = append(chain, &BlockHeader{
+			Version:       1,
+			PreviousBlock: prev,
+			MerkleRoot:    utils.Hash256([]byte{byte(i), byte(i >> 8)}),
+			Timestamp:     genesis.Timestamp + i*600,
+			Bits:          genesis.Bits,
+			Nonce:         []byte{0, 0, 0, 0},
+		})
+	}
+
+	if err := store.Add(chain[1:]...); err != nil {
+		t.Fatalf("failed to add the chain because %s", err.Error())
+	}
+	return store, chain
+}
+
+func TestHeaderStoreAdd(t *testing.T) {
+	store, chain := makeTestHeaderStore(t, 50)
+
+	if store.Height() != 49 {
+		t.Fatalf("expected height 49, got %d", store.Height())
+	}
+
+	hash, _ := chain[30].Hash()
+	if height, ok := store.HeightOf(hash); !ok || height != 30 {
+		t.Fatalf("header found at height %d", height)
+	}
+	if store.Get(30) != chain[30] || store.Get(50) != nil {
+		t.Fatal("unexpected header by height")
+	}
+
+	// headers must extend the tip
+	if err := store.Add(chain[10]); err == nil {
+		t.Fatal("added a header which does not connect")
+	}
+}
+
+func TestHeaderStoreLocator(t *testing.T) {
+	store, chain := makeTestHeaderStore(t, 100)
+
+	locator := store.Locator()
+
+	// 99 down to 90 one at a time, then 88, 84, 76, 60, 28 and the genesis
+	heights := []int{99, 98, 97, 96, 95, 94, 93, 92, 91, 90, 88, 84, 76, 60, 28, 0}
+	if len(locator) != len(heights) {
+		t.Fatalf("expected %d locator hashes, got %d", len(heights), len(locator))
+	}
+	for i, height := range heights {
+		hash, _ := chain[height].Hash()
+		if !utils.CompareByteArrays(locator[i], hash) {
+			t.Fatalf("locator hash %d is not the header at height %d", i, height)
+		}
+	}
+}
+
+func TestHeaderStoreHeadersAfter(t *testing.T) {
+	store, chain := makeTestHeaderStore(t, 100)
+
+	unknown := utils.Hash256([]byte("unknown"))
+	hash40, _ := chain[40].Hash()
+	hash45, _ := chain[45].Hash()
+
+	// first known locator hash is used
+	headers := store.HeadersAfter([][]byte{unknown, hash40}, nil, 2000)
+	if len(headers) != 59 || headers[0] != chain[41] {
+		t.Fatalf("expected the 59 headers after 40, got %d", len(headers))
+	}
+
+	// stops at the stop hash
+	headers = store.HeadersAfter([][]byte{hash40}, hash45, 2000)
+	if len(headers) != 5 || headers[4] != chain[45] {
+		t.Fatalf("expected 5 headers, got %d", len(headers))
+	}
+
+	// limited to the max, unknown locators start at the genesis block
+	headers = store.HeadersAfter([][]byte{unknown}, nil, 10)
+	if len(headers) != 10 || headers[0] != chain[1] {
+		t.Fatalf("expected 10 headers from the genesis block, got %d", len(headers))
+	}
+
+	// nothing after the tip
+	tip, _ := chain[99].Hash()
+	if len(store.HeadersAfter([][]byte{tip}, nil, 2000)) != 0 {
+		t.Fatal("returned headers past the tip")
+	}
+}
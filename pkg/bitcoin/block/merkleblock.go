@@ -2,6 +2,7 @@ package block
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 
@@ -126,6 +127,84 @@ func ParseMerkleBlock(reader *bytes.Reader) (*MerkleBlock, error) {
 
 }
 
+// Makes a merkle block for the block header proving the matched transactions.
+// The transaction hashes are all the hashes of the block, in block order, in
+// big endian (as displayed) byte order.
+func MakeMerkleBlock(header *BlockHeader, txHashes [][]byte, matches []bool) (*MerkleBlock, error) {
+	// the tree is built with the hashes in their internal byte order
+	hashes := make([][]byte, 0, len(txHashes))
+	for _, hash := range txHashes {
+		hashes = append(hashes, utils.ImmutableReorderBytes(hash))
+	}
+
+	flagBits, proof, err := BuildPartialMerkleTree(hashes, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	// pad the flag bits out to a whole number of bytes
+	for len(flagBits)%8 != 0 {
+		flagBits = append(flagBits, 0)
+	}
+	flags, err := utils.BitFieldToBytes(flagBits)
+	if err != nil {
+		return nil, err
+	}
+
+	// hashes are stored big endian, same as the parser
+	proofHashes := make([][]byte, 0, len(proof))
+	for _, hash := range proof {
+		proofHashes = append(proofHashes, utils.ImmutableReorderBytes(hash))
+	}
+
+	return &MerkleBlock{
+		Version:        header.Version,
+		PreviousBlock:  header.PreviousBlock,
+		MerkleRoot:     header.MerkleRoot,
+		Timestamp:      header.Timestamp,
+		Bits:           header.Bits,
+		Nonce:          header.Nonce,
+		Total:          len(txHashes),
+		TxHashes:       proofHashes,
+		NumberOfHashes: uint64(len(proofHashes)),
+		Flags:          flags,
+	}, nil
+}
+
+// Serializes the merkle block into the format of the merkleblock message
+func (m *MerkleBlock) Serialize() ([]byte, error) {
+	// the first 80 bytes are just the block header
+	result, err := m.Header().SerializeHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	// total number of transactions is 4 bytes little endian
+	result = append(result, utils.IntToLittleEndianBytes(m.Total)...)
+
+	// number of hashes is a varint
+	numHashes, err := utils.EncodeUVarInt(uint64(len(m.TxHashes)))
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, numHashes...)
+
+	// each hash is 32 bytes little endian
+	for _, hash := range m.TxHashes {
+		result = append(result, utils.ImmutableReorderBytes(hash)...)
+	}
+
+	// flags are prefixed with their length as a varint
+	flagsLen, err := utils.EncodeUVarInt(uint64(len(m.Flags)))
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, flagsLen...)
+	result = append(result, m.Flags...)
+
+	return result, nil
+}
+
 func verifyMerkleRoot(hashes [][]byte, merkleRoot []byte) bool {
 	// step 1, reorder all the hashes passed in
 	reordered := make([][]byte, len(hashes))
@@ -148,13 +227,18 @@ func (m *MerkleBlock) VerifyMerkleRoot() bool {
 	return verifyMerkleRoot(m.TxHashes, m.MerkleRoot)
 }
 
-func (m *MerkleBlock) IsValid() bool {
+// Rebuilds the partial merkle tree from the flags and hashes carried
+// in the merkle block
+func (m *MerkleBlock) populateTree() (*MerkleTree, error) {
+	if m.Total == 0 {
+		return nil, fmt.Errorf("merkle block has no transactions")
+	}
 
 	// parse the bits filed into a byte array
 	flagBits := utils.BytesToBitField(m.Flags)
 
-	// reorder the hashes
-	newHashes := make([][]byte, len(m.TxHashes))
+	// reorder the hashes back into the internal byte order
+	newHashes := make([][]byte, 0, len(m.TxHashes))
 	for _, hash := range m.TxHashes {
 		newHashes = append(newHashes, utils.ImmutableReorderBytes(hash))
 	}
@@ -163,10 +247,55 @@ func (m *MerkleBlock) IsValid() bool {
 	tree := MakeMerkleTree(m.Total)
 
 	// populate the tree with the hashes
-	tree.PopulateTree(flagBits, newHashes)
+	if err := tree.PopulateTree(flagBits, newHashes); err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+func (m *MerkleBlock) IsValid() bool {
+	tree, err := m.populateTree()
+	if err != nil {
+		return false
+	}
 
 	// validate the root of the tree is the same root as the block
 	root := utils.ImmutableReorderBytes(tree.Root())
 
 	return utils.CompareByteArrays(root, m.MerkleRoot)
 }
+
+// Returns the hashes (big endian, as displayed) of the transactions
+// the merkle block proves are included in the block. Errors if the
+// partial merkle tree does not hash to the merkle root of the block.
+func (m *MerkleBlock) ProvedTxHashes() ([][]byte, error) {
+	tree, err := m.populateTree()
+	if err != nil {
+		return nil, err
+	}
+
+	root := utils.ImmutableReorderBytes(tree.Root())
+	if !utils.CompareByteArrays(root, m.MerkleRoot) {
+		return nil, fmt.Errorf("merkle root mismatch, calculated %x expected %x", root, m.MerkleRoot)
+	}
+
+	proved := make([][]byte, 0, len(tree.Matched))
+	for _, hash := range tree.Matched {
+		proved = append(proved, utils.ImmutableReorderBytes(hash))
+	}
+
+	return proved, nil
+}
+
+// Returns the block header portion of the merkle block
+func (m *MerkleBlock) Header() *BlockHeader {
+	return &BlockHeader{
+		Version:       m.Version,
+		PreviousBlock: m.PreviousBlock,
+		MerkleRoot:    m.MerkleRoot,
+		Timestamp:     m.Timestamp,
+		Bits:          m.Bits,
+		Nonce:         m.Nonce,
+	}
+}
@@ -1 +1,161 @@
 package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const testMerkleBlock = "00000020df3b053dc46f162a9b00c7f0d5124e2676d47bbe7c5d0793a500000000000000ef445fef2ed495c275892206ca533e7411907971013ab83e3b47bd0d692d14d4dc7c835b67d8001ac157e670bf0d00000aba412a0d1480e370173072c9562becffe87aa661c1e4a6dbc305d38ec5dc088a7cf92e6458aca7b32edae818f9c2c98c37e06bf72ae0ce80649a38655ee1e27d34d9421d940b16732f24b94023e9d572a7f9ab8023434a4feb532d2adfc8c2c2158785d1bd04eb99df2e86c54bc13e139862897217400def5d72c280222c4cbaee7261831e1550dbb8fa82853e9fe506fc5fda3f7b919d8fe74b6282f92763cef8e625f977af7c8619c32a369b832bc2d051ecd9c73c51e76370ceabd4f25097c256597fa898d404ed53425de608ac6bfe426f6e2bb457f1c554866eb69dcb8d6bf6f880e9a59b3cd053e6c7060eeacaacf4dac6697dac20e4bd3f38a2ea2543d1ab7953e3430790a9f81e1c67f5b58c825acf46bd02848384eebe9af917274cdfbb1a28a5d58a23a17977def0de10d644258d9c54f886d47d293a411cb6226103b55635"
+
+func TestParseMerkleBlock(t *testing.T) {
+	raw, _ := hex.DecodeString(testMerkleBlock)
+
+	mb, err := ParseMerkleBlock(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the merkle block because %s", err.Error())
+	}
+
+	if mb.Total != 3519 {
+		t.Fatalf("expected 3519 transactions, got %d", mb.Total)
+	}
+
+	if len(mb.TxHashes) != 10 {
+		t.Fatalf("expected 10 hashes, got %d", len(mb.TxHashes))
+	}
+
+	if fmt.Sprintf("%x", mb.Flags) != "b55635" {
+		t.Fatalf("flags parsed incorrectly, got %x", mb.Flags)
+	}
+}
+
+func TestMerkleBlockIsValid(t *testing.T) {
+	raw, _ := hex.DecodeString(testMerkleBlock)
+
+	mb, err := ParseMerkleBlock(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the merkle block because %s", err.Error())
+	}
+
+	if !mb.IsValid() {
+		t.Fatal("failed to validate the merkle block")
+	}
+
+	// tamper with one of the hashes and make sure the proof no longer holds
+	mb.TxHashes[3][0] ^= 0xff
+	if mb.IsValid() {
+		t.Fatal("tampered merkle block validated")
+	}
+}
+
+func TestMerkleBlockProvedTxHashes(t *testing.T) {
+	raw, _ := hex.DecodeString(testMerkleBlock)
+
+	mb, err := ParseMerkleBlock(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the merkle block because %s", err.Error())
+	}
+
+	proved, err := mb.ProvedTxHashes()
+	if err != nil {
+		t.Fatalf("failed to get the proved hashes because %s", err.Error())
+	}
+
+	if len(proved) != 1 {
+		t.Fatalf("expected 1 proved transaction, got %d", len(proved))
+	}
+
+	if fmt.Sprintf("%x", proved[0]) != "6122b61c413a297dd486f8549c8d2544d610def0de7779a1238ad5a5281abbdf" {
+		t.Fatalf("proved the wrong transaction %x", proved[0])
+	}
+}
+
+func TestMerkleBlockSerialize(t *testing.T) {
+	raw, _ := hex.DecodeString(testMerkleBlock)
+
+	mb, err := ParseMerkleBlock(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the merkle block because %s", err.Error())
+	}
+
+	serialized, err := mb.Serialize()
+	if err != nil {
+		t.Fatalf("failed to serialize the merkle block because %s", err.Error())
+	}
+
+	if !bytes.Equal(serialized, raw) {
+		t.Fatalf("serialization does not match the parsed bytes\n%x\n%x", serialized, raw)
+	}
+}
+
+func TestMakeMerkleBlock(t *testing.T) {
+	hashStrs := []string{
+		"9745f7173ef14ee4155722d1cbf13304339fd00d900b759c6f9d58579b5765fb",
+		"5573c8ede34936c29cdfdfe743f7f5fdfbd4f54ba0705259e62f39917065cb9b",
+		"82a02ecbb6623b4274dfcab82b336dc017a27136e08521091e443e62582e8f05",
+		"507ccae5ed9b340363a0e6d765af148be9cb1c8766ccc922f83e4ae681658308",
+		"a7a4aec28e7162e1e9ef33dfa30f0bc0526e6cf4b11a576f6c5de58593898330",
+		"bb6267664bd833fd9fc82582853ab144fece26b7a8a5bf328f8a059445b59add",
+		"ea6d7ac1ee77fbacee58fc717b990c4fcccf1b19af43103c090f601677fd8836",
+		"457743861de496c429912558a106b810b0507975a49773228aa788df40730d41",
+		"7688029288efc9e9a0011c960a6ed9e5466581abf3e3a6c26ee317461add619a",
+		"b1ae7f15836cb2286cdd4e2c37bf9bb7da0a2846d06867a429f654b2e7f383c9",
+		"9b74f89fa3f93e71ff2c241f32945d877281a6a50a6bf94adac002980aafe5ab",
+	}
+
+	hashes := make([][]byte, 0, len(hashStrs))
+	for _, h := range hashStrs {
+		b, _ := hex.DecodeString(h)
+		hashes = append(hashes, b)
+	}
+
+	header := &BlockHeader{
+		Version:       1,
+		PreviousBlock: make([]byte, 32),
+		Timestamp:     1234,
+		Bits:          make([]byte, 4),
+		Nonce:         make([]byte, 4),
+	}
+	for i := range hashes {
+		matches := make([]bool, len(hashes))
+		matches[i] = true
+
+		// the merkle root of the header is over the big endian hashes
+		// in their internal byte order
+		internal := make([][]byte, 0, len(hashes))
+		for _, h := range hashes {
+			internal = append(internal, utils.ImmutableReorderBytes(h))
+		}
+		root, err := utils.MerkleRoot(internal)
+		if err != nil {
+			t.Fatalf("failed to calculate the merkle root because %s", err.Error())
+		}
+		header.MerkleRoot = utils.ImmutableReorderBytes(root)
+
+		mb, err := MakeMerkleBlock(header, hashes, matches)
+		if err != nil {
+			t.Fatalf("failed to make the merkle block because %s", err.Error())
+		}
+
+		// round trip through the wire format
+		serialized, err := mb.Serialize()
+		if err != nil {
+			t.Fatalf("failed to serialize the merkle block because %s", err.Error())
+		}
+		parsed, err := ParseMerkleBlock(bytes.NewReader(serialized))
+		if err != nil {
+			t.Fatalf("failed to parse the merkle block because %s", err.Error())
+		}
+
+		proved, err := parsed.ProvedTxHashes()
+		if err != nil {
+			t.Fatalf("merkle block for tx %d is not valid because %s", i, err.Error())
+		}
+		if len(proved) != 1 || !bytes.Equal(proved[0], hashes[i]) {
+			t.Fatalf("merkle block for tx %d proved %x", i, proved)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// Merkle branch proving a single transaction is included in a block. This
+// is the proof returned by Electrum's blockchain.transaction.get_merkle
+type MerkleProof struct {
+	// 32 bytes - Hash of the transaction being proven, big endian
+	TxHash []byte
+
+	// Position of the transaction in the block
+	Index int
+
+	// Sibling hashes from the leaf up to the root, big endian
+	Siblings [][]byte
+
+	// Height of the block containing the transaction. Only carried
+	// along for the JSON encoding
+	BlockHeight int
+}
+
+// json representation used by Electrum
+type electrumMerkleProof struct {
+	BlockHeight int      `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
+}
+
+// Makes the merkle branch for the transaction at index. The hashes are all
+// the transaction hashes of the block, in block order, big endian.
+func MakeMerkleProof(txHashes [][]byte, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(txHashes) {
+		return nil, fmt.Errorf("index %d is out of range for %d transactions", index, len(txHashes))
+	}
+
+	// work with the hashes in their internal byte order
+	level := make([][]byte, 0, len(txHashes))
+	for _, hash := range txHashes {
+		level = append(level, utils.ImmutableReorderBytes(hash))
+	}
+
+	proof := &MerkleProof{
+		TxHash: txHashes[index],
+		Index:  index,
+	}
+
+	// walk up the tree, at each level the sibling is the node next to us.
+	// When we are the odd one out at the end, the sibling is ourselves
+	pos := index
+	for len(level) > 1 {
+		sibling := pos ^ 1
+		if sibling >= len(level) {
+			sibling = pos
+		}
+		proof.Siblings = append(proof.Siblings, utils.ImmutableReorderBytes(level[sibling]))
+
+		var err error
+		level, err = utils.MerkleParentLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		pos /= 2
+	}
+
+	return proof, nil
+}
+
+// Calculates the merkle root (big endian) the branch hashes up to
+func (p *MerkleProof) Root() []byte {
+	root, _ := p.root()
+	return root
+}
+
+// Calculates the merkle root, also reporting whether the branch takes a
+// position which can't exist. The odd node at the end of a level is paired
+// with a copy of itself, so the same branch read with the node on the right
+// would claim the position past the end of the level
+func (p *MerkleProof) root() ([]byte, bool) {
+	current := utils.ImmutableReorderBytes(p.TxHash)
+	ok := true
+
+	for i, sibling := range p.Siblings {
+		s := utils.ImmutableReorderBytes(sibling)
+
+		// the bit of the index at this level says which side we are on
+		if (p.Index>>i)&1 == 1 {
+			if bytes.Equal(s, current) {
+				ok = false
+			}
+			current = utils.MerkleParent(s, current)
+		} else {
+			current = utils.MerkleParent(current, s)
+		}
+	}
+
+	return utils.ImmutableReorderBytes(current), ok
+}
+
+// Verifies the transaction is included in the block with the header
+func (p *MerkleProof) Verify(header *BlockHeader) bool {
+	if len(p.TxHash) != 32 {
+		return false
+	}
+	// the branch only reads as many bits of the index as it has levels, so
+	// higher ones would let the same proof claim other positions
+	if p.Index < 0 || p.Index>>len(p.Siblings) != 0 {
+		return false
+	}
+	root, ok := p.root()
+	return ok && utils.CompareByteArrays(root, header.MerkleRoot)
+}
+
+// Compact binary encoding of the proof
+func (p *MerkleProof) Serialize() ([]byte, error) {
+	// transaction hash is 32 bytes little endian
+	result := utils.ImmutableReorderBytes(p.TxHash)
+
+	// index is 4 bytes little endian
+	result = append(result, utils.IntToLittleEndianBytes(p.Index)...)
+
+	// the number of siblings as a varint
+	count, err := utils.EncodeUVarInt(uint64(len(p.Siblings)))
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, count...)
+
+	// each sibling is 32 bytes little endian
+	for _, sibling := range p.Siblings {
+		result = append(result, utils.ImmutableReorderBytes(sibling)...)
+	}
+
+	return result, nil
+}
+
+// Parses the compact binary encoding of a proof
+func ParseMerkleProof(reader *bytes.Reader) (*MerkleProof, error) {
+	p := &MerkleProof{}
+
+	txHash, err := ioutil.ReadAll(io.LimitReader(reader, 32))
+	if err != nil {
+		return nil, err
+	}
+	if len(txHash) != 32 {
+		return nil, fmt.Errorf("truncated transaction hash")
+	}
+	p.TxHash = utils.MutableReorderBytes(txHash)
+
+	p.Index = utils.LittleEndianToInt(reader)
+
+	count := utils.ReadVarIntFromBytes(reader)
+
+	// a merkle tree can't be deeper than 32 levels with a 4 byte index
+	if count > 32 {
+		return nil, fmt.Errorf("merkle branch of %d hashes is too long", count)
+	}
+
+	for i := 0; i < int(count); i++ {
+		sibling, err := ioutil.ReadAll(io.LimitReader(reader, 32))
+		if err != nil {
+			return nil, err
+		}
+		if len(sibling) != 32 {
+			return nil, fmt.Errorf("truncated sibling hash %d", i)
+		}
+		p.Siblings = append(p.Siblings, utils.MutableReorderBytes(sibling))
+	}
+
+	return p, nil
+}
+
+// Encodes the proof in the format of Electrum's blockchain.transaction.get_merkle
+func (p MerkleProof) MarshalJSON() ([]byte, error) {
+	e := electrumMerkleProof{
+		BlockHeight: p.BlockHeight,
+		Merkle:      make([]string, 0, len(p.Siblings)),
+		Pos:         p.Index,
+	}
+	for _, sibling := range p.Siblings {
+		e.Merkle = append(e.Merkle, hex.EncodeToString(sibling))
+	}
+
+	return json.Marshal(e)
+}
+
+// Decodes a proof in the format of Electrum's blockchain.transaction.get_merkle.
+// The transaction hash is not part of the format and needs to be set by the caller.
+func (p *MerkleProof) UnmarshalJSON(data []byte) error {
+	var e electrumMerkleProof
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+
+	siblings := make([][]byte, 0, len(e.Merkle))
+	for _, m := range e.Merkle {
+		sibling, err := hex.DecodeString(m)
+		if err != nil {
+			return err
+		}
+		if len(sibling) != 32 {
+			return fmt.Errorf("merkle hash %s is not 32 bytes", m)
+		}
+		siblings = append(siblings, sibling)
+	}
+
+	p.BlockHeight = e.BlockHeight
+	p.Index = e.Pos
+	p.Siblings = siblings
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// makes a header committing to the big endian transaction hashes
+func makeProofTestHeader(t *testing.T, txHashes [][]byte) *BlockHeader {
+	internal := make([][]byte, 0, len(txHashes))
+	for _, h := range txHashes {
+		internal = append(internal, utils.ImmutableReorderBytes(h))
+	}
+
+	root, err := utils.MerkleRoot(internal)
+	if err != nil {
+		t.Fatalf("failed to calculate the merkle root because %s", err.Error())
+	}
+
+	return &BlockHeader{MerkleRoot: utils.ImmutableReorderBytes(root)}
+}
+
+func TestMerkleProofVerify(t *testing.T) {
+	for total := 1; total <= 13; total++ {
+		txHashes := make([][]byte, 0, total)
+		for i := 0; i < total; i++ {
+			txHashes = append(txHashes, utils.Hash256([]byte{byte(i), byte(total)}))
+		}
+		header := makeProofTestHeader(t, txHashes)
+
+		for i := range txHashes {
+			proof, err := MakeMerkleProof(txHashes, i)
+			if err != nil {
+				t.Fatalf("failed to make the proof because %s", err.Error())
+			}
+
+			if !proof.Verify(header) {
+				t.Fatalf("proof for tx %d of %d failed to verify", i, total)
+			}
+
+			// positions past the end of the branch must fail
+			for _, index := range []int{i + 1<<len(proof.Siblings), -1} {
+				bogus := *proof
+				bogus.Index = index
+				if bogus.Verify(header) {
+					t.Fatalf("proof for tx %d of %d verified at index %d", i, total, index)
+				}
+			}
+
+			// the last transaction is paired with itself, so its branch read
+			// as one of the positions past the end must fail
+			if i == total-1 {
+				for index := total; index < 1<<len(proof.Siblings); index++ {
+					bogus := *proof
+					bogus.Index = index
+					if bogus.Verify(header) {
+						t.Fatalf("proof for tx %d of %d verified at index %d", i, total, index)
+					}
+				}
+			}
+
+			// proving a different transaction at the same position must fail
+			proof.TxHash = utils.Hash256([]byte("not in the block"))
+			if proof.Verify(header) {
+				t.Fatalf("bogus proof for tx %d of %d verified", i, total)
+			}
+		}
+	}
+}
+
+func TestMerkleProofSerialize(t *testing.T) {
+	txHashes := make([][]byte, 0, 7)
+	for i := 0; i < 7; i++ {
+		txHashes = append(txHashes, utils.Hash256([]byte{byte(i)}))
+	}
+	header := makeProofTestHeader(t, txHashes)
+
+	proof, err := MakeMerkleProof(txHashes, 5)
+	if err != nil {
+		t.Fatalf("failed to make the proof because %s", err.Error())
+	}
+
+	serialized, err := proof.Serialize()
+	if err != nil {
+		t.Fatalf("failed to serialize the proof because %s", err.Error())
+	}
+
+	// 32 byte hash + 4 byte index + 1 byte count + 3 siblings
+	if len(serialized) != 32+4+1+3*32 {
+		t.Fatalf("unexpected serialization length %d", len(serialized))
+	}
+
+	parsed, err := ParseMerkleProof(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatalf("failed to parse the proof because %s", err.Error())
+	}
+
+	if parsed.Index != 5 || !bytes.Equal(parsed.TxHash, txHashes[5]) {
+		t.Fatalf("proof parsed incorrectly")
+	}
+
+	if !parsed.Verify(header) {
+		t.Fatal("parsed proof failed to verify")
+	}
+}
+
+func TestMerkleProofJSON(t *testing.T) {
+	txHashes := make([][]byte, 0, 3)
+	for i := 0; i < 3; i++ {
+		txHashes = append(txHashes, utils.Hash256([]byte{byte(i)}))
+	}
+	header := makeProofTestHeader(t, txHashes)
+
+	proof, err := MakeMerkleProof(txHashes, 2)
+	if err != nil {
+		t.Fatalf("failed to make the proof because %s", err.Error())
+	}
+	proof.BlockHeight = 450538
+
+	encoded, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatalf("failed to encode the proof because %s", err.Error())
+	}
+
+	// the odd one out is paired with itself, then with the parent of the first two
+	parent := utils.ImmutableReorderBytes(utils.MerkleParent(
+		utils.ImmutableReorderBytes(txHashes[0]),
+		utils.ImmutableReorderBytes(txHashes[1]),
+	))
+	expected := `{"block_height":450538,"merkle":["` + hex.EncodeToString(txHashes[2]) + `","` + hex.EncodeToString(parent) + `"],"pos":2}`
+	if string(encoded) != expected {
+		t.Fatalf("unexpected json encoding\n%s\n%s", encoded, expected)
+	}
+
+	decoded := &MerkleProof{}
+	if err := json.Unmarshal(encoded, decoded); err != nil {
+		t.Fatalf("failed to decode the proof because %s", err.Error())
+	}
+	decoded.TxHash = txHashes[2]
+
+	if decoded.BlockHeight != 450538 || !decoded.Verify(header) {
+		t.Fatal("decoded proof failed to verify")
+	}
+}
@@ -28,6 +28,9 @@ type MerkleTree struct {
 
 	// Current index into the underlying array
 	CurrentIndex int
+
+	// Leaf hashes flagged as matching while populating the tree
+	Matched [][]byte
 }
 
 func (m *MerkleTree) SetMaxDepth() {
@@ -139,14 +142,22 @@ func (m *MerkleTree) PopulateTree(flagBits []byte, hashes [][]byte) error {
 		// left nodes are always given a hash e.g. the rights are the ones
 		// that we need to worry about duplicating
 		if m.IsLeaf() {
-			// dequeue the last flag bit because reasons - the book doesnt explain this
-			flagBits = flagBits[:len(flagBits)-2]
+			if len(flagBits) == 0 || len(hashes) == 0 {
+				return fmt.Errorf("ran out of flag bits or hashes at a leaf")
+			}
 
-			// get the last hash in the array
-			m.SetCurrentNode(hashes[len(hashes)-1])
+			// dequeue the flag bit for the leaf. If it is set, the leaf is
+			// a transaction that matched the filter of the requester
+			if flagBits[0] == 1 {
+				m.Matched = append(m.Matched, hashes[0])
+			}
+			flagBits = flagBits[1:]
 
-			// dequeue the last hash so we have n-1 hashes left
-			hashes = hashes[:len(hashes)-2]
+			// leaves always get the next hash in the list
+			m.SetCurrentNode(hashes[0])
+
+			// dequeue the hash so we have n-1 hashes left
+			hashes = hashes[1:]
 
 			// move up the tree
 			m.Up()
@@ -156,19 +167,29 @@ func (m *MerkleTree) PopulateTree(flagBits []byte, hashes [][]byte) error {
 			//If we don’t have the left child value, there are two possibilities.
 			// This node’s value may be in the hashes field, or it might need calculation.
 			if utils.IsNull(leftHash) {
+				if len(flagBits) == 0 {
+					return fmt.Errorf("ran out of flag bits at depth %d", m.CurrentDepth)
+				}
+
 				// flag bit for this tells whether or not to calculate the node.
 				// it it is 0, next hash is the value for the node. If the bit is
 				// set to a 1, need to calculate the left and maybe the right as well
-				bit := flagBits[len(flagBits)-1]
+				bit := flagBits[0]
 
 				// simulate pop
-				flagBits = flagBits[:len(flagBits)-2]
+				flagBits = flagBits[1:]
 
 				if bit == 0 {
-					m.SetCurrentNode(hashes[len(hashes)-1])
+					if len(hashes) == 0 {
+						return fmt.Errorf("ran out of hashes at depth %d", m.CurrentDepth)
+					}
+					m.SetCurrentNode(hashes[0])
 
 					// simulate pop
-					hashes = hashes[:len(hashes)-2]
+					hashes = hashes[1:]
+
+					// the node is done, move back up to the parent
+					m.Up()
 				} else {
 					// know we have a left node, so move over to it
 					m.Left()
@@ -213,3 +234,77 @@ func (m *MerkleTree) PopulateTree(flagBits []byte, hashes [][]byte) error {
 
 	return nil
 }
+
+// number of nodes at the given height of a tree with total leaves,
+// where height 0 is the leaves
+func widthAtHeight(total, height int) int {
+	return (total + (1 << height) - 1) >> height
+}
+
+// calculates the hash of the node at the height and position in the tree
+func nodeHash(hashes [][]byte, height, pos int) []byte {
+	if height == 0 {
+		return hashes[pos]
+	}
+
+	left := nodeHash(hashes, height-1, pos*2)
+
+	// odd number of nodes at the level below, the left is paired with itself
+	right := left
+	if pos*2+1 < widthAtHeight(len(hashes), height-1) {
+		right = nodeHash(hashes, height-1, pos*2+1)
+	}
+
+	return utils.MerkleParent(left, right)
+}
+
+// depth first traversal of the tree emitting the flag bits and hashes
+// for the partial merkle tree in the same order PopulateTree consumes them
+func traversePartialTree(hashes [][]byte, matches []bool, height, pos int, flagBits []byte, proof [][]byte) ([]byte, [][]byte) {
+	// check if any of the leaves under this node matched
+	parentOfMatch := false
+	for i := pos << height; i < (pos+1)<<height && i < len(hashes); i++ {
+		if matches[i] {
+			parentOfMatch = true
+			break
+		}
+	}
+
+	if parentOfMatch {
+		flagBits = append(flagBits, 1)
+	} else {
+		flagBits = append(flagBits, 0)
+	}
+
+	// leaves and nodes with nothing interesting below them are given as a hash
+	if height == 0 || !parentOfMatch {
+		return flagBits, append(proof, nodeHash(hashes, height, pos))
+	}
+
+	// otherwise descend into the children so they get calculated
+	flagBits, proof = traversePartialTree(hashes, matches, height-1, pos*2, flagBits, proof)
+	if pos*2+1 < widthAtHeight(len(hashes), height-1) {
+		flagBits, proof = traversePartialTree(hashes, matches, height-1, pos*2+1, flagBits, proof)
+	}
+
+	return flagBits, proof
+}
+
+// Builds a partial merkle tree (BIP37) for the transaction hashes of a block.
+// The hashes are in the internal (little endian) byte order and matches flags
+// which of the transactions should be proven. Returns the flag bits (one byte
+// per bit) and hashes which PopulateTree consumes.
+func BuildPartialMerkleTree(hashes [][]byte, matches []bool) ([]byte, [][]byte, error) {
+	if len(hashes) == 0 {
+		return nil, nil, fmt.Errorf("no hashes to build the tree from")
+	}
+	if len(hashes) != len(matches) {
+		return nil, nil, fmt.Errorf("have %d hashes but %d matches", len(hashes), len(matches))
+	}
+
+	// the root sits at the max depth of the tree
+	height := MakeMerkleTree(len(hashes)).MaxDepth
+
+	flagBits, proof := traversePartialTree(hashes, matches, height, 0, nil, nil)
+	return flagBits, proof, nil
+}
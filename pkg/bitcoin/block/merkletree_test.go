@@ -133,3 +133,56 @@ func TestMerkleTreeNormal(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildPartialMerkleTree(t *testing.T) {
+	for total := 1; total <= 20; total++ {
+		hashes := make([][]byte, 0, total)
+		for i := 0; i < total; i++ {
+			hashes = append(hashes, utils.Hash256([]byte{byte(i)}))
+		}
+
+		root, err := utils.MerkleRoot(hashes)
+		if err != nil {
+			t.Fatalf("failed to calculate the merkle root because %s", err.Error())
+		}
+
+		// match every third transaction plus the last one
+		matches := make([]bool, total)
+		var expected [][]byte
+		for i := range matches {
+			if i%3 == 0 || i == total-1 {
+				matches[i] = true
+				expected = append(expected, hashes[i])
+			}
+		}
+
+		flagBits, proof, err := BuildPartialMerkleTree(hashes, matches)
+		if err != nil {
+			t.Fatalf("failed to build the partial tree because %s", err.Error())
+		}
+
+		tree := MakeMerkleTree(total)
+		if err := tree.PopulateTree(flagBits, proof); err != nil {
+			t.Fatalf("failed to populate tree of %d because %s", total, err.Error())
+		}
+
+		if !utils.CompareByteArrays(tree.Root(), root) {
+			t.Fatalf("root mismatch for a tree of %d", total)
+		}
+
+		if len(tree.Matched) != len(expected) {
+			t.Fatalf("expected %d matches for a tree of %d, got %d", len(expected), total, len(tree.Matched))
+		}
+		for i := range expected {
+			if !utils.CompareByteArrays(tree.Matched[i], expected[i]) {
+				t.Fatalf("match %d is wrong for a tree of %d", i, total)
+			}
+		}
+	}
+}
+
+func TestBuildPartialMerkleTreeMismatch(t *testing.T) {
+	if _, _, err := BuildPartialMerkleTree(make([][]byte, 2), make([]bool, 3)); err == nil {
+		t.Fatal("expected an error for mismatched matches")
+	}
+}
@@ -0,0 +1,493 @@
+package addrman
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// Bucket layout. Addresses we have only heard about go into the new buckets,
+// addresses we have successfully connected to are moved into the tried buckets.
+const (
+	NEW_BUCKET_COUNT   = 256
+	TRIED_BUCKET_COUNT = 64
+	BUCKET_SIZE        = 64
+)
+
+// Limits used to decide an address is not worth keeping
+const (
+	// addresses not seen for this long are forgotten
+	HORIZON = 30 * 24 * time.Hour
+
+	// attempts before giving up on an address we never connected to
+	MAX_RETRIES = 3
+
+	// failures since the last success before giving up on an address
+	MAX_FAILURES = 10
+
+	// how far ahead of us an address timestamp may be
+	MAX_FUTURE = 10 * time.Minute
+)
+
+// Everything we know about a peer address
+type AddressInfo struct {
+	// Address and services of the peer
+	Address *messages.NetworkAddress
+
+	// Host of the peer which told us about the address
+	Source string
+
+	// Last time the address was seen on the network
+	LastSeen time.Time
+
+	// Last time we attempted to connect to the address
+	LastAttempt time.Time
+
+	// Last time we successfully connected to the address
+	LastSuccess time.Time
+
+	// Connection attempts since the last success
+	Attempts int
+
+	// Connected to the address successfully at least once
+	Tried bool
+
+	// Bucket the address is in, a new bucket or a tried bucket depending on Tried
+	Bucket int
+}
+
+// Address manager. Records addresses learned from peers, buckets them so a
+// single source can't flood the table, scores them by their connection history
+// and hands out candidates for outbound connections.
+type AddrMan struct {
+	mu sync.Mutex
+
+	// secret used to place addresses in buckets
+	key []byte
+
+	// all the addresses, keyed by host:port
+	addresses map[string]*AddressInfo
+
+	// keys of the addresses in each bucket
+	newBuckets   [NEW_BUCKET_COUNT][]string
+	triedBuckets [TRIED_BUCKET_COUNT][]string
+
+	// file the address manager is persisted to, empty for none
+	path string
+
+	// clock, replaceable for testing
+	now func() time.Time
+}
+
+// Makes an empty address manager persisted to the path. An empty path
+// keeps the addresses in memory only.
+func MakeAddrMan(path string) *AddrMan {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	return &AddrMan{
+		key:       key,
+		addresses: make(map[string]*AddressInfo),
+		path:      path,
+		now:       time.Now,
+	}
+}
+
+// on disk representation of the address manager
+type addrManFile struct {
+	Key       []byte
+	Addresses []*AddressInfo
+}
+
+// Loads the address manager persisted to the path. A missing file results
+// in an empty address manager.
+func LoadAddrMan(path string) (*AddrMan, error) {
+	a := MakeAddrMan(path)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f addrManFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to load the addresses from %s because %s", path, err.Error())
+	}
+	if len(f.Key) != 32 {
+		return nil, fmt.Errorf("invalid bucket key in %s", path)
+	}
+	a.key = f.Key
+
+	// re-bucket everything rather than trusting the stored buckets
+	for _, info := range f.Addresses {
+		if info.Address == nil || !info.Address.IsValid() {
+			continue
+		}
+		a.insert(info)
+	}
+
+	return a, nil
+}
+
+// Persists the addresses to disk
+func (a *AddrMan) Save() error {
+	if a.path == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	f := addrManFile{Key: a.key}
+	for _, info := range a.addresses {
+		f.Addresses = append(f.Addresses, info)
+	}
+	data, err := json.Marshal(f)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file and move it into place so a crash
+	// never leaves a half written file behind
+	tmp := a.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
+
+// Number of addresses known
+func (a *AddrMan) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.addresses)
+}
+
+// Returns what is known about the address, nil if it is unknown
+func (a *AddrMan) Get(address *messages.NetworkAddress) *AddressInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, ok := a.addresses[address.String()]
+	if !ok {
+		return nil
+	}
+	copied := *info
+	return &copied
+}
+
+// group of an address, addresses in the same group are likely run by the
+// same operator. /16 for IPv4, /32 for IPv6 and everything else by network
+func group(address *messages.NetworkAddress) []byte {
+	switch address.NetworkId {
+	case messages.NETWORK_IPV4:
+		return append([]byte{address.NetworkId}, address.Address[:2]...)
+	case messages.NETWORK_IPV6, messages.NETWORK_CJDNS:
+		return append([]byte{address.NetworkId}, address.Address[:4]...)
+	}
+	return []byte{address.NetworkId}
+}
+
+// keyed hash of the data reduced to a bucket number
+func (a *AddrMan) bucketHash(n int, data ...[]byte) int {
+	h := append([]byte{}, a.key...)
+	for _, d := range data {
+		h = append(h, byte(len(d)))
+		h = append(h, d...)
+	}
+	return int(binary.LittleEndian.Uint64(utils.Hash256(h)) % uint64(n))
+}
+
+// new bucket is picked by the group of the address and the group of the
+// source, so one source can only fill a limited number of buckets
+func (a *AddrMan) newBucket(info *AddressInfo) int {
+	source := []byte(info.Source)
+	if s, err := messages.ParseNetworkAddress(info.Source, 0, 0); err == nil {
+		source = group(s)
+	}
+	return a.bucketHash(NEW_BUCKET_COUNT, []byte("new"), group(info.Address), source)
+}
+
+// tried bucket is picked by the address and its group
+func (a *AddrMan) triedBucket(info *AddressInfo) int {
+	return a.bucketHash(TRIED_BUCKET_COUNT, []byte("tried"), []byte(info.Address.String()), group(info.Address))
+}
+
+// Checks if the address is not worth keeping or connecting to
+func (a *AddrMan) isTerrible(info *AddressInfo) bool {
+	now := a.now()
+
+	// never remove things tried in the last minute
+	if !info.LastAttempt.IsZero() && now.Sub(info.LastAttempt) < time.Minute {
+		return false
+	}
+
+	// came in a flying DeLorean
+	if info.LastSeen.After(now.Add(MAX_FUTURE)) {
+		return true
+	}
+
+	// not seen in recent history
+	if info.LastSeen.IsZero() || now.Sub(info.LastSeen) > HORIZON {
+		return true
+	}
+
+	// tried and never connected
+	if info.LastSuccess.IsZero() && info.Attempts >= MAX_RETRIES {
+		return true
+	}
+
+	// too many failures over the last week
+	if now.Sub(info.LastSuccess) > 7*24*time.Hour && info.Attempts >= MAX_FAILURES {
+		return true
+	}
+
+	return false
+}
+
+// Relative chance of the address being picked for a connection
+func (a *AddrMan) score(info *AddressInfo) float64 {
+	if a.isTerrible(info) {
+		return 0
+	}
+
+	chance := 1.0
+
+	// deprioritize very recent attempts
+	if !info.LastAttempt.IsZero() && a.now().Sub(info.LastAttempt) < 10*time.Minute {
+		chance *= 0.01
+	}
+
+	// every failed attempt makes the address less likely to be picked
+	attempts := info.Attempts
+	if attempts > 8 {
+		attempts = 8
+	}
+	chance *= math.Pow(0.66, float64(attempts))
+
+	// addresses we connected to before are preferred
+	if info.Tried {
+		chance *= 2
+	}
+
+	return chance
+}
+
+// removes the key from the bucket
+func removeFromBucket(bucket []string, key string) []string {
+	for i, k := range bucket {
+		if k == key {
+			return append(bucket[:i], bucket[i+1:]...)
+		}
+	}
+	return bucket
+}
+
+// places the address in its bucket, evicting the worst address in the bucket
+// when it is full. Must be called with the lock held
+func (a *AddrMan) insert(info *AddressInfo) bool {
+	key := info.Address.String()
+
+	var bucket *[]string
+	if info.Tried {
+		info.Bucket = a.triedBucket(info)
+		bucket = &a.triedBuckets[info.Bucket]
+	} else {
+		info.Bucket = a.newBucket(info)
+		bucket = &a.newBuckets[info.Bucket]
+	}
+
+	if len(*bucket) >= BUCKET_SIZE {
+		// find the lowest scoring address in the bucket
+		worst, worstScore := "", math.Inf(1)
+		for _, k := range *bucket {
+			if s := a.score(a.addresses[k]); s < worstScore {
+				worst, worstScore = k, s
+			}
+		}
+
+		// the new address has to do better than what is already there
+		if worstScore > a.score(info) {
+			return false
+		}
+
+		*bucket = removeFromBucket(*bucket, worst)
+		delete(a.addresses, worst)
+	}
+
+	*bucket = append(*bucket, key)
+	a.addresses[key] = info
+
+	return true
+}
+
+// removes the address from its bucket. Must be called with the lock held
+func (a *AddrMan) remove(info *AddressInfo) {
+	key := info.Address.String()
+	if info.Tried {
+		a.triedBuckets[info.Bucket] = removeFromBucket(a.triedBuckets[info.Bucket], key)
+	} else {
+		a.newBuckets[info.Bucket] = removeFromBucket(a.newBuckets[info.Bucket], key)
+	}
+	delete(a.addresses, key)
+}
+
+// Adds the addresses learned from the source, typically the contents of an addr
+// or addrv2 message. Returns the number of new addresses.
+func (a *AddrMan) Add(addresses []*messages.NetworkAddress, source string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	added := 0
+	for _, address := range addresses {
+		if address == nil || !address.IsValid() || address.Port == 0 {
+			continue
+		}
+
+		lastSeen := time.Unix(int64(address.Time), 0)
+
+		// already known, just refresh when it was last seen and its services
+		if info, ok := a.addresses[address.String()]; ok {
+			if lastSeen.After(info.LastSeen) && !lastSeen.After(a.now().Add(MAX_FUTURE)) {
+				info.LastSeen = lastSeen
+			}
+			info.Address.Services |= address.Services
+			continue
+		}
+
+		copied := *address
+		info := &AddressInfo{
+			Address:  &copied,
+			Source:   source,
+			LastSeen: lastSeen,
+		}
+		if a.isTerrible(info) {
+			continue
+		}
+
+		if a.insert(info) {
+			added++
+		}
+	}
+
+	return added
+}
+
+// Records a connection attempt to the address
+func (a *AddrMan) Attempt(address *messages.NetworkAddress) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, ok := a.addresses[address.String()]
+	if !ok {
+		return
+	}
+	info.LastAttempt = a.now()
+	info.Attempts++
+}
+
+// Records a successful connection to the address, moving it into the tried buckets
+func (a *AddrMan) Good(address *messages.NetworkAddress) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, ok := a.addresses[address.String()]
+	if !ok {
+		return
+	}
+
+	now := a.now()
+	info.LastSuccess = now
+	info.LastSeen = now
+	info.LastAttempt = now
+	info.Attempts = 0
+
+	if info.Tried {
+		return
+	}
+
+	// move it to the tried table before leaving the new one, so a full
+	// tried bucket can't lose it
+	newBucket := info.Bucket
+	info.Tried = true
+	if !a.insert(info) {
+		// tried bucket full of better addresses, keep it as a new address
+		info.Tried = false
+		info.Bucket = newBucket
+		return
+	}
+	a.newBuckets[newBucket] = removeFromBucket(a.newBuckets[newBucket], address.String())
+}
+
+// Forgets addresses which are no longer worth keeping. Returns the number removed.
+func (a *AddrMan) Cleanup() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed := 0
+	for _, info := range a.addresses {
+		if a.isTerrible(info) {
+			a.remove(info)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Returns up to count addresses to make outbound connections to, best first.
+// When networks are given only addresses on those networks are returned.
+func (a *AddrMan) Candidates(count int, networks ...byte) []*messages.NetworkAddress {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	type candidate struct {
+		info  *AddressInfo
+		score float64
+	}
+
+	var candidates []candidate
+	for _, info := range a.addresses {
+		if len(networks) > 0 && !containsNetwork(networks, info.Address.NetworkId) {
+			continue
+		}
+		if s := a.score(info); s > 0 {
+			candidates = append(candidates, candidate{info, s})
+		}
+	}
+
+	// best first, ties broken by the most recently seen
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].info.LastSeen.After(candidates[j].info.LastSeen)
+	})
+
+	var result []*messages.NetworkAddress
+	for i := 0; i < len(candidates) && i < count; i++ {
+		copied := *candidates[i].info.Address
+		result = append(result, &copied)
+	}
+	return result
+}
+
+func containsNetwork(networks []byte, network byte) bool {
+	for _, n := range networks {
+		if n == network {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package addrman
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+)
+
+var addrManTestNow = time.Unix(1700000000, 0)
+
+func makeTestAddrMan(path string) *AddrMan {
+	a := MakeAddrMan(path)
+	a.now = func() time.Time { return addrManTestNow }
+	return a
+}
+
+func makeTestAddress(ip string) *messages.NetworkAddress {
+	address := messages.MakeNetworkAddress(net.ParseIP(ip), 8333, messages.NODE_NETWORK)
+	address.Time = uint32(addrManTestNow.Add(-time.Hour).Unix())
+	return address
+}
+
+func TestAdd(t *testing.T) {
+	a := makeTestAddrMan("")
+
+	added := a.Add([]*messages.NetworkAddress{
+		makeTestAddress("1.2.3.4"),
+		makeTestAddress("1.2.3.4"),
+		makeTestAddress("2001:db8::1"),
+	}, "10.0.0.1")
+	if added != 2 || a.Len() != 2 {
+		t.Fatalf("expected 2 addresses, added %d", added)
+	}
+
+	// ancient and future addresses are not worth keeping
+	old := makeTestAddress("5.6.7.8")
+	old.Time = uint32(addrManTestNow.Add(-60 * 24 * time.Hour).Unix())
+	future := makeTestAddress("5.6.7.9")
+	future.Time = uint32(addrManTestNow.Add(time.Hour).Unix())
+	if a.Add([]*messages.NetworkAddress{old, future}, "10.0.0.1") != 0 {
+		t.Fatal("added addresses which are not worth keeping")
+	}
+
+	info := a.Get(makeTestAddress("1.2.3.4"))
+	if info == nil || info.Source != "10.0.0.1" || info.Tried {
+		t.Fatal("address recorded incorrectly")
+	}
+}
+
+func TestSingleSourceIsBucketed(t *testing.T) {
+	a := makeTestAddrMan("")
+
+	// a single source flooding addresses from the same /16 can only fill one
+	// new bucket
+	var addresses []*messages.NetworkAddress
+	for i := 0; i < 1000; i++ {
+		addresses = append(addresses, makeTestAddress(fmt.Sprintf("8.8.%d.%d", i/256, i%256)))
+	}
+	a.Add(addresses, "10.0.0.1")
+
+	if a.Len() != BUCKET_SIZE {
+		t.Fatalf("expected a single full bucket of %d addresses, got %d", BUCKET_SIZE, a.Len())
+	}
+}
+
+func TestGoodAndAttempt(t *testing.T) {
+	a := makeTestAddrMan("")
+
+	good := makeTestAddress("1.2.3.4")
+	bad := makeTestAddress("5.6.7.8")
+	a.Add([]*messages.NetworkAddress{good, bad}, "10.0.0.1")
+
+	a.Attempt(good)
+	a.Good(good)
+	if info := a.Get(good); !info.Tried || info.Attempts != 0 {
+		t.Fatal("successful connection was not recorded")
+	}
+
+	// move the clock on so the attempts aren't considered recent
+	a.Attempt(bad)
+	a.Attempt(bad)
+	a.now = func() time.Time { return addrManTestNow.Add(time.Hour) }
+
+	candidates := a.Candidates(10)
+	if len(candidates) != 2 || candidates[0].String() != good.String() {
+		t.Fatalf("expected the tried address first, got %v", candidates)
+	}
+
+	// a third failure without ever connecting makes the address terrible
+	a.Attempt(bad)
+	a.now = func() time.Time { return addrManTestNow.Add(2 * time.Hour) }
+	if len(a.Candidates(10)) != 1 {
+		t.Fatal("handed out an address which never connected")
+	}
+	if a.Cleanup() != 1 || a.Len() != 1 {
+		t.Fatal("failed to clean up the terrible address")
+	}
+}
+
+func TestGoodWithFullTriedBucket(t *testing.T) {
+	a := makeTestAddrMan("")
+
+	target := makeTestAddress("1.2.3.4")
+	a.Add([]*messages.NetworkAddress{target}, "10.0.0.1")
+	bucket := a.triedBucket(&AddressInfo{Address: target})
+
+	// fill the tried bucket of the address with others we connected to
+	filled := 0
+	for i := 0; filled < BUCKET_SIZE; i++ {
+		address := makeTestAddress(fmt.Sprintf("%d.%d.1.1", 11+i/256, i%256))
+		if a.triedBucket(&AddressInfo{Address: address}) != bucket {
+			continue
+		}
+		a.Add([]*messages.NetworkAddress{address}, address.IP().String())
+		a.Good(address)
+		filled++
+	}
+
+	// the others haven't been tried recently, so they score better and the
+	// address stays new, in its new bucket
+	a.now = func() time.Time { return addrManTestNow.Add(time.Hour) }
+	a.Good(target)
+
+	info := a.Get(target)
+	if info == nil || info.Tried || info.LastSuccess.IsZero() {
+		t.Fatal("address was lost moving it to a full tried bucket")
+	}
+	if a.Len() != BUCKET_SIZE+1 || len(a.triedBuckets[bucket]) != BUCKET_SIZE {
+		t.Fatalf("expected the tried bucket to be kept, %d addresses", a.Len())
+	}
+	found := false
+	for _, k := range a.newBuckets[info.Bucket] {
+		found = found || k == target.String()
+	}
+	if !found {
+		t.Fatal("address is not in its new bucket")
+	}
+}
+
+func TestCandidatesByNetwork(t *testing.T) {
+	a := makeTestAddrMan("")
+
+	onion, _ := messages.ParseNetworkAddress("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion", 8333, 0)
+	onion.Time = uint32(addrManTestNow.Unix())
+	a.Add([]*messages.NetworkAddress{onion, makeTestAddress("1.2.3.4")}, "10.0.0.1")
+
+	candidates := a.Candidates(10, messages.NETWORK_TORV3)
+	if len(candidates) != 1 || candidates[0].NetworkId != messages.NETWORK_TORV3 {
+		t.Fatalf("expected only the onion address, got %v", candidates)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	a := makeTestAddrMan(path)
+	a.Add([]*messages.NetworkAddress{makeTestAddress("1.2.3.4"), makeTestAddress("2001:db8::1")}, "10.0.0.1")
+	a.Good(makeTestAddress("1.2.3.4"))
+
+	if err := a.Save(); err != nil {
+		t.Fatalf("failed to save because %s", err.Error())
+	}
+
+	loaded, err := LoadAddrMan(path)
+	if err != nil {
+		t.Fatalf("failed to load because %s", err.Error())
+	}
+	loaded.now = a.now
+
+	if loaded.Len() != 2 {
+		t.Fatalf("expected 2 addresses, loaded %d", loaded.Len())
+	}
+	if info := loaded.Get(makeTestAddress("1.2.3.4")); info == nil || !info.Tried || info.Bucket != a.Get(makeTestAddress("1.2.3.4")).Bucket {
+		t.Fatal("tried address loaded incorrectly")
+	}
+
+	// a missing file is an empty address manager
+	empty, err := LoadAddrMan(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || empty.Len() != 0 {
+		t.Fatal("failed to load a missing file")
+	}
+}
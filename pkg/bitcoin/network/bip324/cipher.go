@@ -0,0 +1,197 @@
+package bip324
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
+)
+
+// Number of messages encrypted with a key before it is replaced
+const REKEY_INTERVAL = 224
+
+// Size of the poly1305 authentication tag
+const TAG_SIZE = 16
+
+// pads the mac input to a multiple of 16 bytes
+func writePadded(mac *poly1305.MAC, b []byte) {
+	mac.Write(b)
+	if rem := len(b) % 16; rem != 0 {
+		mac.Write(make([]byte, 16-rem))
+	}
+}
+
+// computes the RFC8439 tag over the aad and ciphertext
+func aeadTag(key, nonce, aad, ciphertext []byte) ([]byte, error) {
+	stream, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// the one time poly1305 key is the first 32 bytes of block 0
+	var polyKey [32]byte
+	stream.XORKeyStream(polyKey[:], polyKey[:])
+
+	mac := poly1305.New(&polyKey)
+	writePadded(mac, aad)
+	writePadded(mac, ciphertext)
+
+	lengths := make([]byte, 16)
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(aad)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	mac.Write(lengths)
+
+	return mac.Sum(nil), nil
+}
+
+// xors the text with the keystream starting at block 1
+func aeadXor(key, nonce, text []byte) ([]byte, error) {
+	stream, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetCounter(1)
+
+	out := make([]byte, len(text))
+	stream.XORKeyStream(out, text)
+	return out, nil
+}
+
+// ChaCha20-Poly1305 encryption as defined in RFC8439. Returns the ciphertext
+// followed by the tag
+func aeadEncrypt(key, nonce, aad, plaintext []byte) ([]byte, error) {
+	ciphertext, err := aeadXor(key, nonce, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := aeadTag(key, nonce, aad, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return append(ciphertext, tag...), nil
+}
+
+// ChaCha20-Poly1305 decryption as defined in RFC8439 of the ciphertext
+// followed by the tag
+func aeadDecrypt(key, nonce, aad, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < TAG_SIZE {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	body, tag := ciphertext[:len(ciphertext)-TAG_SIZE], ciphertext[len(ciphertext)-TAG_SIZE:]
+
+	expected, err := aeadTag(key, nonce, aad, body)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(tag, expected) != 1 {
+		return nil, fmt.Errorf("authentication tag does not match")
+	}
+
+	return aeadXor(key, nonce, body)
+}
+
+// Forward secure ChaCha20, used to encrypt packet lengths. The keystream runs
+// on across messages and the key is replaced every REKEY_INTERVAL messages
+type FSChaCha20 struct {
+	key          []byte
+	blockCounter uint32
+	chunkCounter uint64
+	keystream    []byte
+}
+
+func MakeFSChaCha20(key []byte) *FSChaCha20 {
+	return &FSChaCha20{key: append([]byte{}, key...)}
+}
+
+func (f *FSChaCha20) keystreamBytes(n int) []byte {
+	for len(f.keystream) < n {
+		nonce := make([]byte, 12)
+		binary.LittleEndian.PutUint64(nonce[4:], f.chunkCounter/REKEY_INTERVAL)
+
+		stream, _ := chacha20.NewUnauthenticatedCipher(f.key, nonce)
+		stream.SetCounter(f.blockCounter)
+		block := make([]byte, 64)
+		stream.XORKeyStream(block, block)
+
+		f.keystream = append(f.keystream, block...)
+		f.blockCounter++
+	}
+
+	ret := f.keystream[:n]
+	f.keystream = f.keystream[n:]
+	return ret
+}
+
+// Encrypts or decrypts the chunk
+func (f *FSChaCha20) Crypt(chunk []byte) []byte {
+	ks := f.keystreamBytes(len(chunk))
+	out := make([]byte, len(chunk))
+	for i := range chunk {
+		out[i] = chunk[i] ^ ks[i]
+	}
+
+	if (f.chunkCounter+1)%REKEY_INTERVAL == 0 {
+		f.key = f.keystreamBytes(32)
+		f.blockCounter = 0
+		f.keystream = nil
+	}
+	f.chunkCounter++
+
+	return out
+}
+
+// Forward secure ChaCha20-Poly1305, used to encrypt packets. The key is
+// replaced every REKEY_INTERVAL packets
+type FSChaCha20Poly1305 struct {
+	key           []byte
+	packetCounter uint64
+}
+
+func MakeFSChaCha20Poly1305(key []byte) *FSChaCha20Poly1305 {
+	return &FSChaCha20Poly1305{key: append([]byte{}, key...)}
+}
+
+func (f *FSChaCha20Poly1305) nonce() []byte {
+	nonce := make([]byte, 12)
+	binary.LittleEndian.PutUint32(nonce[:4], uint32(f.packetCounter%REKEY_INTERVAL))
+	binary.LittleEndian.PutUint64(nonce[4:], f.packetCounter/REKEY_INTERVAL)
+	return nonce
+}
+
+// moves on to the next packet, replacing the key at the end of an interval
+func (f *FSChaCha20Poly1305) next(nonce []byte) error {
+	if (f.packetCounter+1)%REKEY_INTERVAL == 0 {
+		rekeyNonce := append([]byte{0xff, 0xff, 0xff, 0xff}, nonce[4:]...)
+		key, err := aeadEncrypt(f.key, rekeyNonce, nil, make([]byte, 32))
+		if err != nil {
+			return err
+		}
+		f.key = key[:32]
+	}
+	f.packetCounter++
+	return nil
+}
+
+// Encrypts the plaintext, returning the ciphertext followed by the tag
+func (f *FSChaCha20Poly1305) Encrypt(aad, plaintext []byte) ([]byte, error) {
+	nonce := f.nonce()
+	ret, err := aeadEncrypt(f.key, nonce, aad, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return ret, f.next(nonce)
+}
+
+// Decrypts the ciphertext followed by the tag. A packet which fails to
+// authenticate leaves the cipher unusable, the connection must be dropped
+func (f *FSChaCha20Poly1305) Decrypt(aad, ciphertext []byte) ([]byte, error) {
+	nonce := f.nonce()
+	ret, err := aeadDecrypt(f.key, nonce, aad, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return ret, f.next(nonce)
+}
@@ -0,0 +1,128 @@
+package bip324
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"testing"
+)
+
+// RFC8439 section 2.8.2
+func TestAEADVector(t *testing.T) {
+	key, _ := hex.DecodeString("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce, _ := hex.DecodeString("070000004041424344454647")
+	aad, _ := hex.DecodeString("50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+
+	ciphertext, err := aeadEncrypt(key, nonce, aad, plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt because %s", err.Error())
+	}
+
+	if hex.EncodeToString(ciphertext[:16]) != "d31a8d34648e60db7b86afbc53ef7ec2" {
+		t.Fatalf("unexpected ciphertext %x", ciphertext[:16])
+	}
+	if hex.EncodeToString(ciphertext[len(ciphertext)-TAG_SIZE:]) != "1ae10b594f09e26a7e902ecbd0600691" {
+		t.Fatalf("unexpected tag %x", ciphertext[len(ciphertext)-TAG_SIZE:])
+	}
+
+	decrypted, err := aeadDecrypt(key, nonce, aad, ciphertext)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("failed to decrypt")
+	}
+
+	// tampering with the aad or ciphertext fails authentication
+	if _, err := aeadDecrypt(key, nonce, aad[1:], ciphertext); err == nil {
+		t.Fatal("decrypted with the wrong aad")
+	}
+	ciphertext[0] ^= 1
+	if _, err := aeadDecrypt(key, nonce, aad, ciphertext); err == nil {
+		t.Fatal("decrypted a tampered ciphertext")
+	}
+}
+
+func TestFSChaCha20Rekey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	enc := MakeFSChaCha20(key)
+	dec := MakeFSChaCha20(key)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3*REKEY_INTERVAL; i++ {
+		chunk := []byte{byte(i), byte(i >> 8), 0x00}
+		ct := enc.Crypt(chunk)
+		if !bytes.Equal(dec.Crypt(ct), chunk) {
+			t.Fatalf("chunk %d failed to decrypt", i)
+		}
+		seen[string(ct)] = true
+	}
+
+	if !bytes.Equal(enc.key, dec.key) || bytes.Equal(enc.key, key) || enc.chunkCounter != 3*REKEY_INTERVAL {
+		t.Fatal("ciphers were not rekeyed")
+	}
+	if len(seen) < 3*REKEY_INTERVAL-1 {
+		t.Fatal("keystream repeated")
+	}
+}
+
+func TestFSChaCha20Poly1305Rekey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x24}, 32)
+	enc := MakeFSChaCha20Poly1305(key)
+	dec := MakeFSChaCha20Poly1305(key)
+
+	for i := 0; i < 2*REKEY_INTERVAL+5; i++ {
+		plaintext := bytes.Repeat([]byte{byte(i)}, i%50)
+		ct, err := enc.Encrypt([]byte("aad"), plaintext)
+		if err != nil {
+			t.Fatalf("failed to encrypt packet %d because %s", i, err.Error())
+		}
+		pt, err := dec.Decrypt([]byte("aad"), ct)
+		if err != nil || !bytes.Equal(pt, plaintext) {
+			t.Fatalf("packet %d failed to decrypt", i)
+		}
+	}
+
+	if !bytes.Equal(enc.key, dec.key) || bytes.Equal(enc.key, key) {
+		t.Fatal("ciphers were not rekeyed")
+	}
+
+	// a packet from an older key does not decrypt
+	old := MakeFSChaCha20Poly1305(key)
+	ct, _ := old.Encrypt(nil, []byte("stale"))
+	if _, err := dec.Decrypt(nil, ct); err == nil {
+		t.Fatal("decrypted a packet with a stale key")
+	}
+}
+
+// The packet ciphers on their own, with the keys of the BIP324 packet
+// encoding vectors
+func TestPacketCipherVectors(t *testing.T) {
+	for i, row := range readVectorCSV(t, "packet_encoding_test_vectors.csv") {
+		keyL, keyP := row["mid_initiator_l"], row["mid_initiator_p"]
+		if row["in_initiating"] == "0" {
+			keyL, keyP = row["mid_responder_l"], row["mid_responder_p"]
+		}
+		lengthCipher := MakeFSChaCha20(mustHex(keyL))
+		packetCipher := MakeFSChaCha20Poly1305(mustHex(keyP))
+
+		// every packet before is empty, a zero length and a bare header
+		idx, _ := strconv.Atoi(row["in_idx"])
+		for j := 0; j < idx; j++ {
+			lengthCipher.Crypt(make([]byte, LENGTH_SIZE))
+			if _, err := packetCipher.Encrypt(nil, []byte{0x00}); err != nil {
+				t.Fatalf("failed to encrypt packet %d because %s", j, err.Error())
+			}
+		}
+
+		contents := vectorContents(row)
+		header := byte(0x00)
+		if row["in_ignore"] == "1" {
+			header = IGNORE_BIT
+		}
+		length := []byte{byte(len(contents)), byte(len(contents) >> 8), byte(len(contents) >> 16)}
+		ciphertext, err := packetCipher.Encrypt(mustHex(row["in_aad"]), append([]byte{header}, contents...))
+		if err != nil {
+			t.Fatalf("failed to encrypt vector %d because %s", i, err.Error())
+		}
+		checkVectorCiphertext(t, i, row, append(lengthCipher.Crypt(length), ciphertext...))
+	}
+}
@@ -0,0 +1,337 @@
+package bip324
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/ecc/curves/secp256k1"
+)
+
+// Returned by Initiate when the peer hangs up before sending its key, which
+// is what a peer that only speaks v1 does
+var ErrV2Unsupported = errors.New("peer does not support the v2 transport")
+
+// Connection to a peer speaking the v2 transport, or v1 if either side fell
+// back to it. Reads and writes are plain v1 envelopes either way, so the
+// connection can be used anywhere a v1 connection is. Envelopes written are
+// sent as encrypted packets, and packets received are handed back as envelopes.
+type Conn struct {
+	net.Conn
+
+	// nil when talking v1
+	cipher *Cipher
+
+	magic   []byte
+	testnet bool
+
+	// buffered reads from the underlying connection
+	reader *bufio.Reader
+
+	// envelopes decrypted but not read yet
+	readMu  sync.Mutex
+	readBuf []byte
+
+	// envelope bytes written but not sent yet
+	writeMu  sync.Mutex
+	writeBuf []byte
+}
+
+func networkMagic(testnet bool) []byte {
+	if testnet {
+		return envelope.TESTNET_NETWORK_MAGIC[:]
+	}
+	return envelope.MAINNET_NETWORK_MAGIC[:]
+}
+
+// start of the version message of a v1 peer, used to tell v1 peers apart
+func v1Prefix(magic []byte) []byte {
+	prefix := make([]byte, 16)
+	copy(prefix, magic)
+	copy(prefix[4:], "version")
+	return prefix
+}
+
+// Checks if the connection is encrypted
+func (c *Conn) V2() bool {
+	return c.cipher != nil
+}
+
+// Identifies the session, nil when talking v1
+func (c *Conn) SessionId() []byte {
+	if c.cipher == nil {
+		return nil
+	}
+	return c.cipher.SessionId
+}
+
+// Reads the next envelope received from the peer
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.cipher == nil {
+		return c.reader.Read(b)
+	}
+
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.readBuf) == 0 {
+		contents, ignore, err := c.readPacket(nil)
+		if err != nil {
+			return 0, err
+		}
+		if ignore {
+			continue
+		}
+
+		command, payload, err := DecodeMessage(contents)
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = envelope.Make([]byte(command), payload, c.testnet).Serialize()
+	}
+
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// reads and decrypts the next packet
+func (c *Conn) readPacket(aad []byte) ([]byte, bool, error) {
+	encLength := make([]byte, LENGTH_SIZE)
+	if _, err := io.ReadFull(c.reader, encLength); err != nil {
+		return nil, false, err
+	}
+
+	length, err := c.cipher.DecryptLength(encLength)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, ciphertext); err != nil {
+		return nil, false, err
+	}
+
+	return c.cipher.DecryptPacket(ciphertext, aad)
+}
+
+// Sends the envelopes written to the peer. Partial envelopes are held until
+// the rest is written
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.cipher == nil {
+		return c.Conn.Write(b)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.writeBuf = append(c.writeBuf, b...)
+	for len(c.writeBuf) >= 24 {
+		length := int(binary.LittleEndian.Uint32(c.writeBuf[16:20]))
+		if len(c.writeBuf) < 24+length {
+			break
+		}
+
+		command := string(bytes.TrimRight(c.writeBuf[4:16], "\x00"))
+		payload := c.writeBuf[24 : 24+length]
+
+		packet, err := c.cipher.EncryptPacket(EncodeMessage(command, payload), nil, false)
+		if err != nil {
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[24+length:]
+
+		if _, err := c.Conn.Write(packet); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// makes a key pair and random garbage for the handshake
+func makeHandshakeKey() (*big.Int, []byte, []byte, error) {
+	var secret *big.Int
+	for secret == nil || secret.Sign() == 0 {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, nil, err
+		}
+		secret = new(big.Int).Mod(new(big.Int).SetBytes(b), secp256k1.GetNonce())
+	}
+
+	ellswift, err := secp256k1.EllSwiftCreate(secret, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	n := make([]byte, 2)
+	if _, err := rand.Read(n); err != nil {
+		return nil, nil, nil, err
+	}
+	garbage := make([]byte, int(binary.LittleEndian.Uint16(n))%(MAX_GARBAGE_SIZE+1))
+	if _, err := rand.Read(garbage); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return secret, ellswift, garbage, nil
+}
+
+// completes the handshake once the keys are exchanged. Sends our garbage
+// terminator and version packet, then skips the garbage of the peer and reads
+// its version packet
+func (c *Conn) finishHandshake(secret *big.Int, initiator, responder, garbage []byte, initiating bool) error {
+	shared, err := secp256k1.EllSwiftSharedSecret(initiator, responder, secret, initiating)
+	if err != nil {
+		return err
+	}
+
+	c.cipher, err = MakeCipher(shared, c.magic, initiating)
+	if err != nil {
+		return err
+	}
+
+	// the first packet authenticates the garbage we sent, the version packet
+	// has no contents yet
+	version, err := c.cipher.EncryptPacket(nil, garbage, false)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Conn.Write(append(append([]byte{}, c.cipher.SendGarbageTerminator...), version...)); err != nil {
+		return err
+	}
+
+	// garbage runs up to the terminator
+	var received []byte
+	for !bytes.HasSuffix(received, c.cipher.RecvGarbageTerminator) {
+		if len(received) > MAX_GARBAGE_SIZE+GARBAGE_TERMINATOR_SIZE {
+			return fmt.Errorf("garbage terminator not found")
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		received = append(received, b)
+	}
+	theirGarbage := received[:len(received)-GARBAGE_TERMINATOR_SIZE]
+
+	// decoys may come before the version packet, only the first packet
+	// authenticates the garbage
+	aad := theirGarbage
+	for {
+		_, ignore, err := c.readPacket(aad)
+		if err != nil {
+			return err
+		}
+		aad = nil
+		if !ignore {
+			return nil
+		}
+	}
+}
+
+// Performs the v2 handshake as the initiator of the connection. Fails with
+// ErrV2Unsupported if the peer hangs up before sending its key, the connection
+// has to be reopened to fall back to v1.
+func Initiate(ctx context.Context, conn net.Conn, testnet bool) (*Conn, error) {
+	c := &Conn{Conn: conn, magic: networkMagic(testnet), testnet: testnet, reader: bufio.NewReader(conn)}
+
+	stop := envelope.WatchContext(ctx, conn.SetDeadline)
+	defer stop()
+
+	secret, ours, garbage, err := makeHandshakeKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(append([]byte{}, ours...), garbage...)); err != nil {
+		return nil, envelope.ContextError(ctx, "handshake", err)
+	}
+
+	theirs := make([]byte, secp256k1.ELLSWIFT_SIZE)
+	if _, err := io.ReadFull(c.reader, theirs); err != nil {
+		if ctx.Err() == nil && (err == io.EOF || err == io.ErrUnexpectedEOF || isReset(err)) {
+			return nil, ErrV2Unsupported
+		}
+		return nil, envelope.ContextError(ctx, "handshake", err)
+	}
+
+	if err := c.finishHandshake(secret, ours, theirs, garbage, true); err != nil {
+		return nil, envelope.ContextError(ctx, "handshake", err)
+	}
+	return c, nil
+}
+
+// Answers the handshake of a peer which connected to us. Peers which start
+// with a v1 version message are talked to in v1.
+func Accept(ctx context.Context, conn net.Conn, testnet bool) (*Conn, error) {
+	c := &Conn{Conn: conn, magic: networkMagic(testnet), testnet: testnet, reader: bufio.NewReader(conn)}
+
+	stop := envelope.WatchContext(ctx, conn.SetDeadline)
+	defer stop()
+
+	// v1 peers start with the version message, peek so it is left to be read
+	prefix, err := c.reader.Peek(16)
+	if err != nil {
+		return nil, envelope.ContextError(ctx, "handshake", err)
+	}
+	if bytes.Equal(prefix, v1Prefix(c.magic)) {
+		return c, nil
+	}
+
+	theirs := make([]byte, secp256k1.ELLSWIFT_SIZE)
+	if _, err := io.ReadFull(c.reader, theirs); err != nil {
+		return nil, envelope.ContextError(ctx, "handshake", err)
+	}
+
+	secret, ours, garbage, err := makeHandshakeKey()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(append([]byte{}, ours...), garbage...)); err != nil {
+		return nil, envelope.ContextError(ctx, "handshake", err)
+	}
+
+	if err := c.finishHandshake(secret, theirs, ours, garbage, false); err != nil {
+		return nil, envelope.ContextError(ctx, "handshake", err)
+	}
+	return c, nil
+}
+
+// Connects to the peer trying the v2 transport first. If the peer does not
+// support it, the connection is reopened in v1.
+func Dial(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, address string, testnet bool) (*Conn, error) {
+	conn, err := dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Initiate(ctx, conn, testnet)
+	if err == nil {
+		return c, nil
+	}
+	conn.Close()
+	if !errors.Is(err, ErrV2Unsupported) {
+		return nil, err
+	}
+
+	conn, err = dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, magic: networkMagic(testnet), testnet: testnet, reader: bufio.NewReader(conn)}, nil
+}
+
+// checks if the peer reset the connection
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
@@ -0,0 +1,199 @@
+package bip324
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+)
+
+// connected pair of loopback tcp connections
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen because %s", err.Error())
+	}
+	defer listener.Close()
+
+	remote, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial because %s", err.Error())
+	}
+	local, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept because %s", err.Error())
+	}
+	return local, remote
+}
+
+// performs the v2 handshake over a loopback connection
+func v2Pair(t *testing.T) (*Conn, *Conn) {
+	local, remote := tcpPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, err := Accept(ctx, local, true)
+		if err != nil {
+			t.Errorf("failed to accept because %s", err.Error())
+		}
+		accepted <- c
+	}()
+
+	initiator, err := Initiate(ctx, remote, true)
+	if err != nil {
+		t.Fatalf("failed to initiate because %s", err.Error())
+	}
+	responder := <-accepted
+	if responder == nil {
+		t.FailNow()
+	}
+	return initiator, responder
+}
+
+func TestV2Handshake(t *testing.T) {
+	initiator, responder := v2Pair(t)
+	defer initiator.Close()
+	defer responder.Close()
+
+	if !initiator.V2() || !responder.V2() {
+		t.Fatal("connection is not v2")
+	}
+	if !bytes.Equal(initiator.SessionId(), responder.SessionId()) {
+		t.Fatal("session ids do not match")
+	}
+
+	// short and long message types, in both directions
+	sent := []*envelope.Envelope{
+		envelope.Make([]byte("ping"), []byte{1, 2, 3, 4, 5, 6, 7, 8}, true),
+		envelope.Make([]byte("sendaddrv2"), nil, true),
+		envelope.Make([]byte("headers"), bytes.Repeat([]byte{0xab}, 100000), true),
+	}
+	for _, pair := range [][2]*Conn{{initiator, responder}, {responder, initiator}} {
+		go func(w *Conn) {
+			for _, env := range sent {
+				w.Write(env.Serialize())
+			}
+		}(pair[0])
+
+		for _, env := range sent {
+			received, err := envelope.ParseSocket(pair[1], true)
+			if err != nil {
+				t.Fatalf("failed to read the %s because %s", env.Command, err.Error())
+			}
+			if !bytes.Equal(bytes.TrimRight(received.Command, "\x00"), env.Command) || !bytes.Equal(received.Payload, env.Payload) {
+				t.Fatalf("received %s instead of %s", received.Command, env.Command)
+			}
+		}
+	}
+}
+
+func TestV2Decoys(t *testing.T) {
+	initiator, responder := v2Pair(t)
+	defer initiator.Close()
+	defer responder.Close()
+
+	// decoys are dropped, the message after them is read
+	decoy, _ := initiator.cipher.EncryptPacket([]byte("decoy"), nil, true)
+	initiator.Conn.Write(decoy)
+	initiator.Write(envelope.Make([]byte("verack"), nil, true).Serialize())
+
+	received, err := envelope.ParseSocket(responder, true)
+	if err != nil || !bytes.HasPrefix(received.Command, []byte("verack")) {
+		t.Fatalf("expected the verack after the decoy, got %v", err)
+	}
+
+	// a corrupted packet fails to authenticate
+	packet, _ := initiator.cipher.EncryptPacket(EncodeMessage("ping", make([]byte, 8)), nil, false)
+	packet[len(packet)-1] ^= 0x01
+	initiator.Conn.Write(packet)
+	if _, err := envelope.ParseSocket(responder, true); err == nil {
+		t.Fatal("read a corrupted packet")
+	}
+}
+
+func TestAcceptV1(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	version := envelope.Make([]byte("version"), []byte("not really a version"), true)
+	go remote.Write(version.Serialize())
+
+	c, err := Accept(context.Background(), local, true)
+	if err != nil {
+		t.Fatalf("failed to accept because %s", err.Error())
+	}
+	if c.V2() {
+		t.Fatal("v1 peer talked to in v2")
+	}
+
+	// the version message is left to be read
+	received, err := envelope.ParseSocket(c, true)
+	if err != nil || !bytes.Equal(received.Payload, version.Payload) {
+		t.Fatalf("failed to read the version after detecting v1, %v", err)
+	}
+}
+
+func TestDialFallback(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen because %s", err.Error())
+	}
+	defer listener.Close()
+
+	// peer which only speaks v1, dropping connections which start with
+	// anything other than its network magic
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			env, err := envelope.ParseSocket(conn, true)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			conn.Write(envelope.Make([]byte("verack"), nil, true).Serialize())
+			_ = env
+		}
+	}()
+
+	dialer := &net.Dialer{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, dialer.DialContext, "tcp", listener.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("failed to dial because %s", err.Error())
+	}
+	defer c.Close()
+	if c.V2() {
+		t.Fatal("connection to a v1 peer is v2")
+	}
+
+	c.Write(envelope.Make([]byte("version"), nil, true).Serialize())
+	if received, err := envelope.ParseSocket(c, true); err != nil || !bytes.HasPrefix(received.Command, []byte("verack")) {
+		t.Fatalf("failed to talk v1 after falling back, %v", err)
+	}
+}
+
+func TestInitiateTimeout(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	// the peer never answers
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := Initiate(ctx, remote, true); !envelope.IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package bip324
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Sizes of the parts of the v2 transport
+const (
+	// garbage terminator sent after the garbage
+	GARBAGE_TERMINATOR_SIZE = 16
+
+	// most garbage sent after the public key
+	MAX_GARBAGE_SIZE = 4095
+
+	// encrypted length prefix of a packet
+	LENGTH_SIZE = 3
+
+	// header byte of a packet, holding the ignore bit
+	HEADER_SIZE = 1
+
+	// bytes added to the contents of every packet
+	EXPANSION = LENGTH_SIZE + HEADER_SIZE + TAG_SIZE
+
+	// largest packet contents accepted, the largest message plus its type
+	MAX_CONTENTS_SIZE = 4000000 + 1 + 12
+)
+
+// Set in the header of decoy packets which are to be ignored
+const IGNORE_BIT byte = 0x80
+
+// Message types with a one byte encoding, indexed by their short id. Other
+// message types are sent as a zero byte followed by the 12 byte command
+var SHORT_MESSAGE_IDS = [...]string{
+	1:  "addr",
+	2:  "block",
+	3:  "blocktxn",
+	4:  "cmpctblock",
+	5:  "feefilter",
+	6:  "filteradd",
+	7:  "filterclear",
+	8:  "filterload",
+	9:  "getblocks",
+	10: "getblocktxn",
+	11: "getdata",
+	12: "getheaders",
+	13: "headers",
+	14: "inv",
+	15: "mempool",
+	16: "merkleblock",
+	17: "notfound",
+	18: "ping",
+	19: "pong",
+	20: "sendcmpct",
+	21: "tx",
+	22: "getcfilters",
+	23: "cfilter",
+	24: "getcfheaders",
+	25: "cfheaders",
+	26: "getcfcheckpt",
+	27: "cfcheckpt",
+	28: "addrv2",
+}
+
+// Encodes the message as the contents of a packet, the message type followed
+// by the payload
+func EncodeMessage(command string, payload []byte) []byte {
+	for id, c := range SHORT_MESSAGE_IDS {
+		if id > 0 && c == command {
+			return append([]byte{byte(id)}, payload...)
+		}
+	}
+
+	contents := make([]byte, 13, 13+len(payload))
+	copy(contents[1:], command)
+	return append(contents, payload...)
+}
+
+// Decodes the contents of a packet into the message type and payload
+func DecodeMessage(contents []byte) (string, []byte, error) {
+	if len(contents) == 0 {
+		return "", nil, fmt.Errorf("packet has no message type")
+	}
+
+	id := int(contents[0])
+	if id != 0 {
+		if id >= len(SHORT_MESSAGE_IDS) {
+			return "", nil, fmt.Errorf("unknown short message id %d", id)
+		}
+		return SHORT_MESSAGE_IDS[id], contents[1:], nil
+	}
+
+	if len(contents) < 13 {
+		return "", nil, fmt.Errorf("packet message type is truncated")
+	}
+	command := bytes.TrimRight(contents[1:13], "\x00")
+	if len(command) == 0 || bytes.IndexByte(command, 0x00) >= 0 {
+		return "", nil, fmt.Errorf("invalid message type %x", contents[1:13])
+	}
+	return string(command), contents[13:], nil
+}
+
+// Packet ciphers of an established v2 session, one direction each for sending
+// and receiving
+type Cipher struct {
+	sendL *FSChaCha20
+	sendP *FSChaCha20Poly1305
+	recvL *FSChaCha20
+	recvP *FSChaCha20Poly1305
+
+	// Identifies the session, the same for both parties. Can be compared out
+	// of band to detect a man in the middle
+	SessionId []byte
+
+	// Sent and expected after the garbage
+	SendGarbageTerminator []byte
+	RecvGarbageTerminator []byte
+}
+
+// Derives the session keys from the ECDH shared secret and the network magic
+func MakeCipher(secret, magic []byte, initiator bool) (*Cipher, error) {
+	salt := append([]byte("bitcoin_v2_shared_secret"), magic...)
+	prk := hkdf.Extract(sha256.New, secret, salt)
+
+	expand := func(info string) ([]byte, error) {
+		out := make([]byte, 32)
+		_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(info)), out)
+		return out, err
+	}
+
+	keys := make(map[string][]byte)
+	for _, info := range []string{"initiator_L", "initiator_P", "responder_L", "responder_P", "garbage_terminators", "session_id"} {
+		key, err := expand(info)
+		if err != nil {
+			return nil, err
+		}
+		keys[info] = key
+	}
+
+	c := &Cipher{SessionId: keys["session_id"]}
+	terminators := keys["garbage_terminators"]
+	if initiator {
+		c.sendL = MakeFSChaCha20(keys["initiator_L"])
+		c.sendP = MakeFSChaCha20Poly1305(keys["initiator_P"])
+		c.recvL = MakeFSChaCha20(keys["responder_L"])
+		c.recvP = MakeFSChaCha20Poly1305(keys["responder_P"])
+		c.SendGarbageTerminator = terminators[:GARBAGE_TERMINATOR_SIZE]
+		c.RecvGarbageTerminator = terminators[GARBAGE_TERMINATOR_SIZE:]
+	} else {
+		c.sendL = MakeFSChaCha20(keys["responder_L"])
+		c.sendP = MakeFSChaCha20Poly1305(keys["responder_P"])
+		c.recvL = MakeFSChaCha20(keys["initiator_L"])
+		c.recvP = MakeFSChaCha20Poly1305(keys["initiator_P"])
+		c.SendGarbageTerminator = terminators[GARBAGE_TERMINATOR_SIZE:]
+		c.RecvGarbageTerminator = terminators[:GARBAGE_TERMINATOR_SIZE]
+	}
+
+	return c, nil
+}
+
+// Encrypts the contents into a packet, the encrypted length followed by the
+// encrypted header and contents. Decoy packets have the ignore flag set. The
+// contents only have to fit the length prefix, MAX_CONTENTS_SIZE is what we
+// accept from peers
+func (c *Cipher) EncryptPacket(contents, aad []byte, ignore bool) ([]byte, error) {
+	if len(contents) >= 1<<(8*LENGTH_SIZE) {
+		return nil, fmt.Errorf("packet contents of %d bytes are too large", len(contents))
+	}
+
+	header := byte(0x00)
+	if ignore {
+		header = IGNORE_BIT
+	}
+
+	ciphertext, err := c.sendP.Encrypt(aad, append([]byte{header}, contents...))
+	if err != nil {
+		return nil, err
+	}
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(contents)))
+	return append(c.sendL.Crypt(length[:LENGTH_SIZE]), ciphertext...), nil
+}
+
+// Decrypts the length prefix of the next packet, returning the number of
+// bytes which follow it
+func (c *Cipher) DecryptLength(encrypted []byte) (int, error) {
+	if len(encrypted) != LENGTH_SIZE {
+		return 0, fmt.Errorf("length must be %d bytes", LENGTH_SIZE)
+	}
+
+	length := append(c.recvL.Crypt(encrypted), 0x00)
+	contentsLen := int(binary.LittleEndian.Uint32(length))
+	if contentsLen > MAX_CONTENTS_SIZE {
+		return 0, fmt.Errorf("packet contents of %d bytes are too large", contentsLen)
+	}
+	return contentsLen + HEADER_SIZE + TAG_SIZE, nil
+}
+
+// Decrypts the packet following the length prefix, returning the contents
+// and whether it is a decoy to be ignored
+func (c *Cipher) DecryptPacket(ciphertext, aad []byte) ([]byte, bool, error) {
+	plaintext, err := c.recvP.Decrypt(aad, ciphertext)
+	if err != nil {
+		return nil, false, err
+	}
+	return plaintext[HEADER_SIZE:], plaintext[0]&IGNORE_BIT != 0, nil
+}
@@ -0,0 +1,207 @@
+package bip324
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/ecc/curves/secp256k1"
+)
+
+// Reads the rows of a BIP324 test vector file as maps keyed by the columns.
+// The files in testdata are unmodified from bip-0324 in the bips repository
+// at commit 9783d61f1b9c81231581fee026c8e8cb9499d265
+func readVectorCSV(t *testing.T, name string) []map[string]string {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to open %s because %s", name, err.Error())
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read %s because %s", name, err.Error())
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, column := range records[0] {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Contents of the packet a vector encrypts, the contents repeated
+func vectorContents(row map[string]string) []byte {
+	multiply, _ := strconv.Atoi(row["in_multiply"])
+	return bytes.Repeat(mustHex(row["in_contents"]), multiply)
+}
+
+// Checks the ciphertext against the whole packet or the end of it
+func checkVectorCiphertext(t *testing.T, i int, row map[string]string, ciphertext []byte) {
+	if expected := row["out_ciphertext"]; expected != "" && hex.EncodeToString(ciphertext) != expected {
+		t.Fatalf("vector %d encrypted to %x", i, ciphertext)
+	}
+	if suffix := mustHex(row["out_ciphertext_endswith"]); !bytes.HasSuffix(ciphertext, suffix) {
+		t.Fatalf("vector %d encrypted to a packet ending %x", i, ciphertext[len(ciphertext)-len(suffix):])
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	// short ids are one byte
+	contents := EncodeMessage("ping", []byte{1, 2})
+	if !bytes.Equal(contents, []byte{18, 1, 2}) {
+		t.Fatalf("unexpected encoding %x", contents)
+	}
+
+	// everything else is a zero byte and the 12 byte command
+	contents = EncodeMessage("version", []byte{1})
+	if len(contents) != 14 || contents[0] != 0x00 || string(contents[1:8]) != "version" {
+		t.Fatalf("unexpected encoding %x", contents)
+	}
+
+	for _, command := range []string{"ping", "addrv2", "version", "sendaddrv2"} {
+		decoded, payload, err := DecodeMessage(EncodeMessage(command, []byte{0xff}))
+		if err != nil || decoded != command || !bytes.Equal(payload, []byte{0xff}) {
+			t.Fatalf("failed to decode %s", command)
+		}
+	}
+
+	// unassigned short ids and malformed commands are rejected
+	for _, contents := range [][]byte{{}, {29}, {0x00, 'a'}, append([]byte{0x00, 'a', 0x00, 'b'}, make([]byte, 9)...)} {
+		if _, _, err := DecodeMessage(contents); err == nil {
+			t.Fatalf("decoded invalid contents %x", contents)
+		}
+	}
+}
+
+func TestCipherPackets(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x01}, 32)
+	magic := []byte{0x0b, 0x11, 0x09, 0x07}
+
+	initiator, _ := MakeCipher(secret, magic, true)
+	responder, _ := MakeCipher(secret, magic, false)
+
+	if !bytes.Equal(initiator.SendGarbageTerminator, responder.RecvGarbageTerminator) || !bytes.Equal(initiator.SessionId, responder.SessionId) {
+		t.Fatal("ciphers do not agree")
+	}
+
+	// the network is part of the key derivation
+	mainnet, _ := MakeCipher(secret, []byte{0xf9, 0xbe, 0xb4, 0xd9}, true)
+	if bytes.Equal(mainnet.SessionId, initiator.SessionId) {
+		t.Fatal("session id does not depend on the network")
+	}
+
+	for i := 0; i < 300; i++ {
+		contents := bytes.Repeat([]byte{byte(i)}, i)
+		packet, err := initiator.EncryptPacket(contents, []byte("aad"), i%7 == 0)
+		if err != nil {
+			t.Fatalf("failed to encrypt packet %d because %s", i, err.Error())
+		}
+		if len(packet) != len(contents)+EXPANSION {
+			t.Fatalf("packet %d is %d bytes", i, len(packet))
+		}
+
+		length, err := responder.DecryptLength(packet[:LENGTH_SIZE])
+		if err != nil || length != len(packet)-LENGTH_SIZE {
+			t.Fatalf("packet %d length decrypted to %d", i, length)
+		}
+
+		decrypted, ignore, err := responder.DecryptPacket(packet[LENGTH_SIZE:], []byte("aad"))
+		if err != nil || !bytes.Equal(decrypted, contents) || ignore != (i%7 == 0) {
+			t.Fatalf("packet %d failed to decrypt", i)
+		}
+	}
+}
+
+func TestPacketEncodingVectors(t *testing.T) {
+	for i, row := range readVectorCSV(t, "packet_encoding_test_vectors.csv") {
+		initiating := row["in_initiating"] == "1"
+		priv := new(big.Int).SetBytes(mustHex(row["in_priv_ours"]))
+		ours, theirs := mustHex(row["in_ellswift_ours"]), mustHex(row["in_ellswift_theirs"])
+
+		for _, decode := range []struct {
+			encoded []byte
+			x       string
+		}{
+			{ours, row["mid_x_ours"]},
+			{theirs, row["mid_x_theirs"]},
+		} {
+			x, err := secp256k1.EllSwiftDecode(decode.encoded)
+			if err != nil || hex.EncodeToString(x.FillBytes(make([]byte, 32))) != decode.x {
+				t.Fatalf("vector %d decoded %x to %x", i, decode.encoded, x)
+			}
+		}
+		shared, err := secp256k1.EllSwiftECDHXOnly(theirs, priv)
+		if err != nil || hex.EncodeToString(shared) != row["mid_x_shared"] {
+			t.Fatalf("vector %d shared x %x", i, shared)
+		}
+
+		initiator, responder := theirs, ours
+		if initiating {
+			initiator, responder = ours, theirs
+		}
+		secret, err := secp256k1.EllSwiftSharedSecret(initiator, responder, priv, initiating)
+		if err != nil || hex.EncodeToString(secret) != row["mid_shared_secret"] {
+			t.Fatalf("vector %d shared secret %x", i, secret)
+		}
+
+		// the vectors are for mainnet
+		c, _ := MakeCipher(secret, networkMagic(false), initiating)
+		peer, _ := MakeCipher(secret, networkMagic(false), !initiating)
+		if hex.EncodeToString(c.SendGarbageTerminator) != row["mid_send_garbage_terminator"] ||
+			hex.EncodeToString(c.RecvGarbageTerminator) != row["mid_recv_garbage_terminator"] ||
+			hex.EncodeToString(c.SessionId) != row["out_session_id"] {
+			t.Fatalf("vector %d derived the wrong session", i)
+		}
+
+		// empty packets before the one tested, which the peer decrypts along
+		idx, _ := strconv.Atoi(row["in_idx"])
+		for j := 0; j < idx; j++ {
+			packet, _ := c.EncryptPacket(nil, nil, false)
+			peer.DecryptLength(packet[:LENGTH_SIZE])
+			if _, _, err := peer.DecryptPacket(packet[LENGTH_SIZE:], nil); err != nil {
+				t.Fatalf("vector %d failed to decrypt packet %d because %s", i, j, err.Error())
+			}
+		}
+
+		contents, aad, ignore := vectorContents(row), mustHex(row["in_aad"]), row["in_ignore"] == "1"
+		packet, err := c.EncryptPacket(contents, aad, ignore)
+		if err != nil {
+			t.Fatalf("failed to encrypt vector %d because %s", i, err.Error())
+		}
+		checkVectorCiphertext(t, i, row, packet)
+
+		// packets larger than any message are refused by the peer
+		length, err := peer.DecryptLength(packet[:LENGTH_SIZE])
+		if len(contents) > MAX_CONTENTS_SIZE {
+			if err == nil {
+				t.Fatalf("vector %d of %d bytes was accepted", i, len(contents))
+			}
+			continue
+		}
+		if err != nil || length != len(packet)-LENGTH_SIZE {
+			t.Fatalf("vector %d length decrypted to %d", i, length)
+		}
+		decrypted, ignored, err := peer.DecryptPacket(packet[LENGTH_SIZE:], aad)
+		if err != nil || !bytes.Equal(decrypted, contents) || ignored != ignore {
+			t.Fatalf("vector %d failed to decrypt", i)
+		}
+	}
+}
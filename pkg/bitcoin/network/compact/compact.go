@@ -0,0 +1,156 @@
+package compact
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+)
+
+var (
+	// Two transactions of the block have the same short id, so the block
+	// can not be rebuilt from it and has to be downloaded in full
+	ErrShortIDCollision = errors.New("short ids of the compact block collide")
+
+	// The rebuilt block does not hash to the merkle root in the header. A
+	// mempool transaction matched a short id it did not belong to, the
+	// block has to be downloaded in full
+	ErrBadReconstruction = errors.New("rebuilt block does not match the merkle root")
+)
+
+// Block being rebuilt from a compact block (BIP152). The prefilled
+// transactions and the ones found in the mempool are slotted in, the rest
+// are asked for with getblocktxn and filled in from the blocktxn answer.
+type PartialBlock struct {
+	Header *block.BlockHeader
+
+	// big endian
+	hash []byte
+
+	// transactions in block order, nil where still missing
+	txs []*tx.Transaction
+
+	// Number of transactions which came prefilled and from the mempool
+	Prefilled   int
+	FromMempool int
+}
+
+// Starts rebuilding the compact block from the transactions in the mempool
+func MakePartialBlock(cmpct *messages.CmpctBlock, mempool []*tx.Transaction) (*PartialBlock, error) {
+	count := cmpct.TxCount()
+	if count == 0 {
+		return nil, fmt.Errorf("compact block has no transactions")
+	}
+
+	hash, err := cmpct.Header.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PartialBlock{Header: cmpct.Header, hash: hash, txs: make([]*tx.Transaction, count)}
+
+	for _, prefilled := range cmpct.Prefilled {
+		if prefilled.Index >= count {
+			return nil, fmt.Errorf("prefilled transaction index %d is past the %d transactions of the block", prefilled.Index, count)
+		}
+		p.txs[prefilled.Index] = prefilled.Transaction
+		p.Prefilled++
+	}
+
+	// the short ids fill the slots left by the prefilled transactions in order
+	slots := make(map[uint64]int, len(cmpct.ShortIDs))
+	next := 0
+	for _, id := range cmpct.ShortIDs {
+		for p.txs[next] != nil {
+			next++
+		}
+		if _, ok := slots[id]; ok {
+			return nil, ErrShortIDCollision
+		}
+		slots[id] = next
+		next++
+	}
+
+	k0, k1, err := cmpct.ShortIDKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// mempool transactions sharing a short id can't be told apart, the slot
+	// is left for the peer to fill
+	ambiguous := make(map[int]bool)
+	for _, t := range mempool {
+		slot, ok := slots[messages.ShortID(k0, k1, t.WitnessHash())]
+		if !ok || ambiguous[slot] {
+			continue
+		}
+		if p.txs[slot] != nil {
+			if !bytes.Equal(p.txs[slot].WitnessHash(), t.WitnessHash()) {
+				p.txs[slot] = nil
+				p.FromMempool--
+				ambiguous[slot] = true
+			}
+			continue
+		}
+		p.txs[slot] = t
+		p.FromMempool++
+	}
+
+	return p, nil
+}
+
+// Positions of the transactions still missing, increasing
+func (p *PartialBlock) Missing() []int {
+	var missing []int
+	for i, t := range p.txs {
+		if t == nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Request for the missing transactions, nil if there are none
+func (p *PartialBlock) GetBlockTxn() *messages.GetBlockTxn {
+	missing := p.Missing()
+	if len(missing) == 0 {
+		return nil
+	}
+	return messages.MakeGetBlockTxn(p.hash, missing)
+}
+
+// Fills in the missing transactions from the answer to the getblocktxn
+// request and returns the rebuilt block
+func (p *PartialBlock) Fill(txn *messages.BlockTxn) (*block.Block, error) {
+	if !bytes.Equal(txn.BlockHash, p.hash) {
+		return nil, fmt.Errorf("blocktxn is for block %x, not %x", txn.BlockHash, p.hash)
+	}
+
+	missing := p.Missing()
+	if len(txn.Transactions) != len(missing) {
+		return nil, fmt.Errorf("blocktxn has %d transactions, %d are missing", len(txn.Transactions), len(missing))
+	}
+
+	for i, index := range missing {
+		p.txs[index] = txn.Transactions[i]
+	}
+
+	return p.Block()
+}
+
+// Returns the rebuilt block once no transactions are missing, checking the
+// transactions hash to the merkle root
+func (p *PartialBlock) Block() (*block.Block, error) {
+	if missing := p.Missing(); len(missing) > 0 {
+		return nil, fmt.Errorf("%d transactions are still missing", len(missing))
+	}
+
+	b := &block.Block{Header: p.Header, Transactions: append([]*tx.Transaction{}, p.txs...)}
+	if !b.VerifyMerkleRoot() {
+		return nil, ErrBadReconstruction
+	}
+	return b, nil
+}
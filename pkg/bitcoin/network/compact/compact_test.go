@@ -0,0 +1,214 @@
+package compact
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+)
+
+// Block of three transactions, the testnet genesis coinbase, a legacy
+// transaction and the BIP143 native p2wpkh example. The payloads were built
+// independently of this package with a reference SipHash and nonce
+// 0x0123456789abcdef, the coinbase being prefilled.
+const (
+	testBlockHash = "5fbc58afb57217306f513531134d39f8ec3dd77f53b2753f222706470029edd6"
+
+	testCmpctBlock = "0100000000000000000000000000000000000000000000000000000000000000000000001e2017d2a5e3306c8910ead20fcbc8df36acafb2cf586ba9d6b868b6cca6f8b5dae5494dffff001d1aa4ae18efcdab896745230102c6b806a51073e7a3278c051c010001000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+	// asking for the segwit transaction at index 2
+	testGetBlockTxn = "d6ed2900470627223f75b2537fd73decf8394d133135516f301772b5af58bc5f0102"
+
+	testBlockTxn = "d6ed2900470627223f75b2537fd73decf8394d133135516f301772b5af58bc5f0101000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+
+	testLegacyTx = "010000000456919960ac691763688d3d3bcea9ad6ecaf875df5339e148a1fc61c6ed7a069e010000006a47304402204585bcdef85e6b1c6af5c2669d4830ff86e42dd205c0e089bc2a821657e951c002201024a10366077f87d6bce1f7100ad8cfa8a064b39d4e8fe4ea13a7b71aa8180f012102f0da57e85eec2934a82a585ea337ce2f4998b50ae699dd79f5880e253dafafb7feffffffeb8f51f4038dc17e6313cf831d4f02281c2a468bde0fafd37f1bf882729e7fd3000000006a47304402207899531a52d59a6de200179928ca900254a36b8dff8bb75f5f5d71b1cdc26125022008b422690b8461cb52c3cc30330b23d574351872b7c361e9aae3649071c1a7160121035d5c93d9ac96881f19ba1f686f15f009ded7c62efe85a872e6a19b43c15a2937feffffff567bf40595119d1bb8a3037c356efd56170b64cbcc160fb028fa10704b45d775000000006a47304402204c7c7818424c7f7911da6cddc59655a70af1cb5eaf17c69dadbfc74ffa0b662f02207599e08bc8023693ad4e9527dc42c34210f7a7d1d1ddfc8492b654a11e7620a0012102158b46fbdff65d0172b7989aec8850aa0dae49abfb84c81ae6e5b251a58ace5cfeffffffd63a5e6c16e620f86f375925b21cabaf736c779f88fd04dcad51d26690f7f345010000006a47304402200633ea0d3314bea0d95b3cd8dadb2ef79ea8331ffe1e61f762c0f6daea0fabde022029f23b3e9c30f080446150b23852028751635dcee2be669c2a1686a4b5edf304012103ffd6f4a67e94aba353a00882e563ff2722eb4cff0ad6006e86ee20dfe7520d55feffffff0251430f00000000001976a914ab0c0b2e98b1ab6dbf67d4750b0a56244948a87988ac005a6202000000001976a9143c82d7df364eb6c75be8c80df2b3eda8db57397088ac46430600"
+)
+
+// Testnet block 1263442, a coinbase and a segwit spend, announced with nonce
+// 0x0123456789abcdef and the coinbase prefilled. The block and its hash are
+// from the BIP158 vectors, the payloads were built from it outside this
+// package with a reference SipHash.
+const (
+	testnetBlockHash = "000000006f27ddfe1dd680044a34548f41bed47eba9e6f0b310da21423bc5f33"
+
+	testnetCmpctBlock = "000000201c8d1a529c39a396db2db234d5ec152fa651a2872966daccbde028b400000000083f14492679151dbfaa1a825ef4c18518e780c1f91044180280a7d33f4a98ff5f45765aaddc001d38333b9aefcdab8967452301015d6b441a28ae0100010000000001010000000000000000000000000000000000000000000000000000000000000000ffffffff230352471300fe5f45765afe94690a000963676d696e6572343208000000000000000000ffffffff024423a804000000001976a914f2c25ac3d59f3d674b1d1d0a25c27339aaac0ba688ac0000000000000000266a24aa21a9edcb26cb3052426b9ebb4d19c819ef87c19677bbf3a7c46ef0855bd1b2abe834910120000000000000000000000000000000000000000000000000000000000000000000000000"
+
+	testnetGetBlockTxn = "335fbc2314a20d310b6f9eba7ed4be418f54344a0480d61dfedd276f000000000101"
+
+	testnetBlockTxn = "335fbc2314a20d310b6f9eba7ed4be418f54344a0480d61dfedd276f000000000102000000000101d20978463906ba4ff5e7192494b88dd5eb0de85d900ab253af909106faa22cc5010000000004000000014777ff000000000016001446c29eabe8208a33aa1023c741fa79aa92e881ff0347304402207d7ca96134f2bcfdd6b536536fdd39ad17793632016936f777ebb32c22943fda02206014d2fb8a6aa58279797f861042ba604ebd2f8f61e5bddbd9d3be5a245047b201004b632103eeaeba7ce5dc2470221e9517fb498e8d6bd4e73b85b8be655196972eb9ccd5566754b2752103a40b74d43df244799d041f32ce1ad515a6cd99501701540e38750d883ae21d3a68ac00000000"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("failed to decode because %s", err.Error())
+	}
+	return b
+}
+
+func parseTestCmpctBlock(t *testing.T) *messages.CmpctBlock {
+	cmpct, err := messages.ParseCmpctBlock(bytes.NewReader(decodeHex(t, testCmpctBlock)))
+	if err != nil {
+		t.Fatalf("failed to parse the compact block because %s", err.Error())
+	}
+	return cmpct
+}
+
+func parseTestBlockTxn(t *testing.T) *messages.BlockTxn {
+	txn, err := messages.ParseBlockTxn(bytes.NewReader(decodeHex(t, testBlockTxn)))
+	if err != nil {
+		t.Fatalf("failed to parse the blocktxn because %s", err.Error())
+	}
+	return txn
+}
+
+func parseTestLegacyTx(t *testing.T) *tx.Transaction {
+	legacy, err := tx.ParseTransaction(decodeHex(t, testLegacyTx))
+	if err != nil {
+		t.Fatalf("failed to parse the transaction because %s", err.Error())
+	}
+	return legacy
+}
+
+func TestReconstructFromMempool(t *testing.T) {
+	cmpct := parseTestCmpctBlock(t)
+	segwit := parseTestBlockTxn(t).Transactions[0]
+
+	partial, err := MakePartialBlock(cmpct, []*tx.Transaction{segwit, parseTestLegacyTx(t)})
+	if err != nil {
+		t.Fatalf("failed to start the block because %s", err.Error())
+	}
+	if partial.GetBlockTxn() != nil || partial.Prefilled != 1 || partial.FromMempool != 2 {
+		t.Fatalf("expected every transaction to be found, missing %v", partial.Missing())
+	}
+
+	b, err := partial.Block()
+	if err != nil {
+		t.Fatalf("failed to rebuild the block because %s", err.Error())
+	}
+	hash, _ := b.Hash()
+	if hex.EncodeToString(hash) != testBlockHash || len(b.Transactions) != 3 {
+		t.Fatalf("rebuilt the wrong block %x", hash)
+	}
+	if !b.Transactions[0].IsCoinbase() || !bytes.Equal(b.Transactions[2].Serialize(), segwit.Serialize()) {
+		t.Fatal("transactions are out of order")
+	}
+}
+
+func TestReconstructWithBlockTxn(t *testing.T) {
+	cmpct := parseTestCmpctBlock(t)
+
+	partial, err := MakePartialBlock(cmpct, []*tx.Transaction{parseTestLegacyTx(t)})
+	if err != nil {
+		t.Fatalf("failed to start the block because %s", err.Error())
+	}
+
+	request := partial.GetBlockTxn()
+	if request == nil || hex.EncodeToString(request.Serialize()) != testGetBlockTxn {
+		t.Fatalf("unexpected getblocktxn for missing %v", partial.Missing())
+	}
+	if _, err := partial.Block(); err == nil {
+		t.Fatal("built a block with missing transactions")
+	}
+
+	b, err := partial.Fill(parseTestBlockTxn(t))
+	if err != nil {
+		t.Fatalf("failed to fill the block because %s", err.Error())
+	}
+	hash, _ := b.Hash()
+	if hex.EncodeToString(hash) != testBlockHash || !b.VerifyMerkleRoot() {
+		t.Fatalf("rebuilt the wrong block %x", hash)
+	}
+}
+
+func TestFillMismatch(t *testing.T) {
+	cmpct := parseTestCmpctBlock(t)
+	legacy := parseTestLegacyTx(t)
+
+	// the answer has to be for the same block
+	partial, _ := MakePartialBlock(cmpct, []*tx.Transaction{legacy})
+	txn := parseTestBlockTxn(t)
+	txn.BlockHash = make([]byte, 32)
+	if _, err := partial.Fill(txn); err == nil {
+		t.Fatal("filled from the blocktxn of another block")
+	}
+
+	// and hold every missing transaction
+	partial, _ = MakePartialBlock(cmpct, nil)
+	if _, err := partial.Fill(parseTestBlockTxn(t)); err == nil {
+		t.Fatal("filled from a blocktxn missing transactions")
+	}
+
+	// the wrong transaction does not hash to the merkle root
+	partial, _ = MakePartialBlock(cmpct, []*tx.Transaction{legacy})
+	txn = parseTestBlockTxn(t)
+	txn.Transactions[0] = legacy
+	if _, err := partial.Fill(txn); !errors.Is(err, ErrBadReconstruction) {
+		t.Fatalf("expected a bad reconstruction, got %v", err)
+	}
+}
+
+func TestShortIDCollision(t *testing.T) {
+	cmpct := parseTestCmpctBlock(t)
+	cmpct.ShortIDs[1] = cmpct.ShortIDs[0]
+
+	if _, err := MakePartialBlock(cmpct, nil); !errors.Is(err, ErrShortIDCollision) {
+		t.Fatalf("expected a short id collision, got %v", err)
+	}
+}
+
+func TestPrefilledOutOfRange(t *testing.T) {
+	cmpct := parseTestCmpctBlock(t)
+	cmpct.Prefilled[0].Index = cmpct.TxCount()
+
+	if _, err := MakePartialBlock(cmpct, nil); err == nil {
+		t.Fatal("accepted a prefilled transaction past the end of the block")
+	}
+}
+
+func TestReconstructTestnetBlock(t *testing.T) {
+	cmpct, err := messages.ParseCmpctBlock(bytes.NewReader(decodeHex(t, testnetCmpctBlock)))
+	if err != nil {
+		t.Fatalf("failed to parse the compact block because %s", err.Error())
+	}
+	if hex.EncodeToString(cmpct.Serialize()) != testnetCmpctBlock {
+		t.Fatal("compact block did not round trip")
+	}
+	txn, err := messages.ParseBlockTxn(bytes.NewReader(decodeHex(t, testnetBlockTxn)))
+	if err != nil {
+		t.Fatalf("failed to parse the blocktxn because %s", err.Error())
+	}
+	segwit := txn.Transactions[0]
+
+	// the spend is found in the mempool by its wtxid
+	partial, err := MakePartialBlock(cmpct, []*tx.Transaction{segwit})
+	if err != nil {
+		t.Fatalf("failed to start the block because %s", err.Error())
+	}
+	b, err := partial.Block()
+	if err != nil || partial.FromMempool != 1 {
+		t.Fatalf("failed to rebuild the block from the mempool, missing %v", partial.Missing())
+	}
+	hash, _ := b.Hash()
+	if hex.EncodeToString(hash) != testnetBlockHash {
+		t.Fatalf("rebuilt the wrong block %x", hash)
+	}
+
+	// without the mempool the spend is asked for and filled in
+	partial, _ = MakePartialBlock(cmpct, nil)
+	request := partial.GetBlockTxn()
+	if request == nil || hex.EncodeToString(request.Serialize()) != testnetGetBlockTxn {
+		t.Fatalf("unexpected getblocktxn for missing %v", partial.Missing())
+	}
+	b, err = partial.Fill(txn)
+	if err != nil {
+		t.Fatalf("failed to fill the block because %s", err.Error())
+	}
+	hash, _ = b.Hash()
+	if hex.EncodeToString(hash) != testnetBlockHash || !b.VerifyMerkleRoot() {
+		t.Fatalf("rebuilt the wrong block %x", hash)
+	}
+}
@@ -0,0 +1,237 @@
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const (
+	// magic, command, payload length and checksum
+	HEADER_SIZE = 4 + 12 + 4 + 4
+
+	// Largest payload accepted from a peer, the same limit as Bitcoin Core
+	MAX_PAYLOAD_SIZE = 4 * 1000 * 1000
+
+	// Most bytes skipped looking for the magic before giving up on the peer
+	MAX_RESYNC_SIZE = MAX_PAYLOAD_SIZE + HEADER_SIZE
+
+	// buffer between the connection and the reader
+	READ_BUFFER_SIZE = 64 * 1024
+
+	// Most bytes allocated for a payload before any of it has arrived. Larger
+	// payloads grow as their bytes are read
+	PAYLOAD_CHUNK_SIZE = 64 * 1024
+)
+
+var (
+	// The payload length in the header is larger than allowed. Nothing is
+	// read past the header so the stream can not be used anymore
+	ErrPayloadTooLarge = errors.New("payload is too large")
+
+	// The payload does not match the checksum in the header. The whole
+	// message was read so the next one can be read as usual
+	ErrChecksumMismatch = errors.New("checksums do not match")
+
+	// The stream does not start with the network magic
+	ErrBadMagic = errors.New("magic is not correct")
+)
+
+func networkMagic(testnet bool) []byte {
+	if testnet {
+		return TESTNET_NETWORK_MAGIC[:]
+	}
+	return MAINNET_NETWORK_MAGIC[:]
+}
+
+// Reads an envelope, only allocating the payload once its length is known to
+// be within the limit. When resync is set, bytes are skipped until the magic
+// is found, otherwise a bad magic is an error. Returns the number of bytes
+// skipped along with the envelope
+func readEnvelope(reader io.Reader, magic []byte, maxPayload uint32, resync bool) (*Envelope, int, error) {
+	header := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, 0, err
+	}
+
+	skipped := 0
+	for !bytes.Equal(header[:4], magic) {
+		if !resync || skipped >= MAX_RESYNC_SIZE {
+			return nil, skipped, fmt.Errorf("%w, read %x expected %x", ErrBadMagic, header[:4], magic)
+		}
+
+		// slide the header up to the next byte which could start the magic,
+		// refilling the end so nothing past the header is read
+		n := bytes.IndexByte(header[1:], magic[0]) + 1
+		if n == 0 {
+			n = HEADER_SIZE
+		}
+		copy(header, header[n:])
+		if _, err := io.ReadFull(reader, header[HEADER_SIZE-n:]); err != nil {
+			return nil, skipped, err
+		}
+		skipped += n
+	}
+
+	// the length is little endian and checked before allocating the payload
+	length := binary.LittleEndian.Uint32(header[16:20])
+	if length > maxPayload {
+		return nil, skipped, fmt.Errorf("%w, %d bytes is over the limit of %d", ErrPayloadTooLarge, length, maxPayload)
+	}
+
+	payload, err := readPayload(reader, length)
+	if err != nil {
+		return nil, skipped, err
+	}
+
+	// the checksum is the first 4 bytes of the hash256 of the payload
+	checksum := utils.Hash256(payload)[:4]
+	if !bytes.Equal(checksum, header[20:24]) {
+		return nil, skipped, fmt.Errorf("%w, %x vs %x", ErrChecksumMismatch, header[20:24], checksum)
+	}
+
+	return &Envelope{
+		Command: append([]byte{}, header[4:16]...),
+		Payload: payload,
+		Magic:   append([]byte{}, header[:4]...),
+	}, skipped, nil
+}
+
+// Reads a payload of the given length. The length comes from the peer, so
+// memory follows the bytes received rather than the length claimed
+func readPayload(reader io.Reader, length uint32) ([]byte, error) {
+	if length <= PAYLOAD_CHUNK_SIZE {
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return payload, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(PAYLOAD_CHUNK_SIZE)
+	n, err := buf.ReadFrom(io.LimitReader(reader, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if n < int64(length) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+// Counters of a Reader, for monitoring the peer
+type ReaderStats struct {
+	// bytes taken off the stream, including the ones skipped
+	BytesRead uint64
+
+	// envelopes read successfully
+	MessagesRead uint64
+
+	// bytes skipped looking for the magic
+	BytesSkipped uint64
+
+	// envelopes dropped because the checksum did not match
+	ChecksumFailures uint64
+}
+
+// Buffered reader of the envelopes sent by a peer. Payloads are bounded by
+// MaxPayloadSize and checksummed. Once a valid envelope has been read, a bad
+// magic is treated as corruption and the reader skips ahead to the next magic.
+// A stream which never starts with the magic is not a peer we can talk to and
+// fails with ErrBadMagic.
+//
+// Reads are not safe to call concurrently, Stats can be called at any time
+type Reader struct {
+	reader *bufio.Reader
+	source io.Reader
+	magic  []byte
+
+	// Largest payload accepted, MAX_PAYLOAD_SIZE unless changed before reading
+	MaxPayloadSize uint32
+
+	bytesRead        uint64
+	messagesRead     uint64
+	bytesSkipped     uint64
+	checksumFailures uint64
+}
+
+func MakeReader(reader io.Reader, testnet bool) *Reader {
+	return &Reader{
+		reader:         bufio.NewReaderSize(reader, READ_BUFFER_SIZE),
+		source:         reader,
+		magic:          networkMagic(testnet),
+		MaxPayloadSize: MAX_PAYLOAD_SIZE,
+	}
+}
+
+// counts the bytes taken off the buffer
+type countingReader struct {
+	reader io.Reader
+	count  *uint64
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	atomic.AddUint64(c.count, uint64(n))
+	return n, err
+}
+
+// Reads the next envelope. A checksum mismatch leaves the stream at the next
+// envelope, any other error means the stream can not be read anymore
+func (r *Reader) Read() (*Envelope, error) {
+	resync := atomic.LoadUint64(&r.messagesRead) > 0
+
+	env, skipped, err := readEnvelope(countingReader{r.reader, &r.bytesRead}, r.magic, r.MaxPayloadSize, resync)
+	atomic.AddUint64(&r.bytesSkipped, uint64(skipped))
+	if err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			atomic.AddUint64(&r.checksumFailures, 1)
+		}
+		return nil, err
+	}
+
+	atomic.AddUint64(&r.messagesRead, 1)
+	return env, nil
+}
+
+// Reads the next envelope, giving up when the context is cancelled or its
+// deadline passes. The deadline is only applied to sources which support
+// deadlines, such as a net.Conn. Timeouts are returned as a *TimeoutError
+func (r *Reader) ReadContext(ctx context.Context) (*Envelope, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ContextError(ctx, "read", err)
+	}
+
+	if conn, ok := r.source.(interface{ SetReadDeadline(time.Time) error }); ok {
+		stop := WatchContext(ctx, conn.SetReadDeadline)
+		defer stop()
+	}
+
+	env, err := r.Read()
+	if err != nil {
+		return nil, ContextError(ctx, "read", err)
+	}
+	return env, nil
+}
+
+// Snapshot of the counters
+func (r *Reader) Stats() ReaderStats {
+	return ReaderStats{
+		BytesRead:        atomic.LoadUint64(&r.bytesRead),
+		MessagesRead:     atomic.LoadUint64(&r.messagesRead),
+		BytesSkipped:     atomic.LoadUint64(&r.bytesSkipped),
+		ChecksumFailures: atomic.LoadUint64(&r.checksumFailures),
+	}
+}
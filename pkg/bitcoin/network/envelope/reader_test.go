@@ -0,0 +1,177 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReaderReadsStream(t *testing.T) {
+	first := Make([]byte("ping"), []byte{1, 2, 3, 4, 5, 6, 7, 8}, true).Serialize()
+	second := Make([]byte("verack"), nil, true).Serialize()
+
+	r := MakeReader(bytes.NewReader(append(append([]byte{}, first...), second...)), true)
+
+	env, err := r.Read()
+	if err != nil || string(env.Command[:4]) != "ping" || len(env.Payload) != 8 {
+		t.Fatalf("failed to read the first envelope, %v", err)
+	}
+	env, err = r.Read()
+	if err != nil || string(env.Command[:6]) != "verack" {
+		t.Fatalf("failed to read the second envelope, %v", err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("expected the end of the stream, got %v", err)
+	}
+
+	stats := r.Stats()
+	if stats.MessagesRead != 2 || stats.BytesRead != uint64(len(first)+len(second)) || stats.BytesSkipped != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestReaderPayloadTooLarge(t *testing.T) {
+	// only the header is sent, claiming a payload of 4GB
+	header := Make([]byte("block"), nil, false).Serialize()
+	binary.LittleEndian.PutUint32(header[16:20], 0xffffffff)
+
+	_, err := MakeReader(bytes.NewReader(header), false).Read()
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected the payload to be rejected, got %v", err)
+	}
+
+	// the limit can be lowered
+	r := MakeReader(bytes.NewReader(Make([]byte("tx"), make([]byte, 100), false).Serialize()), false)
+	r.MaxPayloadSize = 99
+	if _, err := r.Read(); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected the payload to be rejected, got %v", err)
+	}
+}
+
+func TestReaderPayloadFollowsBytesReceived(t *testing.T) {
+	// only the header is sent, claiming the largest payload allowed
+	header := Make([]byte("block"), nil, false).Serialize()
+	binary.LittleEndian.PutUint32(header[16:20], MAX_PAYLOAD_SIZE)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := MakeReader(bytes.NewReader(append(header, make([]byte, 1000)...)), false).Read()
+	runtime.ReadMemStats(&after)
+
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected a truncated payload, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >= MAX_PAYLOAD_SIZE/4 {
+		t.Fatalf("allocated %d bytes for 1000 bytes of payload", allocated)
+	}
+
+	// large payloads still arrive whole
+	payload := make([]byte, 3*PAYLOAD_CHUNK_SIZE+1)
+	payload[len(payload)-1] = 1
+	env, err := MakeReader(bytes.NewReader(Make([]byte("block"), payload, false).Serialize()), false).Read()
+	if err != nil || !bytes.Equal(env.Payload, payload) {
+		t.Fatalf("failed to read a large payload, %v", err)
+	}
+}
+
+func TestReaderChecksumMismatch(t *testing.T) {
+	bad := Make([]byte("tx"), []byte("payload"), true).Serialize()
+	bad[len(bad)-1] ^= 0xff
+	good := Make([]byte("verack"), nil, true).Serialize()
+
+	r := MakeReader(bytes.NewReader(append(bad, good...)), true)
+
+	if _, err := r.Read(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	// the stream is still in step
+	env, err := r.Read()
+	if err != nil || string(env.Command[:6]) != "verack" {
+		t.Fatalf("failed to read after a checksum mismatch, %v", err)
+	}
+
+	stats := r.Stats()
+	if stats.ChecksumFailures != 1 || stats.MessagesRead != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestReaderResync(t *testing.T) {
+	first := Make([]byte("verack"), nil, false).Serialize()
+	second := Make([]byte("ping"), []byte("12345678"), false).Serialize()
+
+	// garbage holding the first byte of the magic between the envelopes
+	garbage := []byte{0xf9, 0x00, 0xf9, 0xbe, 0x01, 0x02, 0x03}
+
+	stream := append(append(append([]byte{}, first...), garbage...), second...)
+	r := MakeReader(bytes.NewReader(stream), false)
+
+	if _, err := r.Read(); err != nil {
+		t.Fatalf("failed to read the first envelope, %v", err)
+	}
+	env, err := r.Read()
+	if err != nil || string(env.Command[:4]) != "ping" {
+		t.Fatalf("failed to resync, %v", err)
+	}
+
+	stats := r.Stats()
+	if stats.BytesSkipped != uint64(len(garbage)) || stats.BytesRead != uint64(len(stream)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestReaderBadMagic(t *testing.T) {
+	// a stream which never started with the magic is not resynced
+	stream := append([]byte{0x00}, Make([]byte("verack"), nil, false).Serialize()...)
+
+	_, err := MakeReader(bytes.NewReader(stream), false).Read()
+	if !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("expected a bad magic, got %v", err)
+	}
+
+	// neither is a mainnet stream read as testnet
+	_, err = MakeReader(bytes.NewReader(stream[1:]), true).Read()
+	if !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("expected a bad magic, got %v", err)
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	msg := Make([]byte("ping"), []byte("12345678"), false).Serialize()
+
+	if _, err := Parse(bytes.NewReader(msg[:10]), false); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected a truncated header, got %v", err)
+	}
+	if _, err := Parse(bytes.NewReader(msg[:len(msg)-1]), false); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected a truncated payload, got %v", err)
+	}
+}
+
+func TestReaderReadContextTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	r := MakeReader(local, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := r.ReadContext(ctx); !IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// the deadline is cleared afterwards
+	go remote.Write(Make([]byte("verack"), nil, true).Serialize())
+	env, err := r.ReadContext(context.Background())
+	if err != nil || string(env.Command[:6]) != "verack" {
+		t.Fatalf("failed to read after a timeout, %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package envelope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Returned when a read or write on the connection to a peer does not complete
+// in time, either because the deadline of the context passed or the deadline
+// set on the connection did
+type TimeoutError struct {
+	// operation which timed out, read, write or the command waited for
+	Op string
+
+	// underlying error, context.DeadlineExceeded or the network error
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out: %s", e.Op, e.Err.Error())
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Satisfies net.Error so callers checking for timeouts the usual way work
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// Checks if the error is a timeout talking to a peer
+func IsTimeout(err error) bool {
+	var timeout *TimeoutError
+	return errors.As(err, &timeout)
+}
+
+// Applies the deadline of the context to the connection and forces the
+// deadline into the past if the context is cancelled first. The returned
+// function must be called once the operation completes to clear the deadline
+func WatchContext(ctx context.Context, setDeadline func(time.Time) error) func() {
+	deadline, _ := ctx.Deadline()
+	setDeadline(deadline)
+
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			setDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		setDeadline(time.Time{})
+	}
+}
+
+// Converts an error from the connection into the reason the operation was
+// stopped, the cancellation of the context or a typed timeout
+func ContextError(ctx context.Context, op string, err error) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return ctx.Err()
+	case context.DeadlineExceeded:
+		return &TimeoutError{Op: op, Err: ctx.Err()}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &TimeoutError{Op: op, Err: err}
+	}
+	return err
+}
+
+// Reads an envelope from the connection, giving up when the context is
+// cancelled or its deadline passes. Timeouts are returned as a *TimeoutError
+func ParseSocketContext(ctx context.Context, reader net.Conn, testnet bool) (*Envelope, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ContextError(ctx, "read", err)
+	}
+
+	stop := WatchContext(ctx, reader.SetReadDeadline)
+	env, err := ParseSocket(reader, testnet)
+	stop()
+
+	if err != nil {
+		return nil, ContextError(ctx, "read", err)
+	}
+	return env, nil
+}
+
+// Writes the envelope to the connection, giving up when the context is
+// cancelled or its deadline passes. Timeouts are returned as a *TimeoutError
+func WriteSocketContext(ctx context.Context, writer net.Conn, env *Envelope) error {
+	if err := ctx.Err(); err != nil {
+		return ContextError(ctx, "write", err)
+	}
+
+	stop := WatchContext(ctx, writer.SetWriteDeadline)
+	_, err := writer.Write(env.Serialize())
+	stop()
+
+	if err != nil {
+		return ContextError(ctx, "write", err)
+	}
+	return nil
+}
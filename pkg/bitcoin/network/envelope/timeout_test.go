@@ -0,0 +1,68 @@
+package envelope
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseSocketContextTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := ParseSocketContext(ctx, local, true)
+	if !IsTimeout(err) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatal("timeout is not a net.Error timeout")
+	}
+
+	// the deadline is cleared afterwards
+	env := Make([]byte("verack"), nil, true)
+	go remote.Write(env.Serialize())
+	parsed, err := ParseSocketContext(context.Background(), local, true)
+	if err != nil || string(parsed.Command[:6]) != "verack" {
+		t.Fatalf("failed to read after a timeout, %v", err)
+	}
+}
+
+func TestParseSocketContextCancel(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := ParseSocketContext(ctx, local, true)
+	if err != context.Canceled {
+		t.Fatalf("expected the read to be cancelled, got %v", err)
+	}
+}
+
+func TestWriteSocketContextTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// nobody reads the other end of the pipe, so the write stalls
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := WriteSocketContext(ctx, local, Make([]byte("verack"), nil, true))
+	if !IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_ADDR Command = "addr"
+const COMMAND_GETADDR Command = "getaddr"
+
+// Legacy address relay, only IPv4, IPv6 and tor v2 addresses can be sent
+type Addr struct {
+	Addresses []*NetworkAddress
+}
+
+func MakeAddr(addresses []*NetworkAddress) *Addr {
+	return &Addr{Addresses: addresses}
+}
+
+func ParseAddr(reader *bytes.Reader) (*Addr, error) {
+	a := &Addr{}
+
+	count := utils.ReadVarIntFromBytes(reader)
+	if count > MAX_ADDR_TO_SEND {
+		return nil, fmt.Errorf("received %d addresses, more than the maximum of %d", count, MAX_ADDR_TO_SEND)
+	}
+
+	for i := 0; i < int(count); i++ {
+		address, err := ReadNetworkAddress(reader, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse address %d because %s", i, err.Error())
+		}
+		a.Addresses = append(a.Addresses, address)
+	}
+
+	return a, nil
+}
+
+// Serializes the addresses which fit the legacy format, the rest are left out
+func (a *Addr) Serialize() []byte {
+	var addresses []byte
+	count := 0
+	for _, address := range a.Addresses {
+		s, err := address.Serialize(true)
+		if err != nil {
+			continue
+		}
+		addresses = append(addresses, s...)
+		count++
+	}
+
+	result, _ := utils.EncodeUVarInt(uint64(count))
+	return append(result, addresses...)
+}
+
+func (a Addr) GetCommand() Command {
+	return COMMAND_ADDR
+}
+
+// Request for the addresses of peers known to the remote peer
+type GetAddr struct{}
+
+func (g *GetAddr) Serialize() []byte {
+	return nil
+}
+
+func (g GetAddr) GetCommand() Command {
+	return COMMAND_GETADDR
+}
@@ -0,0 +1,49 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+func TestParseAddr(t *testing.T) {
+	// single address from the protocol documentation
+	raw, _ := hex.DecodeString("01" + "e215104d" + "0100000000000000" + "00000000000000000000ffff0a000001" + "208d")
+
+	a, err := ParseAddr(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse addr because %s", err.Error())
+	}
+
+	if len(a.Addresses) != 1 {
+		t.Fatalf("expected 1 address, got %d", len(a.Addresses))
+	}
+
+	address := a.Addresses[0]
+	if address.Time != 0x4d1015e2 || address.Services != NODE_NETWORK || address.String() != "10.0.0.1:8333" {
+		t.Fatalf("address parsed incorrectly %s", address)
+	}
+
+	if !bytes.Equal(a.Serialize(), raw) {
+		t.Fatal("failed to round trip the addr message")
+	}
+}
+
+func TestAddrSkipsAddrV2Only(t *testing.T) {
+	onion, _ := ParseNetworkAddress("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion", 8333, 0)
+
+	a := MakeAddr([]*NetworkAddress{
+		onion,
+		MakeNetworkAddress(net.ParseIP("2001:db8::1"), 8333, 0),
+	})
+
+	parsed, err := ParseAddr(bytes.NewReader(a.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse addr because %s", err.Error())
+	}
+
+	if len(parsed.Addresses) != 1 || parsed.Addresses[0].NetworkId != NETWORK_IPV6 {
+		t.Fatal("tor v3 address should have been left out")
+	}
+}
@@ -0,0 +1,78 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_ADDRV2 Command = "addrv2"
+const COMMAND_SENDADDRV2 Command = "sendaddrv2"
+
+// Most addresses which can be relayed in a single addr or addrv2 message
+const MAX_ADDR_TO_SEND = 1000
+
+// BIP155 address relay supporting tor v3, i2p and cjdns addresses
+type AddrV2 struct {
+	Addresses []*NetworkAddress
+}
+
+func MakeAddrV2(addresses []*NetworkAddress) *AddrV2 {
+	return &AddrV2{Addresses: addresses}
+}
+
+// Parses an addrv2 message. Addresses of networks we don't know about are
+// dropped as required by BIP155.
+func ParseAddrV2(reader *bytes.Reader) (*AddrV2, error) {
+	a := &AddrV2{}
+
+	count := utils.ReadVarIntFromBytes(reader)
+	if count > MAX_ADDR_TO_SEND {
+		return nil, fmt.Errorf("received %d addresses, more than the maximum of %d", count, MAX_ADDR_TO_SEND)
+	}
+
+	for i := 0; i < int(count); i++ {
+		address, err := ReadNetworkAddressV2(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse address %d because %s", i, err.Error())
+		}
+		if !address.IsValid() {
+			continue
+		}
+		a.Addresses = append(a.Addresses, address)
+	}
+
+	return a, nil
+}
+
+func (a *AddrV2) Serialize() []byte {
+	var addresses []byte
+	count := 0
+	for _, address := range a.Addresses {
+		s, err := address.SerializeV2()
+		if err != nil {
+			continue
+		}
+		addresses = append(addresses, s...)
+		count++
+	}
+
+	result, _ := utils.EncodeUVarInt(uint64(count))
+	return append(result, addresses...)
+}
+
+func (a AddrV2) GetCommand() Command {
+	return COMMAND_ADDRV2
+}
+
+// Signals support for addrv2 messages. Sent between version and verack
+type SendAddrV2 struct{}
+
+func (s *SendAddrV2) Serialize() []byte {
+	return nil
+}
+
+func (s SendAddrV2) GetCommand() Command {
+	return COMMAND_SENDADDRV2
+}
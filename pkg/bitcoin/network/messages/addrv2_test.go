@@ -0,0 +1,46 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+func TestAddrV2(t *testing.T) {
+	onion, err := ParseNetworkAddress("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion", 8333, NODE_NETWORK)
+	if err != nil {
+		t.Fatalf("failed to parse the onion address because %s", err.Error())
+	}
+
+	a := MakeAddrV2([]*NetworkAddress{
+		MakeNetworkAddress(net.ParseIP("1.2.3.4"), 8333, NODE_NETWORK),
+		onion,
+	})
+
+	parsed, err := ParseAddrV2(bytes.NewReader(a.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse addrv2 because %s", err.Error())
+	}
+
+	if len(parsed.Addresses) != 2 || parsed.Addresses[1].Host() != onion.Host() {
+		t.Fatal("addrv2 parsed incorrectly")
+	}
+}
+
+func TestAddrV2UnknownNetwork(t *testing.T) {
+	// an address on network 0x42 followed by an IPv4 address. The unknown
+	// network is skipped and the IPv4 address kept
+	raw, _ := hex.DecodeString("02" +
+		"00000000" + "00" + "42" + "03" + "010203" + "0000" +
+		"00000000" + "01" + "01" + "04" + "01020304" + "208d")
+
+	parsed, err := ParseAddrV2(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse addrv2 because %s", err.Error())
+	}
+
+	if len(parsed.Addresses) != 1 || parsed.Addresses[0].String() != "1.2.3.4:8333" {
+		t.Fatal("addrv2 parsed incorrectly")
+	}
+}
@@ -0,0 +1,58 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_BLOCKTXN Command = "blocktxn"
+
+// Transactions of a compact block asked for with getblocktxn, in the order
+// they were asked for
+type BlockTxn struct {
+	// 32 bytes, stored big endian and sent over the wire little endian
+	BlockHash []byte
+
+	Transactions []*tx.Transaction
+}
+
+func ParseBlockTxn(reader *bytes.Reader) (*BlockTxn, error) {
+	hash, err := parseBlockHash(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	count := utils.ReadVarIntFromBytes(reader)
+	if count > MAX_CMPCT_BLOCK_TXS || count > uint64(reader.Len()/60) {
+		return nil, fmt.Errorf("blocktxn message claims %d transactions which do not fit the remaining data", count)
+	}
+
+	b := &BlockTxn{BlockHash: hash}
+	for i := 0; i < int(count); i++ {
+		t, err := tx.ReadTransaction(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse transaction %d because %s", i, err.Error())
+		}
+		b.Transactions = append(b.Transactions, t)
+	}
+
+	return b, nil
+}
+
+func (b *BlockTxn) Serialize() []byte {
+	result := utils.ImmutableReorderBytes(b.BlockHash)
+
+	count, _ := utils.EncodeUVarInt(uint64(len(b.Transactions)))
+	result = append(result, count...)
+	for _, t := range b.Transactions {
+		result = append(result, t.Serialize()...)
+	}
+	return result
+}
+
+func (b BlockTxn) GetCommand() Command {
+	return COMMAND_BLOCKTXN
+}
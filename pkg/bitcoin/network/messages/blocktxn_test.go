@@ -0,0 +1,29 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+)
+
+func TestBlockTxn(t *testing.T) {
+	raw, _ := hex.DecodeString(testCoinbaseTx)
+	coinbase, _ := tx.ParseTransaction(raw)
+
+	hash := bytes.Repeat([]byte{0xcd}, 32)
+	b := &BlockTxn{BlockHash: hash, Transactions: []*tx.Transaction{coinbase, coinbase}}
+
+	parsed, err := ParseBlockTxn(bytes.NewReader(b.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse the blocktxn because %s", err.Error())
+	}
+	if !bytes.Equal(parsed.BlockHash, hash) || len(parsed.Transactions) != 2 || !bytes.Equal(parsed.Transactions[1].Serialize(), raw) {
+		t.Fatal("blocktxn did not round trip")
+	}
+
+	if _, err := ParseBlockTxn(bytes.NewReader(b.Serialize()[:100])); err == nil {
+		t.Fatal("parsed a truncated blocktxn")
+	}
+}
@@ -0,0 +1,114 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_GETCFCHECKPT Command = "getcfcheckpt"
+const COMMAND_CFCHECKPT Command = "cfcheckpt"
+
+// Number of blocks between each filter header checkpoint
+const CFCHECKPT_INTERVAL = 1000
+
+// Request for the filter header checkpoints up to a block (BIP157)
+type GetCFCheckpt struct {
+	// 1 byte - Type of the filter headers requested
+	FilterType byte
+
+	// 32 bytes - Hash of the last block, big endian
+	StopHash []byte
+}
+
+func MakeGetCFCheckpt(filterType byte, stopHash []byte) *GetCFCheckpt {
+	return &GetCFCheckpt{
+		FilterType: filterType,
+		StopHash:   stopHash,
+	}
+}
+
+func ParseGetCFCheckpt(reader *bytes.Reader) (*GetCFCheckpt, error) {
+	g := &GetCFCheckpt{}
+
+	var err error
+	if g.FilterType, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	if g.StopHash, err = readHash(reader); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (g *GetCFCheckpt) Serialize() []byte {
+	result := []byte{g.FilterType}
+	result = append(result, utils.ImmutableReorderBytes(g.StopHash)...)
+	return result
+}
+
+func (g GetCFCheckpt) GetCommand() Command {
+	return COMMAND_GETCFCHECKPT
+}
+
+// Filter headers at every CFCHECKPT_INTERVAL blocks up to the stop hash,
+// sent in response to a getcfcheckpt
+type CFCheckpt struct {
+	// 1 byte - Type of the filter
+	FilterType byte
+
+	// 32 bytes - Hash of the last block, big endian
+	StopHash []byte
+
+	// Filter headers at heights 1000, 2000, ..., big endian
+	FilterHeaders [][]byte
+}
+
+func ParseCFCheckpt(reader *bytes.Reader) (*CFCheckpt, error) {
+	c := &CFCheckpt{}
+
+	var err error
+	if c.FilterType, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	if c.StopHash, err = readHash(reader); err != nil {
+		return nil, err
+	}
+
+	// each header is 32 bytes, so make sure the count is sane
+	count := utils.ReadVarIntFromBytes(reader)
+	if count > uint64(reader.Len()/32) {
+		return nil, fmt.Errorf("%d filter headers do not fit the remaining data", count)
+	}
+
+	for i := 0; i < int(count); i++ {
+		header, err := readHash(reader)
+		if err != nil {
+			return nil, err
+		}
+		c.FilterHeaders = append(c.FilterHeaders, header)
+	}
+
+	return c, nil
+}
+
+func (c *CFCheckpt) Serialize() []byte {
+	result := []byte{c.FilterType}
+	result = append(result, utils.ImmutableReorderBytes(c.StopHash)...)
+
+	count, _ := utils.EncodeUVarInt(uint64(len(c.FilterHeaders)))
+	result = append(result, count...)
+	for _, header := range c.FilterHeaders {
+		result = append(result, utils.ImmutableReorderBytes(header)...)
+	}
+
+	return result
+}
+
+func (c CFCheckpt) GetCommand() Command {
+	return COMMAND_CFCHECKPT
+}
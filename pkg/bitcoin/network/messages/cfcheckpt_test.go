@@ -0,0 +1,45 @@
+package messages
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+func TestCFCheckpt(t *testing.T) {
+	stopHash := utils.Hash256([]byte("stop"))
+
+	g := MakeGetCFCheckpt(block.BASIC_FILTER_TYPE, stopHash)
+	if len(g.Serialize()) != 33 {
+		t.Fatalf("unexpected getcfcheckpt length %d", len(g.Serialize()))
+	}
+
+	parsedGet, err := ParseGetCFCheckpt(bytes.NewReader(g.Serialize()))
+	if err != nil || !bytes.Equal(parsedGet.StopHash, stopHash) {
+		t.Fatal("getcfcheckpt parsed incorrectly")
+	}
+
+	c := &CFCheckpt{
+		FilterType:    block.BASIC_FILTER_TYPE,
+		StopHash:      stopHash,
+		FilterHeaders: [][]byte{utils.Hash256([]byte{1}), utils.Hash256([]byte{2})},
+	}
+
+	parsed, err := ParseCFCheckpt(bytes.NewReader(c.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse cfcheckpt because %s", err.Error())
+	}
+
+	if len(parsed.FilterHeaders) != 2 || !bytes.Equal(parsed.FilterHeaders[1], c.FilterHeaders[1]) {
+		t.Fatal("cfcheckpt parsed incorrectly")
+	}
+
+	// claiming more headers than were sent must fail
+	raw := c.Serialize()
+	raw[33] = 0x03
+	if _, err := ParseCFCheckpt(bytes.NewReader(raw)); err == nil {
+		t.Fatal("parsed a truncated cfcheckpt")
+	}
+}
@@ -0,0 +1,144 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_GETCFHEADERS Command = "getcfheaders"
+const COMMAND_CFHEADERS Command = "cfheaders"
+
+// Maximum number of filter headers which can be requested in a single getcfheaders
+const MAX_GETCFHEADERS_SIZE = 2000
+
+// Request for the filter headers of a range of blocks (BIP157)
+type GetCFHeaders struct {
+	// 1 byte - Type of the filter headers requested
+	FilterType byte
+
+	// 4 bytes little endian - Height of the first block in the range
+	StartHeight uint32
+
+	// 32 bytes - Hash of the last block in the range, big endian
+	StopHash []byte
+}
+
+func MakeGetCFHeaders(filterType byte, startHeight uint32, stopHash []byte) *GetCFHeaders {
+	return &GetCFHeaders{
+		FilterType:  filterType,
+		StartHeight: startHeight,
+		StopHash:    stopHash,
+	}
+}
+
+func ParseGetCFHeaders(reader *bytes.Reader) (*GetCFHeaders, error) {
+	g := &GetCFHeaders{}
+
+	var err error
+	if g.FilterType, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	if reader.Len() < 4 {
+		return nil, fmt.Errorf("truncated start height")
+	}
+	g.StartHeight = utils.LittleEndianToUInt32(reader)
+
+	if g.StopHash, err = readHash(reader); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (g *GetCFHeaders) Serialize() []byte {
+	result := []byte{g.FilterType}
+	result = append(result, utils.UInt32ToLittleEndianBytes(g.StartHeight)...)
+	result = append(result, utils.ImmutableReorderBytes(g.StopHash)...)
+	return result
+}
+
+func (g GetCFHeaders) GetCommand() Command {
+	return COMMAND_GETCFHEADERS
+}
+
+// Filter hashes of a range of blocks along with the filter header of the
+// block before the range, sent in response to a getcfheaders
+type CFHeaders struct {
+	// 1 byte - Type of the filter
+	FilterType byte
+
+	// 32 bytes - Hash of the last block in the range, big endian
+	StopHash []byte
+
+	// 32 bytes - Filter header of the block before the range, big endian
+	PreviousFilterHeader []byte
+
+	// Filter hashes of each block in the range, big endian
+	FilterHashes [][]byte
+}
+
+func ParseCFHeaders(reader *bytes.Reader) (*CFHeaders, error) {
+	c := &CFHeaders{}
+
+	var err error
+	if c.FilterType, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	if c.StopHash, err = readHash(reader); err != nil {
+		return nil, err
+	}
+
+	if c.PreviousFilterHeader, err = readHash(reader); err != nil {
+		return nil, err
+	}
+
+	count := utils.ReadVarIntFromBytes(reader)
+	if count > MAX_GETCFHEADERS_SIZE {
+		return nil, fmt.Errorf("received %d filter hashes, more than the maximum of %d", count, MAX_GETCFHEADERS_SIZE)
+	}
+
+	for i := 0; i < int(count); i++ {
+		hash, err := readHash(reader)
+		if err != nil {
+			return nil, err
+		}
+		c.FilterHashes = append(c.FilterHashes, hash)
+	}
+
+	return c, nil
+}
+
+func (c *CFHeaders) Serialize() []byte {
+	result := []byte{c.FilterType}
+	result = append(result, utils.ImmutableReorderBytes(c.StopHash)...)
+	result = append(result, utils.ImmutableReorderBytes(c.PreviousFilterHeader)...)
+
+	count, _ := utils.EncodeUVarInt(uint64(len(c.FilterHashes)))
+	result = append(result, count...)
+	for _, hash := range c.FilterHashes {
+		result = append(result, utils.ImmutableReorderBytes(hash)...)
+	}
+
+	return result
+}
+
+// Chains the filter hashes onto the previous filter header returning the
+// filter header of each block in the range, big endian
+func (c *CFHeaders) FilterHeaders() [][]byte {
+	headers := make([][]byte, 0, len(c.FilterHashes))
+	previous := c.PreviousFilterHeader
+	for _, hash := range c.FilterHashes {
+		previous = block.FilterHeader(hash, previous)
+		headers = append(headers, previous)
+	}
+	return headers
+}
+
+func (c CFHeaders) GetCommand() Command {
+	return COMMAND_CFHEADERS
+}
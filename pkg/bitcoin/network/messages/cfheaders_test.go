@@ -0,0 +1,56 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+func TestGetCFHeadersSerialize(t *testing.T) {
+	stopHash := utils.Hash256([]byte("stop"))
+	g := MakeGetCFHeaders(block.BASIC_FILTER_TYPE, 1000, stopHash)
+
+	expected := "00" + "e8030000" + hex.EncodeToString(utils.ImmutableReorderBytes(stopHash))
+	if hex.EncodeToString(g.Serialize()) != expected {
+		t.Fatalf("unexpected serialization %x", g.Serialize())
+	}
+
+	parsed, err := ParseGetCFHeaders(bytes.NewReader(g.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse getcfheaders because %s", err.Error())
+	}
+	if parsed.StartHeight != 1000 || !bytes.Equal(parsed.StopHash, stopHash) {
+		t.Fatal("getcfheaders parsed incorrectly")
+	}
+}
+
+func TestCFHeaders(t *testing.T) {
+	// filter hash of the testnet genesis filter 019dfca8
+	filterHash := utils.ImmutableReorderBytes(utils.Hash256([]byte{0x01, 0x9d, 0xfc, 0xa8}))
+	stopHash, _ := hex.DecodeString(cfilterTestBlockHash)
+
+	c := &CFHeaders{
+		FilterType:           block.BASIC_FILTER_TYPE,
+		StopHash:             stopHash,
+		PreviousFilterHeader: make([]byte, 32),
+		FilterHashes:         [][]byte{filterHash},
+	}
+
+	parsed, err := ParseCFHeaders(bytes.NewReader(c.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse cfheaders because %s", err.Error())
+	}
+
+	if !bytes.Equal(parsed.Serialize(), c.Serialize()) {
+		t.Fatal("failed to round trip the cfheaders message")
+	}
+
+	// genesis filter header from the BIP158 test vectors
+	headers := parsed.FilterHeaders()
+	if len(headers) != 1 || hex.EncodeToString(headers[0]) != "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750" {
+		t.Fatalf("unexpected filter headers %x", headers)
+	}
+}
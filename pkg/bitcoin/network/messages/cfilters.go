@@ -0,0 +1,141 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_GETCFILTERS Command = "getcfilters"
+const COMMAND_CFILTER Command = "cfilter"
+
+// Maximum number of filters which can be requested in a single getcfilters
+const MAX_GETCFILTERS_SIZE = 1000
+
+// Request for the compact filters of a range of blocks (BIP157)
+type GetCFilters struct {
+	// 1 byte - Type of the filters requested
+	FilterType byte
+
+	// 4 bytes little endian - Height of the first block in the range
+	StartHeight uint32
+
+	// 32 bytes - Hash of the last block in the range, big endian
+	StopHash []byte
+}
+
+func MakeGetCFilters(filterType byte, startHeight uint32, stopHash []byte) *GetCFilters {
+	return &GetCFilters{
+		FilterType:  filterType,
+		StartHeight: startHeight,
+		StopHash:    stopHash,
+	}
+}
+
+func ParseGetCFilters(reader *bytes.Reader) (*GetCFilters, error) {
+	g := &GetCFilters{}
+
+	var err error
+	if g.FilterType, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	if reader.Len() < 4 {
+		return nil, fmt.Errorf("truncated start height")
+	}
+	g.StartHeight = utils.LittleEndianToUInt32(reader)
+
+	if g.StopHash, err = readHash(reader); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+func (g *GetCFilters) Serialize() []byte {
+	result := []byte{g.FilterType}
+	result = append(result, utils.UInt32ToLittleEndianBytes(g.StartHeight)...)
+	result = append(result, utils.ImmutableReorderBytes(g.StopHash)...)
+	return result
+}
+
+func (g GetCFilters) GetCommand() Command {
+	return COMMAND_GETCFILTERS
+}
+
+// Compact filter of a single block sent in response to a getcfilters
+type CFilter struct {
+	// 1 byte - Type of the filter
+	FilterType byte
+
+	// 32 bytes - Hash of the block the filter is for, big endian
+	BlockHash []byte
+
+	// Variable length - The serialized filter
+	FilterBytes []byte
+}
+
+func MakeCFilter(filterType byte, blockHash []byte, filter *block.GCSFilter) (*CFilter, error) {
+	filterBytes, err := filter.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CFilter{
+		FilterType:  filterType,
+		BlockHash:   blockHash,
+		FilterBytes: filterBytes,
+	}, nil
+}
+
+func ParseCFilter(reader *bytes.Reader) (*CFilter, error) {
+	c := &CFilter{}
+
+	var err error
+	if c.FilterType, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	if c.BlockHash, err = readHash(reader); err != nil {
+		return nil, err
+	}
+
+	// filter is a varint length followed by the filter itself
+	length := utils.ReadVarIntFromBytes(reader)
+	if length > uint64(reader.Len()) {
+		return nil, fmt.Errorf("filter of %d bytes does not fit the remaining data", length)
+	}
+	if c.FilterBytes, err = ioutil.ReadAll(io.LimitReader(reader, int64(length))); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *CFilter) Serialize() []byte {
+	result := []byte{c.FilterType}
+	result = append(result, utils.ImmutableReorderBytes(c.BlockHash)...)
+
+	length, _ := utils.EncodeUVarInt(uint64(len(c.FilterBytes)))
+	result = append(result, length...)
+	result = append(result, c.FilterBytes...)
+
+	return result
+}
+
+// Parses the filter carried by the message. Only basic filters are supported.
+func (c *CFilter) Filter() (*block.GCSFilter, error) {
+	if c.FilterType != block.BASIC_FILTER_TYPE {
+		return nil, fmt.Errorf("unsupported filter type %d", c.FilterType)
+	}
+
+	return block.ParseBasicFilter(c.BlockHash, bytes.NewReader(c.FilterBytes))
+}
+
+func (c CFilter) GetCommand() Command {
+	return COMMAND_CFILTER
+}
@@ -0,0 +1,70 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// hash of the testnet genesis block
+const cfilterTestBlockHash = "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"
+
+func TestGetCFiltersSerialize(t *testing.T) {
+	stopHash, _ := hex.DecodeString(cfilterTestBlockHash)
+	g := MakeGetCFilters(block.BASIC_FILTER_TYPE, 0, stopHash)
+
+	expected := "00" + "00000000" + hex.EncodeToString(utils.ImmutableReorderBytes(stopHash))
+	if hex.EncodeToString(g.Serialize()) != expected {
+		t.Fatalf("unexpected serialization %x", g.Serialize())
+	}
+
+	parsed, err := ParseGetCFilters(bytes.NewReader(g.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse getcfilters because %s", err.Error())
+	}
+	if parsed.StartHeight != 0 || !bytes.Equal(parsed.StopHash, stopHash) {
+		t.Fatal("getcfilters parsed incorrectly")
+	}
+}
+
+func TestParseCFilter(t *testing.T) {
+	blockHash, _ := hex.DecodeString(cfilterTestBlockHash)
+
+	// basic filter of the testnet genesis block from the BIP158 test vectors
+	raw, _ := hex.DecodeString("00" + hex.EncodeToString(utils.ImmutableReorderBytes(blockHash)) + "04019dfca8")
+
+	c, err := ParseCFilter(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse cfilter because %s", err.Error())
+	}
+
+	if !bytes.Equal(c.BlockHash, blockHash) || hex.EncodeToString(c.FilterBytes) != "019dfca8" {
+		t.Fatal("cfilter parsed incorrectly")
+	}
+
+	if !bytes.Equal(c.Serialize(), raw) {
+		t.Fatal("failed to round trip the cfilter message")
+	}
+
+	filter, err := c.Filter()
+	if err != nil {
+		t.Fatalf("failed to parse the filter because %s", err.Error())
+	}
+
+	// the only item in the genesis filter is the coinbase output script
+	script, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	match, err := filter.Match(script)
+	if err != nil || !match {
+		t.Fatal("coinbase script did not match the genesis filter")
+	}
+}
+
+func TestParseCFilterTruncated(t *testing.T) {
+	raw, _ := hex.DecodeString("00" + cfilterTestBlockHash + "04019d")
+	if _, err := ParseCFilter(bytes.NewReader(raw)); err == nil {
+		t.Fatal("parsed a truncated cfilter")
+	}
+}
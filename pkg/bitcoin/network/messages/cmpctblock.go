@@ -0,0 +1,213 @@
+package messages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_CMPCTBLOCK Command = "cmpctblock"
+
+// Size of a short transaction id
+const SHORT_ID_SIZE = 6
+
+// Most transactions a compact block can describe. Indexes are 16 bits wide
+const MAX_CMPCT_BLOCK_TXS = 0xffff
+
+// Transaction sent in full with a compact block, usually the coinbase
+type PrefilledTx struct {
+	// position of the transaction in the block
+	Index int
+
+	Transaction *tx.Transaction
+}
+
+// Block announced as its header and the short ids of its transactions
+// (BIP152). Transactions the peer expects us not to have are sent in full
+type CmpctBlock struct {
+	// 80 bytes
+	Header *block.BlockHeader
+
+	// 8 bytes little endian, salts the short ids
+	Nonce uint64
+
+	// 6 bytes each, little endian. Transactions of the block in order,
+	// skipping the prefilled ones
+	ShortIDs []uint64
+
+	// varint differentially encoded index followed by the transaction
+	Prefilled []PrefilledTx
+}
+
+// Makes a compact block for the block with only the coinbase prefilled
+func MakeCmpctBlock(b *block.Block, nonce uint64) (*CmpctBlock, error) {
+	if len(b.Transactions) == 0 {
+		return nil, fmt.Errorf("block has no transactions")
+	}
+
+	c := &CmpctBlock{
+		Header:    b.Header,
+		Nonce:     nonce,
+		Prefilled: []PrefilledTx{{Index: 0, Transaction: b.Transactions[0]}},
+	}
+
+	k0, k1, err := c.ShortIDKey()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range b.Transactions[1:] {
+		c.ShortIDs = append(c.ShortIDs, ShortID(k0, k1, t.WitnessHash()))
+	}
+
+	return c, nil
+}
+
+// Number of transactions in the block
+func (c *CmpctBlock) TxCount() int {
+	return len(c.ShortIDs) + len(c.Prefilled)
+}
+
+// SipHash key of the short ids, the little endian halves of the sha256 of the
+// header followed by the nonce
+func (c *CmpctBlock) ShortIDKey() (uint64, uint64, error) {
+	header, err := c.Header.SerializeHeader()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	key := sha256.Sum256(append(header, utils.UInt64ToLittleEndianBytes(c.Nonce)...))
+	return binary.LittleEndian.Uint64(key[0:8]), binary.LittleEndian.Uint64(key[8:16]), nil
+}
+
+// Short id of a transaction, the lower 6 bytes of the SipHash of its wtxid.
+// The wtxid is big endian and hashed in the order it is sent over the wire
+func ShortID(k0, k1 uint64, wtxid []byte) uint64 {
+	return utils.SipHash24(k0, k1, utils.ImmutableReorderBytes(wtxid)) & 0xffffffffffff
+}
+
+// reads a list of differentially encoded indexes, each being the gap from the
+// index before it
+func parseIndexes(reader *bytes.Reader, count int) ([]int, error) {
+	indexes := make([]int, 0, count)
+	next := 0
+	for i := 0; i < count; i++ {
+		if reader.Len() == 0 {
+			return nil, fmt.Errorf("truncated index %d", i)
+		}
+		gap := utils.ReadVarIntFromBytes(reader)
+		if gap > MAX_CMPCT_BLOCK_TXS || uint64(next)+gap > MAX_CMPCT_BLOCK_TXS {
+			return nil, fmt.Errorf("index %d overflows", i)
+		}
+		indexes = append(indexes, next+int(gap))
+		next += int(gap) + 1
+	}
+	return indexes, nil
+}
+
+// writes increasing indexes as the gap from the index before each
+func serializeIndexes(indexes []int) []byte {
+	var result []byte
+	next := 0
+	for _, index := range indexes {
+		gap, _ := utils.EncodeUVarInt(uint64(index - next))
+		result = append(result, gap...)
+		next = index + 1
+	}
+	return result
+}
+
+func ParseCmpctBlock(reader *bytes.Reader) (*CmpctBlock, error) {
+	c := &CmpctBlock{}
+
+	if reader.Len() < 80 {
+		return nil, fmt.Errorf("cmpctblock message is missing the header")
+	}
+
+	var err error
+	if c.Header, err = block.ParseHeader(reader); err != nil {
+		return nil, err
+	}
+
+	// nonce is 8 bytes little endian
+	if reader.Len() < 8 {
+		return nil, fmt.Errorf("cmpctblock message is missing the nonce")
+	}
+	c.Nonce = utils.LittleEndianToUInt64(reader)
+
+	// short ids are 6 bytes little endian each
+	count := utils.ReadVarIntFromBytes(reader)
+	if count > MAX_CMPCT_BLOCK_TXS || count > uint64(reader.Len()/SHORT_ID_SIZE) {
+		return nil, fmt.Errorf("cmpctblock message claims %d short ids which do not fit the remaining data", count)
+	}
+	for i := 0; i < int(count); i++ {
+		id := make([]byte, 8)
+		reader.Read(id[:SHORT_ID_SIZE])
+		c.ShortIDs = append(c.ShortIDs, binary.LittleEndian.Uint64(id))
+	}
+
+	// prefilled transactions, each a differentially encoded index and the
+	// transaction
+	count = utils.ReadVarIntFromBytes(reader)
+	if count > MAX_CMPCT_BLOCK_TXS || count > uint64(reader.Len()/61) {
+		return nil, fmt.Errorf("cmpctblock message claims %d prefilled transactions which do not fit the remaining data", count)
+	}
+	next := 0
+	for i := 0; i < int(count); i++ {
+		if reader.Len() == 0 {
+			return nil, fmt.Errorf("truncated prefilled transaction %d", i)
+		}
+		gap := utils.ReadVarIntFromBytes(reader)
+		if gap > MAX_CMPCT_BLOCK_TXS || uint64(next)+gap > MAX_CMPCT_BLOCK_TXS {
+			return nil, fmt.Errorf("prefilled transaction %d index overflows", i)
+		}
+		index := next + int(gap)
+
+		t, err := tx.ReadTransaction(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse prefilled transaction %d because %s", i, err.Error())
+		}
+		c.Prefilled = append(c.Prefilled, PrefilledTx{Index: index, Transaction: t})
+		next = index + 1
+	}
+
+	if c.TxCount() > MAX_CMPCT_BLOCK_TXS {
+		return nil, fmt.Errorf("cmpctblock message has too many transactions")
+	}
+
+	return c, nil
+}
+
+func (c *CmpctBlock) Serialize() []byte {
+	result, _ := c.Header.SerializeHeader()
+
+	// nonce is 8 bytes little endian
+	result = append(result, utils.UInt64ToLittleEndianBytes(c.Nonce)...)
+
+	// short ids are the low 6 bytes, little endian
+	count, _ := utils.EncodeUVarInt(uint64(len(c.ShortIDs)))
+	result = append(result, count...)
+	for _, id := range c.ShortIDs {
+		result = append(result, utils.UInt64ToLittleEndianBytes(id)[:SHORT_ID_SIZE]...)
+	}
+
+	count, _ = utils.EncodeUVarInt(uint64(len(c.Prefilled)))
+	result = append(result, count...)
+	next := 0
+	for _, p := range c.Prefilled {
+		gap, _ := utils.EncodeUVarInt(uint64(p.Index - next))
+		result = append(result, gap...)
+		result = append(result, p.Transaction.Serialize()...)
+		next = p.Index + 1
+	}
+
+	return result
+}
+
+func (c CmpctBlock) GetCommand() Command {
+	return COMMAND_CMPCTBLOCK
+}
@@ -0,0 +1,82 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+)
+
+// coinbase of the testnet genesis block
+const testCoinbaseTx = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func makeTestCmpctBlock(t *testing.T) *CmpctBlock {
+	raw, _ := hex.DecodeString(testCoinbaseTx)
+	coinbase, err := tx.ParseTransaction(raw)
+	if err != nil {
+		t.Fatalf("failed to parse the coinbase because %s", err.Error())
+	}
+
+	header, _ := block.GetTestnetGenesisBlock()
+	return &CmpctBlock{
+		Header:   header,
+		Nonce:    0x0123456789abcdef,
+		ShortIDs: []uint64{0x911d354fa242, 0x000000000001, 0xffffffffffff},
+		Prefilled: []PrefilledTx{
+			{Index: 0, Transaction: coinbase},
+			{Index: 3, Transaction: coinbase},
+		},
+	}
+}
+
+func TestCmpctBlock(t *testing.T) {
+	c := makeTestCmpctBlock(t)
+	raw := c.Serialize()
+
+	// the second prefilled index is sent as the gap from the first
+	coinbaseLen := len(testCoinbaseTx) / 2
+	if raw[len(raw)-coinbaseLen-1] != 0x02 {
+		t.Fatalf("prefilled index was not differentially encoded")
+	}
+
+	parsed, err := ParseCmpctBlock(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the cmpctblock because %s", err.Error())
+	}
+	if parsed.Nonce != c.Nonce || parsed.TxCount() != 5 || parsed.Prefilled[1].Index != 3 {
+		t.Fatalf("cmpctblock did not round trip")
+	}
+	for i, id := range c.ShortIDs {
+		if parsed.ShortIDs[i] != id {
+			t.Fatalf("short id %d did not round trip, %x", i, parsed.ShortIDs[i])
+		}
+	}
+	if !bytes.Equal(parsed.Serialize(), raw) {
+		t.Fatal("cmpctblock did not round trip")
+	}
+
+	for _, length := range []int{0, 50, 85, 90, len(raw) - 1} {
+		if _, err := ParseCmpctBlock(bytes.NewReader(raw[:length])); err == nil {
+			t.Fatalf("parsed a cmpctblock truncated to %d bytes", length)
+		}
+	}
+}
+
+func TestShortID(t *testing.T) {
+	// header of a block holding the genesis coinbase and the BIP143 native
+	// p2wpkh example, short id computed with a reference SipHash
+	raw, _ := hex.DecodeString("010000000000000000000000000000000000000000000000000000000000000000000000df7974051d6a8b87a016fbd368f6aa556cebf054355e54b9c1f19ba62eb5639bdae5494dffff001d1aa4ae18")
+	header, _ := block.ParseHeader(bytes.NewReader(raw))
+	wtxid, _ := hex.DecodeString("c36c38370907df2324d9ce9d149d191192f338b37665a82e78e76a12c909b762")
+
+	c := &CmpctBlock{Header: header, Nonce: 0x0123456789abcdef}
+	k0, k1, err := c.ShortIDKey()
+	if err != nil {
+		t.Fatalf("failed to derive the key because %s", err.Error())
+	}
+	if id := ShortID(k0, k1, wtxid); id != 0x911d354fa242 {
+		t.Fatalf("unexpected short id %x", id)
+	}
+}
@@ -0,0 +1,38 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_FEEFILTER Command = "feefilter"
+
+// Asks the peer not to announce transactions paying less than the fee rate
+// (BIP133)
+type FeeFilter struct {
+	// 8 bytes little endian, satoshis per 1000 bytes
+	FeeRate uint64
+}
+
+func MakeFeeFilter(feeRate uint64) *FeeFilter {
+	return &FeeFilter{FeeRate: feeRate}
+}
+
+func ParseFeeFilter(reader *bytes.Reader) (*FeeFilter, error) {
+	if reader.Len() < 8 {
+		return nil, fmt.Errorf("feefilter message is too short")
+	}
+
+	// fee rate is 8 bytes little endian
+	return MakeFeeFilter(utils.LittleEndianToUInt64(reader)), nil
+}
+
+func (f *FeeFilter) Serialize() []byte {
+	return utils.UInt64ToLittleEndianBytes(f.FeeRate)
+}
+
+func (f FeeFilter) GetCommand() Command {
+	return COMMAND_FEEFILTER
+}
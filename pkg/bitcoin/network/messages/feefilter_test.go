@@ -0,0 +1,27 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestFeeFilter(t *testing.T) {
+	// 1000 satoshis per 1000 bytes
+	raw, _ := hex.DecodeString("e803000000000000")
+
+	f, err := ParseFeeFilter(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the feefilter because %s", err.Error())
+	}
+	if f.FeeRate != 1000 {
+		t.Fatalf("expected a fee rate of 1000, got %d", f.FeeRate)
+	}
+	if !bytes.Equal(MakeFeeFilter(1000).Serialize(), raw) {
+		t.Fatal("feefilter did not round trip")
+	}
+
+	if _, err := ParseFeeFilter(bytes.NewReader(raw[:7])); err == nil {
+		t.Fatal("parsed a truncated feefilter")
+	}
+}
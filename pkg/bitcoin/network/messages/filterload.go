@@ -0,0 +1,48 @@
+package messages
+
+import (
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_FILTERLOAD Command = "filterload"
+
+// Loads a BIP37 bloom filter into the remote peer so only
+// matching transactions and merkle blocks are relayed to us
+type FilterLoad struct {
+	Filter *block.BloomFilter
+
+	// One of the block.BLOOM_UPDATE_* flags
+	Flag byte
+}
+
+func MakeFilterLoad(filter *block.BloomFilter, flag byte) *FilterLoad {
+	return &FilterLoad{
+		Filter: filter,
+		Flag:   flag,
+	}
+}
+
+func (f *FilterLoad) Serialize() []byte {
+	// start with the size of the filter in bytes as a varint
+	result, _ := utils.EncodeUVarInt(uint64(f.Filter.Size))
+
+	// next is the bit field packed into bytes
+	filterBytes, _ := f.Filter.FilterBytes()
+	result = append(result, filterBytes...)
+
+	// function count is 4 bytes little endian
+	result = append(result, utils.UInt32ToLittleEndianBytes(uint32(f.Filter.FunctionCount))...)
+
+	// tweak is 4 bytes little endian
+	result = append(result, utils.UInt32ToLittleEndianBytes(uint32(f.Filter.Tweak))...)
+
+	// finally the flag which is a single byte
+	result = append(result, f.Flag)
+
+	return result
+}
+
+func (f FilterLoad) GetCommand() Command {
+	return COMMAND_FILTERLOAD
+}
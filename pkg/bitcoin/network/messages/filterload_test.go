@@ -0,0 +1,20 @@
+package messages
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+)
+
+func TestFilterLoadSerialize(t *testing.T) {
+	filter := block.MakeBloomFilter(10, 5, 99)
+	filter.Add([]byte("Hello World"))
+	filter.Add([]byte("Goodbye!"))
+
+	filterLoad := MakeFilterLoad(filter, block.BLOOM_UPDATE_ALL)
+
+	if fmt.Sprintf("%x", filterLoad.Serialize()) != "0a4000600a080000010940050000006300000001" {
+		t.Fatalf("filterload serialization mismatch, got %x", filterLoad.Serialize())
+	}
+}
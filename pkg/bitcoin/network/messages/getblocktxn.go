@@ -0,0 +1,66 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_GETBLOCKTXN Command = "getblocktxn"
+
+// Asks for the transactions of a compact block which could not be found
+// locally (BIP152)
+type GetBlockTxn struct {
+	// 32 bytes, stored big endian and sent over the wire little endian
+	BlockHash []byte
+
+	// positions of the transactions in the block, increasing. Sent
+	// differentially encoded
+	Indexes []int
+}
+
+func MakeGetBlockTxn(blockHash []byte, indexes []int) *GetBlockTxn {
+	return &GetBlockTxn{BlockHash: blockHash, Indexes: indexes}
+}
+
+// reads the block hash which starts both getblocktxn and blocktxn
+func parseBlockHash(reader *bytes.Reader) ([]byte, error) {
+	hash := make([]byte, 32)
+	if _, err := io.ReadFull(reader, hash); err != nil {
+		return nil, fmt.Errorf("truncated block hash")
+	}
+	return utils.MutableReorderBytes(hash), nil
+}
+
+func ParseGetBlockTxn(reader *bytes.Reader) (*GetBlockTxn, error) {
+	hash, err := parseBlockHash(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	count := utils.ReadVarIntFromBytes(reader)
+	if count > MAX_CMPCT_BLOCK_TXS || count > uint64(reader.Len()) {
+		return nil, fmt.Errorf("getblocktxn message claims %d indexes which do not fit the remaining data", count)
+	}
+
+	indexes, err := parseIndexes(reader, int(count))
+	if err != nil {
+		return nil, err
+	}
+
+	return MakeGetBlockTxn(hash, indexes), nil
+}
+
+func (g *GetBlockTxn) Serialize() []byte {
+	result := utils.ImmutableReorderBytes(g.BlockHash)
+
+	count, _ := utils.EncodeUVarInt(uint64(len(g.Indexes)))
+	result = append(result, count...)
+	return append(result, serializeIndexes(g.Indexes)...)
+}
+
+func (g GetBlockTxn) GetCommand() Command {
+	return COMMAND_GETBLOCKTXN
+}
@@ -0,0 +1,37 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestGetBlockTxn(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xab}, 32)
+	hash[0] = 0x00
+
+	g := MakeGetBlockTxn(hash, []int{1, 2, 5, 300})
+	raw := g.Serialize()
+
+	// indexes are sent as the gap from the one before
+	if hex.EncodeToString(raw[32:]) != "04010002fd2601" {
+		t.Fatalf("unexpected indexes %x", raw[32:])
+	}
+
+	parsed, err := ParseGetBlockTxn(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the getblocktxn because %s", err.Error())
+	}
+	if !bytes.Equal(parsed.BlockHash, hash) || len(parsed.Indexes) != 4 || parsed.Indexes[3] != 300 {
+		t.Fatalf("getblocktxn did not round trip %v", parsed.Indexes)
+	}
+
+	// indexes past the largest block are rejected
+	overflow := append(append([]byte{}, raw[:32]...), 0x02, 0xfd, 0xff, 0xff, 0x00)
+	if _, err := ParseGetBlockTxn(bytes.NewReader(overflow)); err == nil {
+		t.Fatal("parsed an overflowing index")
+	}
+	if _, err := ParseGetBlockTxn(bytes.NewReader(raw[:len(raw)-3])); err == nil {
+		t.Fatal("parsed a truncated getblocktxn")
+	}
+}
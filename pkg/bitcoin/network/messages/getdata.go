@@ -1,9 +1,87 @@
 package messages
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_GETDATA Command = "getdata"
+
+// Inventory types which can be announced via inv and requested via getdata
+const (
+	TX_DATA_TYPE             uint32 = 1
+	BLOCK_DATA_TYPE          uint32 = 2
+	FILTERED_BLOCK_DATA_TYPE uint32 = 3
+	COMPACT_BLOCK_DATA_TYPE  uint32 = 4
+	WTX_DATA_TYPE            uint32 = 5
+)
+
+// Single inventory entry of a getdata request
+type InventoryItem struct {
+	// 4 bytes little endian
+	Type uint32
+
+	// 32 bytes, stored big endian and sent over the wire little endian
+	Identifier []byte
+}
+
 type GetDataMessage struct {
-	Data []byte
+	Data []InventoryItem
 }
 
 func MakeGetDataMessage() *GetDataMessage {
 	return &GetDataMessage{}
 }
+
+// Adds an item to request from the remote peer
+func (g *GetDataMessage) Add(dataType uint32, identifier []byte) {
+	g.Data = append(g.Data, InventoryItem{Type: dataType, Identifier: identifier})
+}
+
+func ParseGetData(reader *bytes.Reader) (*GetDataMessage, error) {
+	g := MakeGetDataMessage()
+
+	// number of items is the first thing in the stream as a varint
+	count := utils.ReadVarIntFromBytes(reader)
+
+	for i := 0; i < int(count); i++ {
+		// type is 4 bytes little endian
+		dataType := utils.LittleEndianToUInt32(reader)
+
+		// identifier is 32 bytes little endian
+		identifier, err := ioutil.ReadAll(io.LimitReader(reader, 32))
+		if err != nil {
+			return nil, err
+		}
+		if len(identifier) != 32 {
+			return nil, fmt.Errorf("truncated inventory item %d", i)
+		}
+
+		g.Add(dataType, utils.MutableReorderBytes(identifier))
+	}
+
+	return g, nil
+}
+
+func (g *GetDataMessage) Serialize() []byte {
+	// start with the number of items as a varint
+	result, _ := utils.EncodeUVarInt(uint64(len(g.Data)))
+
+	for _, item := range g.Data {
+		// type is 4 bytes little endian
+		result = append(result, utils.UInt32ToLittleEndianBytes(item.Type)...)
+
+		// identifier needs to be little endian
+		result = append(result, utils.ImmutableReorderBytes(item.Identifier)...)
+	}
+
+	return result
+}
+
+func (g GetDataMessage) GetCommand() Command {
+	return COMMAND_GETDATA
+}
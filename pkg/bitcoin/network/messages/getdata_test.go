@@ -0,0 +1,46 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+func TestGetDataSerialize(t *testing.T) {
+	hash1, _ := hex.DecodeString("00000000000000cac712b726e4326e596170574c01a16001692510c44025eb30")
+	hash2, _ := hex.DecodeString("00000000000000beb88910c46f6b442312361c6693a7fb52065b583979844910")
+
+	getData := MakeGetDataMessage()
+	getData.Add(FILTERED_BLOCK_DATA_TYPE, hash1)
+	getData.Add(FILTERED_BLOCK_DATA_TYPE, hash2)
+
+	expected := "020300000030eb2540c41025690160a1014c577061596e32e426b712c7ca00000000000000030000001049847939585b0652fba793661c361223446b6fc41089b8be00000000000000"
+	if fmt.Sprintf("%x", getData.Serialize()) != expected {
+		t.Fatalf("getdata serialization mismatch, got %x", getData.Serialize())
+	}
+}
+
+func TestParseGetData(t *testing.T) {
+	raw, _ := hex.DecodeString("020300000030eb2540c41025690160a1014c577061596e32e426b712c7ca00000000000000030000001049847939585b0652fba793661c361223446b6fc41089b8be00000000000000")
+
+	getData, err := ParseGetData(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse getdata because %s", err.Error())
+	}
+
+	if len(getData.Data) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(getData.Data))
+	}
+
+	hash1, _ := hex.DecodeString("00000000000000cac712b726e4326e596170574c01a16001692510c44025eb30")
+	if getData.Data[0].Type != FILTERED_BLOCK_DATA_TYPE || !utils.CompareByteArrays(getData.Data[0].Identifier, hash1) {
+		t.Fatalf("first item parsed incorrectly %v", getData.Data[0])
+	}
+
+	if !utils.CompareByteArrays(getData.Serialize(), raw) {
+		t.Fatal("failed to round trip the getdata message")
+	}
+}
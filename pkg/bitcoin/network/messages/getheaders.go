@@ -1,6 +1,8 @@
 package messages
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
@@ -8,11 +10,21 @@ import (
 
 const COMMAND_GETHEADERS Command = "getheaders"
 
+// Most block locator hashes accepted in a getheaders message
+const MAX_LOCATOR_SIZE = 101
+
+// Most headers sent in response to a getheaders message
+const MAX_HEADERS_RESULTS = 2000
+
 type GetHeaders struct {
 	Version    uint32
 	NumHashes  uint32
 	StartBlock []byte
 	EndBlock   []byte
+
+	// Block locator, hashes of blocks we have from the tip backwards, big
+	// endian. When empty the start block is the only hash sent
+	Locator [][]byte
 }
 
 func (h GetHeaders) GetCommand() Command {
@@ -39,16 +51,75 @@ func MakeGetHeaders(version, numHashes uint32, startBlock, endBlock []byte) (*Ge
 	}, nil
 }
 
+// Makes a getheaders message asking for the headers following the first
+// locator hash the peer knows about, up to the stop hash. A nil stop hash asks
+// for as many headers as the peer will send
+func MakeGetHeadersFromLocator(version uint32, locator [][]byte, stopHash []byte) (*GetHeaders, error) {
+	if len(locator) == 0 {
+		return nil, fmt.Errorf("must specify at least one locator hash")
+	}
+	if len(locator) > MAX_LOCATOR_SIZE {
+		return nil, fmt.Errorf("locator has %d hashes, the most allowed is %d", len(locator), MAX_LOCATOR_SIZE)
+	}
+
+	g, err := MakeGetHeaders(version, uint32(len(locator)), locator[0], stopHash)
+	if err != nil {
+		return nil, err
+	}
+	g.Locator = locator
+	return g, nil
+}
+
+// Parses a getheaders message from a bytestream
+func ParseGetHeaders(reader *bytes.Reader) (*GetHeaders, error) {
+	g := &GetHeaders{}
+
+	if err := binary.Read(reader, binary.LittleEndian, &g.Version); err != nil {
+		return nil, err
+	}
+
+	count := utils.ReadVarIntFromBytes(reader)
+	if count == 0 || count > MAX_LOCATOR_SIZE {
+		return nil, fmt.Errorf("locator has %d hashes", count)
+	}
+	g.NumHashes = uint32(count)
+
+	for i := uint64(0); i < count; i++ {
+		hash, err := readHash(reader)
+		if err != nil {
+			return nil, err
+		}
+		g.Locator = append(g.Locator, hash)
+	}
+	g.StartBlock = g.Locator[0]
+
+	var err error
+	g.EndBlock, err = readHash(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
 // Serializes the message for transmit over the network
 func (g *GetHeaders) Serialize() []byte {
 	// protocol is 4 bytes little endian
 	result := utils.UInt32ToLittleEndianBytes(g.Version)
 
+	locator := g.Locator
+	if len(locator) == 0 {
+		locator = [][]byte{g.StartBlock}
+	}
+
 	// the number of hashes is a varint
-	result = append(result, utils.IntToVarintBytes(int(g.NumHashes))...)
+	count, _ := utils.EncodeUVarInt(uint64(len(locator)))
+	result = append(result, count...)
 
-	// Start block is little endian
-	result = append(result, utils.ImmutableReorderBytes(g.StartBlock)...)
+	// locator hashes are little endian
+	for _, hash := range locator {
+		result = append(result, utils.ImmutableReorderBytes(hash)...)
+	}
 
 	// End block is little endian
 	result = append(result, utils.ImmutableReorderBytes(g.EndBlock)...)
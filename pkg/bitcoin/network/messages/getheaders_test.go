@@ -1,6 +1,11 @@
 package messages
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
 
 func TestMakeGetHeaders(t *testing.T) {
 	MakeGetHeaders(
@@ -10,3 +15,50 @@ func TestMakeGetHeaders(t *testing.T) {
 		nil,
 	)
 }
+
+func TestGetHeadersLocator(t *testing.T) {
+	locator := [][]byte{
+		utils.Hash256([]byte("tip")),
+		utils.Hash256([]byte("tip-1")),
+		utils.Hash256([]byte("genesis")),
+	}
+	stop := utils.Hash256([]byte("stop"))
+
+	g, err := MakeGetHeadersFromLocator(PROTOCOL_VERSION, locator, stop)
+	if err != nil {
+		t.Fatalf("failed to make the message because %s", err.Error())
+	}
+
+	// version + count + hashes + stop hash
+	serialized := g.Serialize()
+	if len(serialized) != 4+1+3*32+32 {
+		t.Fatalf("unexpected serialization length %d", len(serialized))
+	}
+
+	parsed, err := ParseGetHeaders(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatalf("failed to parse the message because %s", err.Error())
+	}
+	if parsed.Version != PROTOCOL_VERSION || parsed.NumHashes != 3 || !bytes.Equal(parsed.EndBlock, stop) {
+		t.Fatal("message parsed incorrectly")
+	}
+	for i := range locator {
+		if !bytes.Equal(parsed.Locator[i], locator[i]) {
+			t.Fatalf("locator hash %d parsed incorrectly", i)
+		}
+	}
+	if !bytes.Equal(parsed.StartBlock, locator[0]) {
+		t.Fatal("start block is not the first locator hash")
+	}
+
+	// a single start block serializes the same as a locator of one
+	single, _ := MakeGetHeaders(PROTOCOL_VERSION, 1, locator[0], stop)
+	one, _ := MakeGetHeadersFromLocator(PROTOCOL_VERSION, locator[:1], stop)
+	if !bytes.Equal(single.Serialize(), one.Serialize()) {
+		t.Fatal("single start block serialized differently to a locator")
+	}
+
+	if _, err := MakeGetHeadersFromLocator(PROTOCOL_VERSION, make([][]byte, MAX_LOCATOR_SIZE+1), nil); err == nil {
+		t.Fatal("made a message with an oversized locator")
+	}
+}
@@ -25,6 +25,11 @@ func ParseHeaders(reader *bytes.Reader) (*Headers, error) {
 	// 	return nil, err
 	// }
 
+	// each header takes 81 bytes, make sure the count is sane before trusting it
+	if numBlocks > reader.Len()/81 {
+		return nil, fmt.Errorf("headers message claims %d headers which do not fit the remaining data", numBlocks)
+	}
+
 	// all the block headers we've parsed
 	var bhs []*block.BlockHeader
 
@@ -53,8 +58,23 @@ func ParseHeaders(reader *bytes.Reader) (*Headers, error) {
 	return &Headers{BlockHeaders: bhs}, nil
 }
 
+// Serializes the headers, each followed by an empty transaction count
 func (h Headers) Serialize() []byte {
-	return nil
+	result, err := utils.EncodeUVarInt(uint64(len(h.BlockHeaders)))
+	if err != nil {
+		return nil
+	}
+
+	for _, header := range h.BlockHeaders {
+		s, err := header.SerializeHeader()
+		if err != nil {
+			return nil
+		}
+		result = append(result, s...)
+		result = append(result, 0x00)
+	}
+
+	return result
 }
 
 func (h Headers) GetCommand() Command {
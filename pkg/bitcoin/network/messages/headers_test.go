@@ -1,7 +1,49 @@
 package messages
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+)
 
 func TestHeaders(t *testing.T) {
 
 }
+
+func TestHeadersSerialize(t *testing.T) {
+	genesis, err := block.GetTestnetGenesisBlock()
+	if err != nil {
+		t.Fatalf("failed to get the genesis block because %s", err.Error())
+	}
+
+	// enough headers to need a multi byte count
+	h := Headers{}
+	for i := 0; i < 300; i++ {
+		h.BlockHeaders = append(h.BlockHeaders, genesis)
+	}
+
+	serialized := h.Serialize()
+	if len(serialized) != 3+300*81 || serialized[0] != 0xfd {
+		t.Fatalf("unexpected serialization length %d", len(serialized))
+	}
+
+	parsed, err := ParseHeaders(bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatalf("failed to parse the headers because %s", err.Error())
+	}
+	if len(parsed.BlockHeaders) != 300 {
+		t.Fatalf("parsed %d headers", len(parsed.BlockHeaders))
+	}
+
+	expected, _ := genesis.Hash()
+	hash, _ := parsed.BlockHeaders[299].Hash()
+	if !bytes.Equal(hash, expected) {
+		t.Fatal("header parsed incorrectly")
+	}
+
+	// count larger than the data
+	if _, err := ParseHeaders(bytes.NewReader([]byte{0xfd, 0xd0, 0x07})); err == nil {
+		t.Fatal("parsed headers which are not there")
+	}
+}
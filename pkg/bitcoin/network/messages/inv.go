@@ -0,0 +1,37 @@
+package messages
+
+import (
+	"bytes"
+)
+
+const COMMAND_INV Command = "inv"
+
+// Announces transactions and blocks, laid out the same as getdata
+type Inv struct {
+	Items []InventoryItem
+}
+
+func MakeInv() *Inv {
+	return &Inv{}
+}
+
+// Adds an item to announce to the remote peer
+func (i *Inv) Add(dataType uint32, identifier []byte) {
+	i.Items = append(i.Items, InventoryItem{Type: dataType, Identifier: identifier})
+}
+
+func ParseInv(reader *bytes.Reader) (*Inv, error) {
+	g, err := ParseGetData(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Inv{Items: g.Data}, nil
+}
+
+func (i *Inv) Serialize() []byte {
+	return (&GetDataMessage{Data: i.Items}).Serialize()
+}
+
+func (i Inv) GetCommand() Command {
+	return COMMAND_INV
+}
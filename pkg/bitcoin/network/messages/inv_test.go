@@ -0,0 +1,31 @@
+package messages
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInv(t *testing.T) {
+	txid := bytes.Repeat([]byte{0x11}, 32)
+	block := bytes.Repeat([]byte{0x22}, 32)
+	block[0] = 0x00
+
+	inv := MakeInv()
+	inv.Add(WTX_DATA_TYPE, txid)
+	inv.Add(BLOCK_DATA_TYPE, block)
+
+	raw := inv.Serialize()
+
+	// identifiers are sent little endian
+	if raw[len(raw)-1] != 0x00 {
+		t.Fatal("identifier was not reversed")
+	}
+
+	parsed, err := ParseInv(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the inv because %s", err.Error())
+	}
+	if len(parsed.Items) != 2 || parsed.Items[0].Type != WTX_DATA_TYPE || !bytes.Equal(parsed.Items[1].Identifier, block) {
+		t.Fatal("inv did not round trip")
+	}
+}
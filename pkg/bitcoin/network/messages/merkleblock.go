@@ -0,0 +1,36 @@
+package messages
+
+import (
+	"bytes"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+)
+
+const COMMAND_MERKLEBLOCK Command = "merkleblock"
+
+// Filtered block sent in response to a getdata for a filtered block
+type MerkleBlock struct {
+	*block.MerkleBlock
+}
+
+func ParseMerkleBlock(reader *bytes.Reader) (*MerkleBlock, error) {
+	mb, err := block.ParseMerkleBlock(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MerkleBlock{MerkleBlock: mb}, nil
+}
+
+func MakeMerkleBlock(mb *block.MerkleBlock) *MerkleBlock {
+	return &MerkleBlock{MerkleBlock: mb}
+}
+
+func (m MerkleBlock) Serialize() []byte {
+	b, _ := m.MerkleBlock.Serialize()
+	return b
+}
+
+func (m MerkleBlock) GetCommand() Command {
+	return COMMAND_MERKLEBLOCK
+}
@@ -1,8 +1,29 @@
 package messages
 
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
 type Command string
 
 type Message interface {
 	Serialize() []byte
 	GetCommand() Command
 }
+
+// reads a 32 byte little endian hash off the wire and returns it big endian
+func readHash(reader *bytes.Reader) ([]byte, error) {
+	hash, err := ioutil.ReadAll(io.LimitReader(reader, 32))
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) != 32 {
+		return nil, fmt.Errorf("truncated hash")
+	}
+	return utils.MutableReorderBytes(hash), nil
+}
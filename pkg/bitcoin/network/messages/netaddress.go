@@ -0,0 +1,392 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+	"golang.org/x/crypto/sha3"
+)
+
+// BIP155 network ids
+const (
+	NETWORK_IPV4  byte = 0x01
+	NETWORK_IPV6  byte = 0x02
+	NETWORK_TORV2 byte = 0x03
+	NETWORK_TORV3 byte = 0x04
+	NETWORK_I2P   byte = 0x05
+	NETWORK_CJDNS byte = 0x06
+)
+
+// Longest address accepted in an addrv2 message
+const MAX_ADDRV2_SIZE = 512
+
+// address lengths of each of the BIP155 networks
+var networkAddressLengths = map[byte]int{
+	NETWORK_IPV4:  4,
+	NETWORK_IPV6:  16,
+	NETWORK_TORV2: 10,
+	NETWORK_TORV3: 32,
+	NETWORK_I2P:   32,
+	NETWORK_CJDNS: 16,
+}
+
+// prefixes used to squeeze addresses into the 16 bytes of the legacy encoding
+var ipv4MappedPrefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+var onionCatPrefix = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
+// prefix Bitcoin Core uses for its internal names, fd6b:88c0:8724::/48
+var internalPrefix = []byte{0xfd, 0x6b, 0x88, 0xc0, 0x87, 0x24}
+
+// lower case rfc4648 base32 without padding as used by tor and i2p
+var addressEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+const torV3Version byte = 0x03
+
+// Address of a peer as relayed in addr and addrv2 messages
+type NetworkAddress struct {
+	// 4 bytes little endian - Last time the peer was seen. Not part of
+	// the addresses in the version message
+	Time uint32
+
+	// Services advertised by the peer
+	Services uint64
+
+	// BIP155 network id
+	NetworkId byte
+
+	// Raw address, the length depends on the network
+	Address []byte
+
+	// Big Endian
+	Port uint16
+}
+
+// Serializes the IP as 16 bytes, IPv4 addresses are encoded as IPv4-mapped IPv6
+func IPSerialization(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return append(append([]byte{}, ipv4MappedPrefix...), ip4...)
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		return append([]byte{}, ip16...)
+	}
+
+	// no address is all zeros
+	return make([]byte, 16)
+}
+
+// Serializes an IPv4 address as an IPv4-mapped IPv6 address
+func IPv4Serialization(ip net.IP) []byte {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		ip4 = make([]byte, 4)
+	}
+	return IPSerialization(ip4)
+}
+
+// reads a 16 byte network address, returning IPv4 addresses in their 4 byte form
+func parseIp(reader *bytes.Reader) (net.IP, error) {
+	ip, err := ioutil.ReadAll(io.LimitReader(reader, 16))
+	if err != nil {
+		return nil, err
+	}
+	if len(ip) != 16 {
+		return nil, fmt.Errorf("truncated ip address")
+	}
+
+	if ip4 := net.IP(ip).To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return net.IP(ip), nil
+}
+
+// reads a 2 byte big endian port
+func parsePort(reader *bytes.Reader) (uint16, error) {
+	port, err := ioutil.ReadAll(io.LimitReader(reader, 2))
+	if err != nil {
+		return 0, err
+	}
+	if len(port) != 2 {
+		return 0, fmt.Errorf("truncated port")
+	}
+	return uint16(port[0])<<8 | uint16(port[1]), nil
+}
+
+// Makes a network address from an IPv4 or IPv6 address
+func MakeNetworkAddress(ip net.IP, port uint16, services uint64) *NetworkAddress {
+	a := &NetworkAddress{
+		Services: services,
+		Port:     port,
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		a.NetworkId = NETWORK_IPV4
+		a.Address = append([]byte{}, ip4...)
+	} else {
+		a.NetworkId = NETWORK_IPV6
+		a.Address = append([]byte{}, ip.To16()...)
+	}
+
+	return a
+}
+
+// Makes a network address from the host, which may be an IPv4 or IPv6 address,
+// a tor v3 .onion address or an i2p .b32.i2p address
+func ParseNetworkAddress(host string, port uint16, services uint64) (*NetworkAddress, error) {
+	a := &NetworkAddress{
+		Services: services,
+		Port:     port,
+	}
+	lower := strings.ToLower(host)
+
+	switch {
+	case strings.HasSuffix(lower, ".onion"):
+		decoded, err := addressEncoding.DecodeString(strings.TrimSuffix(lower, ".onion"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid onion address %s", host)
+		}
+
+		switch len(decoded) {
+		case 10:
+			a.NetworkId = NETWORK_TORV2
+			a.Address = decoded
+		case 32 + 2 + 1:
+			// tor v3 is the public key, a 2 byte checksum and the version
+			pubkey := decoded[:32]
+			if decoded[34] != torV3Version {
+				return nil, fmt.Errorf("unsupported onion address version %d", decoded[34])
+			}
+			if !bytes.Equal(decoded[32:34], torV3Checksum(pubkey)) {
+				return nil, fmt.Errorf("invalid onion address checksum %s", host)
+			}
+			a.NetworkId = NETWORK_TORV3
+			a.Address = pubkey
+		default:
+			return nil, fmt.Errorf("invalid onion address %s", host)
+		}
+	case strings.HasSuffix(lower, ".b32.i2p"):
+		decoded, err := addressEncoding.DecodeString(strings.TrimSuffix(lower, ".b32.i2p"))
+		if err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("invalid i2p address %s", host)
+		}
+		a.NetworkId = NETWORK_I2P
+		a.Address = decoded
+	default:
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %s", host)
+		}
+		return MakeNetworkAddress(ip, port, services), nil
+	}
+
+	return a, nil
+}
+
+// checksum of a tor v3 address, the first 2 bytes of
+// sha3_256(".onion checksum" || pubkey || version)
+func torV3Checksum(pubkey []byte) []byte {
+	data := append([]byte(".onion checksum"), pubkey...)
+	data = append(data, torV3Version)
+	sum := sha3.Sum256(data)
+	return sum[:2]
+}
+
+// Checks the address has the correct length for its network. BIP155 does not
+// allow IPv6 addresses which embed an IPv4, Tor v2 or internal address
+func (a *NetworkAddress) IsValid() bool {
+	length, ok := networkAddressLengths[a.NetworkId]
+	if !ok || len(a.Address) != length {
+		return false
+	}
+
+	if a.NetworkId == NETWORK_IPV6 {
+		for _, prefix := range [][]byte{ipv4MappedPrefix, onionCatPrefix, internalPrefix} {
+			if bytes.HasPrefix(a.Address, prefix) {
+				return false
+			}
+		}
+	}
+
+	// cjdns addresses all live in fc00::/8
+	if a.NetworkId == NETWORK_CJDNS && a.Address[0] != 0xfc {
+		return false
+	}
+
+	return true
+}
+
+// Returns the address as an IP for the IPv4, IPv6 and CJDNS networks, nil otherwise
+func (a *NetworkAddress) IP() net.IP {
+	switch a.NetworkId {
+	case NETWORK_IPV4, NETWORK_IPV6, NETWORK_CJDNS:
+		return net.IP(a.Address)
+	}
+	return nil
+}
+
+// Returns the host, in the form used to connect to it
+func (a *NetworkAddress) Host() string {
+	switch a.NetworkId {
+	case NETWORK_IPV4, NETWORK_IPV6, NETWORK_CJDNS:
+		return net.IP(a.Address).String()
+	case NETWORK_TORV2:
+		return addressEncoding.EncodeToString(a.Address) + ".onion"
+	case NETWORK_TORV3:
+		data := append(append([]byte{}, a.Address...), torV3Checksum(a.Address)...)
+		data = append(data, torV3Version)
+		return addressEncoding.EncodeToString(data) + ".onion"
+	case NETWORK_I2P:
+		return addressEncoding.EncodeToString(a.Address) + ".b32.i2p"
+	}
+	return hex.EncodeToString(a.Address)
+}
+
+func (a NetworkAddress) String() string {
+	return net.JoinHostPort(a.Host(), strconv.Itoa(int(a.Port)))
+}
+
+// Returns the 16 byte legacy encoding of the address. Tor v3, I2P and CJDNS
+// addresses can't be represented and return an error
+func (a *NetworkAddress) legacyAddress() ([]byte, error) {
+	switch a.NetworkId {
+	case NETWORK_IPV4, NETWORK_IPV6:
+		return IPSerialization(net.IP(a.Address)), nil
+	case NETWORK_TORV2:
+		return append(append([]byte{}, onionCatPrefix...), a.Address...), nil
+	}
+	return nil, fmt.Errorf("network %d can't be encoded in a legacy address", a.NetworkId)
+}
+
+// Serializes the address in the legacy addr format. The time is only
+// included for addr messages, the version message leaves it out.
+func (a *NetworkAddress) Serialize(withTime bool) ([]byte, error) {
+	address, err := a.legacyAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []byte
+	if withTime {
+		result = utils.UInt32ToLittleEndianBytes(a.Time)
+	}
+
+	// services is 8 bytes little endian
+	result = append(result, utils.UInt64ToLittleEndianBytes(a.Services)...)
+	result = append(result, address...)
+
+	// port is 2 bytes big endian
+	result = append(result, byte(a.Port>>8), byte(a.Port))
+
+	return result, nil
+}
+
+// Parses an address in the legacy addr format
+func ReadNetworkAddress(reader *bytes.Reader, withTime bool) (*NetworkAddress, error) {
+	a := &NetworkAddress{}
+
+	if withTime {
+		if reader.Len() < 4 {
+			return nil, fmt.Errorf("truncated address time")
+		}
+		a.Time = utils.LittleEndianToUInt32(reader)
+	}
+
+	if reader.Len() < 8 {
+		return nil, fmt.Errorf("truncated address services")
+	}
+	a.Services = utils.LittleEndianToUInt64(reader)
+
+	ip, err := parseIp(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip16 := ip.To16(); bytes.HasPrefix(ip16, onionCatPrefix) {
+		a.NetworkId = NETWORK_TORV2
+		a.Address = append([]byte{}, ip16[len(onionCatPrefix):]...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		a.NetworkId = NETWORK_IPV4
+		a.Address = ip4
+	} else {
+		a.NetworkId = NETWORK_IPV6
+		a.Address = ip16
+	}
+
+	if a.Port, err = parsePort(reader); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Serializes the address in the BIP155 addrv2 format
+func (a *NetworkAddress) SerializeV2() ([]byte, error) {
+	if !a.IsValid() {
+		return nil, fmt.Errorf("invalid address for network %d", a.NetworkId)
+	}
+
+	result := utils.UInt32ToLittleEndianBytes(a.Time)
+
+	// services is a varint in addrv2
+	services, err := utils.EncodeUVarInt(a.Services)
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, services...)
+
+	result = append(result, a.NetworkId)
+	length, _ := utils.EncodeUVarInt(uint64(len(a.Address)))
+	result = append(result, length...)
+	result = append(result, a.Address...)
+
+	result = append(result, byte(a.Port>>8), byte(a.Port))
+
+	return result, nil
+}
+
+// Parses an address in the BIP155 addrv2 format. Addresses of networks we don't
+// know about are returned as is, callers should check IsValid before using them
+func ReadNetworkAddressV2(reader *bytes.Reader) (*NetworkAddress, error) {
+	a := &NetworkAddress{}
+
+	if reader.Len() < 4 {
+		return nil, fmt.Errorf("truncated address time")
+	}
+	a.Time = utils.LittleEndianToUInt32(reader)
+	a.Services = utils.ReadVarIntFromBytes(reader)
+
+	var err error
+	if a.NetworkId, err = reader.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	length := utils.ReadVarIntFromBytes(reader)
+	if length > MAX_ADDRV2_SIZE {
+		return nil, fmt.Errorf("address of %d bytes is too long", length)
+	}
+
+	// known networks must have the right address length
+	if expected, ok := networkAddressLengths[a.NetworkId]; ok && uint64(expected) != length {
+		return nil, fmt.Errorf("address of %d bytes is invalid for network %d", length, a.NetworkId)
+	}
+
+	if a.Address, err = ioutil.ReadAll(io.LimitReader(reader, int64(length))); err != nil {
+		return nil, err
+	}
+	if uint64(len(a.Address)) != length {
+		return nil, fmt.Errorf("truncated address")
+	}
+
+	if a.Port, err = parsePort(reader); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
@@ -0,0 +1,174 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+func TestIPSerialization(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"1.2.3.4", "00000000000000000000ffff01020304"},
+		{"::ffff:1.2.3.4", "00000000000000000000ffff01020304"},
+		{"2001:db8::1", "20010db8000000000000000000000001"},
+	}
+
+	for _, test := range tests {
+		result := hex.EncodeToString(IPSerialization(net.ParseIP(test.ip)))
+		if result != test.expected {
+			t.Fatalf("unexpected serialization of %s %s", test.ip, result)
+		}
+	}
+
+	if hex.EncodeToString(IPSerialization(nil)) != "00000000000000000000000000000000" {
+		t.Fatal("unexpected serialization of a missing ip")
+	}
+}
+
+func TestNetworkAddressLegacy(t *testing.T) {
+	a := MakeNetworkAddress(net.ParseIP("2001:db8::1"), 8333, NODE_NETWORK)
+	a.Time = 0x5f5e1000
+
+	serialized, err := a.Serialize(true)
+	if err != nil {
+		t.Fatalf("failed to serialize the address because %s", err.Error())
+	}
+
+	expected := "00105e5f" + "0100000000000000" + "20010db8000000000000000000000001" + "208d"
+	if hex.EncodeToString(serialized) != expected {
+		t.Fatalf("unexpected serialization %x", serialized)
+	}
+
+	parsed, err := ReadNetworkAddress(bytes.NewReader(serialized), true)
+	if err != nil {
+		t.Fatalf("failed to parse the address because %s", err.Error())
+	}
+	if parsed.NetworkId != NETWORK_IPV6 || parsed.String() != "[2001:db8::1]:8333" || parsed.Time != a.Time {
+		t.Fatalf("address parsed incorrectly %s", parsed)
+	}
+
+	// IPv4-mapped addresses come back as IPv4
+	a = MakeNetworkAddress(net.ParseIP("10.0.0.1"), 18333, 0)
+	serialized, _ = a.Serialize(false)
+	parsed, _ = ReadNetworkAddress(bytes.NewReader(serialized), false)
+	if parsed.NetworkId != NETWORK_IPV4 || parsed.String() != "10.0.0.1:18333" {
+		t.Fatalf("address parsed incorrectly %s", parsed)
+	}
+}
+
+func TestTorV3Address(t *testing.T) {
+	host := "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion"
+
+	a, err := ParseNetworkAddress(host, 9050, 0)
+	if err != nil {
+		t.Fatalf("failed to parse the onion address because %s", err.Error())
+	}
+	if a.NetworkId != NETWORK_TORV3 || len(a.Address) != 32 {
+		t.Fatal("onion address parsed incorrectly")
+	}
+	if a.Host() != host {
+		t.Fatalf("onion address did not round trip %s", a.Host())
+	}
+
+	// tor v3 can only be sent in addrv2
+	if _, err := a.Serialize(true); err == nil {
+		t.Fatal("serialized a tor v3 address in the legacy format")
+	}
+
+	// corrupt the checksum
+	if _, err := ParseNetworkAddress("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczaa.onion", 9050, 0); err == nil {
+		t.Fatal("accepted an onion address with a bad checksum")
+	}
+}
+
+func TestI2PAddress(t *testing.T) {
+	a := &NetworkAddress{NetworkId: NETWORK_I2P, Address: bytes.Repeat([]byte{0xab}, 32), Port: 0}
+
+	parsed, err := ParseNetworkAddress(a.Host(), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to parse the i2p address because %s", err.Error())
+	}
+	if parsed.NetworkId != NETWORK_I2P || !bytes.Equal(parsed.Address, a.Address) {
+		t.Fatalf("i2p address did not round trip %s", a.Host())
+	}
+}
+
+func TestNetworkAddressV2(t *testing.T) {
+	cjdns, _ := hex.DecodeString("fc000001000200030004000500060007")
+	tests := []struct {
+		address  *NetworkAddress
+		expected string
+	}{
+		{
+			MakeNetworkAddress(net.ParseIP("1.2.3.4"), 8333, NODE_NETWORK|NODE_WITNESS),
+			"00000000" + "09" + "01" + "04" + "01020304" + "208d",
+		},
+		{
+			&NetworkAddress{NetworkId: NETWORK_CJDNS, Address: cjdns, Port: 8333},
+			"00000000" + "00" + "06" + "10" + "fc000001000200030004000500060007" + "208d",
+		},
+	}
+
+	for _, test := range tests {
+		serialized, err := test.address.SerializeV2()
+		if err != nil {
+			t.Fatalf("failed to serialize %s because %s", test.address, err.Error())
+		}
+		if hex.EncodeToString(serialized) != test.expected {
+			t.Fatalf("unexpected serialization of %s %x", test.address, serialized)
+		}
+
+		parsed, err := ReadNetworkAddressV2(bytes.NewReader(serialized))
+		if err != nil {
+			t.Fatalf("failed to parse %s because %s", test.address, err.Error())
+		}
+		if parsed.String() != test.address.String() || parsed.Services != test.address.Services {
+			t.Fatalf("address did not round trip %s", parsed)
+		}
+	}
+
+	// an IPv4 address must be 4 bytes
+	bad, _ := hex.DecodeString("00000000" + "00" + "01" + "05" + "0102030405" + "208d")
+	if _, err := ReadNetworkAddressV2(bytes.NewReader(bad)); err == nil {
+		t.Fatal("accepted an IPv4 address of the wrong length")
+	}
+
+	// cjdns addresses must be in fc00::/8
+	a := &NetworkAddress{NetworkId: NETWORK_CJDNS, Address: make([]byte, 16)}
+	if a.IsValid() {
+		t.Fatal("accepted a cjdns address outside of fc00::/8")
+	}
+}
+
+func TestNetworkAddressIsValidIPv6(t *testing.T) {
+	tests := []struct {
+		address string
+		valid   bool
+	}{
+		{"2001:db8::1", true},
+		// ipv4 mapped
+		{"::ffff:1.2.3.4", false},
+		// tor v2 behind the onioncat prefix
+		{"fd87:d87e:eb43:edb1:8e4:3588:e546:35ca", false},
+		// bitcoin core internal names
+		{"fd6b:88c0:8724:1:2:3:4:5", false},
+	}
+
+	for _, test := range tests {
+		a := &NetworkAddress{NetworkId: NETWORK_IPV6, Address: net.ParseIP(test.address).To16()}
+		if a.IsValid() != test.valid {
+			t.Fatalf("expected %s to be valid %t", test.address, test.valid)
+		}
+
+		// invalid addresses are dropped from addrv2 messages
+		payload := MakeAddrV2([]*NetworkAddress{a}).Serialize()
+		msg, err := ParseAddrV2(bytes.NewReader(payload))
+		if err != nil || (len(msg.Addresses) == 1) != test.valid {
+			t.Fatalf("unexpected addrv2 parse of %s, %v", test.address, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package messages
+
+import (
+	"bytes"
+)
+
+const COMMAND_NOTFOUND Command = "notfound"
+
+// Answers a getdata with the items the peer does not have, laid out the same
+// as getdata
+type NotFound struct {
+	Items []InventoryItem
+}
+
+func MakeNotFound() *NotFound {
+	return &NotFound{}
+}
+
+// Adds an item the remote peer asked for but we do not have
+func (n *NotFound) Add(dataType uint32, identifier []byte) {
+	n.Items = append(n.Items, InventoryItem{Type: dataType, Identifier: identifier})
+}
+
+func ParseNotFound(reader *bytes.Reader) (*NotFound, error) {
+	g, err := ParseGetData(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &NotFound{Items: g.Data}, nil
+}
+
+func (n *NotFound) Serialize() []byte {
+	return (&GetDataMessage{Data: n.Items}).Serialize()
+}
+
+func (n NotFound) GetCommand() Command {
+	return COMMAND_NOTFOUND
+}
@@ -0,0 +1,23 @@
+package messages
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNotFound(t *testing.T) {
+	block := bytes.Repeat([]byte{0x22}, 32)
+	block[0] = 0x00
+
+	notFound := MakeNotFound()
+	notFound.Add(FILTERED_BLOCK_DATA_TYPE, block)
+
+	msg, err := ParseMessage(COMMAND_NOTFOUND, notFound.Serialize())
+	if err != nil {
+		t.Fatalf("failed to parse the notfound because %s", err.Error())
+	}
+	parsed := msg.(*NotFound)
+	if len(parsed.Items) != 1 || parsed.Items[0].Type != FILTERED_BLOCK_DATA_TYPE || !bytes.Equal(parsed.Items[0].Identifier, block) {
+		t.Fatal("notfound did not round trip")
+	}
+}
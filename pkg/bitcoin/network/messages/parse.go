@@ -0,0 +1,76 @@
+package messages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Returned when parsing a message for a command which is not supported
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Parses the payload of a network envelope into the message for the command
+func ParseMessage(cmd Command, payload []byte) (Message, error) {
+	reader := bytes.NewReader(payload)
+
+	switch cmd {
+	case COMMAND_VERSION:
+		return ParseVersion(reader)
+	case COMMAND_VERACK:
+		return ParseVerAck(payload), nil
+	case COMMAND_PING:
+		return ParsePing(reader)
+	case COMMAND_PONG:
+		return ParsePong(reader)
+	case COMMAND_GETHEADERS:
+		return ParseGetHeaders(reader)
+	case COMMAND_HEADERS:
+		return ParseHeaders(reader)
+	case COMMAND_GETDATA:
+		return ParseGetData(reader)
+	case COMMAND_MERKLEBLOCK:
+		return ParseMerkleBlock(reader)
+	case COMMAND_TX:
+		return ParseTx(payload)
+	case COMMAND_ADDR:
+		return ParseAddr(reader)
+	case COMMAND_ADDRV2:
+		return ParseAddrV2(reader)
+	case COMMAND_GETADDR:
+		return &GetAddr{}, nil
+	case COMMAND_SENDADDRV2:
+		return &SendAddrV2{}, nil
+	case COMMAND_INV:
+		return ParseInv(reader)
+	case COMMAND_NOTFOUND:
+		return ParseNotFound(reader)
+	case COMMAND_SENDHEADERS:
+		return &SendHeaders{}, nil
+	case COMMAND_WTXIDRELAY:
+		return &WtxidRelay{}, nil
+	case COMMAND_FEEFILTER:
+		return ParseFeeFilter(reader)
+	case COMMAND_SENDCMPCT:
+		return ParseSendCmpct(reader)
+	case COMMAND_CMPCTBLOCK:
+		return ParseCmpctBlock(reader)
+	case COMMAND_GETBLOCKTXN:
+		return ParseGetBlockTxn(reader)
+	case COMMAND_BLOCKTXN:
+		return ParseBlockTxn(reader)
+	case COMMAND_GETCFILTERS:
+		return ParseGetCFilters(reader)
+	case COMMAND_CFILTER:
+		return ParseCFilter(reader)
+	case COMMAND_GETCFHEADERS:
+		return ParseGetCFHeaders(reader)
+	case COMMAND_CFHEADERS:
+		return ParseCFHeaders(reader)
+	case COMMAND_GETCFCHECKPT:
+		return ParseGetCFCheckpt(reader)
+	case COMMAND_CFCHECKPT:
+		return ParseCFCheckpt(reader)
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrUnknownCommand, cmd)
+}
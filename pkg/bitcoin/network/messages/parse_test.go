@@ -0,0 +1,35 @@
+package messages
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	ping := MakePing()
+
+	msg, err := ParseMessage(COMMAND_PING, ping.Serialize())
+	if err != nil {
+		t.Fatalf("failed to parse the ping because %s", err.Error())
+	}
+	if msg.GetCommand() != COMMAND_PING {
+		t.Fatalf("parsed a %s", msg.GetCommand())
+	}
+
+	msg, err = ParseMessage(COMMAND_VERACK, nil)
+	if err != nil || msg.GetCommand() != COMMAND_VERACK {
+		t.Fatal("failed to parse the verack")
+	}
+
+	// negotiation messages without a payload
+	for _, cmd := range []Command{COMMAND_SENDHEADERS, COMMAND_WTXIDRELAY, COMMAND_SENDADDRV2} {
+		msg, err := ParseMessage(cmd, nil)
+		if err != nil || msg.GetCommand() != cmd || len(msg.Serialize()) != 0 {
+			t.Fatalf("failed to parse the %s", cmd)
+		}
+	}
+
+	if _, err := ParseMessage(Command("bogus"), nil); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatal("parsed an unknown command")
+	}
+}
@@ -1,15 +1,54 @@
 package messages
 
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
 const COMMAND_PING Command = "ping"
 
-type Ping struct{}
+// Size of the nonce of a ping and pong (BIP31)
+const PING_NONCE_SIZE = 8
+
+type Ping struct {
+	// 8 bytes - Random nonce echoed back in the pong (BIP31). Pings from
+	// peers older than BIP31 carry no nonce
+	Nonce []byte
+}
 
-func ParsePing() {}
+func ParsePing(reader *bytes.Reader) (*Ping, error) {
+	if reader.Len() == 0 {
+		return &Ping{}, nil
+	}
+
+	nonce, err := parsePingNonce(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Ping{Nonce: nonce}, nil
+}
 
-func MakePing() {}
+// reads the 8 byte nonce of a ping or pong
+func parsePingNonce(reader *bytes.Reader) ([]byte, error) {
+	nonce := make([]byte, PING_NONCE_SIZE)
+	if _, err := io.ReadFull(reader, nonce); err != nil {
+		return nil, fmt.Errorf("truncated ping nonce")
+	}
+	return nonce, nil
+}
+
+// Makes a ping with a random nonce
+func MakePing() *Ping {
+	nonce := make([]byte, PING_NONCE_SIZE)
+	rand.Read(nonce)
+
+	return &Ping{Nonce: nonce}
+}
 
 func (v *Ping) Serialize() []byte {
-	return nil
+	return v.Nonce
 }
 
 func (v Ping) GetCommand() Command {
@@ -1 +1,33 @@
 package messages
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	ping := MakePing()
+	if len(ping.Nonce) != 8 {
+		t.Fatalf("expected an 8 byte nonce, got %x", ping.Nonce)
+	}
+
+	parsed, err := ParsePing(bytes.NewReader(ping.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse the ping because %s", err.Error())
+	}
+	if !bytes.Equal(parsed.Nonce, ping.Nonce) {
+		t.Fatal("ping did not round trip")
+	}
+
+	// pre BIP31 pings have no nonce
+	parsed, err = ParsePing(bytes.NewReader(nil))
+	if err != nil || len(parsed.Nonce) != 0 {
+		t.Fatal("failed to parse an empty ping")
+	}
+}
+
+func TestPingTruncatedNonce(t *testing.T) {
+	if _, err := ParsePing(bytes.NewReader([]byte{1, 2, 3})); err == nil {
+		t.Fatal("parsed a ping with a truncated nonce")
+	}
+}
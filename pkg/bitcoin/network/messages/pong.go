@@ -1,9 +1,7 @@
 package messages
 
 import (
-	"io"
-	"io/ioutil"
-	"net"
+	"bytes"
 )
 
 const COMMAND_PONG Command = "pong"
@@ -12,10 +10,10 @@ type Pong struct {
 	Nonce []byte
 }
 
-func ParsePong(socket net.Conn) (*Pong, error) {
+func ParsePong(reader *bytes.Reader) (*Pong, error) {
 	// pong, is just reading the 8 bytes sent to the receiver
 	// and throwing it back at them
-	nonce, err := ioutil.ReadAll(io.LimitReader(socket, 8))
+	nonce, err := parsePingNonce(reader)
 	if err != nil {
 		return nil, err
 	}
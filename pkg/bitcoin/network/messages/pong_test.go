@@ -1 +1,23 @@
 package messages
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPong(t *testing.T) {
+	ping := MakePing()
+
+	pong, err := ParsePong(bytes.NewReader(MakePong(ping.Nonce).Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse the pong because %s", err.Error())
+	}
+	if !bytes.Equal(pong.Nonce, ping.Nonce) {
+		t.Fatal("pong did not round trip")
+	}
+
+	// pongs always carry the nonce
+	if _, err := ParsePong(bytes.NewReader(nil)); err == nil {
+		t.Fatal("parsed a pong without a nonce")
+	}
+}
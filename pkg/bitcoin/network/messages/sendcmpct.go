@@ -0,0 +1,53 @@
+package messages
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+const COMMAND_SENDCMPCT Command = "sendcmpct"
+
+// Version of compact blocks using wtxids for the short ids, the only one
+// supported
+const CMPCT_VERSION uint64 = 2
+
+// Signals support for compact blocks (BIP152)
+type SendCmpct struct {
+	// 1 byte, the peer is to push new blocks as cmpctblock messages without
+	// being asked, known as high bandwidth mode
+	Announce bool
+
+	// 8 bytes little endian
+	Version uint64
+}
+
+func MakeSendCmpct(announce bool) *SendCmpct {
+	return &SendCmpct{Announce: announce, Version: CMPCT_VERSION}
+}
+
+func ParseSendCmpct(reader *bytes.Reader) (*SendCmpct, error) {
+	if reader.Len() < 9 {
+		return nil, fmt.Errorf("sendcmpct message is too short")
+	}
+
+	announce, _ := reader.ReadByte()
+
+	// version is 8 bytes little endian
+	return &SendCmpct{Announce: announce != 0x00, Version: utils.LittleEndianToUInt64(reader)}, nil
+}
+
+func (s *SendCmpct) Serialize() []byte {
+	result := []byte{0x00}
+	if s.Announce {
+		result[0] = 0x01
+	}
+
+	// version is 8 bytes little endian
+	return append(result, utils.UInt64ToLittleEndianBytes(s.Version)...)
+}
+
+func (s SendCmpct) GetCommand() Command {
+	return COMMAND_SENDCMPCT
+}
@@ -0,0 +1,32 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSendCmpct(t *testing.T) {
+	// low bandwidth mode, version 2
+	raw, _ := hex.DecodeString("000200000000000000")
+
+	s, err := ParseSendCmpct(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the sendcmpct because %s", err.Error())
+	}
+	if s.Announce || s.Version != CMPCT_VERSION {
+		t.Fatalf("unexpected sendcmpct %+v", s)
+	}
+	if !bytes.Equal(MakeSendCmpct(false).Serialize(), raw) {
+		t.Fatal("sendcmpct did not round trip")
+	}
+
+	announce := MakeSendCmpct(true).Serialize()
+	if announce[0] != 0x01 {
+		t.Fatal("announce flag not set")
+	}
+
+	if _, err := ParseSendCmpct(bytes.NewReader(raw[:8])); err == nil {
+		t.Fatal("parsed a truncated sendcmpct")
+	}
+}
@@ -0,0 +1,14 @@
+package messages
+
+const COMMAND_SENDHEADERS Command = "sendheaders"
+
+// Asks the peer to announce new blocks with headers instead of inv (BIP130)
+type SendHeaders struct{}
+
+func (s *SendHeaders) Serialize() []byte {
+	return nil
+}
+
+func (s SendHeaders) GetCommand() Command {
+	return COMMAND_SENDHEADERS
+}
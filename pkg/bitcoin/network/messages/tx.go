@@ -0,0 +1,33 @@
+package messages
+
+import (
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+)
+
+const COMMAND_TX Command = "tx"
+
+// Transaction relayed by the remote peer
+type Tx struct {
+	Transaction *tx.Transaction
+}
+
+func ParseTx(payload []byte) (*Tx, error) {
+	t, err := tx.ParseTransaction(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Transaction: t}, nil
+}
+
+func MakeTx(t *tx.Transaction) *Tx {
+	return &Tx{Transaction: t}
+}
+
+func (t *Tx) Serialize() []byte {
+	return t.Transaction.Serialize()
+}
+
+func (t Tx) GetCommand() Command {
+	return COMMAND_TX
+}
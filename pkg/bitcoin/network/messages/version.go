@@ -1,14 +1,60 @@
 package messages
 
 import (
-	"encoding/hex"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"time"
 
 	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
 )
 
 const COMMAND_VERSION Command = "version"
 
+// Protocol version spoken by this implementation
+const PROTOCOL_VERSION uint32 = 70016
+
+// Oldest protocol version we are willing to talk to
+const MIN_PEER_PROTOCOL_VERSION uint32 = 31800
+
+// Protocol versions which introduced the features we care about
+const (
+	// ping nonces and the pong message (BIP31), in the versions after it
+	BIP31_VERSION uint32 = 60000
+
+	// relay flag in the version message (BIP37)
+	RELAY_VERSION uint32 = 70001
+
+	// sendheaders message (BIP130)
+	SENDHEADERS_VERSION uint32 = 70012
+
+	// feefilter message (BIP133)
+	FEEFILTER_VERSION uint32 = 70013
+
+	// compact blocks (BIP152)
+	SHORT_IDS_BLOCKS_VERSION uint32 = 70014
+
+	// wtxidrelay message (BIP339)
+	WTXID_RELAY_VERSION uint32 = 70016
+)
+
+// Longest user agent accepted from a peer
+const MAX_USER_AGENT_LENGTH = 256
+
+// Service bits advertised in the services field of the version message
+const (
+	NODE_NETWORK         uint64 = 1 << 0
+	NODE_GETUTXO         uint64 = 1 << 1
+	NODE_BLOOM           uint64 = 1 << 2
+	NODE_WITNESS         uint64 = 1 << 3
+	NODE_COMPACT_FILTERS uint64 = 1 << 6
+	NODE_NETWORK_LIMITED uint64 = 1 << 10
+	NODE_P2P_V2          uint64 = 1 << 11
+)
+
 func (v Version) GetCommand() Command {
 	return COMMAND_VERSION
 }
@@ -25,7 +71,7 @@ type Version struct {
 	// 8 byte little endian number
 	ReceiverServices int
 
-	// IPv4, IPv6, OnionCat. This impl only supports IPv4
+	// IPv4 or IPv6, IPv4 is sent as an IPv4-mapped IPv6 address
 	ReceiverIp net.IP
 
 	// Big Endian
@@ -34,7 +80,7 @@ type Version struct {
 	// 8 byte little endian number
 	SenderServices int
 
-	// IPv4, IPv6, OnionCat. This impl only supports IPv4
+	// IPv4 or IPv6, IPv4 is sent as an IPv4-mapped IPv6 address
 	SenderIp net.IP
 
 	// Big Endian
@@ -59,28 +105,122 @@ func MakeVersion(testnet bool) *Version {
 		port = 18333
 	}
 
+	// random nonce so we can detect connecting to ourselves
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+
 	return &Version{
-		Version:          70015,
+		Version:          PROTOCOL_VERSION,
 		Services:         0,
-		Timestamp:        0,
+		Timestamp:        uint64(time.Now().Unix()),
 		ReceiverServices: 0,
 		ReceiverIp:       net.ParseIP("127.0.0.1"),
 		ReceiverPort:     uint16(port),
 		SenderServices:   0,
 		SenderIp:         net.ParseIP("127.0.0.1"),
 		SenderPort:       uint16(port),
-		Nonce:            []byte{},
+		Nonce:            nonce,
 		UserAgent:        []byte("/programmingbitcoin:0.1"),
 		LatestBlock:      0,
 		Relay:            false,
 	}
 }
 
-func IPv4Serialization(ip net.IP) []byte {
-	prefixBytes, _ := hex.DecodeString("00000000000000000000ffff")
-	var ipBytes []byte = ip
-	prefixBytes = append(prefixBytes, ipBytes...)
-	return prefixBytes
+// Checks if the sender advertises the service
+func (v *Version) HasService(service uint64) bool {
+	return v.Services&service == service
+}
+
+// Parses a version message received from a peer
+func ParseVersion(reader *bytes.Reader) (*Version, error) {
+	v := &Version{}
+
+	// version, services, timestamp and the receiver services are fixed size
+	if reader.Len() < 4+8+8+8 {
+		return nil, fmt.Errorf("version message is too short")
+	}
+
+	// version is 4 bytes little endian
+	v.Version = utils.LittleEndianToUInt32(reader)
+
+	// services is an 8 byte little endian integer
+	v.Services = utils.LittleEndianToUInt64(reader)
+
+	// time stamp is 8 bytes little endian
+	v.Timestamp = utils.LittleEndianToUInt64(reader)
+
+	// receiver services is 8 bytes little endian
+	v.ReceiverServices = int(utils.LittleEndianToUInt64(reader))
+
+	var err error
+	if v.ReceiverIp, err = parseIp(reader); err != nil {
+		return nil, err
+	}
+	if v.ReceiverPort, err = parsePort(reader); err != nil {
+		return nil, err
+	}
+
+	// sender services is 8 bytes little endian
+	if reader.Len() < 8 {
+		return nil, fmt.Errorf("version message is missing the sender")
+	}
+	v.SenderServices = int(utils.LittleEndianToUInt64(reader))
+
+	if v.SenderIp, err = parseIp(reader); err != nil {
+		return nil, err
+	}
+	if v.SenderPort, err = parsePort(reader); err != nil {
+		return nil, err
+	}
+
+	// nonce is 8 bytes
+	if v.Nonce, err = ioutil.ReadAll(io.LimitReader(reader, 8)); err != nil {
+		return nil, err
+	}
+	if len(v.Nonce) != 8 {
+		return nil, fmt.Errorf("truncated nonce")
+	}
+
+	// user agent is a varint length followed by the string
+	length := utils.ReadVarIntFromBytes(reader)
+	if length > MAX_USER_AGENT_LENGTH {
+		return nil, fmt.Errorf("user agent of %d bytes is too long", length)
+	}
+	if v.UserAgent, err = ioutil.ReadAll(io.LimitReader(reader, int64(length))); err != nil {
+		return nil, err
+	}
+	if uint64(len(v.UserAgent)) != length {
+		return nil, fmt.Errorf("truncated user agent")
+	}
+
+	// latest block is 4 bytes little endian
+	if reader.Len() < 4 {
+		return nil, fmt.Errorf("version message is missing the start height")
+	}
+	v.LatestBlock = utils.LittleEndianToUInt32(reader)
+
+	// the relay flag was added in 70001 and is optional. When it is missing
+	// the peer wants transactions relayed
+	v.Relay = true
+	if v.Version >= RELAY_VERSION {
+		if relay, err := reader.ReadByte(); err == nil {
+			v.Relay = relay != 0x00
+		}
+	}
+
+	return v, nil
+}
+
+// Negotiates the protocol version to speak with the peer which is the lower
+// of ours and theirs. Peers older than the minimum version are rejected
+func NegotiateVersion(ours, theirs uint32) (uint32, error) {
+	if theirs < MIN_PEER_PROTOCOL_VERSION {
+		return 0, fmt.Errorf("peer protocol version %d is older than the minimum %d", theirs, MIN_PEER_PROTOCOL_VERSION)
+	}
+	if theirs < ours {
+		return theirs, nil
+	}
+	return ours, nil
 }
 
 func (v *Version) Serialize() []byte {
@@ -96,8 +236,8 @@ func (v *Version) Serialize() []byte {
 	// receiver services is 8 bytes little endian
 	result = append(result, utils.UInt64ToLittleEndianBytes(uint64(v.ReceiverServices))...)
 
-	// IPv4 is 10 00 bytes and 2 FF then the receiver IP
-	result = append(result, IPv4Serialization(v.ReceiverIp)...)
+	// IPv6 or IPv4-mapped IPv6 receiver IP
+	result = append(result, IPSerialization(v.ReceiverIp)...)
 
 	// Receiver port is 2 bytles big endian endian
 	result = append(result, utils.ShortToBigEndianBytes(int16(v.ReceiverPort))...)
@@ -105,14 +245,16 @@ func (v *Version) Serialize() []byte {
 	// sender services is 8 bytes little endian
 	result = append(result, utils.UInt64ToLittleEndianBytes(uint64(v.SenderServices))...)
 
-	// IPv4 is 10 00 bytes and 2 FF then the receiver IP
-	result = append(result, IPv4Serialization(v.SenderIp)...)
+	// IPv6 or IPv4-mapped IPv6 sender IP
+	result = append(result, IPSerialization(v.SenderIp)...)
 
 	// Receiver port is 2 bytles big endian endian
 	result = append(result, utils.ShortToBigEndianBytes(int16(v.SenderPort))...)
 
-	// sender services is the nonce value
-	result = append(result, v.Nonce...)
+	// nonce is always 8 bytes
+	nonce := make([]byte, 8)
+	copy(nonce, v.Nonce)
+	result = append(result, nonce...)
 
 	// user agent is a variable length string, starting with a varint signifying the length to read
 	length, _ := utils.EncodeUVarInt(uint64(len(v.UserAgent)))
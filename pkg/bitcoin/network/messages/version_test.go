@@ -1,6 +1,8 @@
 package messages
 
 import (
+	"bytes"
+	"encoding/hex"
 	"net"
 	"testing"
 
@@ -51,3 +53,105 @@ func TestSerialize(t *testing.T) {
 	// }
 	// fmt.Printf("%x\n", resp)
 }
+
+// version message from a Satoshi 0.7.2 node, from the protocol documentation.
+// Predates the relay flag.
+const satoshiVersionPayload = "62ea0000010000000000000011b2d05000000000010000000000000000000000000000000000ffff000000000000010000000000000000000000000000000000ffff0000000000003b2eb35d8ce617650f2f5361746f7368693a302e372e322fc03e0300"
+
+func TestParseVersion(t *testing.T) {
+	raw, _ := hex.DecodeString(satoshiVersionPayload)
+
+	v, err := ParseVersion(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse the version because %s", err.Error())
+	}
+
+	if v.Version != 60002 {
+		t.Fatalf("unexpected version %d", v.Version)
+	}
+	if !v.HasService(NODE_NETWORK) || v.HasService(NODE_COMPACT_FILTERS) {
+		t.Fatalf("unexpected services %x", v.Services)
+	}
+	if v.Timestamp != 1355854353 {
+		t.Fatalf("unexpected timestamp %d", v.Timestamp)
+	}
+	if v.ReceiverServices != 1 || !v.ReceiverIp.Equal(net.IPv4zero) || v.ReceiverPort != 0 {
+		t.Fatal("receiver parsed incorrectly")
+	}
+	if hex.EncodeToString(v.Nonce) != "3b2eb35d8ce61765" {
+		t.Fatalf("unexpected nonce %x", v.Nonce)
+	}
+	if string(v.UserAgent) != "/Satoshi:0.7.2/" {
+		t.Fatalf("unexpected user agent %s", v.UserAgent)
+	}
+	if v.LatestBlock != 212672 {
+		t.Fatalf("unexpected start height %d", v.LatestBlock)
+	}
+
+	// relay defaults to true when the flag is missing
+	if !v.Relay {
+		t.Fatal("relay should default to true")
+	}
+}
+
+func TestParseVersionRelay(t *testing.T) {
+	v := MakeVersion(true)
+	v.Services = NODE_NETWORK | NODE_WITNESS | NODE_COMPACT_FILTERS
+	v.SenderIp = net.ParseIP("10.0.0.1")
+	v.LatestBlock = 2000000
+	v.Relay = false
+
+	parsed, err := ParseVersion(bytes.NewReader(v.Serialize()))
+	if err != nil {
+		t.Fatalf("failed to parse the version because %s", err.Error())
+	}
+
+	if parsed.Relay || !parsed.HasService(NODE_COMPACT_FILTERS) || parsed.LatestBlock != 2000000 {
+		t.Fatal("version parsed incorrectly")
+	}
+	if !parsed.SenderIp.Equal(v.SenderIp) || parsed.SenderPort != 18333 {
+		t.Fatalf("unexpected sender %s:%d", parsed.SenderIp, parsed.SenderPort)
+	}
+	if !bytes.Equal(parsed.Nonce, v.Nonce) || !bytes.Equal(parsed.Serialize(), v.Serialize()) {
+		t.Fatal("failed to round trip the version")
+	}
+}
+
+func TestVersionSerialize(t *testing.T) {
+	v := &Version{
+		Version:      70015,
+		ReceiverIp:   net.IPv4zero,
+		ReceiverPort: 8333,
+		SenderIp:     net.IPv4zero,
+		SenderPort:   8333,
+		Nonce:        make([]byte, 8),
+		UserAgent:    []byte("/programmingbitcoin:0.1/"),
+	}
+
+	expected := "7f11010000000000000000000000000000000000000000000000000000000000000000000000ffff00000000208d000000000000000000000000000000000000ffff00000000208d0000000000000000182f70726f6772616d6d696e67626974636f696e3a302e312f0000000000"
+	if hex.EncodeToString(v.Serialize()) != expected {
+		t.Fatalf("unexpected serialization %x", v.Serialize())
+	}
+}
+
+func TestParseVersionTruncated(t *testing.T) {
+	raw, _ := hex.DecodeString(satoshiVersionPayload)
+
+	for _, length := range []int{0, 20, 50, 80, 90, len(raw) - 2} {
+		if _, err := ParseVersion(bytes.NewReader(raw[:length])); err == nil {
+			t.Fatalf("parsed a version truncated to %d bytes", length)
+		}
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	if v, _ := NegotiateVersion(PROTOCOL_VERSION, 70016); v != PROTOCOL_VERSION {
+		t.Fatalf("negotiated %d with a newer peer", v)
+	}
+	if v, _ := NegotiateVersion(PROTOCOL_VERSION, 60002); v != 60002 {
+		t.Fatalf("negotiated %d with an older peer", v)
+	}
+	if _, err := NegotiateVersion(PROTOCOL_VERSION, 209); err == nil {
+		t.Fatal("negotiated with an ancient peer")
+	}
+}
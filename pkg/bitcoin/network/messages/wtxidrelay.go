@@ -0,0 +1,15 @@
+package messages
+
+const COMMAND_WTXIDRELAY Command = "wtxidrelay"
+
+// Asks the peer to announce transactions by wtxid (BIP339). Sent between
+// version and verack
+type WtxidRelay struct{}
+
+func (w *WtxidRelay) Serialize() []byte {
+	return nil
+}
+
+func (w WtxidRelay) GetCommand() Command {
+	return COMMAND_WTXIDRELAY
+}
@@ -0,0 +1,637 @@
+package peers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/addrman"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/bip324"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/node/simple"
+)
+
+// How long a peer has to complete the version handshake
+const HANDSHAKE_TIMEOUT = 30 * time.Second
+
+// How often the manager tops up its outbound connections
+const CONNECT_INTERVAL = 5 * time.Second
+
+// How often peers are pinged and how long they have to answer
+const (
+	PING_INTERVAL = 2 * time.Minute
+	PING_TIMEOUT  = 20 * time.Minute
+)
+
+// Returned when the manager is shut down
+var ErrShutdown = errors.New("peer manager is shut down")
+
+// Peer did not answer a ping in time, returned wrapped in an *envelope.TimeoutError
+var ErrPingTimeout = simple.ErrPingTimeout
+
+// Message received from a peer
+type PeerMessage struct {
+	Peer    *Peer
+	Message messages.Message
+}
+
+// Connected peer. Messages are read by a goroutine per peer and dispatched to
+// the subscribers of the manager, so the peer is only ever written to directly.
+type Peer struct {
+	*simple.Node
+
+	// host:port of the peer
+	Address string
+
+	// Peer connected to us rather than us to it
+	Inbound bool
+
+	// stops the read loop of the peer
+	cancel context.CancelFunc
+
+	// closed once the peer is disconnected
+	done chan struct{}
+
+	// reason the peer was disconnected, the first failure wins
+	errMu sync.Mutex
+	err   error
+}
+
+// Sends a message to the peer, safe to call from multiple goroutines
+func (p *Peer) Send(msg messages.Message) error {
+	return p.SendContext(context.Background(), msg)
+}
+
+// Sends a message to the peer, giving up when the context is cancelled. Safe
+// to call from multiple goroutines
+func (p *Peer) SendContext(ctx context.Context, msg messages.Message) error {
+	return p.Node.SendContext(ctx, msg)
+}
+
+// Disconnects from the peer
+func (p *Peer) Close() {
+	p.cancel()
+	p.Socket.Close()
+}
+
+// Channel closed once the peer is disconnected
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Reason the peer was disconnected, only valid once Done is closed
+func (p *Peer) Err() error {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	return p.err
+}
+
+// records why the peer is being dropped and disconnects it
+func (p *Peer) fail(err error) {
+	p.errMu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.errMu.Unlock()
+
+	p.Close()
+}
+
+type subscriber struct {
+	commands []messages.Command
+	ch       chan PeerMessage
+}
+
+// Peer manager. Holds up to MaxOutbound outbound connections picked from the
+// address manager and up to MaxInbound connections accepted by Serve, reads
+// from every peer concurrently and dispatches the parsed messages to the
+// subscribers. Pings, the version handshake and, when there is a header store,
+// getheaders requests are handled without involving the subscribers.
+type Manager struct {
+	Testnet bool
+
+	// Number of outbound connections to maintain
+	MaxOutbound int
+
+	// Most inbound connections accepted at once
+	MaxInbound int
+
+	// Headers served to peers asking for them, nil passes getheaders
+	// requests on to the subscribers
+	Headers *block.HeaderStore
+
+	// Source of addresses to connect to, may be nil when connecting manually
+	AddrMan *addrman.AddrMan
+
+	// Opens connections to peers, replaceable for testing or proxying
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Talk the encrypted v2 transport (BIP324) to peers which support it,
+	// falling back to v1 for those which do not
+	V2Transport bool
+
+	// Fee rate in satoshis per 1000 bytes below which peers are asked not to
+	// announce transactions, zero asks for everything
+	MinFeeRate uint64
+
+	// How often peers are pinged, zero disables pinging
+	PingInterval time.Duration
+
+	// How long a peer has to answer a ping before it is disconnected
+	PingTimeout time.Duration
+
+	mu          sync.Mutex
+	peers       map[string]*Peer
+	subscribers []*subscriber
+
+	// inbound connections still in their handshake, each holds one of the
+	// MaxInbound slots
+	handshaking int
+
+	// lifetime of the manager, every peer context derives from it
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// read loops in flight
+	wg sync.WaitGroup
+
+	// set once shut down
+	closed bool
+}
+
+// Default number of inbound connections accepted
+const MAX_INBOUND = 117
+
+func MakeManager(testnet bool, maxOutbound int, addrMan *addrman.AddrMan) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	dialer := &net.Dialer{}
+
+	return &Manager{
+		Testnet:      testnet,
+		MaxOutbound:  maxOutbound,
+		MaxInbound:   MAX_INBOUND,
+		AddrMan:      addrMan,
+		Dial:         dialer.DialContext,
+		PingInterval: PING_INTERVAL,
+		PingTimeout:  PING_TIMEOUT,
+		peers:        make(map[string]*Peer),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Subscribes to messages with the commands, or every message when no commands
+// are given. The channel is closed when the manager is shut down. Subscribers
+// must keep up, a full channel holds up the read loop of the peer.
+func (m *Manager) Subscribe(buffer int, commands ...messages.Command) <-chan PeerMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan PeerMessage, buffer)
+	if m.closed {
+		close(ch)
+		return ch
+	}
+
+	m.subscribers = append(m.subscribers, &subscriber{commands: commands, ch: ch})
+	return ch
+}
+
+// Returns the connected peers
+func (m *Manager) Peers() []*Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Sends the message to every connected peer, returning the number of peers it was sent to
+func (m *Manager) Broadcast(msg messages.Message) int {
+	sent := 0
+	for _, p := range m.Peers() {
+		if err := p.Send(msg); err == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Connects to the peer at host:port, performs the handshake and starts reading
+// from it. The context only bounds connecting, the peer stays connected until
+// it disconnects, is closed or the manager is shut down.
+func (m *Manager) Connect(ctx context.Context, address string) (*Peer, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	if _, ok := m.peers[address]; ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("already connected to %s", address)
+	}
+	m.mu.Unlock()
+
+	var conn net.Conn
+	var err error
+	if m.V2Transport {
+		conn, err = bip324.Dial(ctx, m.Dial, "tcp", address, m.Testnet)
+	} else {
+		conn, err = m.Dial(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := m.handshake(ctx, conn, address, false)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := m.add(peer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// performs the version handshake on the connection, answering it for inbound
+// peers, giving up when the context is cancelled or the peer takes too long
+func (m *Manager) handshake(ctx context.Context, conn net.Conn, address string, inbound bool) (*Peer, error) {
+	ctx, cancel := context.WithTimeout(ctx, HANDSHAKE_TIMEOUT)
+	defer cancel()
+
+	// inbound peers pick the transport, v1 peers are detected by their version
+	if inbound && m.V2Transport {
+		c, err := bip324.Accept(ctx, conn, m.Testnet)
+		if err != nil {
+			return nil, fmt.Errorf("v2 handshake with %s failed because %w", address, err)
+		}
+		conn = c
+	}
+
+	// reads are left unbounded, quiet peers are caught by the pings instead
+	node := &simple.Node{Testnet: m.Testnet, Socket: conn, WriteTimeout: simple.DEFAULT_WRITE_TIMEOUT, MinFeeRate: m.MinFeeRate}
+	if m.Headers != nil {
+		node.StartHeight = uint32(m.Headers.Height())
+	}
+	if m.V2Transport {
+		node.Services |= messages.NODE_P2P_V2
+	}
+
+	var err error
+	if inbound {
+		err = node.AcceptHandshakeContext(ctx)
+	} else {
+		err = node.HandshakeContext(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("handshake with %s failed because %w", address, err)
+	}
+
+	return &Peer{Node: node, Address: address, Inbound: inbound}, nil
+}
+
+// Accepts inbound connections on the listener until the context is cancelled
+// or the manager is shut down, answering their handshakes. Connections past
+// MaxInbound are closed straight away. The listener is closed on return.
+func (m *Manager) Serve(ctx context.Context, listener net.Listener) error {
+	// handshakes in flight are abandoned when serving stops
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// closing the listener unblocks the accept
+	go func() {
+		select {
+		case <-serveCtx.Done():
+		case <-m.ctx.Done():
+			cancel()
+		}
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if m.ctx.Err() != nil {
+				return ErrShutdown
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		if !m.reserveInbound() {
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			// the peer takes over the slot once it is registered
+			defer m.releaseInbound()
+
+			peer, err := m.handshake(serveCtx, conn, conn.RemoteAddr().String(), true)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if err := m.add(peer); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// Listens for inbound connections on the host:port, see Serve
+func (m *Manager) Listen(ctx context.Context, address string) error {
+	lc := &net.ListenConfig{}
+	listener, err := lc.Listen(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return m.Serve(ctx, listener)
+}
+
+// takes an inbound slot for a connection being accepted, so connections
+// still in their handshake count towards MaxInbound
+func (m *Manager) reserveInbound() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.countLocked(true)+m.handshaking >= m.MaxInbound {
+		return false
+	}
+	m.handshaking++
+	return true
+}
+
+// gives back the slot taken by reserveInbound once the handshake is over
+func (m *Manager) releaseInbound() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handshaking--
+}
+
+// counts the inbound or outbound peers, the lock must be held
+func (m *Manager) countLocked(inbound bool) int {
+	count := 0
+	for _, p := range m.peers {
+		if p.Inbound == inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// registers the peer and starts its read loop
+func (m *Manager) add(peer *Peer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrShutdown
+	}
+	if _, ok := m.peers[peer.Address]; ok {
+		return fmt.Errorf("already connected to %s", peer.Address)
+	}
+	if peer.Inbound && m.countLocked(true) >= m.MaxInbound {
+		return fmt.Errorf("too many inbound peers")
+	}
+
+	var ctx context.Context
+	ctx, peer.cancel = context.WithCancel(m.ctx)
+	peer.done = make(chan struct{})
+	m.peers[peer.Address] = peer
+
+	m.wg.Add(1)
+	go m.readLoop(ctx, peer)
+
+	if m.PingInterval > 0 {
+		m.wg.Add(1)
+		go m.pingLoop(ctx, peer)
+	}
+
+	return nil
+}
+
+// reads from the peer until it disconnects, servicing pings and dispatching
+// everything else to the subscribers
+func (m *Manager) readLoop(ctx context.Context, peer *Peer) {
+	defer m.wg.Done()
+	defer func() {
+		peer.Close()
+
+		m.mu.Lock()
+		delete(m.peers, peer.Address)
+		m.mu.Unlock()
+
+		close(peer.done)
+	}()
+
+	for {
+		env, err := peer.ReadContext(ctx)
+		if errors.Is(err, envelope.ErrChecksumMismatch) {
+			// the message is dropped but the stream is still in step
+			continue
+		}
+		if err != nil {
+			peer.fail(err)
+			return
+		}
+
+		cmd := messages.Command(bytes.Trim(env.Command, "\x00"))
+		switch cmd {
+		case messages.COMMAND_PING:
+			// answer pings ourselves with the nonce of the ping
+			if err := peer.SendContext(ctx, messages.MakePong(env.Payload)); err != nil {
+				peer.fail(err)
+				return
+			}
+			continue
+		case messages.COMMAND_PONG:
+			peer.HandlePong(env.Payload)
+			continue
+		case messages.COMMAND_VERSION, messages.COMMAND_VERACK:
+			// the handshake is over, a second one is ignored
+			continue
+		case messages.COMMAND_SENDHEADERS, messages.COMMAND_FEEFILTER, messages.COMMAND_WTXIDRELAY,
+			messages.COMMAND_SENDCMPCT, messages.COMMAND_SENDADDRV2:
+			// relay preferences are kept on the peer, which ignores the
+			// wtxidrelay and sendaddrv2 only allowed before the verack
+			if _, err := peer.HandleNegotiation(cmd, env.Payload); err != nil {
+				peer.fail(err)
+				return
+			}
+			continue
+		case messages.COMMAND_GETHEADERS:
+			if m.Headers != nil {
+				if err := m.serveHeaders(ctx, peer, env.Payload); err != nil {
+					peer.fail(err)
+					return
+				}
+				continue
+			}
+		}
+
+		msg, err := messages.ParseMessage(cmd, env.Payload)
+		if errors.Is(err, messages.ErrUnknownCommand) {
+			// ignore what we don't understand for extensibility
+			continue
+		}
+		if err != nil {
+			peer.fail(fmt.Errorf("invalid %s message from %s because %s", cmd, peer.Address, err.Error()))
+			return
+		}
+
+		if !m.dispatch(ctx, PeerMessage{Peer: peer, Message: msg}) {
+			peer.fail(ctx.Err())
+			return
+		}
+	}
+}
+
+// answers a getheaders request from the header store
+func (m *Manager) serveHeaders(ctx context.Context, peer *Peer, payload []byte) error {
+	request, err := messages.ParseGetHeaders(bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("invalid getheaders message from %s because %s", peer.Address, err.Error())
+	}
+
+	headers := m.Headers.HeadersAfter(request.Locator, request.EndBlock, messages.MAX_HEADERS_RESULTS)
+	return peer.SendContext(ctx, &messages.Headers{BlockHeaders: headers})
+}
+
+// pings the peer every PingInterval, disconnecting it if a ping goes
+// unanswered for PingTimeout
+func (m *Manager) pingLoop(ctx context.Context, peer *Peer) {
+	defer m.wg.Done()
+
+	if err := peer.KeepAlive(ctx, m.PingInterval, m.PingTimeout); err != nil && ctx.Err() == nil {
+		peer.fail(err)
+	}
+}
+
+// hands the message to every interested subscriber. Returns false if the
+// context was cancelled while waiting on a subscriber
+func (m *Manager) dispatch(ctx context.Context, pm PeerMessage) bool {
+	m.mu.Lock()
+	subscribers := append([]*subscriber{}, m.subscribers...)
+	m.mu.Unlock()
+
+	cmd := pm.Message.GetCommand()
+	for _, s := range subscribers {
+		if len(s.commands) > 0 && !containsCommand(s.commands, cmd) {
+			continue
+		}
+		select {
+		case s.ch <- pm:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func containsCommand(commands []messages.Command, cmd messages.Command) bool {
+	for _, c := range commands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// opens outbound connections to candidates from the address manager until
+// MaxOutbound peers are connected
+func (m *Manager) fillOutbound(ctx context.Context) {
+	if m.AddrMan == nil {
+		return
+	}
+
+	m.mu.Lock()
+	needed := m.MaxOutbound - m.countLocked(false)
+	m.mu.Unlock()
+	if needed <= 0 {
+		return
+	}
+
+	// only IP addresses can be dialed directly
+	for _, candidate := range m.AddrMan.Candidates(needed*2, messages.NETWORK_IPV4, messages.NETWORK_IPV6) {
+		if needed == 0 || ctx.Err() != nil {
+			return
+		}
+
+		address := candidate.String()
+		m.mu.Lock()
+		_, connected := m.peers[address]
+		m.mu.Unlock()
+		if connected {
+			continue
+		}
+
+		m.AddrMan.Attempt(candidate)
+		connectCtx, cancel := context.WithTimeout(ctx, HANDSHAKE_TIMEOUT)
+		_, err := m.Connect(connectCtx, address)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		m.AddrMan.Good(candidate)
+		needed--
+	}
+}
+
+// Maintains the outbound connections until the context is cancelled, then
+// shuts the manager down
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(CONNECT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		m.fillOutbound(ctx)
+
+		select {
+		case <-ctx.Done():
+			m.Shutdown()
+			return ctx.Err()
+		case <-m.ctx.Done():
+			return ErrShutdown
+		case <-ticker.C:
+		}
+	}
+}
+
+// Disconnects every peer, waits for the read loops to finish and closes the
+// subscriber channels
+func (m *Manager) Shutdown() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	m.mu.Unlock()
+
+	m.cancel()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	for _, s := range m.subscribers {
+		close(s.ch)
+	}
+	m.subscribers = nil
+	m.mu.Unlock()
+}
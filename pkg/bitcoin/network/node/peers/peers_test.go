@@ -0,0 +1,532 @@
+package peers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/addrman"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/bip324"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/node/simple"
+)
+
+// remote end of a connection to a fake peer
+type fakePeer struct {
+	conn net.Conn
+}
+
+func (f *fakePeer) send(msg messages.Message) error {
+	_, err := f.conn.Write(envelope.Make([]byte(msg.GetCommand()), msg.Serialize(), true).Serialize())
+	return err
+}
+
+func (f *fakePeer) read() (messages.Command, []byte, error) {
+	env, err := envelope.ParseSocket(f.conn, true)
+	if err != nil {
+		return "", nil, err
+	}
+	return messages.Command(bytes.Trim(env.Command, "\x00")), env.Payload, nil
+}
+
+// answers the handshake from the responder side
+func (f *fakePeer) handshake() error {
+	if _, _, err := f.read(); err != nil {
+		return err
+	}
+	if err := f.send(messages.MakeVersion(true)); err != nil {
+		return err
+	}
+
+	// wtxidrelay and sendaddrv2 come before the verack
+	for {
+		cmd, _, err := f.read()
+		if err != nil {
+			return err
+		}
+		if cmd == messages.COMMAND_VERACK {
+			break
+		}
+	}
+	if err := f.send(&messages.VersionAck{}); err != nil {
+		return err
+	}
+
+	// sendheaders then sendcmpct once the handshake is over
+	for i := 0; i < 2; i++ {
+		if _, _, err := f.read(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialer handing out pipes to fake peers, the remote ends are sent on the channel
+func fakeDialer(remotes chan *fakePeer) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		remotes <- &fakePeer{conn: remote}
+		return local, nil
+	}
+}
+
+func TestConnectAndDispatch(t *testing.T) {
+	remotes := make(chan *fakePeer, 1)
+	m := MakeManager(true, 1, nil)
+	m.Dial = fakeDialer(remotes)
+	defer m.Shutdown()
+
+	addrs := m.Subscribe(1, messages.COMMAND_ADDR)
+	all := m.Subscribe(10)
+
+	errs := make(chan error, 1)
+	go func() {
+		f := <-remotes
+		if err := f.handshake(); err != nil {
+			errs <- err
+			return
+		}
+
+		// pings are answered by the manager with the same nonce
+		ping := messages.MakePing()
+		f.send(ping)
+		cmd, payload, err := f.read()
+		if err != nil || cmd != messages.COMMAND_PONG || !bytes.Equal(payload, ping.Nonce) {
+			errs <- fmt.Errorf("expected a pong for the ping, got %s %x", cmd, payload)
+			return
+		}
+
+		// unknown messages are ignored, known ones are dispatched
+		f.conn.Write(envelope.Make([]byte("bogus"), nil, true).Serialize())
+		f.send(messages.MakeAddr([]*messages.NetworkAddress{
+			messages.MakeNetworkAddress(net.ParseIP("1.2.3.4"), 8333, 0),
+		}))
+		errs <- nil
+	}()
+
+	peer, err := m.Connect(context.Background(), "10.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("failed to connect because %s", err.Error())
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ch := range []<-chan PeerMessage{addrs, all} {
+		select {
+		case pm := <-ch:
+			addr, ok := pm.Message.(*messages.Addr)
+			if !ok || pm.Peer != peer || len(addr.Addresses) != 1 {
+				t.Fatalf("unexpected message %v", pm)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the addr message")
+		}
+	}
+
+	if len(m.Peers()) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(m.Peers()))
+	}
+
+	// can't connect twice to the same peer
+	if _, err := m.Connect(context.Background(), "10.0.0.1:8333"); err == nil {
+		t.Fatal("connected to the same peer twice")
+	}
+}
+
+func TestPeerDisconnect(t *testing.T) {
+	remotes := make(chan *fakePeer, 1)
+	m := MakeManager(true, 1, nil)
+	m.Dial = fakeDialer(remotes)
+	defer m.Shutdown()
+
+	go func() {
+		f := <-remotes
+		f.handshake()
+		f.conn.Close()
+	}()
+
+	peer, err := m.Connect(context.Background(), "10.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("failed to connect because %s", err.Error())
+	}
+
+	select {
+	case <-peer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer disconnect was not noticed")
+	}
+
+	if peer.Err() == nil || len(m.Peers()) != 0 {
+		t.Fatal("disconnected peer was not removed")
+	}
+}
+
+func TestConnectCancelled(t *testing.T) {
+	remotes := make(chan *fakePeer, 1)
+	m := MakeManager(true, 1, nil)
+	m.Dial = fakeDialer(remotes)
+	defer m.Shutdown()
+
+	// peer which never answers the handshake
+	go func() {
+		f := <-remotes
+		f.read()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := m.Connect(ctx, "10.0.0.1:8333"); err == nil {
+		t.Fatal("connected to a peer which never finished the handshake")
+	}
+}
+
+func TestRunFillsOutboundAndShutsDown(t *testing.T) {
+	book := addrman.MakeAddrMan("")
+	var addresses []*messages.NetworkAddress
+	for i := 1; i <= 3; i++ {
+		a := messages.MakeNetworkAddress(net.ParseIP(fmt.Sprintf("%d.1.1.1", i)), 8333, 0)
+		a.Time = uint32(time.Now().Unix())
+		addresses = append(addresses, a)
+	}
+	book.Add(addresses, "seed")
+
+	remotes := make(chan *fakePeer, 3)
+	m := MakeManager(true, 2, book)
+	m.Dial = fakeDialer(remotes)
+
+	var wg sync.WaitGroup
+	go func() {
+		for f := range remotes {
+			wg.Add(1)
+			go func(f *fakePeer) {
+				defer wg.Done()
+				f.handshake()
+				// hold the connection open until the manager closes it
+				for {
+					if _, _, err := f.read(); err != nil {
+						return
+					}
+				}
+			}(f)
+		}
+	}()
+
+	sub := m.Subscribe(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.Peers()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 peers, got %d", len(m.Peers()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after the context was cancelled")
+	}
+
+	if len(m.Peers()) != 0 {
+		t.Fatal("peers still connected after shutdown")
+	}
+	if _, ok := <-sub; ok {
+		t.Fatal("subscription was not closed")
+	}
+
+	close(remotes)
+	wg.Wait()
+}
+
+func TestPingTimeout(t *testing.T) {
+	remotes := make(chan *fakePeer, 1)
+	m := MakeManager(true, 1, nil)
+	m.Dial = fakeDialer(remotes)
+	m.PingInterval = 10 * time.Millisecond
+	m.PingTimeout = 50 * time.Millisecond
+	defer m.Shutdown()
+
+	// peer reads our pings but never answers them
+	go func() {
+		f := <-remotes
+		f.handshake()
+		for {
+			if _, _, err := f.read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	peer, err := m.Connect(context.Background(), "10.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("failed to connect because %s", err.Error())
+	}
+
+	select {
+	case <-peer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("unresponsive peer was not disconnected")
+	}
+
+	if !envelope.IsTimeout(peer.Err()) || !errors.Is(peer.Err(), ErrPingTimeout) {
+		t.Fatalf("expected a ping timeout, got %v", peer.Err())
+	}
+}
+
+func TestPingAnswered(t *testing.T) {
+	remotes := make(chan *fakePeer, 1)
+	m := MakeManager(true, 1, nil)
+	m.Dial = fakeDialer(remotes)
+	m.PingInterval = 10 * time.Millisecond
+	m.PingTimeout = 50 * time.Millisecond
+	defer m.Shutdown()
+
+	// peer answering every ping
+	pings := make(chan struct{}, 100)
+	go func() {
+		f := <-remotes
+		f.handshake()
+		for {
+			cmd, payload, err := f.read()
+			if err != nil {
+				return
+			}
+			if cmd == messages.COMMAND_PING {
+				f.send(messages.MakePong(payload))
+				pings <- struct{}{}
+			}
+		}
+	}()
+
+	peer, err := m.Connect(context.Background(), "10.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("failed to connect because %s", err.Error())
+	}
+
+	// several ping timeouts pass without the peer being dropped
+	for i := 0; i < 10; i++ {
+		select {
+		case <-pings:
+		case <-peer.Done():
+			t.Fatalf("responsive peer was disconnected because %v", peer.Err())
+		case <-time.After(5 * time.Second):
+			t.Fatal("peer was not pinged")
+		}
+	}
+}
+
+func TestServeInbound(t *testing.T) {
+	genesis, err := block.GetTestnetGenesisBlock()
+	if err != nil {
+		t.Fatalf("failed to get the genesis block because %s", err.Error())
+	}
+	store, err := block.MakeHeaderStore(genesis)
+	if err != nil {
+		t.Fatalf("failed to make the header store because %s", err.Error())
+	}
+	previous := genesis
+	for i := 1; i <= 10; i++ {
+		prevHash, _ := previous.Hash()
+		header := &block.BlockHeader{
+			Version:       1,
+			PreviousBlock: prevHash,
+			MerkleRoot:    make([]byte, 32),
+			Timestamp:     genesis.Timestamp + i*600,
+			Bits:          genesis.Bits,
+			Nonce:         []byte{byte(i), 0, 0, 0},
+		}
+		if err := store.Add(header); err != nil {
+			t.Fatalf("failed to add header %d because %s", i, err.Error())
+		}
+		previous = header
+	}
+
+	m := MakeManager(true, 0, nil)
+	m.MaxInbound = 1
+	m.Headers = store
+	defer m.Shutdown()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen because %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- m.Serve(ctx, listener) }()
+
+	// connect with one of our own nodes
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial because %s", err.Error())
+	}
+	defer conn.Close()
+
+	node := &simple.Node{Testnet: true, Socket: conn, ReadTimeout: 5 * time.Second}
+	if err := node.HandshakeContext(context.Background()); err != nil {
+		t.Fatalf("handshake failed because %s", err.Error())
+	}
+	if node.PeerVersion.LatestBlock != 10 {
+		t.Fatalf("expected a start height of 10, got %d", node.PeerVersion.LatestBlock)
+	}
+
+	// headers after the fifth block
+	hash5, _ := store.Get(5).Hash()
+	getHeaders, _ := messages.MakeGetHeadersFromLocator(messages.PROTOCOL_VERSION, [][]byte{hash5}, nil)
+	if err := node.Send(getHeaders); err != nil {
+		t.Fatalf("failed to send getheaders because %s", err.Error())
+	}
+	msg, err := node.WaitFor(messages.COMMAND_HEADERS)
+	if err != nil {
+		t.Fatalf("failed to receive the headers because %s", err.Error())
+	}
+	headers := (*msg).(*messages.Headers)
+	if len(headers.BlockHeaders) != 5 || headers.BlockHeaders[4].Timestamp != store.Get(10).Timestamp {
+		t.Fatalf("expected the last 5 headers, got %d", len(headers.BlockHeaders))
+	}
+
+	peers := m.Peers()
+	if len(peers) != 1 || !peers[0].Inbound {
+		t.Fatal("inbound peer was not registered")
+	}
+
+	// only one inbound peer is allowed, the next connection is dropped
+	extra, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial because %s", err.Error())
+	}
+	defer extra.Close()
+	extra.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := extra.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("connection past the inbound limit was not closed, %v", err)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != context.Canceled {
+			t.Fatalf("unexpected error from serve %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the context was cancelled")
+	}
+}
+
+func TestServeReservesInbound(t *testing.T) {
+	m := MakeManager(true, 0, nil)
+	m.MaxInbound = 1
+	defer m.Shutdown()
+	address := serveLoopback(t, m)
+
+	// a connection which hasn't finished its handshake holds the only slot
+	stalled, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to dial because %s", err.Error())
+	}
+	extra, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to dial because %s", err.Error())
+	}
+	defer extra.Close()
+	extra.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := extra.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("connection past the inbound limit was not closed, %v", err)
+	}
+
+	// the failed handshake gives the slot back
+	stalled.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("failed to dial because %s", err.Error())
+		}
+		node := &simple.Node{Testnet: true, Socket: conn, ReadTimeout: 5 * time.Second}
+		err = node.HandshakeContext(context.Background())
+		if err == nil {
+			defer conn.Close()
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("slot of the failed handshake was not released, %s", err.Error())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the peer is registered just after our side of the handshake is over
+	for len(m.Peers()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if peers := m.Peers(); len(peers) != 1 || !peers[0].Inbound {
+		t.Fatal("inbound peer was not registered")
+	}
+}
+
+// serves the manager on a loopback listener, returning its address
+func serveLoopback(t *testing.T, m *Manager) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen because %s", err.Error())
+	}
+	go m.Serve(context.Background(), listener)
+	return listener.Addr().String()
+}
+
+func TestV2Transport(t *testing.T) {
+	for _, serverV2 := range []bool{true, false} {
+		server := MakeManager(true, 0, nil)
+		server.V2Transport = serverV2
+		address := serveLoopback(t, server)
+
+		client := MakeManager(true, 1, nil)
+		client.V2Transport = true
+		addrs := server.Subscribe(1, messages.COMMAND_ADDR)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		peer, err := client.Connect(ctx, address)
+		cancel()
+		if err != nil {
+			t.Fatalf("failed to connect because %s", err.Error())
+		}
+
+		conn, ok := peer.Socket.(*bip324.Conn)
+		if !ok || conn.V2() != serverV2 {
+			t.Fatalf("expected v2 to be %t", serverV2)
+		}
+		if peer.PeerHasService(messages.NODE_P2P_V2) != serverV2 {
+			t.Fatal("v2 support was not advertised")
+		}
+
+		// messages get through either way
+		client.Broadcast(messages.MakeAddr([]*messages.NetworkAddress{
+			messages.MakeNetworkAddress(net.ParseIP("1.2.3.4"), 8333, 0),
+		}))
+		select {
+		case pm := <-addrs:
+			if len(pm.Message.(*messages.Addr).Addresses) != 1 {
+				t.Fatal("addr message was mangled")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("addr message was not received")
+		}
+
+		client.Shutdown()
+		server.Shutdown()
+	}
+}
@@ -0,0 +1,52 @@
+package simple
+
+import (
+	"context"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+)
+
+// Asks the peer for the addresses of the peers it knows about
+func (n *Node) GetAddresses(ctx context.Context) ([]*messages.NetworkAddress, error) {
+	if err := n.SendContext(ctx, &messages.GetAddr{}); err != nil {
+		return nil, err
+	}
+
+	msg, err := n.WaitForContext(ctx, messages.COMMAND_ADDR, messages.COMMAND_ADDRV2)
+	if err != nil {
+		return nil, err
+	}
+
+	switch m := (*msg).(type) {
+	case *messages.Addr:
+		return m.Addresses, nil
+	case *messages.AddrV2:
+		return m.Addresses, nil
+	}
+	return nil, nil
+}
+
+// Advertises the addresses to the peer. Peers which asked for addrv2 get
+// everything, other peers only get the addresses the legacy addr message can hold
+func (n *Node) AdvertiseAddresses(ctx context.Context, addresses []*messages.NetworkAddress) error {
+	for len(addresses) > 0 {
+		batch := addresses
+		if len(batch) > messages.MAX_ADDR_TO_SEND {
+			batch = batch[:messages.MAX_ADDR_TO_SEND]
+		}
+		addresses = addresses[len(batch):]
+
+		var msg messages.Message
+		if n.Features().AddrV2 {
+			msg = messages.MakeAddrV2(batch)
+		} else {
+			msg = messages.MakeAddr(batch)
+		}
+
+		if err := n.SendContext(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+)
+
+func TestGetAddresses(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	address := messages.MakeNetworkAddress(net.ParseIP("1.2.3.4"), 8333, messages.NODE_NETWORK)
+	go func() {
+		env, err := envelope.ParseSocket(remote, true)
+		if err != nil || messages.Command(bytes.Trim(env.Command, "\x00")) != messages.COMMAND_GETADDR {
+			t.Errorf("expected a getaddr")
+			return
+		}
+		addrV2 := messages.MakeAddrV2([]*messages.NetworkAddress{address})
+		remote.Write(envelope.Make([]byte(messages.COMMAND_ADDRV2), addrV2.Serialize(), true).Serialize())
+	}()
+
+	node := &Node{Testnet: true, Socket: local}
+	addresses, err := node.GetAddresses(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get the addresses because %s", err.Error())
+	}
+
+	if len(addresses) != 1 || addresses[0].String() != "1.2.3.4:8333" {
+		t.Fatalf("unexpected addresses %v", addresses)
+	}
+}
+
+func TestAdvertiseAddresses(t *testing.T) {
+	onion, _ := messages.ParseNetworkAddress("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion", 8333, 0)
+	addresses := []*messages.NetworkAddress{onion}
+
+	for _, addrV2 := range []bool{false, true} {
+		local, remote := net.Pipe()
+
+		received := make(chan *envelope.Envelope, 1)
+		go func() {
+			env, _ := envelope.ParseSocket(remote, true)
+			received <- env
+		}()
+
+		node := &Node{Testnet: true, Socket: local}
+		if addrV2 {
+			node.HandleNegotiation(messages.COMMAND_SENDADDRV2, nil)
+		}
+		if err := node.AdvertiseAddresses(context.Background(), addresses); err != nil {
+			t.Fatalf("failed to advertise the addresses because %s", err.Error())
+		}
+		env := <-received
+
+		// legacy peers can't be sent the onion address
+		expected := messages.COMMAND_ADDR
+		count := 0
+		if addrV2 {
+			expected = messages.COMMAND_ADDRV2
+			count = 1
+		}
+		if messages.Command(bytes.Trim(env.Command, "\x00")) != expected || int(env.Payload[0]) != count {
+			t.Fatalf("unexpected %s message with %d addresses", env.Command, env.Payload[0])
+		}
+
+		local.Close()
+		remote.Close()
+	}
+}
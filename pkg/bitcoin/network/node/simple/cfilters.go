@@ -0,0 +1,102 @@
+package simple
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// Downloads the basic filter headers (BIP157) for a chain of trusted block
+// headers starting at the genesis block, so the index of each header is its
+// height. The checkpoints for the chain are requested first and every batch of
+// filter headers is checked against them as it is downloaded.
+//
+// Returns the filter header of each block, big endian, indexed by height.
+func (n *Node) GetFilterHeaders(ctx context.Context, headers []*block.BlockHeader) ([][]byte, error) {
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("no block headers supplied")
+	}
+
+	// hash every header up front, they are needed as stop hashes
+	hashes := make([][]byte, 0, len(headers))
+	for _, header := range headers {
+		hash, err := header.Hash()
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	tip := len(headers) - 1
+
+	//
+	// request the checkpoints up to the tip
+	//
+	if err := n.SendContext(ctx, messages.MakeGetCFCheckpt(block.BASIC_FILTER_TYPE, hashes[tip])); err != nil {
+		return nil, err
+	}
+	msg, err := n.WaitForContext(ctx, messages.COMMAND_CFCHECKPT)
+	if err != nil {
+		return nil, err
+	}
+	checkpt := (*msg).(*messages.CFCheckpt)
+
+	if checkpt.FilterType != block.BASIC_FILTER_TYPE || !utils.CompareByteArrays(checkpt.StopHash, hashes[tip]) {
+		return nil, fmt.Errorf("received checkpoints for the wrong filter type or chain")
+	}
+	if len(checkpt.FilterHeaders) != tip/messages.CFCHECKPT_INTERVAL {
+		return nil, fmt.Errorf("expected %d checkpoints, received %d", tip/messages.CFCHECKPT_INTERVAL, len(checkpt.FilterHeaders))
+	}
+
+	//
+	// download the filter headers in batches, chaining each batch onto the last
+	//
+	filterHeaders := make([][]byte, 0, len(headers))
+	previous := make([]byte, 32)
+	for start := 0; start <= tip; start += messages.MAX_GETCFHEADERS_SIZE {
+		stop := start + messages.MAX_GETCFHEADERS_SIZE - 1
+		if stop > tip {
+			stop = tip
+		}
+
+		if err := n.SendContext(ctx, messages.MakeGetCFHeaders(block.BASIC_FILTER_TYPE, uint32(start), hashes[stop])); err != nil {
+			return nil, err
+		}
+		msg, err := n.WaitForContext(ctx, messages.COMMAND_CFHEADERS)
+		if err != nil {
+			return nil, err
+		}
+		cfheaders := (*msg).(*messages.CFHeaders)
+
+		if cfheaders.FilterType != block.BASIC_FILTER_TYPE || !utils.CompareByteArrays(cfheaders.StopHash, hashes[stop]) {
+			return nil, fmt.Errorf("received filter headers for the wrong filter type or range")
+		}
+		if len(cfheaders.FilterHashes) != stop-start+1 {
+			return nil, fmt.Errorf("expected %d filter hashes, received %d", stop-start+1, len(cfheaders.FilterHashes))
+		}
+
+		// the batch must continue on from the headers we already have
+		if !utils.CompareByteArrays(cfheaders.PreviousFilterHeader, previous) {
+			return nil, fmt.Errorf("filter headers starting at %d do not connect to the previous filter header", start)
+		}
+
+		for i, header := range cfheaders.FilterHeaders() {
+			height := start + i
+
+			// check the header against the checkpoint at every interval
+			if height > 0 && height%messages.CFCHECKPT_INTERVAL == 0 {
+				checkpoint := checkpt.FilterHeaders[height/messages.CFCHECKPT_INTERVAL-1]
+				if !utils.CompareByteArrays(header, checkpoint) {
+					return nil, fmt.Errorf("filter header at height %d does not match the checkpoint", height)
+				}
+			}
+
+			filterHeaders = append(filterHeaders, header)
+			previous = header
+		}
+	}
+
+	return filterHeaders, nil
+}
@@ -0,0 +1,128 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// makes a chain of headers linked by their previous block hashes
+func makeFilterTestChain(t *testing.T, length int) ([]*block.BlockHeader, map[string]int) {
+	bits, _ := block.GetLowestBitsBytes()
+	headers := make([]*block.BlockHeader, 0, length)
+	heights := make(map[string]int)
+	previous := make([]byte, 32)
+	for i := 0; i < length; i++ {
+		header := &block.BlockHeader{
+			Version:       1,
+			PreviousBlock: previous,
+			MerkleRoot:    utils.Hash256(utils.IntToLittleEndianBytes(i)),
+			Timestamp:     i,
+			Bits:          bits,
+			Nonce:         make([]byte, 4),
+		}
+		hash, err := header.Hash()
+		if err != nil {
+			t.Fatalf("failed to hash the header because %s", err.Error())
+		}
+		headers = append(headers, header)
+		heights[string(hash)] = i
+		previous = hash
+	}
+	return headers, heights
+}
+
+// fake peer serving filter headers for the chain. The filter hash of each block
+// is derived from its height and the checkpoint at badCheckpoint is corrupted
+func serveFilterHeaders(conn net.Conn, heights map[string]int, badCheckpoint int) {
+	filterHashes := make([][]byte, len(heights))
+	filterHeaders := make([][]byte, len(heights))
+	previous := make([]byte, 32)
+	for i := range filterHashes {
+		filterHashes[i] = utils.Hash256([]byte{byte(i), byte(i >> 8)})
+		filterHeaders[i] = block.FilterHeader(filterHashes[i], previous)
+		previous = filterHeaders[i]
+	}
+
+	for {
+		env, err := envelope.ParseSocket(conn, true)
+		if err != nil {
+			return
+		}
+
+		var response messages.Message
+		switch messages.Command(bytes.Trim(env.Command, "\x00")) {
+		case messages.COMMAND_GETCFCHECKPT:
+			g, _ := messages.ParseGetCFCheckpt(bytes.NewReader(env.Payload))
+			c := &messages.CFCheckpt{FilterType: g.FilterType, StopHash: g.StopHash}
+			for h := messages.CFCHECKPT_INTERVAL; h <= heights[string(g.StopHash)]; h += messages.CFCHECKPT_INTERVAL {
+				header := filterHeaders[h]
+				if h == badCheckpoint {
+					header = make([]byte, 32)
+				}
+				c.FilterHeaders = append(c.FilterHeaders, header)
+			}
+			response = c
+		case messages.COMMAND_GETCFHEADERS:
+			g, _ := messages.ParseGetCFHeaders(bytes.NewReader(env.Payload))
+			start, stop := int(g.StartHeight), heights[string(g.StopHash)]
+			c := &messages.CFHeaders{
+				FilterType:           g.FilterType,
+				StopHash:             g.StopHash,
+				PreviousFilterHeader: make([]byte, 32),
+				FilterHashes:         filterHashes[start : stop+1],
+			}
+			if start > 0 {
+				c.PreviousFilterHeader = filterHeaders[start-1]
+			}
+			response = c
+		}
+
+		conn.Write(envelope.Make([]byte(response.GetCommand()), response.Serialize(), true).Serialize())
+	}
+}
+
+func TestGetFilterHeaders(t *testing.T) {
+	headers, heights := makeFilterTestChain(t, 2500)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go serveFilterHeaders(remote, heights, -1)
+
+	node := &Node{Testnet: true, Socket: local}
+	filterHeaders, err := node.GetFilterHeaders(context.Background(), headers)
+	if err != nil {
+		t.Fatalf("failed to get the filter headers because %s", err.Error())
+	}
+
+	if len(filterHeaders) != 2500 {
+		t.Fatalf("expected 2500 filter headers, got %d", len(filterHeaders))
+	}
+
+	// the last header must chain on from the one before it, 2499 = 0x09c3
+	last := block.FilterHeader(utils.Hash256([]byte{0xc3, 0x09}), filterHeaders[2498])
+	if !bytes.Equal(filterHeaders[2499], last) {
+		t.Fatal("filter headers do not chain")
+	}
+}
+
+func TestGetFilterHeadersBadCheckpoint(t *testing.T) {
+	headers, heights := makeFilterTestChain(t, 2500)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go serveFilterHeaders(remote, heights, 2000)
+
+	node := &Node{Testnet: true, Socket: local}
+	if _, err := node.GetFilterHeaders(context.Background(), headers); err == nil {
+		t.Fatal("accepted filter headers which do not match the checkpoints")
+	}
+}
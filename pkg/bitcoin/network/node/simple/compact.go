@@ -0,0 +1,65 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/compact"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+)
+
+// Downloads the block as a compact block (BIP152), rebuilding it from the
+// mempool and asking the peer for the transactions which are not in it. Fails
+// with compact.ErrShortIDCollision or compact.ErrBadReconstruction when the
+// block has to be downloaded in full instead.
+func (n *Node) GetCompactBlock(ctx context.Context, hash []byte, mempool []*tx.Transaction) (*block.Block, error) {
+	getData := messages.MakeGetDataMessage()
+	getData.Add(messages.COMPACT_BLOCK_DATA_TYPE, hash)
+	if err := n.SendContext(ctx, getData); err != nil {
+		return nil, err
+	}
+
+	msg, err := n.WaitForContext(ctx, messages.COMMAND_CMPCTBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	cmpct := (*msg).(*messages.CmpctBlock)
+
+	received, err := cmpct.Header.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(received, hash) {
+		return nil, fmt.Errorf("asked for block %x, received %x", hash, received)
+	}
+
+	return n.ReconstructBlock(ctx, cmpct, mempool)
+}
+
+// Rebuilds the block of a compact block received from the peer, asking the
+// peer for the transactions which are not in the mempool
+func (n *Node) ReconstructBlock(ctx context.Context, cmpct *messages.CmpctBlock, mempool []*tx.Transaction) (*block.Block, error) {
+	partial, err := compact.MakePartialBlock(cmpct, mempool)
+	if err != nil {
+		return nil, err
+	}
+
+	request := partial.GetBlockTxn()
+	if request == nil {
+		return partial.Block()
+	}
+
+	if err := n.SendContext(ctx, request); err != nil {
+		return nil, err
+	}
+
+	msg, err := n.WaitForContext(ctx, messages.COMMAND_BLOCKTXN)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.Fill((*msg).(*messages.BlockTxn))
+}
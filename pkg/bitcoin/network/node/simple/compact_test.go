@@ -0,0 +1,66 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+)
+
+// Compact block of the genesis coinbase, a legacy transaction and the BIP143
+// native p2wpkh example, the segwit transaction being sent in blocktxn
+const (
+	testCompactBlockHash = "5fbc58afb57217306f513531134d39f8ec3dd77f53b2753f222706470029edd6"
+
+	testCmpctBlock = "0100000000000000000000000000000000000000000000000000000000000000000000001e2017d2a5e3306c8910ead20fcbc8df36acafb2cf586ba9d6b868b6cca6f8b5dae5494dffff001d1aa4ae18efcdab896745230102c6b806a51073e7a3278c051c010001000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+	testBlockTxn = "d6ed2900470627223f75b2537fd73decf8394d133135516f301772b5af58bc5f0101000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+
+	testMempoolTx = "010000000456919960ac691763688d3d3bcea9ad6ecaf875df5339e148a1fc61c6ed7a069e010000006a47304402204585bcdef85e6b1c6af5c2669d4830ff86e42dd205c0e089bc2a821657e951c002201024a10366077f87d6bce1f7100ad8cfa8a064b39d4e8fe4ea13a7b71aa8180f012102f0da57e85eec2934a82a585ea337ce2f4998b50ae699dd79f5880e253dafafb7feffffffeb8f51f4038dc17e6313cf831d4f02281c2a468bde0fafd37f1bf882729e7fd3000000006a47304402207899531a52d59a6de200179928ca900254a36b8dff8bb75f5f5d71b1cdc26125022008b422690b8461cb52c3cc30330b23d574351872b7c361e9aae3649071c1a7160121035d5c93d9ac96881f19ba1f686f15f009ded7c62efe85a872e6a19b43c15a2937feffffff567bf40595119d1bb8a3037c356efd56170b64cbcc160fb028fa10704b45d775000000006a47304402204c7c7818424c7f7911da6cddc59655a70af1cb5eaf17c69dadbfc74ffa0b662f02207599e08bc8023693ad4e9527dc42c34210f7a7d1d1ddfc8492b654a11e7620a0012102158b46fbdff65d0172b7989aec8850aa0dae49abfb84c81ae6e5b251a58ace5cfeffffffd63a5e6c16e620f86f375925b21cabaf736c779f88fd04dcad51d26690f7f345010000006a47304402200633ea0d3314bea0d95b3cd8dadb2ef79ea8331ffe1e61f762c0f6daea0fabde022029f23b3e9c30f080446150b23852028751635dcee2be669c2a1686a4b5edf304012103ffd6f4a67e94aba353a00882e563ff2722eb4cff0ad6006e86ee20dfe7520d55feffffff0251430f00000000001976a914ab0c0b2e98b1ab6dbf67d4750b0a56244948a87988ac005a6202000000001976a9143c82d7df364eb6c75be8c80df2b3eda8db57397088ac46430600"
+)
+
+func TestGetCompactBlock(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	hash, _ := hex.DecodeString(testCompactBlockHash)
+	cmpct, _ := hex.DecodeString(testCmpctBlock)
+	blockTxn, _ := hex.DecodeString(testBlockTxn)
+	raw, _ := hex.DecodeString(testMempoolTx)
+	mempoolTx, _ := tx.ParseTransaction(raw)
+
+	go func() {
+		reader := envelope.MakeReader(remote, true)
+
+		cmd, payload := readCommand(t, reader)
+		getData, err := messages.ParseGetData(bytes.NewReader(payload))
+		if cmd != messages.COMMAND_GETDATA || err != nil || getData.Data[0].Type != messages.COMPACT_BLOCK_DATA_TYPE {
+			t.Errorf("expected a getdata for the compact block, received %s", cmd)
+			return
+		}
+		remote.Write(envelope.Make([]byte(messages.COMMAND_CMPCTBLOCK), cmpct, true).Serialize())
+
+		// only the segwit transaction is missing from the mempool
+		cmd, payload = readCommand(t, reader)
+		request, err := messages.ParseGetBlockTxn(bytes.NewReader(payload))
+		if cmd != messages.COMMAND_GETBLOCKTXN || err != nil || len(request.Indexes) != 1 || request.Indexes[0] != 2 {
+			t.Errorf("expected a getblocktxn for index 2, received %s", cmd)
+			return
+		}
+		remote.Write(envelope.Make([]byte(messages.COMMAND_BLOCKTXN), blockTxn, true).Serialize())
+	}()
+
+	node := &Node{Testnet: true, Socket: local}
+	b, err := node.GetCompactBlock(context.Background(), hash, []*tx.Transaction{mempoolTx})
+	if err != nil {
+		t.Fatalf("failed to get the compact block because %s", err.Error())
+	}
+	if len(b.Transactions) != 3 || !bytes.Equal(b.Transactions[1].Hash(), mempoolTx.Hash()) {
+		t.Fatal("rebuilt the wrong block")
+	}
+}
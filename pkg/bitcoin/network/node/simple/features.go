@@ -0,0 +1,158 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+)
+
+// Relay preferences the peer negotiated around the handshake
+type PeerFeatures struct {
+	// announce new blocks with headers rather than inv (BIP130)
+	SendHeaders bool
+
+	// transactions paying less, in satoshis per 1000 bytes, are not
+	// announced (BIP133)
+	FeeFilter uint64
+
+	// announce transactions by wtxid rather than txid (BIP339)
+	WtxidRelay bool
+
+	// compact block version supported by the peer, zero if none (BIP152)
+	CompactVersion uint64
+
+	// peer wants new blocks pushed as compact blocks without asking
+	CompactAnnounce bool
+
+	// relay addresses as addrv2 (BIP155)
+	AddrV2 bool
+}
+
+// Relay preferences negotiated by the peer so far
+func (n *Node) Features() PeerFeatures {
+	n.featuresMu.Lock()
+	defer n.featuresMu.Unlock()
+	return n.features
+}
+
+// records the verack of the peer, ending the window for wtxidrelay and
+// sendaddrv2
+func (n *Node) receivedVerack() {
+	n.featuresMu.Lock()
+	defer n.featuresMu.Unlock()
+	n.verackReceived = true
+}
+
+// Records the relay preferences sent by the peer in sendheaders, feefilter,
+// wtxidrelay, sendcmpct and sendaddrv2 messages. Returns false for any other
+// command
+func (n *Node) HandleNegotiation(cmd messages.Command, payload []byte) (bool, error) {
+	n.featuresMu.Lock()
+	defer n.featuresMu.Unlock()
+
+	switch cmd {
+	case messages.COMMAND_SENDADDRV2:
+		// peer wants addresses relayed as addrv2. BIP155 only allows it
+		// before the verack, later ones are ignored
+		if !n.verackReceived {
+			n.features.AddrV2 = true
+		}
+	case messages.COMMAND_SENDHEADERS:
+		n.features.SendHeaders = true
+	case messages.COMMAND_WTXIDRELAY:
+		// BIP339 only allows it before the verack, and only when we sent
+		// wtxidrelay too, which needs a version that knows about it
+		if !n.verackReceived && n.ProtocolVersion >= messages.WTXID_RELAY_VERSION {
+			n.features.WtxidRelay = true
+		}
+	case messages.COMMAND_FEEFILTER:
+		filter, err := messages.ParseFeeFilter(bytes.NewReader(payload))
+		if err != nil {
+			return true, err
+		}
+		n.features.FeeFilter = filter.FeeRate
+	case messages.COMMAND_SENDCMPCT:
+		sendCmpct, err := messages.ParseSendCmpct(bytes.NewReader(payload))
+		if err != nil {
+			return true, err
+		}
+		// other versions are ignored, peers send one per version they support
+		if sendCmpct.Version == messages.CMPCT_VERSION {
+			n.features.CompactVersion = sendCmpct.Version
+			n.features.CompactAnnounce = sendCmpct.Announce
+		}
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// tells the peer how we want things relayed, sent once the handshake is over
+func (n *Node) sendNegotiation(ctx context.Context) error {
+	if n.ProtocolVersion >= messages.SENDHEADERS_VERSION {
+		if err := n.SendContext(ctx, &messages.SendHeaders{}); err != nil {
+			return err
+		}
+	}
+
+	// compact blocks are only asked for, never pushed to us
+	if n.ProtocolVersion >= messages.SHORT_IDS_BLOCKS_VERSION {
+		if err := n.SendContext(ctx, messages.MakeSendCmpct(false)); err != nil {
+			return err
+		}
+	}
+
+	if n.MinFeeRate > 0 && n.ProtocolVersion >= messages.FEEFILTER_VERSION {
+		if err := n.SendContext(ctx, messages.MakeFeeFilter(n.MinFeeRate)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Announces a new block to the peer, as a header if the peer asked for
+// sendheaders, otherwise as an inv
+func (n *Node) AnnounceBlock(ctx context.Context, header *block.BlockHeader) error {
+	if n.Features().SendHeaders {
+		return n.SendContext(ctx, &messages.Headers{BlockHeaders: []*block.BlockHeader{header}})
+	}
+
+	hash, err := header.Hash()
+	if err != nil {
+		return err
+	}
+
+	inv := messages.MakeInv()
+	inv.Add(messages.BLOCK_DATA_TYPE, hash)
+	return n.SendContext(ctx, inv)
+}
+
+// Announces a transaction to the peer, by wtxid if the peer asked for
+// wtxidrelay. Transactions below the fee filter of the peer, or sent to a peer
+// which does not want transactions relayed, are skipped. Returns whether the
+// transaction was announced
+func (n *Node) AnnounceTx(ctx context.Context, txid, wtxid []byte, feeRate uint64) (bool, error) {
+	if n.PeerVersion != nil && !n.PeerVersion.Relay {
+		return false, nil
+	}
+
+	features := n.Features()
+	if feeRate < features.FeeFilter {
+		return false, nil
+	}
+
+	inv := messages.MakeInv()
+	if features.WtxidRelay {
+		inv.Add(messages.WTX_DATA_TYPE, wtxid)
+	} else {
+		inv.Add(messages.TX_DATA_TYPE, txid)
+	}
+	if err := n.SendContext(ctx, inv); err != nil {
+		return false, err
+	}
+	return true, nil
+}
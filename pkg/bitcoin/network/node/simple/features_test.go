@@ -0,0 +1,220 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+)
+
+// reads the next message, returning its command and payload
+func readCommand(t *testing.T, reader *envelope.Reader) (messages.Command, []byte) {
+	env, err := reader.Read()
+	if err != nil {
+		t.Errorf("failed to read because %s", err.Error())
+		return "", nil
+	}
+	return messages.Command(bytes.Trim(env.Command, "\x00")), env.Payload
+}
+
+func sendCommand(conn net.Conn, msg messages.Message) {
+	conn.Write(envelope.Make([]byte(msg.GetCommand()), msg.Serialize(), true).Serialize())
+}
+
+func TestNegotiation(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	received := make(chan []messages.Command, 1)
+	go func() {
+		reader := envelope.MakeReader(remote, true)
+		var commands []messages.Command
+
+		readCommand(t, reader)
+		sendCommand(remote, messages.MakeVersion(true))
+		sendCommand(remote, &messages.WtxidRelay{})
+		sendCommand(remote, &messages.SendAddrV2{})
+		sendCommand(remote, &messages.VersionAck{})
+
+		// everything up to our verack, then the preferences sent after it
+		for len(commands) < 6 {
+			cmd, payload := readCommand(t, reader)
+			if cmd == messages.COMMAND_SENDCMPCT && !bytes.Equal(payload, messages.MakeSendCmpct(false).Serialize()) {
+				t.Errorf("unexpected sendcmpct %x", payload)
+			}
+			if cmd == messages.COMMAND_FEEFILTER && !bytes.Equal(payload, messages.MakeFeeFilter(1000).Serialize()) {
+				t.Errorf("unexpected feefilter %x", payload)
+			}
+			commands = append(commands, cmd)
+		}
+		received <- commands
+
+		sendCommand(remote, &messages.SendHeaders{})
+		sendCommand(remote, messages.MakeFeeFilter(2000))
+		// version 1 is not supported and ignored
+		sendCommand(remote, &messages.SendCmpct{Announce: false, Version: 1})
+		sendCommand(remote, messages.MakeSendCmpct(true))
+		sendCommand(remote, messages.MakeInv())
+	}()
+
+	node := &Node{Testnet: true, Socket: local, MinFeeRate: 1000}
+	if err := node.HandshakeContext(context.Background()); err != nil {
+		t.Fatalf("handshake failed because %s", err.Error())
+	}
+
+	expected := []messages.Command{
+		messages.COMMAND_WTXIDRELAY, messages.COMMAND_SENDADDRV2, messages.COMMAND_VERACK,
+		messages.COMMAND_SENDHEADERS, messages.COMMAND_SENDCMPCT, messages.COMMAND_FEEFILTER,
+	}
+	commands := <-received
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Fatalf("expected %v, received %v", expected, commands)
+		}
+	}
+
+	if _, err := node.WaitForContext(context.Background(), messages.COMMAND_INV); err != nil {
+		t.Fatalf("failed to wait for the inv because %s", err.Error())
+	}
+
+	features := node.Features()
+	if !features.SendHeaders || !features.WtxidRelay || features.FeeFilter != 2000 {
+		t.Fatalf("unexpected features %+v", features)
+	}
+	if features.CompactVersion != messages.CMPCT_VERSION || !features.CompactAnnounce {
+		t.Fatalf("unexpected compact block features %+v", features)
+	}
+	if !features.AddrV2 {
+		t.Fatal("peer addrv2 support was not recorded")
+	}
+}
+
+// handshakes the node with a fake peer which sends the messages between its
+// version and its verack. Returns the reader of the peer, past everything the
+// node sends around the handshake
+func handshakeWith(t *testing.T, node *Node, remote net.Conn, beforeVerack ...messages.Message) *envelope.Reader {
+	reader := envelope.MakeReader(remote, true)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readCommand(t, reader)
+		version := messages.MakeVersion(true)
+		version.Relay = true
+		sendCommand(remote, version)
+		for _, msg := range beforeVerack {
+			sendCommand(remote, msg)
+		}
+		sendCommand(remote, &messages.VersionAck{})
+	}()
+
+	if err := node.HandshakeContext(context.Background()); err != nil {
+		t.Fatalf("handshake failed because %s", err.Error())
+	}
+	<-done
+
+	// sendcmpct is the last of the preferences sent after the handshake
+	for {
+		cmd, _ := readCommand(t, reader)
+		if cmd == "" || cmd == messages.COMMAND_SENDCMPCT {
+			return reader
+		}
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	node := &Node{Testnet: true, Socket: local}
+	reader := handshakeWith(t, node, remote)
+	ctx := context.Background()
+
+	headers, _ := makeFilterTestChain(t, 1)
+	hash, _ := headers[0].Hash()
+
+	// blocks are announced with inv until the peer asks for headers
+	if err := node.AnnounceBlock(ctx, headers[0]); err != nil {
+		t.Fatalf("failed to announce the block because %s", err.Error())
+	}
+	cmd, payload := readCommand(t, reader)
+	inv, err := messages.ParseInv(bytes.NewReader(payload))
+	if cmd != messages.COMMAND_INV || err != nil || inv.Items[0].Type != messages.BLOCK_DATA_TYPE || !bytes.Equal(inv.Items[0].Identifier, hash) {
+		t.Fatalf("expected an inv for the block, received %s", cmd)
+	}
+
+	node.HandleNegotiation(messages.COMMAND_SENDHEADERS, nil)
+	if err := node.AnnounceBlock(ctx, headers[0]); err != nil {
+		t.Fatalf("failed to announce the block because %s", err.Error())
+	}
+	if cmd, _ := readCommand(t, reader); cmd != messages.COMMAND_HEADERS {
+		t.Fatalf("expected the block header, received %s", cmd)
+	}
+
+	txid := bytes.Repeat([]byte{0x01}, 32)
+	wtxid := bytes.Repeat([]byte{0x02}, 32)
+
+	// transactions below the fee filter are not announced
+	node.HandleNegotiation(messages.COMMAND_FEEFILTER, messages.MakeFeeFilter(5000).Serialize())
+	if sent, err := node.AnnounceTx(ctx, txid, wtxid, 4999); sent || err != nil {
+		t.Fatal("announced a transaction below the fee filter")
+	}
+
+	// wtxidrelay and sendaddrv2 after the verack are ignored
+	node.HandleNegotiation(messages.COMMAND_WTXIDRELAY, nil)
+	node.HandleNegotiation(messages.COMMAND_SENDADDRV2, nil)
+	if features := node.Features(); features.WtxidRelay || features.AddrV2 {
+		t.Fatalf("accepted preferences sent after the verack %+v", features)
+	}
+
+	if sent, err := node.AnnounceTx(ctx, txid, wtxid, 5000); !sent || err != nil {
+		t.Fatal("failed to announce the transaction")
+	}
+	_, payload = readCommand(t, reader)
+	inv, _ = messages.ParseInv(bytes.NewReader(payload))
+	if inv.Items[0].Type != messages.TX_DATA_TYPE || !bytes.Equal(inv.Items[0].Identifier, txid) {
+		t.Fatal("expected the transaction to be announced by txid")
+	}
+
+	// malformed preferences are an error
+	if ok, err := node.HandleNegotiation(messages.COMMAND_FEEFILTER, []byte{0x01}); !ok || err == nil {
+		t.Fatal("accepted a malformed feefilter")
+	}
+	if ok, _ := node.HandleNegotiation(messages.COMMAND_PING, nil); ok {
+		t.Fatal("handled a ping as a negotiation message")
+	}
+}
+
+func TestAnnounceWtxid(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	// wtxidrelay is meaningless before we know the peer's version
+	node := &Node{Testnet: true, Socket: local}
+	node.HandleNegotiation(messages.COMMAND_WTXIDRELAY, nil)
+	if node.Features().WtxidRelay {
+		t.Fatal("accepted wtxidrelay before the version")
+	}
+
+	reader := handshakeWith(t, node, remote, &messages.WtxidRelay{})
+	if !node.Features().WtxidRelay {
+		t.Fatal("wtxidrelay sent before the verack was not recorded")
+	}
+
+	txid := bytes.Repeat([]byte{0x01}, 32)
+	wtxid := bytes.Repeat([]byte{0x02}, 32)
+	if sent, err := node.AnnounceTx(context.Background(), txid, wtxid, 0); !sent || err != nil {
+		t.Fatal("failed to announce the transaction")
+	}
+	_, payload := readCommand(t, reader)
+	inv, _ := messages.ParseInv(bytes.NewReader(payload))
+	if inv.Items[0].Type != messages.WTX_DATA_TYPE || !bytes.Equal(inv.Items[0].Identifier, wtxid) {
+		t.Fatal("expected the transaction to be announced by wtxid")
+	}
+}
@@ -0,0 +1,152 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+)
+
+// Peer did not answer a ping in time, returned wrapped in an *envelope.TimeoutError
+var ErrPingTimeout = errors.New("peer did not answer the ping")
+
+// Round trip times of the pings answered by the peer
+type PingStats struct {
+	// most recent, fastest and mean round trip
+	Last    time.Duration
+	Min     time.Duration
+	Average time.Duration
+
+	// pings answered
+	Samples uint64
+
+	// how long the outstanding ping has been waiting, zero if there is none
+	Wait time.Duration
+}
+
+// ping waiting for a pong, and the round trips measured so far
+type pingState struct {
+	nonce []byte
+	sent  time.Time
+
+	// signalled when the outstanding ping is answered
+	pong chan struct{}
+
+	last    time.Duration
+	min     time.Duration
+	total   time.Duration
+	samples uint64
+}
+
+// Sends a ping with a random nonce and starts timing it. Peers older than
+// BIP31 do not answer pings, so they are sent a ping without a nonce which is
+// not timed
+func (n *Node) SendPing(ctx context.Context) error {
+	_, err := n.sendPing(ctx)
+	return err
+}
+
+// sends a ping, returning the channel signalled when it is answered or nil
+// when the peer does not answer pings
+func (n *Node) sendPing(ctx context.Context) (<-chan struct{}, error) {
+	if n.ProtocolVersion != 0 && n.ProtocolVersion <= messages.BIP31_VERSION {
+		return nil, n.SendContext(ctx, &messages.Ping{})
+	}
+
+	ping := messages.MakePing()
+
+	n.pingMu.Lock()
+	n.ping.nonce = ping.Nonce
+	n.ping.sent = time.Now()
+	if n.ping.pong == nil {
+		n.ping.pong = make(chan struct{}, 1)
+	}
+	// drop the answer to an earlier ping nobody waited for
+	select {
+	case <-n.ping.pong:
+	default:
+	}
+	pong := n.ping.pong
+	n.pingMu.Unlock()
+
+	return pong, n.SendContext(ctx, ping)
+}
+
+// Records the round trip if the pong answers the outstanding ping. Returns
+// false for pongs which do not match, they are ignored
+func (n *Node) HandlePong(nonce []byte) bool {
+	n.pingMu.Lock()
+	defer n.pingMu.Unlock()
+
+	if n.ping.nonce == nil || !bytes.Equal(n.ping.nonce, nonce) {
+		return false
+	}
+
+	rtt := time.Since(n.ping.sent)
+	n.ping.nonce = nil
+	n.ping.last = rtt
+	if n.ping.samples == 0 || rtt < n.ping.min {
+		n.ping.min = rtt
+	}
+	n.ping.total += rtt
+	n.ping.samples++
+
+	select {
+	case n.ping.pong <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Round trip times of the pings answered so far
+func (n *Node) PingStats() PingStats {
+	n.pingMu.Lock()
+	defer n.pingMu.Unlock()
+
+	stats := PingStats{
+		Last:    n.ping.last,
+		Min:     n.ping.min,
+		Samples: n.ping.samples,
+	}
+	if n.ping.samples > 0 {
+		stats.Average = n.ping.total / time.Duration(n.ping.samples)
+	}
+	if n.ping.nonce != nil {
+		stats.Wait = time.Since(n.ping.sent)
+	}
+	return stats
+}
+
+// Pings the peer every interval until the context is cancelled, returning an
+// *envelope.TimeoutError wrapping ErrPingTimeout if a ping goes unanswered for
+// the timeout. Pongs are only seen by whoever reads from the node, so it must
+// be read from while this runs, WaitFor hands pongs to HandlePong
+func (n *Node) KeepAlive(ctx context.Context, interval, timeout time.Duration) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		pong, err := n.sendPing(ctx)
+		if err != nil {
+			return err
+		}
+		if pong == nil {
+			// pre BIP31 peers are not timed
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-pong:
+		case <-time.After(timeout):
+			return &envelope.TimeoutError{Op: "ping", Err: ErrPingTimeout}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+)
+
+// answers pings after the delay, preceded by a pong with the wrong nonce
+// which has to be ignored. Pings are left unanswered when answer is false
+func servePings(t *testing.T, node *Node, delay time.Duration, answer bool) {
+	local, remote := tcpPair(t)
+	t.Cleanup(func() {
+		local.Close()
+		remote.Close()
+	})
+	node.Socket = local
+
+	go func() {
+		reader := envelope.MakeReader(remote, true)
+		for {
+			env, err := reader.Read()
+			if err != nil {
+				return
+			}
+			if !answer {
+				continue
+			}
+
+			time.Sleep(delay)
+			wrong := envelope.Make([]byte(messages.COMMAND_PONG), messages.MakePing().Nonce, true)
+			pong := envelope.Make([]byte(messages.COMMAND_PONG), env.Payload, true)
+			if _, err := remote.Write(append(wrong.Serialize(), pong.Serialize()...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	// somebody has to read the node for the pongs to be seen
+	go node.WaitForContext(context.Background(), messages.COMMAND_HEADERS)
+}
+
+func TestKeepAlive(t *testing.T) {
+	node := &Node{Testnet: true}
+	servePings(t, node, 10*time.Millisecond, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err := node.KeepAlive(ctx, 5*time.Millisecond, time.Second)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the keep alive to run until the deadline, got %v", err)
+	}
+
+	stats := node.PingStats()
+	if stats.Samples < 2 {
+		t.Fatalf("expected several pings to be answered, got %d", stats.Samples)
+	}
+	if stats.Min < 10*time.Millisecond || stats.Average < stats.Min || stats.Last < stats.Min {
+		t.Fatalf("unexpected round trips %+v", stats)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	node := &Node{Testnet: true}
+	servePings(t, node, 0, false)
+
+	err := node.KeepAlive(context.Background(), 5*time.Millisecond, 50*time.Millisecond)
+	if !envelope.IsTimeout(err) || !errors.Is(err, ErrPingTimeout) {
+		t.Fatalf("expected the ping to time out, got %v", err)
+	}
+
+	stats := node.PingStats()
+	if stats.Samples != 0 || stats.Wait < 50*time.Millisecond {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSendPingPreBip31(t *testing.T) {
+	// peers older than BIP31 get a ping without a nonce and are not timed.
+	// BIP31 came after version 60000, not with it
+	for _, version := range []uint32{messages.MIN_PEER_PROTOCOL_VERSION, messages.BIP31_VERSION} {
+		t.Run(fmt.Sprint(version), func(t *testing.T) {
+			local, remote := tcpPair(t)
+			defer local.Close()
+			defer remote.Close()
+
+			node := &Node{Testnet: true, Socket: local, ProtocolVersion: version}
+			if err := node.SendPing(context.Background()); err != nil {
+				t.Fatalf("failed to send the ping because %s", err.Error())
+			}
+
+			env, err := envelope.ParseSocket(remote, true)
+			if err != nil || len(env.Payload) != 0 {
+				t.Fatalf("expected an empty ping to version %d, got %v", version, err)
+			}
+			if node.PingStats().Wait != 0 || node.HandlePong(nil) {
+				t.Fatal("ping without a nonce is being timed")
+			}
+		})
+	}
+}
@@ -2,31 +2,88 @@ package simple
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
 	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
 )
 
+// Default deadlines for nodes made with MakeNode
+const (
+	DEFAULT_READ_TIMEOUT  = 2 * time.Minute
+	DEFAULT_WRITE_TIMEOUT = 30 * time.Second
+)
+
 type Node struct {
 	Testnet bool
 	Host    string
 	Port    uint16
 	Socket  net.Conn
+
+	// Longest to wait for a message from the peer, or to write one to it.
+	// Zero waits for as long as the context allows
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Version message received from the peer, holding its capabilities
+	PeerVersion *messages.Version
+
+	// Protocol version negotiated with the peer, the lower of ours and theirs
+	ProtocolVersion uint32
+
+	// Services and block height advertised in our version message
+	Services    uint64
+	StartHeight uint32
+
+	// Fee rate in satoshis per 1000 bytes below which the peer is asked not
+	// to announce transactions to us. Zero sends no feefilter
+	MinFeeRate uint64
+
+	// Nonce of the version message we sent, used to detect self connections
+	nonce []byte
+
+	// relay preferences negotiated by the peer, and whether its verack was
+	// received, after which wtxidrelay and sendaddrv2 are ignored
+	featuresMu     sync.Mutex
+	features       PeerFeatures
+	verackReceived bool
+
+	// serializes sends, which set the write deadline of the socket
+	sendMu sync.Mutex
+
+	// ping waiting for a pong and the round trips measured
+	pingMu sync.Mutex
+	ping   pingState
+
+	// buffered reader of the socket, made on first use
+	reader     *envelope.Reader
+	readerOnce sync.Once
 }
 
 func MakeNode(testnet bool, host string, Port uint16) (*Node, error) {
+	return MakeNodeContext(context.Background(), testnet, host, Port)
+}
+
+// Connects to the node, giving up when the context is cancelled. The port
+// defaults to the port of the network when zero
+func MakeNodeContext(ctx context.Context, testnet bool, host string, Port uint16) (*Node, error) {
 	// determine which port to used based on testnet/mainnet
-	port := uint16(8333)
-	if testnet {
-		port = 18333
+	port := Port
+	if port == 0 {
+		port = envelope.MAINNET_PORT
+		if testnet {
+			port = envelope.TESTNET_PORT
+		}
 	}
 
 	// assuming we have a host name and it is not an IP address
 	// this is the assumption we are making, we need to do a resolution
 	// of the IP address
-	ips, err := net.LookupIP(host)
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
 	if err != nil {
 		return nil, err
 	}
@@ -35,9 +92,10 @@ func MakeNode(testnet bool, host string, Port uint16) (*Node, error) {
 	}
 
 	// attempt to open a socket to the remote peer
-	connStr := fmt.Sprintf("%s:%d", ips[0], port)
+	connStr := net.JoinHostPort(ips[0].String(), fmt.Sprint(port))
 
-	conn, err := net.Dial("tcp", connStr)
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", connStr)
 	if err != nil {
 		return nil, err
 	}
@@ -46,58 +104,234 @@ func MakeNode(testnet bool, host string, Port uint16) (*Node, error) {
 	// store this in the node and return the node object
 
 	return &Node{
-		Port:    port,
-		Socket:  conn,
-		Testnet: testnet,
+		Host:         host,
+		Port:         port,
+		Socket:       conn,
+		Testnet:      testnet,
+		ReadTimeout:  DEFAULT_READ_TIMEOUT,
+		WriteTimeout: DEFAULT_WRITE_TIMEOUT,
 	}, nil
 }
 
+// bounds the context by the timeout if one is set
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 // sends a network message to the the remote peer
 func (n *Node) Send(msg messages.Message) error {
+	return n.SendContext(context.Background(), msg)
+}
+
+// Sends a network message to the remote peer, giving up when the context is
+// cancelled or the write timeout of the node passes. Safe to call from
+// multiple goroutines
+func (n *Node) SendContext(ctx context.Context, msg messages.Message) error {
+	n.sendMu.Lock()
+	defer n.sendMu.Unlock()
+
 	// create a network envelope for the message
 	env := envelope.Make([]byte(msg.GetCommand()), msg.Serialize(), n.Testnet)
 
-	// send the envelope to the remote peer
-	_, err := n.Socket.Write(env.Serialize())
+	ctx, cancel := withTimeout(ctx, n.WriteTimeout)
+	defer cancel()
 
-	if err != nil {
-		return err
-	}
-	return nil
+	// send the envelope to the remote peer
+	return envelope.WriteSocketContext(ctx, n.Socket, env)
 }
 
-// Perform a handshake function with a specific node
+// Perform a handshake function with a specific node. The version message of
+// the peer is recorded on the node along with the negotiated protocol version.
 func (n *Node) Handshake() bool {
-	// start of the handshake with a version message
+	return n.HandshakeContext(context.Background()) == nil
+}
+
+// makes the version message we send to the peer
+func (n *Node) makeVersion() *messages.Version {
 	version := messages.MakeVersion(n.Testnet)
+	version.Services = n.Services
+	version.LatestBlock = n.StartHeight
+	n.nonce = version.Nonce
+	return version
+}
+
+// Performs the handshake, giving up when the context is cancelled
+func (n *Node) HandshakeContext(ctx context.Context) error {
+	// start of the handshake with a version message
+	version := n.makeVersion()
 
 	// send the version message
-	n.Send(version)
+	if err := n.SendContext(ctx, version); err != nil {
+		return err
+	}
 
 	// after a version message, we expect back a version message from
-	// peer as well as a verack message
-	_, err := n.WaitFor(messages.COMMAND_VERACK)
-	return err == nil
+	// peer as well as a verack message, in either order
+	receivedVersion, receivedVerack := false, false
+	for !receivedVersion || !receivedVerack {
+		msg, err := n.WaitForContext(ctx, messages.COMMAND_VERSION, messages.COMMAND_VERACK)
+		if err != nil {
+			return err
+		}
+
+		switch (*msg).(type) {
+		case *messages.Version:
+			receivedVersion = true
+		case *messages.VersionAck:
+			receivedVerack = true
+		}
+	}
+
+	return n.sendNegotiation(ctx)
 }
 
-// Returns a network envelope read from the remote peer
-func (n *Node) Read() (*envelope.Envelope, error) {
-	env, err := envelope.ParseSocket(n.Socket, n.Testnet)
+// Answers the handshake of a peer which connected to us. The peer speaks
+// first, so its version is read and accepted before ours is sent back along
+// with the verack, then the verack for our version is waited for.
+func (n *Node) AcceptHandshakeContext(ctx context.Context) error {
+	env, err := n.ReadContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	cmd := messages.Command(bytes.Trim(env.Command, "\x00"))
+	if cmd != messages.COMMAND_VERSION {
+		return fmt.Errorf("expected a version message, received %s", cmd)
+	}
+
+	version := n.makeVersion()
+	if _, err := n.acceptVersion(env.Payload); err != nil {
+		return err
+	}
+
+	if err := n.SendContext(ctx, version); err != nil {
+		return err
+	}
+	if err := n.sendVerack(ctx); err != nil {
+		return err
+	}
+
+	if _, err := n.WaitForContext(ctx, messages.COMMAND_VERACK); err != nil {
+		return err
+	}
+
+	return n.sendNegotiation(ctx)
+}
+
+// Records the version message received from the peer and negotiates the
+// protocol version to use. A verack is sent back once the version is accepted.
+func (n *Node) handleVersion(ctx context.Context, payload []byte) (*messages.Version, error) {
+	version, err := n.acceptVersion(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.sendVerack(ctx); err != nil {
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// parses the version message of the peer, checks it is not ourselves and
+// negotiates the protocol version
+func (n *Node) acceptVersion(payload []byte) (*messages.Version, error) {
+	version, err := messages.ParseVersion(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
 
+	// a peer echoing our nonce means we connected to ourselves
+	if n.nonce != nil && bytes.Equal(version.Nonce, n.nonce) {
+		return nil, fmt.Errorf("connected to self")
+	}
+
+	protocolVersion, err := messages.NegotiateVersion(messages.PROTOCOL_VERSION, version.Version)
 	if err != nil {
 		return nil, err
 	}
 
-	return env, nil
+	n.PeerVersion = version
+	n.ProtocolVersion = protocolVersion
+
+	return version, nil
+}
+
+// acknowledges the version of the peer
+func (n *Node) sendVerack(ctx context.Context) error {
+	// wtxid relay and addrv2 support have to be signalled before the verack
+	if n.ProtocolVersion >= messages.WTXID_RELAY_VERSION {
+		if err := n.SendContext(ctx, &messages.WtxidRelay{}); err != nil {
+			return err
+		}
+	}
+	if err := n.SendContext(ctx, &messages.SendAddrV2{}); err != nil {
+		return err
+	}
+
+	return n.SendContext(ctx, &messages.VersionAck{})
+}
+
+// Checks if the peer advertised the service in its version message
+func (n *Node) PeerHasService(service uint64) bool {
+	return n.PeerVersion != nil && n.PeerVersion.HasService(service)
+}
+
+// Returns a network envelope read from the remote peer
+func (n *Node) Read() (*envelope.Envelope, error) {
+	return n.ReadContext(context.Background())
+}
+
+// Returns a network envelope read from the remote peer, giving up when the
+// context is cancelled or the read timeout of the node passes. A timeout may
+// leave part of a message unread, so the peer should be dropped after one
+func (n *Node) ReadContext(ctx context.Context) (*envelope.Envelope, error) {
+	ctx, cancel := withTimeout(ctx, n.ReadTimeout)
+	defer cancel()
+
+	return n.envelopeReader().ReadContext(ctx)
+}
+
+func (n *Node) envelopeReader() *envelope.Reader {
+	n.readerOnce.Do(func() {
+		n.reader = envelope.MakeReader(n.Socket, n.Testnet)
+	})
+	return n.reader
+}
+
+// Counters of the bytes and messages read from the peer
+func (n *Node) ReadStats() envelope.ReaderStats {
+	return n.envelopeReader().Stats()
+}
+
+// checks if the command is one of the commands being waited for
+func waitingFor(cmd messages.Command, commands []messages.Command) bool {
+	for _, c := range commands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// Synchronous blocking call waiting for any of the supplied network messages
+func (n *Node) WaitFor(commands ...messages.Command) (*messages.Message, error) {
+	return n.WaitForContext(context.Background(), commands...)
 }
 
-// Synchronous blocking call waiting for a particular network message
-func (n *Node) WaitFor(command messages.Command) (*messages.Message, error) {
+// Waits for any of the supplied network messages, giving up when the context
+// is cancelled. The read timeout of the node applies to each message read, so
+// a peer which keeps talking without sending what we want is only stopped by
+// the context
+func (n *Node) WaitForContext(ctx context.Context, commands ...messages.Command) (*messages.Message, error) {
 
 	var cmd messages.Command
 	payload := []byte{}
 	for {
-		env, err := n.Read()
+		env, err := n.ReadContext(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -106,7 +340,10 @@ func (n *Node) WaitFor(command messages.Command) (*messages.Message, error) {
 		// command is a netascii string, so convert everything to
 		// a string and then command and compare
 		_cmd := bytes.Trim(env.Command, "\x00")
-		if messages.Command(string(_cmd)) == command {
+		if messages.Command(_cmd) == messages.COMMAND_VERACK {
+			n.receivedVerack()
+		}
+		if waitingFor(messages.Command(string(_cmd)), commands) {
 			cmd = messages.Command(string(bytes.Trim(env.Command, "\x00")))
 			payload = env.Payload
 			break
@@ -115,50 +352,43 @@ func (n *Node) WaitFor(command messages.Command) (*messages.Message, error) {
 			cmd := messages.Command(bytes.Trim(env.Command, "\x00"))
 
 			if cmd == new(messages.Version).GetCommand() {
-				// received a version command message, send back
-				// a version ack message
-				verack := &messages.VersionAck{}
-				n.Send(verack)
+				// received a version command message, record the
+				// peer's capabilities and send back a version ack message
+				if _, err := n.handleVersion(ctx, env.Payload); err != nil {
+					return nil, err
+				}
+			} else if ok, err := n.HandleNegotiation(cmd, env.Payload); ok {
+				// relay preferences of the peer
+				if err != nil {
+					return nil, err
+				}
 			} else if cmd == new(messages.Ping).GetCommand() {
 				// send a pong response. The payload of the message
 				// envelope is the nonce value needed for the construction
 				// of the correct pong message
 				pong := messages.MakePong(env.Payload)
-				n.Send(pong)
+				if err := n.SendContext(ctx, pong); err != nil {
+					return nil, err
+				}
+			} else if cmd == messages.COMMAND_PONG {
+				// times the ping it answers, if any
+				n.HandlePong(env.Payload)
 			}
 		}
 	}
 
 	// command is the command we are waiting for, return it as the correct message type
-	// parse of course back to the user.
-	switch cmd {
-	case messages.COMMAND_VERSION:
-		var msg messages.Message
-
-		// this should be a parse call here, but there is no impl for parsing of a
-		// version message at this time from the payload, ....
-		fmt.Println(payload)
-		versionMsg := messages.MakeVersion(n.Testnet)
-		msg = versionMsg
-		return &msg, nil
-	case messages.COMMAND_VERACK:
-		verack := messages.ParseVerAck(payload)
-		var msg messages.Message
-		msg = verack
-		return &msg, nil
-	case messages.COMMAND_HEADERS:
-		// parse the headers into a headers object
-		headers, err := messages.ParseHeaders(bytes.NewReader(payload))
-		if err != nil {
-			return nil, err
-		}
-
-		// now cast it into its base interface
-		var msg messages.Message
-		msg = headers
-		return &msg, nil
-
-	default:
-		return nil, fmt.Errorf("unknown command matched %s", cmd)
+	// parse of course back to the user. The version message is recorded on the way through
+	var msg messages.Message
+	var err error
+	if cmd == messages.COMMAND_VERSION {
+		msg, err = n.handleVersion(ctx, payload)
+	} else {
+		msg, err = messages.ParseMessage(cmd, payload)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
 }
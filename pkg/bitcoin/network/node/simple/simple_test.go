@@ -1,10 +1,15 @@
 package simple
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
 	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
 	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
 )
@@ -203,3 +208,183 @@ func TestGetAllHeaders(t *testing.T) {
 		}
 	}
 }
+
+// fake peer answering the handshake with its version followed by a verack
+func serveHandshake(t *testing.T, conn net.Conn, version *messages.Version) {
+	// our version
+	if _, err := envelope.ParseSocket(conn, true); err != nil {
+		t.Errorf("failed to read the version because %s", err.Error())
+		return
+	}
+
+	conn.Write(envelope.Make([]byte(messages.COMMAND_VERSION), version.Serialize(), true).Serialize())
+
+	// sendaddrv2 then the verack for their version
+	for _, expected := range []messages.Command{messages.COMMAND_SENDADDRV2, messages.COMMAND_VERACK} {
+		env, err := envelope.ParseSocket(conn, true)
+		if err != nil {
+			t.Errorf("failed to read the %s because %s", expected, err.Error())
+			return
+		}
+		if messages.Command(bytes.Trim(env.Command, "\x00")) != expected {
+			t.Errorf("expected a %s, received %s", expected, env.Command)
+		}
+	}
+
+	conn.Write(envelope.Make([]byte(messages.COMMAND_SENDADDRV2), nil, true).Serialize())
+	conn.Write(envelope.Make([]byte(messages.COMMAND_VERACK), nil, true).Serialize())
+}
+
+func TestHandshakeRecordsPeerVersion(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	peerVersion := messages.MakeVersion(true)
+	peerVersion.Version = 70012
+	peerVersion.Services = messages.NODE_NETWORK | messages.NODE_COMPACT_FILTERS
+	peerVersion.UserAgent = []byte("/Satoshi:0.13.1/")
+	go serveHandshake(t, remote, peerVersion)
+
+	node := &Node{Testnet: true, Socket: local}
+	if !node.Handshake() {
+		t.Fatal("handshake failed")
+	}
+
+	if node.ProtocolVersion != 70012 {
+		t.Fatalf("expected to negotiate 70012, got %d", node.ProtocolVersion)
+	}
+	if !node.PeerHasService(messages.NODE_COMPACT_FILTERS) || node.PeerHasService(messages.NODE_BLOOM) {
+		t.Fatalf("unexpected peer services %x", node.PeerVersion.Services)
+	}
+	if string(node.PeerVersion.UserAgent) != "/Satoshi:0.13.1/" {
+		t.Fatalf("unexpected peer user agent %s", node.PeerVersion.UserAgent)
+	}
+	if !node.Features().AddrV2 {
+		t.Fatal("peer addrv2 support was not recorded")
+	}
+}
+
+func TestHandshakeRejectsOldPeer(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	peerVersion := messages.MakeVersion(true)
+	peerVersion.Version = 209
+	go func() {
+		envelope.ParseSocket(remote, true)
+		remote.Write(envelope.Make([]byte(messages.COMMAND_VERSION), peerVersion.Serialize(), true).Serialize())
+	}()
+
+	node := &Node{Testnet: true, Socket: local}
+	if node.Handshake() {
+		t.Fatal("handshake succeeded with an obsolete peer")
+	}
+}
+
+func TestWaitForStalledPeer(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// peer keeps pinging but never sends what we wait for, only the
+	// context stops the wait
+	go func() {
+		for {
+			if _, err := remote.Write(envelope.Make([]byte(messages.COMMAND_PING), messages.MakePing().Nonce, true).Serialize()); err != nil {
+				return
+			}
+			if _, err := envelope.ParseSocket(remote, true); err != nil {
+				return
+			}
+		}
+	}()
+
+	node := &Node{Testnet: true, Socket: local, ReadTimeout: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := node.WaitForContext(ctx, messages.COMMAND_HEADERS)
+	if !envelope.IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	node := &Node{Testnet: true, Socket: local, ReadTimeout: 50 * time.Millisecond}
+	if _, err := node.Read(); !envelope.IsTimeout(err) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// cancelling the context stops the read before the timeout
+	node.ReadTimeout = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := node.ReadContext(ctx); err != context.Canceled {
+		t.Fatalf("expected the read to be cancelled, got %v", err)
+	}
+}
+
+// connected pair of loopback tcp connections. Unlike a pipe, writes are
+// buffered so both ends can write at once as real peers do
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen because %s", err.Error())
+	}
+	defer listener.Close()
+
+	remote, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial because %s", err.Error())
+	}
+	local, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept because %s", err.Error())
+	}
+	return local, remote
+}
+
+func TestAcceptHandshake(t *testing.T) {
+	local, remote := tcpPair(t)
+	defer local.Close()
+	defer remote.Close()
+
+	// the dialing side is one of our nodes too
+	dialer := &Node{Testnet: true, Socket: remote}
+	errs := make(chan error, 1)
+	go func() { errs <- dialer.HandshakeContext(context.Background()) }()
+
+	node := &Node{Testnet: true, Socket: local, Services: messages.NODE_NETWORK, StartHeight: 42}
+	if err := node.AcceptHandshakeContext(context.Background()); err != nil {
+		t.Fatalf("failed to accept the handshake because %s", err.Error())
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("dialer failed the handshake because %s", err.Error())
+	}
+
+	if node.ProtocolVersion != messages.PROTOCOL_VERSION || !node.Features().AddrV2 {
+		t.Fatal("peer version was not recorded")
+	}
+	if dialer.PeerVersion.LatestBlock != 42 || !dialer.PeerHasService(messages.NODE_NETWORK) {
+		t.Fatal("our version was not advertised")
+	}
+}
+
+func TestAcceptHandshakeRequiresVersion(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go remote.Write(envelope.Make([]byte(messages.COMMAND_VERACK), nil, true).Serialize())
+
+	node := &Node{Testnet: true, Socket: local}
+	if err := node.AcceptHandshakeContext(context.Background()); err == nil {
+		t.Fatal("accepted a handshake which did not start with a version")
+	}
+}
@@ -0,0 +1,171 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/tx"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// Transaction which has been proven to be included in a block
+type ConfirmedTransaction struct {
+	// Hash of the block the transaction was included in
+	BlockHash []byte
+
+	// The transaction itself
+	Transaction *tx.Transaction
+
+	// Indexes of the outputs paying to one of the watched scripts
+	Outputs []int
+}
+
+// checks the outputs of a transaction against the watched scripts and
+// returns the indexes of the outputs which pay to one of them
+func matchOutputs(t *tx.Transaction, watched []*script.Script) []int {
+	var matches []int
+	for i, out := range t.Outputs {
+		if out.ScriptPubkey == nil {
+			continue
+		}
+		serialized := out.ScriptPubkey.Serialize()
+		for _, w := range watched {
+			if utils.CompareByteArrays(serialized, w.Serialize()) {
+				matches = append(matches, i)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// SPV proof of inclusion. Loads the bloom filter into the remote peer, requests
+// the filtered blocks for the trusted headers and validates each merkle block
+// against its header. Transactions proven by the merkle blocks which pay to
+// one of the watched scripts are returned.
+//
+// The headers are trusted, meaning the caller has already validated the proof
+// of work and continuity of the chain they belong to.
+//
+// The peer answers the getdata in order, so a ping sent after it marks the end
+// of the answer. Proven transactions the peer did not send, as BIP37 lets it
+// skip the ones it believes we have, are left out. A block the peer does not
+// have is an error
+func (n *Node) GetConfirmedTransactions(ctx context.Context, headers []*block.BlockHeader, filter *block.BloomFilter, watched []*script.Script) ([]*ConfirmedTransaction, error) {
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("no block headers supplied")
+	}
+
+	// load the filter so the peer only sends us what we are interested in
+	if err := n.SendContext(ctx, messages.MakeFilterLoad(filter, block.BLOOM_UPDATE_ALL)); err != nil {
+		return nil, err
+	}
+
+	// request a filtered block for each trusted header, keeping track of the
+	// headers by their hash so the merkle blocks can be checked against them
+	trusted := make(map[string]*block.BlockHeader)
+	getData := messages.MakeGetDataMessage()
+	for _, header := range headers {
+		hash, err := header.Hash()
+		if err != nil {
+			return nil, err
+		}
+		trusted[fmt.Sprintf("%x", hash)] = header
+		getData.Add(messages.FILTERED_BLOCK_DATA_TYPE, hash)
+	}
+	if err := n.SendContext(ctx, getData); err != nil {
+		return nil, err
+	}
+
+	// the pong to this ping comes after everything sent for the getdata
+	barrier := messages.MakePing()
+	if err := n.SendContext(ctx, barrier); err != nil {
+		return nil, err
+	}
+
+	// transactions we have a proof for, but have not received yet. Keyed by
+	// transaction id with the hash of the block proving it as the value
+	pending := make(map[string][]byte)
+
+	var confirmed []*ConfirmedTransaction
+	for {
+		msg, err := n.WaitForContext(ctx, messages.COMMAND_MERKLEBLOCK, messages.COMMAND_TX, messages.COMMAND_NOTFOUND, messages.COMMAND_PONG)
+		if err != nil {
+			return nil, err
+		}
+
+		switch m := (*msg).(type) {
+		case *messages.Pong:
+			if !bytes.Equal(m.Nonce, barrier.Nonce) {
+				// answer to some other ping, such as a keep alive
+				n.HandlePong(m.Nonce)
+				continue
+			}
+
+			if len(trusted) > 0 {
+				return nil, fmt.Errorf("peer did not send %d of the filtered blocks", len(trusted))
+			}
+			return confirmed, nil
+		case *messages.NotFound:
+			for _, item := range m.Items {
+				id := fmt.Sprintf("%x", item.Identifier)
+				if _, ok := trusted[id]; ok {
+					return nil, fmt.Errorf("peer does not have block %s", id)
+				}
+				delete(pending, id)
+			}
+		case *messages.MerkleBlock:
+			// the merkle block must be for one of the blocks we asked for
+			hash, err := m.Header().Hash()
+			if err != nil {
+				return nil, err
+			}
+			hashStr := fmt.Sprintf("%x", hash)
+			header, ok := trusted[hashStr]
+			if !ok {
+				return nil, fmt.Errorf("received unrequested merkle block %s", hashStr)
+			}
+
+			// the header hash commits to the merkle root, but check it explicitly
+			// against the trusted header anyway before validating the proof
+			if !utils.CompareByteArrays(header.MerkleRoot, m.MerkleRoot) {
+				return nil, fmt.Errorf("merkle root of block %s does not match the trusted header", hashStr)
+			}
+
+			proved, err := m.ProvedTxHashes()
+			if err != nil {
+				return nil, fmt.Errorf("invalid merkle block %s because %s", hashStr, err.Error())
+			}
+			for _, txHash := range proved {
+				pending[fmt.Sprintf("%x", txHash)] = hash
+			}
+
+			delete(trusted, hashStr)
+		case *messages.Tx:
+			// only transactions proven by a merkle block count, anything else
+			// is relayed from the mempool and is not confirmed
+			id := m.Transaction.ID()
+			blockHash, ok := pending[id]
+			if !ok {
+				continue
+			}
+			delete(pending, id)
+
+			outputs := matchOutputs(m.Transaction, watched)
+			if len(outputs) == 0 {
+				// bloom filters have false positives
+				continue
+			}
+
+			confirmed = append(confirmed, &ConfirmedTransaction{
+				BlockHash:   blockHash,
+				Transaction: m.Transaction,
+				Outputs:     outputs,
+			})
+		}
+	}
+}
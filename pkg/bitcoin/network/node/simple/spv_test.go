@@ -0,0 +1,217 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/block"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/envelope"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/network/messages"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// signed transaction paying to mzx5YhAH9kNHtcN481u6WkjeHjYtVeKVh2
+const spvTestTx = "010000000199a24308080ab26e6fb65c4eccfadf76749bb5bfa8cb08f291320b3c21e56f0d0d0000006c4930460221008ed46aa2cf12d6d81065bfabe903670165b538f65ee9a3385e6327d80c66d3b50221003124f804410527497329ec4715e18558082d489b218677bd029e7fa306a72236012103935581e52c354cd2f484fe8ed83af7a3097005b2f9c60bff71d35bd795f54b67ffffffff02408af701000000001976a914d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f88ac80969800000000001976a914507b27411ccf7f16f10297de6cef3f291623eddf88ac00000000"
+
+// builds a 3 transaction block where the transaction at index 1 is
+// the one matching the filter and returns the header and the merkle
+// block payload proving the transaction
+func makeSpvTestBlock(t *testing.T, rawTx []byte) (*block.BlockHeader, []byte) {
+	h0 := utils.Hash256([]byte("a"))
+	h1 := utils.Hash256(rawTx)
+	h2 := utils.Hash256([]byte("c"))
+
+	root, err := utils.MerkleRoot([][]byte{h0, h1, h2})
+	if err != nil {
+		t.Fatalf("failed to calculate the merkle root because %s", err.Error())
+	}
+
+	bits, _ := block.GetLowestBitsBytes()
+	header := &block.BlockHeader{
+		Version:       1,
+		PreviousBlock: make([]byte, 32),
+		MerkleRoot:    utils.ImmutableReorderBytes(root),
+		Timestamp:     1234,
+		Bits:          bits,
+		Nonce:         make([]byte, 4),
+	}
+
+	payload, err := header.SerializeHeader()
+	if err != nil {
+		t.Fatalf("failed to serialize the header because %s", err.Error())
+	}
+
+	// total transactions in the block
+	payload = append(payload, utils.IntToLittleEndianBytes(3)...)
+
+	// depth first traversal with only h1 matching gives flag bits
+	// 1 (root), 1 (left parent), 0 (h0), 1 (h1), 0 (right parent)
+	payload = append(payload, 0x03)
+	payload = append(payload, h0...)
+	payload = append(payload, h1...)
+	payload = append(payload, utils.MerkleParent(h2, h2)...)
+	payload = append(payload, 0x01, 0x0b)
+
+	return header, payload
+}
+
+// fake peer answering the filterload, getdata and ping with the envelopes,
+// then the pong marking the end of the answer
+func serveFilteredBlocks(t *testing.T, remote net.Conn, answer ...*envelope.Envelope) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		var ping []byte
+		for _, expected := range []messages.Command{messages.COMMAND_FILTERLOAD, messages.COMMAND_GETDATA, messages.COMMAND_PING} {
+			env, err := envelope.ParseSocket(remote, true)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if messages.Command(bytes.Trim(env.Command, "\x00")) != expected {
+				t.Errorf("expected %s, received %s", expected, env.Command)
+			}
+			ping = env.Payload
+		}
+
+		for _, env := range answer {
+			remote.Write(env.Serialize())
+		}
+		remote.Write(envelope.Make([]byte(messages.COMMAND_PONG), ping, true).Serialize())
+		errs <- nil
+	}()
+	return errs
+}
+
+func TestGetConfirmedTransactions(t *testing.T) {
+	rawTx, _ := hex.DecodeString(spvTestTx)
+	header, merkleBlock := makeSpvTestBlock(t, rawTx)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	node := &Node{Testnet: true, Socket: local}
+
+	// a pong answering some other ping does not end the exchange
+	errs := serveFilteredBlocks(t, remote,
+		envelope.Make([]byte(messages.COMMAND_MERKLEBLOCK), merkleBlock, true),
+		envelope.Make([]byte(messages.COMMAND_PONG), messages.MakePing().Nonce, true),
+		envelope.Make([]byte(messages.COMMAND_TX), rawTx, true),
+	)
+
+	h160, err := utils.DecodeBase58("mzx5YhAH9kNHtcN481u6WkjeHjYtVeKVh2")
+	if err != nil {
+		t.Fatalf("failed to decode the address because %s", err.Error())
+	}
+	watched := script.MakeP2pkh(h160)
+
+	filter := block.MakeBloomFilter(30, 5, 90210)
+	filter.Add(h160)
+
+	confirmed, err := node.GetConfirmedTransactions(context.Background(), []*block.BlockHeader{header}, filter, []*script.Script{watched})
+	if err != nil {
+		t.Fatalf("failed to get the confirmed transactions because %s", err.Error())
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("fake peer failed because %s", err.Error())
+	}
+
+	if len(confirmed) != 1 {
+		t.Fatalf("expected 1 confirmed transaction, got %d", len(confirmed))
+	}
+
+	if confirmed[0].Transaction.ID() != hex.EncodeToString(utils.ImmutableReorderBytes(utils.Hash256(rawTx))) {
+		t.Fatalf("confirmed the wrong transaction %s", confirmed[0].Transaction.ID())
+	}
+
+	if len(confirmed[0].Outputs) != 1 || confirmed[0].Outputs[0] != 0 {
+		t.Fatalf("expected output 0 to match, got %v", confirmed[0].Outputs)
+	}
+}
+
+func TestGetConfirmedTransactionsSkippedTx(t *testing.T) {
+	rawTx, _ := hex.DecodeString(spvTestTx)
+	header, merkleBlock := makeSpvTestBlock(t, rawTx)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	node := &Node{Testnet: true, Socket: local}
+
+	// the peer believes we already have the transaction and only sends the
+	// merkle block
+	serveFilteredBlocks(t, remote, envelope.Make([]byte(messages.COMMAND_MERKLEBLOCK), merkleBlock, true))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	confirmed, err := node.GetConfirmedTransactions(ctx, []*block.BlockHeader{header}, block.MakeBloomFilter(30, 5, 90210), nil)
+	if err != nil || len(confirmed) != 0 {
+		t.Fatalf("expected no confirmed transactions, got %d and %v", len(confirmed), err)
+	}
+}
+
+func TestGetConfirmedTransactionsNotFound(t *testing.T) {
+	rawTx, _ := hex.DecodeString(spvTestTx)
+	header, _ := makeSpvTestBlock(t, rawTx)
+	hash, _ := header.Hash()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	node := &Node{Testnet: true, Socket: local}
+
+	// a pruned peer does not have the block
+	notFound := messages.MakeNotFound()
+	notFound.Add(messages.FILTERED_BLOCK_DATA_TYPE, hash)
+	serveFilteredBlocks(t, remote, envelope.Make([]byte(messages.COMMAND_NOTFOUND), notFound.Serialize(), true))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := node.GetConfirmedTransactions(ctx, []*block.BlockHeader{header}, block.MakeBloomFilter(30, 5, 90210), nil)
+	if err == nil || envelope.IsTimeout(err) || !strings.Contains(err.Error(), "does not have block") {
+		t.Fatalf("expected the missing block to be reported, got %v", err)
+	}
+
+	// a peer which ignores the block is caught by the pong
+	local, remote = net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	node = &Node{Testnet: true, Socket: local}
+	serveFilteredBlocks(t, remote)
+	_, err = node.GetConfirmedTransactions(ctx, []*block.BlockHeader{header}, block.MakeBloomFilter(30, 5, 90210), nil)
+	if err == nil || envelope.IsTimeout(err) {
+		t.Fatalf("expected the missing block to be reported, got %v", err)
+	}
+}
+
+func TestGetConfirmedTransactionsBadProof(t *testing.T) {
+	rawTx, _ := hex.DecodeString(spvTestTx)
+	header, merkleBlock := makeSpvTestBlock(t, rawTx)
+
+	// corrupt the hash of the matched transaction so the proof
+	// no longer hashes to the merkle root in the header
+	merkleBlock[80+4+1+32] ^= 0xff
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	node := &Node{Testnet: true, Socket: local}
+	serveFilteredBlocks(t, remote, envelope.Make([]byte(messages.COMMAND_MERKLEBLOCK), merkleBlock, true))
+
+	_, err := node.GetConfirmedTransactions(context.Background(), []*block.BlockHeader{header}, block.MakeBloomFilter(30, 5, 90210), nil)
+	if err == nil {
+		t.Fatal("accepted a merkle block with an invalid proof")
+	}
+}
@@ -0,0 +1,148 @@
+package script
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+// Parses a script written in assembly, opcodes and hex encoded elements
+// separated by whitespace:
+//
+//	OP_DUP OP_HASH160 <hex> OP_EQUALVERIFY OP_CHECKSIG
+//
+// Elements may be wrapped in angle brackets and "<>" pushes an empty element.
+// Opcodes are matched ignoring case and the OP_ prefix may be left out for
+// names which are not also valid hex.
+func ParseAsm(asm string) (*Script, error) {
+	var commands []Command
+
+	for i, token := range strings.Fields(asm) {
+		// <hex> is always an element
+		if strings.HasPrefix(token, "<") && strings.HasSuffix(token, ">") {
+			data, err := hex.DecodeString(token[1 : len(token)-1])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse token %d %q because %s", i, token, err.Error())
+			}
+			commands = append(commands, Command{Bytes: data})
+			continue
+		}
+
+		// bare hex is an element, anything else has to be an opcode
+		if data, err := hex.DecodeString(token); err == nil {
+			commands = append(commands, Command{Bytes: data})
+			continue
+		}
+
+		op, ok := opcodes.Lookup(token)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse token %d %q because it is not an opcode or hex", i, token)
+		}
+
+		// push opcodes are written as the element they push
+		if op >= 1 && op <= opcodes.OP_PUSHDATA4 {
+			return nil, fmt.Errorf("failed to parse token %d %q because pushes are written as the hex element", i, token)
+		}
+		commands = append(commands, Command{Bytes: []byte{byte(op)}, OpCode: true})
+	}
+
+	s := &Script{Commands: commands}
+
+	raw, err := s.RawSerialize()
+	if err != nil {
+		return nil, err
+	}
+	s.RawScript = raw
+
+	return s, nil
+}
+
+// Parses a script written in the assembly of Bitcoin Core's test vectors:
+//
+//	0x4c 0x01 0x07 'Az' -1 16 1000 DUP OP_HASH160
+//
+// Decimal numbers are pushed as script numbers, 0x prefixed hex is inserted
+// as raw bytes, quoted strings are pushed and opcodes are named with or
+// without the OP_ prefix. Push opcodes can only be written as raw bytes.
+func ParseCoreAsm(asm string) (*Script, error) {
+	var raw []byte
+
+	for i, token := range strings.Fields(asm) {
+		// decimal numbers, -1 and 0 to 16 use their opcodes
+		if n, err := strconv.ParseInt(token, 10, 64); err == nil && !strings.HasPrefix(token, "+") {
+			if n > 0xffffffff || n < -0xffffffff {
+				return nil, fmt.Errorf("failed to parse token %d %q because it is out of range", i, token)
+			}
+			switch {
+			case n == 0:
+				raw = append(raw, byte(opcodes.OP_0))
+			case n == -1 || n >= 1 && n <= 16:
+				raw = append(raw, byte(opcodes.OP_1+uint32(n)-1))
+			default:
+				push, _ := (&Script{Commands: []Command{{Bytes: opcodes.EncodeNum(int(n))}}}).RawSerialize()
+				raw = append(raw, push...)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(token, "0x") && len(token) > 2 {
+			data, err := hex.DecodeString(token[2:])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse token %d %q because %s", i, token, err.Error())
+			}
+			raw = append(raw, data...)
+			continue
+		}
+
+		if len(token) >= 2 && strings.HasPrefix(token, "'") && strings.HasSuffix(token, "'") {
+			push, err := (&Script{Commands: []Command{{Bytes: []byte(token[1 : len(token)-1])}}}).RawSerialize()
+			if err != nil {
+				return nil, err
+			}
+			raw = append(raw, push...)
+			continue
+		}
+
+		op, ok := opcodes.Lookup(token)
+		if !ok || op < opcodes.OP_NOP && op != opcodes.OP_RESERVED {
+			return nil, fmt.Errorf("failed to parse token %d %q because it is not an opcode", i, token)
+		}
+		raw = append(raw, byte(op))
+	}
+
+	commands, err := ParseCommands(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{RawScript: raw, Commands: commands}, nil
+}
+
+// Returns the script in assembly, the opcode names and the elements as hex
+func (s Script) Asm() string {
+	tokens := make([]string, 0, len(s.Commands))
+	for _, c := range s.Commands {
+		tokens = append(tokens, c.Asm())
+	}
+	return strings.Join(tokens, " ")
+}
+
+// Returns the command in assembly, the opcode name or the element as hex
+func (c Command) Asm() string {
+	switch {
+	case c.Unparsed:
+		return "[error]"
+	case c.OpCode:
+		return opcodes.Name(uint32(c.Bytes[0]))
+	case len(c.Bytes) == 0:
+		return "<>"
+	default:
+		return hex.EncodeToString(c.Bytes)
+	}
+}
+
+func (s Script) String() string {
+	return s.Asm()
+}
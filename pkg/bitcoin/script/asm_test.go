@@ -0,0 +1,110 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+func TestParseAsm(t *testing.T) {
+	asm := "OP_DUP OP_HASH160 <d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f> OP_EQUALVERIFY OP_CHECKSIG"
+	s, err := ParseAsm(asm)
+	if err != nil {
+		t.Fatalf("failed to parse the asm because %s", err.Error())
+	}
+	if !s.IsP2pkhScriptPubkey() {
+		t.Fatal("expected a p2pkh script")
+	}
+	if hex.EncodeToString(s.RawScript) != "76a914d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f88ac" {
+		t.Fatalf("unexpected script %x", s.RawScript)
+	}
+
+	// names without the prefix, in any case, and bare hex
+	s2, err := ParseAsm("dup op_hash160 d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f EqualVerify OP_CHECKSIG")
+	if err != nil || !bytes.Equal(s2.RawScript, s.RawScript) {
+		t.Fatalf("failed to parse the relaxed asm")
+	}
+
+	for _, bad := range []string{"OP_DUP OP_FOO", "<abc>", "OP_PUSHDATA1 00", "zz"} {
+		if _, err := ParseAsm(bad); err == nil {
+			t.Fatalf("parsed %q", bad)
+		}
+	}
+}
+
+func TestAsmRoundTrip(t *testing.T) {
+	for _, asm := range []string{
+		"OP_0 d52ad7ca9b3d096a38e752c2018e6fbc40cdf26f",
+		"OP_IF OP_SHA256 <> OP_EQUALVERIFY OP_ELSE OP_CHECKLOCKTIMEVERIFY OP_DROP OP_ENDIF OP_CHECKSIG",
+		"OP_1 OP_16 OP_1NEGATE OP_CAT OP_CHECKSIGADD OP_UNKNOWN_0xbb OP_INVALIDOPCODE",
+		strings.Repeat("ab", 76) + " " + strings.Repeat("cd", 256),
+	} {
+		s, err := ParseAsm(asm)
+		if err != nil {
+			t.Fatalf("failed to parse %q because %s", asm, err.Error())
+		}
+		if s.Asm() != asm {
+			t.Fatalf("expected %q, disassembled %q", asm, s.Asm())
+		}
+
+		// and back through the serialized form
+		parsed, err := Parse(bytes.NewReader(s.Serialize()))
+		if err != nil {
+			t.Fatalf("failed to parse %q because %s", asm, err.Error())
+		}
+		if parsed.Asm() != strings.Replace(asm, "<>", "OP_0", 1) {
+			t.Fatalf("expected %q, disassembled %q", asm, parsed.Asm())
+		}
+	}
+}
+
+func TestOpcodeNames(t *testing.T) {
+	if opcodes.Name(opcodes.OP_CHECKSIG) != "OP_CHECKSIG" {
+		t.Fatalf("unexpected name %s", opcodes.Name(opcodes.OP_CHECKSIG))
+	}
+
+	// alternative names map to the same opcode
+	for name, expected := range map[string]uint32{
+		"OP_TRUE":         opcodes.OP_1,
+		"false":           opcodes.OP_0,
+		"NOP2":            opcodes.OP_CHECKLOCKTIMEVERIFY,
+		"op_nop3":         opcodes.OP_CHECKSEQUENCEVERIFY,
+		"OP_UNKNOWN_0xbb": 0xbb,
+	} {
+		if op, ok := opcodes.Lookup(name); !ok || op != expected {
+			t.Fatalf("expected %s to be %d, got %d", name, expected, op)
+		}
+	}
+
+	if _, ok := opcodes.Lookup("OP_UNKNOWN_0x100"); ok {
+		t.Fatal("looked up an opcode wider than a byte")
+	}
+}
+
+func TestParseCoreAsm(t *testing.T) {
+	for asm, expected := range map[string]string{
+		"0 -1 1 16 17 -2 1000":    "004f51600111018202e803",
+		"0x4c 0x01 0x07 NOP":      "4c010761",
+		"'Az' '' DUP OP_HASH160":  "02417a0076a9",
+		"2147483648 RESERVED NOP": "0500000080005061",
+	} {
+		s, err := ParseCoreAsm(asm)
+		if err != nil {
+			t.Fatalf("failed to parse %q because %s", asm, err.Error())
+		}
+		if hex.EncodeToString(s.RawScript) != expected {
+			t.Fatalf("expected %q to be %s, got %x", asm, expected, s.RawScript)
+		}
+	}
+
+	// pushes are written as numbers or raw bytes, truncated pushes can't be
+	// parsed and numbers are limited to 4 bytes and a sign
+	for _, bad := range []string{"OP_1", "PUSHDATA1", "0x4c01", "0x4", "4294967296", "FOO"} {
+		if _, err := ParseCoreAsm(bad); err == nil {
+			t.Fatalf("parsed %q", bad)
+		}
+	}
+}
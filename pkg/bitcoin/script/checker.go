@@ -0,0 +1,233 @@
+package script
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+	S256 "github.com/ryohare/programming-bitcoin-go/pkg/ecc/curves/secp256k1"
+)
+
+// Rules signatures are checked under, which decide the signature hash
+type SigVersion int
+
+const (
+	// legacy and P2SH scripts
+	SIGVERSION_BASE SigVersion = 0
+
+	// version 0 witness scripts, hashed per BIP143
+	SIGVERSION_WITNESS_V0 SigVersion = 1
+)
+
+// Hash types of signatures, the last byte of a signature
+const (
+	SIGHASH_ALL          byte = 1
+	SIGHASH_NONE         byte = 2
+	SIGHASH_SINGLE       byte = 3
+	SIGHASH_ANYONECANPAY byte = 0x80
+)
+
+// Locktimes below this are block heights, above it unix timestamps
+const LOCKTIME_THRESHOLD = 500000000
+
+// Relative locktime bits of the input sequence (BIP68)
+const (
+	SEQUENCE_FINAL                 = 0xffffffff
+	SEQUENCE_LOCKTIME_DISABLE_FLAG = 1 << 31
+	SEQUENCE_LOCKTIME_TYPE_FLAG    = 1 << 22
+	SEQUENCE_LOCKTIME_MASK         = 0x0000ffff
+)
+
+// Checks signatures and timelocks against the transaction spending the
+// output, which the interpreter itself knows nothing about
+type SignatureChecker interface {
+	// Checks the signature, DER followed by the hash type, by the SEC public
+	// key. The script code is the serialized script being run from the last
+	// OP_CODESEPARATOR on, without its length
+	CheckSig(sig, pubkey, scriptCode []byte, version SigVersion) bool
+
+	// Checks the transaction locktime has passed the OP_CHECKLOCKTIMEVERIFY
+	// argument (BIP65)
+	CheckLockTime(lockTime int64) bool
+
+	// Checks the input sequence has passed the OP_CHECKSEQUENCEVERIFY
+	// argument (BIP112)
+	CheckSequence(sequence int64) bool
+}
+
+// Fields of the spending transaction the timelock opcodes check against.
+// Embedded by signature checkers to implement CheckLockTime and CheckSequence
+type LockTimeChecker struct {
+	LockTime uint32
+
+	// sequence of the input being spent
+	Sequence uint32
+
+	Version uint32
+}
+
+func (c LockTimeChecker) CheckLockTime(lockTime int64) bool {
+	// heights can only be compared with heights and times with times
+	if (int64(c.LockTime) < LOCKTIME_THRESHOLD) != (lockTime < LOCKTIME_THRESHOLD) {
+		return false
+	}
+	if lockTime > int64(c.LockTime) {
+		return false
+	}
+
+	// a final input disables the transaction locktime
+	return c.Sequence != SEQUENCE_FINAL
+}
+
+func (c LockTimeChecker) CheckSequence(sequence int64) bool {
+	// relative locktimes only exist from version 2 on, and not for inputs
+	// which disable them
+	if c.Version < 2 || c.Sequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+		return false
+	}
+
+	mask := int64(SEQUENCE_LOCKTIME_TYPE_FLAG | SEQUENCE_LOCKTIME_MASK)
+	txSequence := int64(c.Sequence) & mask
+	sequence &= mask
+
+	// blocks can only be compared with blocks and time with time
+	if (txSequence < SEQUENCE_LOCKTIME_TYPE_FLAG) != (sequence < SEQUENCE_LOCKTIME_TYPE_FLAG) {
+		return false
+	}
+	return sequence <= txSequence
+}
+
+// Checks every signature against the same signature hash, for scripts run
+// outside of a transaction
+type hashChecker struct {
+	LockTimeChecker
+	z *big.Int
+}
+
+func (c hashChecker) CheckSig(sig, pubkey, scriptCode []byte, version SigVersion) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	return VerifySignature(sig[:len(sig)-1], pubkey, c.z)
+}
+
+// Verifies the DER signature, without the hash type, by the SEC public key
+// over the signature hash z. Both are parsed as loosely as consensus allows,
+// lax DER and hybrid keys included. The flags which need strict encodings are
+// checked by the interpreter before the signature gets here
+func VerifySignature(der, pubkey []byte, z *big.Int) bool {
+	point, err := parsePubKey(pubkey)
+	if err != nil {
+		return false
+	}
+	sig, err := S256.ParseSignatureLax(der)
+	if err != nil {
+		return false
+	}
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.Cmp(S256.GetNonce()) >= 0 || sig.S.Cmp(S256.GetNonce()) >= 0 {
+		return false
+	}
+
+	result, err := point.Verify(*z, *sig)
+	return err == nil && result
+}
+
+// Parses the SEC public key, also allowing the hybrid encoding consensus
+// accepts: 0x06 for an even y or 0x07 for an odd one, followed by both
+// coordinates like an uncompressed key
+func parsePubKey(pubkey []byte) (*S256.S256Point, error) {
+	if len(pubkey) == 65 && (pubkey[0] == 0x06 || pubkey[0] == 0x07) {
+		if pubkey[64]&1 != pubkey[0]&1 {
+			return nil, fmt.Errorf("hybrid key prefix 0x%02x does not match y", pubkey[0])
+		}
+		return S256.ParseSec(append([]byte{0x04}, pubkey[1:]...))
+	}
+	return S256.ParseSec(pubkey)
+}
+
+// Checks the signature is strict DER followed by the hash type (BIP66):
+// 0x30 [total length] 0x02 [R length] [R] 0x02 [S length] [S] [hash type]
+func isValidSignatureEncoding(sig []byte) bool {
+	if len(sig) < 9 || len(sig) > 73 {
+		return false
+	}
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return false
+	}
+
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != len(sig) {
+		return false
+	}
+
+	// R and S are positive integers without padding
+	if sig[2] != 0x02 || lenR == 0 || sig[4]&0x80 != 0 {
+		return false
+	}
+	if lenR > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0 {
+		return false
+	}
+	if sig[lenR+4] != 0x02 || lenS == 0 || sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0x00 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+	return true
+}
+
+// Checks the S value of the strict DER signature is at most half the order
+func isLowDERSignature(sig []byte) bool {
+	parsed, err := S256.ParseSignature(sig[:len(sig)-1])
+	if err != nil {
+		return false
+	}
+	half := new(big.Int).Rsh(S256.GetNonce(), 1)
+	return parsed.S.Cmp(half) <= 0
+}
+
+func isDefinedHashType(sig []byte) bool {
+	hashType := sig[len(sig)-1] &^ SIGHASH_ANYONECANPAY
+	return hashType >= SIGHASH_ALL && hashType <= SIGHASH_SINGLE
+}
+
+// Checks the encoding of the signature against the flags. The empty
+// signature is always allowed, it is how a signature check is made to fail
+func checkSignatureEncoding(sig []byte, flags VerifyFlags) error {
+	if len(sig) == 0 {
+		return nil
+	}
+	if flags.Has(SCRIPT_VERIFY_DERSIG|SCRIPT_VERIFY_LOW_S|SCRIPT_VERIFY_STRICTENC) && !isValidSignatureEncoding(sig) {
+		return opcodes.ScriptErrSigDER
+	}
+	if flags.Has(SCRIPT_VERIFY_LOW_S) && !isLowDERSignature(sig) {
+		return opcodes.ScriptErrSigHighS
+	}
+	if flags.Has(SCRIPT_VERIFY_STRICTENC) && !isDefinedHashType(sig) {
+		return opcodes.ScriptErrSigHashType
+	}
+	return nil
+}
+
+func isCompressedPubKey(pubkey []byte) bool {
+	return len(pubkey) == 33 && (pubkey[0] == 0x02 || pubkey[0] == 0x03)
+}
+
+func isCompressedOrUncompressedPubKey(pubkey []byte) bool {
+	return isCompressedPubKey(pubkey) || len(pubkey) == 65 && pubkey[0] == 0x04
+}
+
+// Checks the encoding of the public key against the flags
+func checkPubKeyEncoding(pubkey []byte, flags VerifyFlags, version SigVersion) error {
+	if flags.Has(SCRIPT_VERIFY_STRICTENC) && !isCompressedOrUncompressedPubKey(pubkey) {
+		return opcodes.ScriptErrPubkeyType
+	}
+	if flags.Has(SCRIPT_VERIFY_WITNESS_PUBKEYTYPE) && version == SIGVERSION_WITNESS_V0 && !isCompressedPubKey(pubkey) {
+		return opcodes.ScriptErrWitnessPubkeyType
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package script
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+// Runs a script one command at a time, exposing the stacks and the program
+// counter of the interpreter between the commands
+type Debugger struct {
+	script *Script
+	e      *execution
+
+	// error the script failed with, nil while it is running fine
+	err error
+}
+
+// Makes a debugger running the script with the interpreter, like EvalScript
+func MakeDebugger(s *Script, flags VerifyFlags, checker SignatureChecker, version SigVersion) *Debugger {
+	return &Debugger{
+		script: s,
+		e:      newExecution(&opcodes.Stack{}, s, flags, checker, version),
+
+		// scripts too big to run fail before their first command
+		err: checkScriptSize(s),
+	}
+}
+
+// Makes a debugger running the script the way Evaluate does
+func (s *Script) Debug(z *big.Int, locktime, sequence, version uint64) *Debugger {
+	checker := hashChecker{
+		LockTimeChecker: LockTimeChecker{
+			LockTime: uint32(locktime),
+			Sequence: uint32(sequence),
+			Version:  uint32(version),
+		},
+		z: z,
+	}
+	return MakeDebugger(s, SCRIPT_VERIFY_NONE, checker, SIGVERSION_BASE)
+}
+
+// Checks if the script has failed or every command has been run
+func (d *Debugger) Done() bool {
+	return d.err != nil || d.e.done()
+}
+
+// Runs the next command. Returns the *ScriptError the script failed with,
+// which is returned again by every later step
+func (d *Debugger) Step() error {
+	if d.Done() {
+		return d.err
+	}
+	d.err = d.e.next()
+	return d.err
+}
+
+// Runs the remaining commands, failing with ScriptErrEvalFalse when the
+// script finishes without a true result
+func (d *Debugger) Run() error {
+	for !d.Done() {
+		d.Step()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	stack := d.e.stack
+	if stack.Len() == 0 || !opcodes.CastToBool(stack.Elements[stack.Len()-1].Bytes) {
+		return scriptError(opcodes.ScriptErrEvalFalse)
+	}
+	return nil
+}
+
+// Returns the error the script failed with, nil when it hasn't
+func (d *Debugger) Err() error {
+	return d.err
+}
+
+// Returns the index of the next command to run
+func (d *Debugger) PC() int {
+	return d.e.pc
+}
+
+// Returns the next command to run, false once every command has been run
+func (d *Debugger) Next() (Command, bool) {
+	if d.e.done() {
+		return Command{}, false
+	}
+	return d.e.commands[d.e.pc], true
+}
+
+// Returns a copy of the elements of the main stack, the top one last
+func (d *Debugger) Stack() [][]byte {
+	return stackElements(d.e.stack)
+}
+
+// Returns a copy of the elements of the alt stack, the top one last
+func (d *Debugger) AltStack() [][]byte {
+	return stackElements(d.e.altStack)
+}
+
+// Returns whether each OP_IF being run took its branch, innermost last
+func (d *Debugger) Conditions() []bool {
+	return append([]bool{}, d.e.conditions...)
+}
+
+func stackElements(s *opcodes.Stack) [][]byte {
+	elements := make([][]byte, s.Len())
+	for i, el := range s.Elements {
+		elements[i] = append([]byte{}, el.Bytes...)
+	}
+	return elements
+}
+
+// State of the interpreter after running a command
+type TraceStep struct {
+	// index of the command run and the command in assembly
+	PC      int    `json:"pc"`
+	Command string `json:"command"`
+
+	// elements as hex, the top one last
+	Stack    []string `json:"stack"`
+	AltStack []string `json:"altStack"`
+
+	Conditions []bool `json:"conditions"`
+
+	// error the command failed with
+	Error string `json:"error,omitempty"`
+}
+
+// Execution of a script, the state after every command it ran
+type Trace struct {
+	Script string      `json:"script"`
+	Steps  []TraceStep `json:"steps"`
+
+	// error the script failed with, empty when it succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// Runs the debugger to the end, recording the state after each command
+func RecordTrace(d *Debugger) *Trace {
+	t := &Trace{Script: d.script.Asm(), Steps: []TraceStep{}}
+	for !d.Done() {
+		c, _ := d.Next()
+		step := TraceStep{PC: d.PC(), Command: c.Asm()}
+		if err := d.Step(); err != nil {
+			step.Error = err.Error()
+		}
+		step.Stack = hexElements(d.Stack())
+		step.AltStack = hexElements(d.AltStack())
+		step.Conditions = d.Conditions()
+		t.Steps = append(t.Steps, step)
+	}
+	if err := d.Run(); err != nil {
+		t.Error = err.Error()
+	}
+	return t
+}
+
+// Writes the trace as indented JSON
+func (t *Trace) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+func hexElements(elements [][]byte) []string {
+	encoded := make([]string, len(elements))
+	for i, el := range elements {
+		encoded[i] = hex.EncodeToString(el)
+	}
+	return encoded
+}
@@ -0,0 +1,121 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+func TestDebuggerStep(t *testing.T) {
+	d := mustParseAsm(t, "OP_2 OP_3 OP_ADD OP_5 OP_EQUAL").Debug(big.NewInt(0), 0, 0, 1)
+
+	for i, expected := range [][]string{
+		{"02"},
+		{"02", "03"},
+		{"05"},
+		{"05", "05"},
+		{"01"},
+	} {
+		if d.Done() || d.PC() != i {
+			t.Fatalf("expected to be at command %d, at %d", i, d.PC())
+		}
+		if err := d.Step(); err != nil {
+			t.Fatalf("failed to run command %d because %s", i, err.Error())
+		}
+		if got := hexElements(d.Stack()); len(got) != len(expected) || (len(got) > 0 && got[len(got)-1] != expected[len(expected)-1]) {
+			t.Fatalf("unexpected stack %v after command %d", got, i)
+		}
+	}
+	if !d.Done() || d.PC() != 5 {
+		t.Fatal("expected every command to have been run")
+	}
+	if _, ok := d.Next(); ok {
+		t.Fatal("expected no command after the last one")
+	}
+	if err := d.Run(); err != nil {
+		t.Fatalf("failed to finish the script because %s", err.Error())
+	}
+}
+
+func TestDebuggerFailure(t *testing.T) {
+	d := mustParseAsm(t, "OP_1 OP_ADD OP_1").Debug(big.NewInt(0), 0, 0, 1)
+
+	d.Step()
+	err := d.Step()
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.Index != 1 || scriptErr.Code != opcodes.ScriptErrStackUnderflow {
+		t.Fatalf("expected the script to fail at OP_ADD, got %v", err)
+	}
+	if !d.Done() || d.Step() != err || d.Run() != err || d.Err() != err {
+		t.Fatal("expected the script to stay failed")
+	}
+
+	// the stack is returned as a copy
+	d = mustParseAsm(t, "OP_0").Debug(big.NewInt(0), 0, 0, 1)
+	d.Step()
+	d.Stack()[0] = []byte{1}
+	if !errors.Is(d.Run(), opcodes.ScriptErrEvalFalse) {
+		t.Fatal("expected the script to finish false")
+	}
+}
+
+func TestDebuggerConditions(t *testing.T) {
+	d := mustParseAsm(t, "OP_1 OP_IF OP_0 OP_IF OP_ENDIF OP_ELSE OP_ENDIF OP_1").Debug(big.NewInt(0), 0, 0, 1)
+
+	for i, expected := range [][]bool{
+		{},
+		{true},
+		{true},
+		{true, false},
+		{true},
+		{false},
+		{},
+		{},
+	} {
+		if err := d.Step(); err != nil {
+			t.Fatalf("failed to run command %d because %s", i, err.Error())
+		}
+		got := d.Conditions()
+		if len(got) != len(expected) {
+			t.Fatalf("unexpected conditions %v after command %d", got, i)
+		}
+		for j := range got {
+			if got[j] != expected[j] {
+				t.Fatalf("unexpected conditions %v after command %d", got, i)
+			}
+		}
+	}
+	if err := d.Run(); err != nil {
+		t.Fatalf("failed to finish the script because %s", err.Error())
+	}
+}
+
+func TestRecordTrace(t *testing.T) {
+	trace := RecordTrace(mustParseAsm(t, "OP_1 OP_DUP OP_ADD OP_3 OP_EQUALVERIFY").Debug(big.NewInt(0), 0, 0, 1))
+
+	if trace.Script != "OP_1 OP_DUP OP_ADD OP_3 OP_EQUALVERIFY" || len(trace.Steps) != 5 {
+		t.Fatalf("unexpected trace of %q with %d steps", trace.Script, len(trace.Steps))
+	}
+	if step := trace.Steps[2]; step.PC != 2 || step.Command != "OP_ADD" || len(step.Stack) != 1 || step.Stack[0] != "02" {
+		t.Fatalf("unexpected step %+v", step)
+	}
+	if last := trace.Steps[4]; last.Error == "" || trace.Error != last.Error {
+		t.Fatal("expected the trace to record the failure of OP_EQUALVERIFY")
+	}
+
+	var buf bytes.Buffer
+	if err := trace.WriteJSON(&buf); err != nil {
+		t.Fatalf("failed to write the trace because %s", err.Error())
+	}
+	var decoded Trace
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to read the trace back because %s", err.Error())
+	}
+	if len(decoded.Steps) != 5 || decoded.Steps[1].Stack[1] != "01" || decoded.Error != trace.Error {
+		t.Fatalf("unexpected trace read back %+v", decoded)
+	}
+}
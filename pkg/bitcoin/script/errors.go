@@ -0,0 +1,50 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+// Returned when a script fails. Wraps the error code so the reason can be
+// checked with errors.Is, like errors.Is(err, opcodes.ScriptErrEvalFalse)
+type ScriptError struct {
+	Code opcodes.ErrorCode
+
+	// index of the command the script failed at, -1 when it failed as a
+	// whole like when finishing with a false result
+	Index int
+
+	// opcode of the command, the push opcode for data elements
+	Op uint32
+}
+
+func (e *ScriptError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("script failed because %s", e.Code.Error())
+	}
+	return fmt.Sprintf("script failed at %s (command %d) because %s", opcodes.Name(e.Op), e.Index, e.Code.Error())
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Code
+}
+
+// Makes the error of a script failing as a whole
+func scriptError(code opcodes.ErrorCode) error {
+	return &ScriptError{Code: code, Index: -1}
+}
+
+// Returns the code of the error a script failed with, ScriptErrOk when it
+// didn't fail
+func ErrorCode(err error) opcodes.ErrorCode {
+	if err == nil {
+		return opcodes.ScriptErrOk
+	}
+	var code opcodes.ErrorCode
+	if errors.As(err, &code) {
+		return code
+	}
+	return opcodes.ScriptErrUnknown
+}
@@ -0,0 +1,168 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rules the interpreter enforces on top of the bare script semantics. The
+// values match Bitcoin Core's SCRIPT_VERIFY_* flags so flag sets can be
+// exchanged with it.
+type VerifyFlags uint32
+
+const (
+	SCRIPT_VERIFY_NONE VerifyFlags = 0
+
+	// Evaluate P2SH redeem scripts (BIP16)
+	SCRIPT_VERIFY_P2SH VerifyFlags = 1 << 0
+
+	// Signatures must be strict DER with a defined hash type and public keys
+	// compressed or uncompressed SEC
+	SCRIPT_VERIFY_STRICTENC VerifyFlags = 1 << 1
+
+	// Signatures must be strict DER (BIP66)
+	SCRIPT_VERIFY_DERSIG VerifyFlags = 1 << 2
+
+	// The S value of signatures must be in the lower half of the curve order
+	SCRIPT_VERIFY_LOW_S VerifyFlags = 1 << 3
+
+	// The extra element OP_CHECKMULTISIG pops must be empty (BIP147)
+	SCRIPT_VERIFY_NULLDUMMY VerifyFlags = 1 << 4
+
+	// scriptSigs may only push data
+	SCRIPT_VERIFY_SIGPUSHONLY VerifyFlags = 1 << 5
+
+	// Elements must be pushed with the smallest opcode possible
+	SCRIPT_VERIFY_MINIMALDATA VerifyFlags = 1 << 6
+
+	// OP_NOP1 and OP_NOP4 to OP_NOP10 fail instead of doing nothing, so they
+	// stay free for soft forks
+	SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_NOPS VerifyFlags = 1 << 7
+
+	// Exactly one element must be left on the stack once the scripts ran
+	SCRIPT_VERIFY_CLEANSTACK VerifyFlags = 1 << 8
+
+	// Enforce OP_CHECKLOCKTIMEVERIFY (BIP65), otherwise it is OP_NOP2
+	SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY VerifyFlags = 1 << 9
+
+	// Enforce OP_CHECKSEQUENCEVERIFY (BIP112), otherwise it is OP_NOP3
+	SCRIPT_VERIFY_CHECKSEQUENCEVERIFY VerifyFlags = 1 << 10
+
+	// Evaluate witness programs (BIP141)
+	SCRIPT_VERIFY_WITNESS VerifyFlags = 1 << 11
+
+	// Witness versions above 0 fail instead of succeeding unchecked
+	SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM VerifyFlags = 1 << 12
+
+	// The argument of OP_IF and OP_NOTIF in witness scripts must be empty or
+	// exactly 0x01
+	SCRIPT_VERIFY_MINIMALIF VerifyFlags = 1 << 13
+
+	// Signatures which fail to verify must be empty
+	SCRIPT_VERIFY_NULLFAIL VerifyFlags = 1 << 14
+
+	// Public keys in witness scripts must be compressed
+	SCRIPT_VERIFY_WITNESS_PUBKEYTYPE VerifyFlags = 1 << 15
+
+	// OP_CODESEPARATOR and signatures found in the script code fail in
+	// legacy scripts
+	SCRIPT_VERIFY_CONST_SCRIPTCODE VerifyFlags = 1 << 16
+)
+
+// Rules every block has to follow
+const CONSENSUS_SCRIPT_VERIFY_FLAGS = SCRIPT_VERIFY_P2SH |
+	SCRIPT_VERIFY_DERSIG |
+	SCRIPT_VERIFY_NULLDUMMY |
+	SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY |
+	SCRIPT_VERIFY_CHECKSEQUENCEVERIFY |
+	SCRIPT_VERIFY_WITNESS
+
+// Rules transactions have to follow to be relayed
+const STANDARD_SCRIPT_VERIFY_FLAGS = CONSENSUS_SCRIPT_VERIFY_FLAGS |
+	SCRIPT_VERIFY_STRICTENC |
+	SCRIPT_VERIFY_MINIMALDATA |
+	SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_NOPS |
+	SCRIPT_VERIFY_CLEANSTACK |
+	SCRIPT_VERIFY_MINIMALIF |
+	SCRIPT_VERIFY_NULLFAIL |
+	SCRIPT_VERIFY_LOW_S |
+	SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM |
+	SCRIPT_VERIFY_WITNESS_PUBKEYTYPE |
+	SCRIPT_VERIFY_CONST_SCRIPTCODE
+
+// Flag names as Bitcoin Core writes them, in bit order
+var flagNames = []struct {
+	flag VerifyFlags
+	name string
+}{
+	{SCRIPT_VERIFY_P2SH, "P2SH"},
+	{SCRIPT_VERIFY_STRICTENC, "STRICTENC"},
+	{SCRIPT_VERIFY_DERSIG, "DERSIG"},
+	{SCRIPT_VERIFY_LOW_S, "LOW_S"},
+	{SCRIPT_VERIFY_NULLDUMMY, "NULLDUMMY"},
+	{SCRIPT_VERIFY_SIGPUSHONLY, "SIGPUSHONLY"},
+	{SCRIPT_VERIFY_MINIMALDATA, "MINIMALDATA"},
+	{SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_NOPS, "DISCOURAGE_UPGRADABLE_NOPS"},
+	{SCRIPT_VERIFY_CLEANSTACK, "CLEANSTACK"},
+	{SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY, "CHECKLOCKTIMEVERIFY"},
+	{SCRIPT_VERIFY_CHECKSEQUENCEVERIFY, "CHECKSEQUENCEVERIFY"},
+	{SCRIPT_VERIFY_WITNESS, "WITNESS"},
+	{SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM, "DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM"},
+	{SCRIPT_VERIFY_MINIMALIF, "MINIMALIF"},
+	{SCRIPT_VERIFY_NULLFAIL, "NULLFAIL"},
+	{SCRIPT_VERIFY_WITNESS_PUBKEYTYPE, "WITNESS_PUBKEYTYPE"},
+	{SCRIPT_VERIFY_CONST_SCRIPTCODE, "CONST_SCRIPTCODE"},
+}
+
+// Parses a comma separated list of flag names such as "P2SH,STRICTENC".
+// NONE and the empty string are no flags
+func ParseVerifyFlags(names string) (VerifyFlags, error) {
+	var flags VerifyFlags
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "NONE" {
+			continue
+		}
+
+		found := false
+		for _, f := range flagNames {
+			if f.name == name {
+				flags |= f.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("failed to parse flags because %s is not a known flag", name)
+		}
+	}
+	return flags, nil
+}
+
+// Checks if any of the flags is set
+func (f VerifyFlags) Has(flag VerifyFlags) bool {
+	return f&flag != 0
+}
+
+// Checks if every one of the flags is set
+func (f VerifyFlags) HasAll(flags VerifyFlags) bool {
+	return f&flags == flags
+}
+
+// Returns the flag names separated by commas, NONE without flags
+func (f VerifyFlags) String() string {
+	var names []string
+	for _, n := range flagNames {
+		if f.Has(n.flag) {
+			names = append(names, n.name)
+			f &^= n.flag
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(f)))
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
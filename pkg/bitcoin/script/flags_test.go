@@ -0,0 +1,42 @@
+package script
+
+import "testing"
+
+func TestParseVerifyFlags(t *testing.T) {
+	flags, err := ParseVerifyFlags("P2SH, STRICTENC,WITNESS")
+	if err != nil {
+		t.Fatalf("failed to parse the flags because %s", err.Error())
+	}
+	if flags != SCRIPT_VERIFY_P2SH|SCRIPT_VERIFY_STRICTENC|SCRIPT_VERIFY_WITNESS {
+		t.Fatalf("unexpected flags %s", flags)
+	}
+	if flags.String() != "P2SH,STRICTENC,WITNESS" {
+		t.Fatalf("unexpected names %s", flags.String())
+	}
+
+	for _, none := range []string{"", "NONE"} {
+		if flags, err := ParseVerifyFlags(none); err != nil || flags != SCRIPT_VERIFY_NONE {
+			t.Fatalf("expected no flags for %q", none)
+		}
+	}
+	if SCRIPT_VERIFY_NONE.String() != "NONE" {
+		t.Fatalf("unexpected names %s", SCRIPT_VERIFY_NONE.String())
+	}
+
+	if !flags.Has(SCRIPT_VERIFY_P2SH|SCRIPT_VERIFY_CLEANSTACK) || flags.HasAll(SCRIPT_VERIFY_P2SH|SCRIPT_VERIFY_CLEANSTACK) {
+		t.Fatal("expected only some of the flags to be set")
+	}
+	if !flags.HasAll(SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_WITNESS) {
+		t.Fatal("expected both flags to be set")
+	}
+
+	if _, err := ParseVerifyFlags("P2SH,FOO"); err == nil {
+		t.Fatal("parsed an unknown flag")
+	}
+
+	// every standard flag round trips through its name
+	parsed, err := ParseVerifyFlags(STANDARD_SCRIPT_VERIFY_FLAGS.String())
+	if err != nil || parsed != STANDARD_SCRIPT_VERIFY_FLAGS {
+		t.Fatalf("failed to round trip %s", STANDARD_SCRIPT_VERIFY_FLAGS)
+	}
+}
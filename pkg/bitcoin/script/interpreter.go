@@ -0,0 +1,903 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+// Most public keys OP_CHECKMULTISIG accepts
+const MAX_PUBKEYS_PER_MULTISIG = 20
+
+// Most elements the main and alt stacks may hold together
+const MAX_STACK_SIZE = 1000
+
+// Longest script which can be run, serialized
+const MAX_SCRIPT_SIZE = 10000
+
+// Longest element a script can push
+const MAX_SCRIPT_ELEMENT_SIZE = 520
+
+// Most opcodes a script can have, not counting pushes
+const MAX_OPS_PER_SCRIPT = 201
+
+// Returns the opcode of the command. Opcodes are a single byte, wider
+// commands are read as a big endian number
+func (c Command) Op() uint32 {
+	if len(c.Bytes) == 1 {
+		return uint32(c.Bytes[0])
+	}
+	b := make([]byte, 4)
+	copy(b[4-len(c.Bytes):], c.Bytes)
+	return binary.BigEndian.Uint32(b)
+}
+
+// Checks the script only pushes data. OP_1NEGATE and OP_1 to OP_16 count as
+// pushes
+func (s Script) IsPushOnly() bool {
+	for _, c := range s.Commands {
+		if c.Unparsed || c.OpCode && c.Op() > opcodes.OP_16 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the version and program of a witness program script, a version
+// opcode followed by a direct push of 2 to 40 bytes (BIP141)
+func (s Script) WitnessProgram() (int, []byte, bool) {
+	raw, err := s.RawSerialize()
+	if err != nil || len(raw) < 4 || len(raw) > 42 {
+		return 0, nil, false
+	}
+
+	version := uint32(raw[0])
+	if version != opcodes.OP_0 && (version < opcodes.OP_1 || version > opcodes.OP_16) {
+		return 0, nil, false
+	}
+	if int(raw[1])+2 != len(raw) {
+		return 0, nil, false
+	}
+
+	if version == opcodes.OP_0 {
+		return 0, raw[2:], true
+	}
+	return int(version-opcodes.OP_1) + 1, raw[2:], true
+}
+
+// Checks the script is exactly OP_HASH160 <20 bytes> OP_EQUAL, with the hash
+// pushed directly (BIP16)
+func isPayToScriptHash(s *Script) bool {
+	raw, err := s.RawSerialize()
+	if err != nil || len(raw) != 23 {
+		return false
+	}
+	return uint32(raw[0]) == opcodes.OP_HASH160 && raw[1] == 20 && uint32(raw[22]) == opcodes.OP_EQUAL
+}
+
+// Checks the element was pushed with the smallest push possible. Single
+// byte numbers have to be pushed with OP_1NEGATE and OP_1 to OP_16
+func checkMinimalPush(c Command) bool {
+	length := len(c.Bytes)
+	switch {
+	case length == 1 && c.Bytes[0] >= 1 && c.Bytes[0] <= 16:
+		return false
+	case length == 1 && c.Bytes[0] == 0x81:
+		return false
+	case c.PushOp == 0:
+		// built in code, serialized with the smallest push
+		return true
+	}
+	return c.PushOp == pushOpFor(length)
+}
+
+// Returns the commands with every push of the element removed, and if any
+// was found. Only used by legacy signature checks, which can't sign over
+// their own signatures
+func findAndDelete(commands []Command, element []byte) ([]Command, bool) {
+	target, _ := (&Script{Commands: []Command{{Bytes: element}}}).RawSerialize()
+
+	result := make([]Command, 0, len(commands))
+	found := false
+	for _, c := range commands {
+		if !c.OpCode {
+			raw, _ := (&Script{Commands: []Command{c}}).RawSerialize()
+			if bytes.Equal(raw, target) {
+				found = true
+				continue
+			}
+		}
+		result = append(result, c)
+	}
+	return result, found
+}
+
+// State of a script being run
+type execution struct {
+	commands []Command
+	stack    *opcodes.Stack
+	altStack *opcodes.Stack
+	flags    VerifyFlags
+	checker  SignatureChecker
+	version  SigVersion
+
+	// index of the next command to run
+	pc int
+
+	// index of the command after the last OP_CODESEPARATOR, where the
+	// script code signatures sign starts
+	codeSeparator int
+
+	// whether each OP_IF being run took its branch, innermost last
+	conditions []bool
+
+	// opcodes run so far which count towards MAX_OPS_PER_SCRIPT
+	opCount int
+}
+
+func newExecution(stack *opcodes.Stack, s *Script, flags VerifyFlags, checker SignatureChecker, version SigVersion) *execution {
+	stack.RequireMinimal = flags.Has(SCRIPT_VERIFY_MINIMALDATA)
+	return &execution{
+		commands: s.Commands,
+		stack:    stack,
+		altStack: &opcodes.Stack{},
+		flags:    flags,
+		checker:  checker,
+		version:  version,
+	}
+}
+
+// Runs the script on the stack, stopping at the first command which fails.
+// The error is a *ScriptError with the index of the command
+func EvalScript(stack *opcodes.Stack, s *Script, flags VerifyFlags, checker SignatureChecker, version SigVersion) error {
+	if err := checkScriptSize(s); err != nil {
+		return err
+	}
+	e := newExecution(stack, s, flags, checker, version)
+	for !e.done() {
+		if err := e.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the serialized script is at most MAX_SCRIPT_SIZE bytes
+func checkScriptSize(s *Script) error {
+	raw, err := s.RawSerialize()
+	if err != nil || len(raw) > MAX_SCRIPT_SIZE {
+		return scriptError(opcodes.ScriptErrScriptSize)
+	}
+	return nil
+}
+
+// Checks every command has been run
+func (e *execution) done() bool {
+	return e.pc >= len(e.commands)
+}
+
+// Runs the next command, failing with a *ScriptError
+func (e *execution) next() error {
+	c := e.commands[e.pc]
+	if err := e.step(); err != nil {
+		return &ScriptError{Code: ErrorCode(err), Index: e.pc - 1, Op: commandOp(c)}
+	}
+	if e.stack.Len()+e.altStack.Len() > MAX_STACK_SIZE {
+		return &ScriptError{Code: opcodes.ScriptErrStackSize, Index: e.pc - 1, Op: commandOp(c)}
+	}
+	// every OP_IF needs an OP_ENDIF by the end of the script
+	if e.done() && len(e.conditions) > 0 {
+		return scriptError(opcodes.ScriptErrUnbalancedConditional)
+	}
+	return nil
+}
+
+// Returns the opcode of the command, the push opcode for data elements
+func commandOp(c Command) uint32 {
+	if c.OpCode {
+		return c.Op()
+	}
+	if c.PushOp != 0 {
+		return c.PushOp
+	}
+	return pushOpFor(len(c.Bytes))
+}
+
+// Runs the next command
+func (e *execution) step() error {
+	c := e.commands[e.pc]
+	e.pc++
+
+	stack := e.stack
+	executing := e.executing()
+
+	// a push running past the end of the script fails wherever it is
+	if c.Unparsed {
+		return opcodes.ScriptErrBadOpcode
+	}
+
+	// data elements are pushed as they are. Like the opcode limits below,
+	// the size limit holds in branches which aren't taken
+	if !c.OpCode {
+		if len(c.Bytes) > MAX_SCRIPT_ELEMENT_SIZE {
+			return opcodes.ScriptErrPushSize
+		}
+		if !executing {
+			return nil
+		}
+		if e.flags.Has(SCRIPT_VERIFY_MINIMALDATA) && !checkMinimalPush(c) {
+			return opcodes.ScriptErrMinimalData
+		}
+		stack.Push(c.Bytes)
+		return nil
+	}
+
+	if c.Op() > opcodes.OP_16 {
+		e.opCount++
+		if e.opCount > MAX_OPS_PER_SCRIPT {
+			return opcodes.ScriptErrOpCount
+		}
+	}
+	// disabled opcodes fail the script wherever they are, so this comes
+	// before skipping the branches which aren't taken
+	if isDisabled(c.Op()) {
+		return opcodes.ScriptErrDisabledOpcode
+	}
+
+	// only the conditionals are run in branches which aren't taken, so they
+	// can be matched up. OP_VERIF and OP_VERNOTIF are among them and fail
+	// wherever they are
+	if !executing && (c.Op() < opcodes.OP_IF || c.Op() > opcodes.OP_ENDIF) {
+		return nil
+	}
+
+	switch c.Op() {
+	case opcodes.OP_0:
+		return stack.Op0()
+	case opcodes.OP_1NEGATE:
+		return stack.Op1Negate()
+	case opcodes.OP_1:
+		return stack.Op1()
+	case opcodes.OP_2:
+		return stack.Op2()
+	case opcodes.OP_3:
+		return stack.Op3()
+	case opcodes.OP_4:
+		return stack.Op4()
+	case opcodes.OP_5:
+		return stack.Op5()
+	case opcodes.OP_6:
+		return stack.Op6()
+	case opcodes.OP_7:
+		return stack.Op7()
+	case opcodes.OP_8:
+		return stack.Op8()
+	case opcodes.OP_9:
+		return stack.Op9()
+	case opcodes.OP_10:
+		return stack.Op10()
+	case opcodes.OP_11:
+		return stack.Op11()
+	case opcodes.OP_12:
+		return stack.Op12()
+	case opcodes.OP_13:
+		return stack.Op13()
+	case opcodes.OP_14:
+		return stack.Op14()
+	case opcodes.OP_15:
+		return stack.Op15()
+	case opcodes.OP_16:
+		return stack.Op16()
+	case opcodes.OP_NOP:
+		return stack.OpNop()
+	case opcodes.OP_NOP1, opcodes.OP_NOP4, opcodes.OP_NOP5, opcodes.OP_NOP6,
+		opcodes.OP_NOP7, opcodes.OP_NOP8, opcodes.OP_NOP9, opcodes.OP_NOP10:
+		// reserved for soft forks
+		return e.upgradableNop()
+	case opcodes.OP_CHECKLOCKTIMEVERIFY:
+		if !e.flags.Has(SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY) {
+			return e.upgradableNop()
+		}
+		return e.checkLockTimeVerify()
+	case opcodes.OP_CHECKSEQUENCEVERIFY:
+		if !e.flags.Has(SCRIPT_VERIFY_CHECKSEQUENCEVERIFY) {
+			return e.upgradableNop()
+		}
+		return e.checkSequenceVerify()
+	case opcodes.OP_IF:
+		return e.opIf(false)
+	case opcodes.OP_NOTIF:
+		return e.opIf(true)
+	case opcodes.OP_ELSE:
+		if len(e.conditions) == 0 {
+			return opcodes.ScriptErrUnbalancedConditional
+		}
+		e.conditions[len(e.conditions)-1] = !e.conditions[len(e.conditions)-1]
+		return nil
+	case opcodes.OP_ENDIF:
+		if len(e.conditions) == 0 {
+			return opcodes.ScriptErrUnbalancedConditional
+		}
+		e.conditions = e.conditions[:len(e.conditions)-1]
+		return nil
+	case opcodes.OP_VERIFY:
+		return stack.OpVerify()
+	case opcodes.OP_RETURN:
+		return stack.OpReturn()
+	case opcodes.OP_TOALTSTACK:
+		return stack.OpToAltStack(e.altStack)
+	case opcodes.OP_FROMALTSTACK:
+		return stack.OpFromAltStack(e.altStack)
+	case opcodes.OP_2DROP:
+		return stack.Op2Drop()
+	case opcodes.OP_2DUP:
+		return stack.Op2Dup()
+	case opcodes.OP_3DUP:
+		return stack.Op3Dup()
+	case opcodes.OP_2OVER:
+		return stack.Op2Over()
+	case opcodes.OP_2ROT:
+		return stack.Op2Rot()
+	case opcodes.OP_2SWAP:
+		return stack.Op2Swap()
+	case opcodes.OP_IFDUP:
+		return stack.OpIfDup()
+	case opcodes.OP_DEPTH:
+		return stack.OpDepth()
+	case opcodes.OP_DROP:
+		return stack.OpDrop()
+	case opcodes.OP_DUP:
+		return stack.OpDup()
+	case opcodes.OP_NIP:
+		return stack.OpNip()
+	case opcodes.OP_OVER:
+		return stack.OpOver()
+	case opcodes.OP_PICK:
+		return stack.OpPick()
+	case opcodes.OP_ROLL:
+		return stack.OpRoll()
+	case opcodes.OP_ROT:
+		return stack.OpRot()
+	case opcodes.OP_SWAP:
+		return stack.OpSwap()
+	case opcodes.OP_TUCK:
+		return stack.OpTuck()
+	case opcodes.OP_SIZE:
+		return stack.OpSize()
+	case opcodes.OP_EQUAL:
+		return stack.OpEqual()
+	case opcodes.OP_EQUALVERIFY:
+		return stack.OpEqualVerify()
+	case opcodes.OP_1ADD:
+		return stack.Op1Add()
+	case opcodes.OP_1SUB:
+		return stack.Op1Sub()
+	case opcodes.OP_NEGATE:
+		return stack.OpNegate()
+	case opcodes.OP_ABS:
+		return stack.OpAbs()
+	case opcodes.OP_NOT:
+		return stack.OpNot()
+	case opcodes.OP_0NOTEQUAL:
+		return stack.Op0NotEqual()
+	case opcodes.OP_ADD:
+		return stack.OpAdd()
+	case opcodes.OP_SUB:
+		return stack.OpSub()
+	case opcodes.OP_BOOLAND:
+		return stack.OpBoolAnd()
+	case opcodes.OP_BOOLOR:
+		return stack.OpBoolOr()
+	case opcodes.OP_NUMEQUAL:
+		return stack.OpNumEqual()
+	case opcodes.OP_NUMEQUALVERIFY:
+		return stack.OpNumEqualVerify()
+	case opcodes.OP_NUMNOTEQUAL:
+		return stack.OpNumNotEqual()
+	case opcodes.OP_LESSTHAN:
+		return stack.OpLessThan()
+	case opcodes.OP_GREATERTHAN:
+		return stack.OpGreaterThan()
+	case opcodes.OP_LESSTHANOREQUAL:
+		return stack.OpLessOrEqualThan()
+	case opcodes.OP_GREATERTHANOREQUAL:
+		return stack.OpGreaterOrEqualThan()
+	case opcodes.OP_MIN:
+		return stack.OpMin()
+	case opcodes.OP_MAX:
+		return stack.OpMax()
+	case opcodes.OP_WITHIN:
+		return stack.OpWithIn()
+	case opcodes.OP_RIPEMD160:
+		return stack.OpRipeMd160()
+	case opcodes.OP_SHA1:
+		return stack.OpSha1()
+	case opcodes.OP_SHA256:
+		return stack.OpSha256()
+	case opcodes.OP_HASH160:
+		return stack.OpHash160()
+	case opcodes.OP_HASH256:
+		return stack.OpHash256()
+	case opcodes.OP_CODESEPARATOR:
+		// legacy scripts can't have their script code changed under
+		// CONST_SCRIPTCODE
+		if e.version == SIGVERSION_BASE && e.flags.Has(SCRIPT_VERIFY_CONST_SCRIPTCODE) {
+			return opcodes.ScriptErrOpCodeSeparator
+		}
+		e.codeSeparator = e.pc
+		return nil
+	case opcodes.OP_CHECKSIG:
+		return e.checkSig(false)
+	case opcodes.OP_CHECKSIGVERIFY:
+		return e.checkSig(true)
+	case opcodes.OP_CHECKMULTISIG:
+		return e.checkMultisig(false)
+	case opcodes.OP_CHECKMULTISIGVERIFY:
+		return e.checkMultisig(true)
+	}
+
+	// not an opcode the interpreter knows
+	return opcodes.ScriptErrBadOpcode
+}
+
+// Checks if the opcode was disabled in the early days of Bitcoin, making any
+// script containing it fail
+func isDisabled(op uint32) bool {
+	switch op {
+	case opcodes.OP_CAT, opcodes.OP_SUBSTR, opcodes.OP_LEFT, opcodes.OP_RIGHT,
+		opcodes.OP_INVERT, opcodes.OP_AND, opcodes.OP_OR, opcodes.OP_XOR,
+		opcodes.OP_2MUL, opcodes.OP_2DIV, opcodes.OP_MUL, opcodes.OP_DIV,
+		opcodes.OP_MOD, opcodes.OP_LSHIFT, opcodes.OP_RSHIFT:
+		return true
+	}
+	return false
+}
+
+// The NOPs reserved for soft forks, which fail under
+// DISCOURAGE_UPGRADABLE_NOPS
+func (e *execution) upgradableNop() error {
+	if e.flags.Has(SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_NOPS) {
+		return opcodes.ScriptErrDiscourageUpgradableNops
+	}
+	return nil
+}
+
+// Checks every OP_IF being run took its branch, so the commands are run
+func (e *execution) executing() bool {
+	for _, condition := range e.conditions {
+		if !condition {
+			return false
+		}
+	}
+	return true
+}
+
+// OP_IF and OP_NOTIF. Pops the condition when the branch they are in is
+// being run, otherwise neither of their branches is
+func (e *execution) opIf(not bool) error {
+	condition := false
+	if e.executing() {
+		if e.stack.Len() < 1 {
+			return opcodes.ScriptErrUnbalancedConditional
+		}
+		if err := e.checkMinimalIf(); err != nil {
+			return err
+		}
+		condition = opcodes.CastToBool(e.stack.Pop().Bytes) != not
+	}
+	e.conditions = append(e.conditions, condition)
+	return nil
+}
+
+// Under MINIMALIF the argument of OP_IF and OP_NOTIF in witness scripts has
+// to be empty or 0x01
+func (e *execution) checkMinimalIf() error {
+	if e.version != SIGVERSION_WITNESS_V0 || !e.flags.Has(SCRIPT_VERIFY_MINIMALIF) {
+		return nil
+	}
+	if e.stack.Len() < 1 {
+		return opcodes.ScriptErrUnbalancedConditional
+	}
+	top := e.stack.Elements[e.stack.Len()-1].Bytes
+	if len(top) > 1 || len(top) == 1 && top[0] != 1 {
+		return opcodes.ScriptErrMinimalIf
+	}
+	return nil
+}
+
+// Returns the element n from the top of the stack, 1 being the top
+func (e *execution) top(n int) []byte {
+	return e.stack.Elements[e.stack.Len()-n].Bytes
+}
+
+// Returns the script code signatures sign, the commands from the last
+// OP_CODESEPARATOR on with the signatures removed from legacy scripts
+func (e *execution) scriptCode(sigs [][]byte) ([]byte, error) {
+	commands := e.commands[e.codeSeparator:]
+
+	if e.version == SIGVERSION_BASE {
+		for _, sig := range sigs {
+			var found bool
+			commands, found = findAndDelete(commands, sig)
+			if found && e.flags.Has(SCRIPT_VERIFY_CONST_SCRIPTCODE) {
+				return nil, opcodes.ScriptErrSigFindAndDelete
+			}
+		}
+	}
+
+	raw, err := (&Script{Commands: commands}).RawSerialize()
+	if err != nil {
+		return nil, opcodes.ScriptErrUnknown
+	}
+	return raw, nil
+}
+
+// Pushes the result of a signature check, or fails with the code when it's
+// the verify variant which failed
+func (e *execution) pushResult(success, verify bool, code opcodes.ErrorCode) error {
+	if verify {
+		if !success {
+			return code
+		}
+		return nil
+	}
+	if success {
+		e.stack.Push(opcodes.EncodeNum(1))
+	} else {
+		e.stack.Push(opcodes.EncodeNum(0))
+	}
+	return nil
+}
+
+// OP_CHECKSIG and OP_CHECKSIGVERIFY. Pops the public key and the signature
+// and pushes whether the signature is valid
+func (e *execution) checkSig(verify bool) error {
+	if e.stack.Len() < 2 {
+		return opcodes.ScriptErrStackUnderflow
+	}
+	sig := e.top(2)
+	pubkey := e.top(1)
+
+	scriptCode, err := e.scriptCode([][]byte{sig})
+	if err != nil {
+		return err
+	}
+
+	if err := checkSignatureEncoding(sig, e.flags); err != nil {
+		return err
+	}
+	if err := checkPubKeyEncoding(pubkey, e.flags, e.version); err != nil {
+		return err
+	}
+
+	success := len(sig) > 0 && e.checker.CheckSig(sig, pubkey, scriptCode, e.version)
+	if !success && e.flags.Has(SCRIPT_VERIFY_NULLFAIL) && len(sig) > 0 {
+		return opcodes.ScriptErrSigNullFail
+	}
+
+	e.stack.Pop()
+	e.stack.Pop()
+
+	return e.pushResult(success, verify, opcodes.ScriptErrCheckSigVerify)
+}
+
+// OP_CHECKMULTISIG and OP_CHECKMULTISIGVERIFY. The stack holds
+// <dummy> <sig 1> ... <sig m> <m> <pubkey 1> ... <pubkey n> <n>, each
+// signature has to match one of the public keys, in order
+func (e *execution) checkMultisig(verify bool) error {
+	i := 1
+	if e.stack.Len() < i {
+		return opcodes.ScriptErrStackUnderflow
+	}
+
+	n, err := opcodes.MakeScriptNum(e.top(i), e.stack.RequireMinimal, opcodes.MAX_NUM_SIZE)
+	if err != nil {
+		return err
+	}
+	keys := int(n)
+	if keys < 0 || keys > MAX_PUBKEYS_PER_MULTISIG {
+		return opcodes.ScriptErrPubkeyCount
+	}
+
+	// each public key counts towards the opcode limit
+	e.opCount += keys
+	if e.opCount > MAX_OPS_PER_SCRIPT {
+		return opcodes.ScriptErrOpCount
+	}
+	i++
+	key := i
+
+	// keys not used by a signature are left to check NULLFAIL against
+	unusedKeys := keys + 2
+	i += keys
+	if e.stack.Len() < i {
+		return opcodes.ScriptErrStackUnderflow
+	}
+
+	n, err = opcodes.MakeScriptNum(e.top(i), e.stack.RequireMinimal, opcodes.MAX_NUM_SIZE)
+	if err != nil {
+		return err
+	}
+	sigCount := int(n)
+	if sigCount < 0 || sigCount > keys {
+		return opcodes.ScriptErrSigCount
+	}
+	i++
+	sig := i
+	i += sigCount
+	if e.stack.Len() < i {
+		return opcodes.ScriptErrStackUnderflow
+	}
+
+	sigs := make([][]byte, 0, sigCount)
+	for k := 0; k < sigCount; k++ {
+		sigs = append(sigs, e.top(sig+k))
+	}
+	scriptCode, err := e.scriptCode(sigs)
+	if err != nil {
+		return err
+	}
+
+	success := true
+	for success && sigCount > 0 {
+		s := e.top(sig)
+		pubkey := e.top(key)
+
+		if err := checkSignatureEncoding(s, e.flags); err != nil {
+			return err
+		}
+		if err := checkPubKeyEncoding(pubkey, e.flags, e.version); err != nil {
+			return err
+		}
+
+		if len(s) > 0 && e.checker.CheckSig(s, pubkey, scriptCode, e.version) {
+			sig++
+			sigCount--
+		}
+		key++
+		keys--
+
+		// more signatures left than keys to match them means failure
+		if sigCount > keys {
+			success = false
+		}
+	}
+
+	// clean up the arguments, failed signatures have to be empty under
+	// NULLFAIL
+	for ; i > 1; i-- {
+		if !success && e.flags.Has(SCRIPT_VERIFY_NULLFAIL) && unusedKeys == 0 && len(e.top(1)) > 0 {
+			return opcodes.ScriptErrSigNullFail
+		}
+		if unusedKeys > 0 {
+			unusedKeys--
+		}
+		e.stack.Pop()
+	}
+
+	// the extra element popped by mistake in the original implementation,
+	// which has to be empty under NULLDUMMY
+	if e.stack.Len() < 1 {
+		return opcodes.ScriptErrStackUnderflow
+	}
+	if e.flags.Has(SCRIPT_VERIFY_NULLDUMMY) && len(e.top(1)) > 0 {
+		return opcodes.ScriptErrSigNullDummy
+	}
+	e.stack.Pop()
+
+	return e.pushResult(success, verify, opcodes.ScriptErrCheckMultisigVerify)
+}
+
+// OP_CHECKLOCKTIMEVERIFY, fails unless the transaction locktime has passed
+// the top element, which is left on the stack
+func (e *execution) checkLockTimeVerify() error {
+	if e.stack.Len() < 1 {
+		return opcodes.ScriptErrStackUnderflow
+	}
+
+	lockTime, err := opcodes.MakeScriptNum(e.top(1), e.stack.RequireMinimal, opcodes.MAX_LOCKTIME_NUM_SIZE)
+	if err != nil {
+		return err
+	}
+	if lockTime < 0 {
+		return opcodes.ScriptErrNegativeLocktime
+	}
+	if !e.checker.CheckLockTime(int64(lockTime)) {
+		return opcodes.ScriptErrUnsatisfiedLocktime
+	}
+	return nil
+}
+
+// OP_CHECKSEQUENCEVERIFY, fails unless the input sequence has passed the
+// top element, which is left on the stack
+func (e *execution) checkSequenceVerify() error {
+	if e.stack.Len() < 1 {
+		return opcodes.ScriptErrStackUnderflow
+	}
+
+	sequence, err := opcodes.MakeScriptNum(e.top(1), e.stack.RequireMinimal, opcodes.MAX_LOCKTIME_NUM_SIZE)
+	if err != nil {
+		return err
+	}
+	if sequence < 0 {
+		return opcodes.ScriptErrNegativeLocktime
+	}
+
+	// arguments with the disable flag set are a NOP for soft forks
+	if sequence&SEQUENCE_LOCKTIME_DISABLE_FLAG != 0 {
+		return nil
+	}
+	if !e.checker.CheckSequence(int64(sequence)) {
+		return opcodes.ScriptErrUnsatisfiedLocktime
+	}
+	return nil
+}
+
+// Spends the output locked by the scriptPubkey with the scriptSig and the
+// witness of the input, under the rules of the flags. The error is a
+// *ScriptError with the reason the spend failed
+func VerifyScript(scriptSig, scriptPubkey *Script, witness [][]byte, flags VerifyFlags, checker SignatureChecker) error {
+	// CLEANSTACK only makes sense once P2SH and witness scripts are run
+	if flags.Has(SCRIPT_VERIFY_CLEANSTACK) && !flags.HasAll(SCRIPT_VERIFY_P2SH|SCRIPT_VERIFY_WITNESS) {
+		return scriptError(opcodes.ScriptErrUnknown)
+	}
+
+	if flags.Has(SCRIPT_VERIFY_SIGPUSHONLY) && !scriptSig.IsPushOnly() {
+		return scriptError(opcodes.ScriptErrSigPushOnly)
+	}
+
+	stack := &opcodes.Stack{}
+	if err := EvalScript(stack, scriptSig, flags, checker, SIGVERSION_BASE); err != nil {
+		return err
+	}
+
+	// P2SH runs the redeem script on what the scriptSig left
+	var p2shStack opcodes.Stack
+	if flags.Has(SCRIPT_VERIFY_P2SH) {
+		p2shStack.Elements = append([]opcodes.StackElement{}, stack.Elements...)
+	}
+
+	if err := EvalScript(stack, scriptPubkey, flags, checker, SIGVERSION_BASE); err != nil {
+		return err
+	}
+	if stack.Len() == 0 || !opcodes.CastToBool(stack.Elements[stack.Len()-1].Bytes) {
+		return scriptError(opcodes.ScriptErrEvalFalse)
+	}
+
+	// native witness programs, which need an empty scriptSig
+	hadWitness := false
+	if flags.Has(SCRIPT_VERIFY_WITNESS) {
+		if version, program, ok := scriptPubkey.WitnessProgram(); ok {
+			hadWitness = true
+			if len(scriptSig.Commands) != 0 {
+				return scriptError(opcodes.ScriptErrWitnessMalleated)
+			}
+			if err := verifyWitnessProgram(witness, version, program, flags, checker); err != nil {
+				return err
+			}
+
+			// the witness left a single true element, the scriptPubkey
+			// left true as well
+			stack.Elements = stack.Elements[:1]
+		}
+	}
+
+	if flags.Has(SCRIPT_VERIFY_P2SH) && isPayToScriptHash(scriptPubkey) {
+		if !scriptSig.IsPushOnly() {
+			return scriptError(opcodes.ScriptErrSigPushOnly)
+		}
+
+		// the scriptPubkey checked the last element hashes to the script
+		// hash, so it can't be empty
+		stack = &p2shStack
+		serialized := stack.Pop().Bytes
+
+		commands, err := ParseCommands(serialized)
+		if err != nil {
+			return scriptError(opcodes.ScriptErrBadOpcode)
+		}
+		redeemScript := &Script{RawScript: serialized, Commands: commands}
+
+		if err := EvalScript(stack, redeemScript, flags, checker, SIGVERSION_BASE); err != nil {
+			return err
+		}
+		if stack.Len() == 0 || !opcodes.CastToBool(stack.Elements[stack.Len()-1].Bytes) {
+			return scriptError(opcodes.ScriptErrEvalFalse)
+		}
+
+		// witness programs nested in P2SH, the scriptSig has to be the push
+		// of the redeem script alone
+		if flags.Has(SCRIPT_VERIFY_WITNESS) {
+			if version, program, ok := redeemScript.WitnessProgram(); ok {
+				hadWitness = true
+				pushed, _ := (&Script{Commands: []Command{{Bytes: serialized}}}).RawSerialize()
+				if raw, _ := scriptSig.RawSerialize(); !bytes.Equal(raw, pushed) {
+					return scriptError(opcodes.ScriptErrWitnessMalleatedP2SH)
+				}
+				if err := verifyWitnessProgram(witness, version, program, flags, checker); err != nil {
+					return err
+				}
+				stack.Elements = stack.Elements[:1]
+			}
+		}
+	}
+
+	if flags.Has(SCRIPT_VERIFY_CLEANSTACK) && stack.Len() != 1 {
+		return scriptError(opcodes.ScriptErrCleanStack)
+	}
+
+	// inputs which don't spend witness programs can't carry a witness
+	if flags.Has(SCRIPT_VERIFY_WITNESS) && !hadWitness && len(witness) > 0 {
+		return scriptError(opcodes.ScriptErrWitnessUnexpected)
+	}
+
+	return nil
+}
+
+// Runs the witness against the witness program. Version 0 programs are a
+// pubkey hash (P2WPKH) or a script hash (P2WSH), later versions are left
+// for soft forks
+func verifyWitnessProgram(witness [][]byte, version int, program []byte, flags VerifyFlags, checker SignatureChecker) error {
+	if version != 0 {
+		if flags.Has(SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM) {
+			return scriptError(opcodes.ScriptErrDiscourageUpgradableWitnessProgram)
+		}
+		return nil
+	}
+
+	var witnessScript *Script
+	stack := &opcodes.Stack{}
+
+	switch len(program) {
+	case 32:
+		// the last witness item is the script, which has to hash to the
+		// program
+		if len(witness) == 0 {
+			return scriptError(opcodes.ScriptErrWitnessProgramWitnessEmpty)
+		}
+		serialized := witness[len(witness)-1]
+		hash := sha256.Sum256(serialized)
+		if !bytes.Equal(hash[:], program) {
+			return scriptError(opcodes.ScriptErrWitnessProgramMismatch)
+		}
+
+		commands, err := ParseCommands(serialized)
+		if err != nil {
+			return scriptError(opcodes.ScriptErrBadOpcode)
+		}
+		witnessScript = &Script{RawScript: serialized, Commands: commands}
+		witness = witness[:len(witness)-1]
+	case 20:
+		// a signature and public key spending the pubkey hash like P2PKH
+		if len(witness) != 2 {
+			return scriptError(opcodes.ScriptErrWitnessProgramMismatch)
+		}
+		witnessScript = MakeP2pkh(program)
+	default:
+		return scriptError(opcodes.ScriptErrWitnessProgramWrongLength)
+	}
+
+	// the items are pushed like script elements and held to the same
+	// limit, the witness script itself excepted
+	for _, item := range witness {
+		if len(item) > MAX_SCRIPT_ELEMENT_SIZE {
+			return scriptError(opcodes.ScriptErrPushSize)
+		}
+		stack.Push(item)
+	}
+
+	if err := EvalScript(stack, witnessScript, flags, checker, SIGVERSION_WITNESS_V0); err != nil {
+		return err
+	}
+
+	// witness scripts have to leave exactly one true element
+	if stack.Len() != 1 {
+		return scriptError(opcodes.ScriptErrCleanStack)
+	}
+	if !opcodes.CastToBool(stack.Elements[0].Bytes) {
+		return scriptError(opcodes.ScriptErrEvalFalse)
+	}
+	return nil
+}
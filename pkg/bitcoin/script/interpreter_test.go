@@ -0,0 +1,503 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+	S256 "github.com/ryohare/programming-bitcoin-go/pkg/ecc/curves/secp256k1"
+)
+
+// Parses the asm, failing the test if it is not valid
+func mustParseAsm(t *testing.T, asm string) *Script {
+	s, err := ParseAsm(asm)
+	if err != nil {
+		t.Fatalf("failed to parse %q because %s", asm, err.Error())
+	}
+	return s
+}
+
+// Parses the raw script, failing the test if it is not valid
+func mustParseRaw(t *testing.T, rawHex string) *Script {
+	raw, _ := hex.DecodeString(rawHex)
+	commands, err := ParseCommands(raw)
+	if err != nil {
+		t.Fatalf("failed to parse %s because %s", rawHex, err.Error())
+	}
+	return &Script{RawScript: raw, Commands: commands}
+}
+
+func TestVerifyScriptFlags(t *testing.T) {
+	checker := hashChecker{}
+
+	for _, test := range []struct {
+		scriptSig    string
+		scriptPubkey string
+		flags        VerifyFlags
+		code         opcodes.ErrorCode
+	}{
+		// extra elements are only a problem with CLEANSTACK
+		{"OP_1 OP_1", "", SCRIPT_VERIFY_P2SH, opcodes.ScriptErrOk},
+		{"OP_1 OP_1", "", SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_WITNESS | SCRIPT_VERIFY_CLEANSTACK, opcodes.ScriptErrCleanStack},
+		{"OP_1", "", SCRIPT_VERIFY_CLEANSTACK, opcodes.ScriptErrUnknown},
+		{"OP_1", "", SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_CLEANSTACK, opcodes.ScriptErrUnknown},
+
+		// upgradable nops
+		{"OP_1", "OP_NOP1", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"OP_1", "OP_NOP10", SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_NOPS, opcodes.ScriptErrDiscourageUpgradableNops},
+
+		// the scriptSig may only push data
+		{"OP_1 OP_DUP", "OP_EQUAL", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"OP_1 OP_DUP", "OP_EQUAL", SCRIPT_VERIFY_SIGPUSHONLY, opcodes.ScriptErrSigPushOnly},
+
+		// the dummy element of OP_CHECKMULTISIG
+		{"OP_0", "OP_0 OP_0 OP_CHECKMULTISIG", SCRIPT_VERIFY_NULLDUMMY, opcodes.ScriptErrOk},
+		{"OP_1", "OP_0 OP_0 OP_CHECKMULTISIG", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"OP_1", "OP_0 OP_0 OP_CHECKMULTISIG", SCRIPT_VERIFY_NULLDUMMY, opcodes.ScriptErrSigNullDummy},
+
+		// timelocks are nops without their flags
+		{"OP_1", "OP_CHECKLOCKTIMEVERIFY", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"OP_1", "OP_CHECKLOCKTIMEVERIFY", SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY, opcodes.ScriptErrUnsatisfiedLocktime},
+		{"OP_1", "OP_CHECKSEQUENCEVERIFY", SCRIPT_VERIFY_CHECKSEQUENCEVERIFY, opcodes.ScriptErrUnsatisfiedLocktime},
+
+		// P2SH runs the redeem script OP_1 only with the flag
+		{"51", "OP_HASH160 da1745e9b549bd0bfa1a569971c77eba30cd5a4b OP_EQUAL", SCRIPT_VERIFY_P2SH, opcodes.ScriptErrOk},
+		{"00", "OP_HASH160 9f7fd096d37ed2c0e3f7f0cfc924beef4ffceb68 OP_EQUAL", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"00", "OP_HASH160 9f7fd096d37ed2c0e3f7f0cfc924beef4ffceb68 OP_EQUAL", SCRIPT_VERIFY_P2SH, opcodes.ScriptErrEvalFalse},
+	} {
+		err := VerifyScript(mustParseAsm(t, test.scriptSig), mustParseAsm(t, test.scriptPubkey), nil, test.flags, checker)
+		if code := ErrorCode(err); code != test.code {
+			t.Errorf("expected %q %q under %s to give %s, got %s", test.scriptSig, test.scriptPubkey, test.flags, test.code.Name(), code.Name())
+		}
+	}
+}
+
+func TestScriptError(t *testing.T) {
+	err := VerifyScript(mustParseAsm(t, "OP_1"), mustParseAsm(t, "OP_DUP OP_EQUALVERIFY OP_1 OP_ADD"), nil, SCRIPT_VERIFY_NONE, hashChecker{})
+	if !errors.Is(err, opcodes.ScriptErrStackUnderflow) {
+		t.Fatalf("expected a stack underflow, got %v", err)
+	}
+
+	// the index and opcode of the command which failed
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.Index != 3 || scriptErr.Op != opcodes.OP_ADD {
+		t.Fatalf("expected the failure at OP_ADD, got %v", err)
+	}
+	if err.Error() != "script failed at OP_ADD (command 3) because operation not valid with the current stack size" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+
+	// failures of the script as a whole have no command
+	err = VerifyScript(mustParseAsm(t, "OP_0"), mustParseAsm(t, ""), nil, SCRIPT_VERIFY_NONE, hashChecker{})
+	if !errors.As(err, &scriptErr) || scriptErr.Index != -1 || scriptErr.Code != opcodes.ScriptErrEvalFalse {
+		t.Fatalf("expected EVAL_FALSE, got %v", err)
+	}
+
+	if ErrorCode(nil) != opcodes.ScriptErrOk || ErrorCode(errors.New("other")) != opcodes.ScriptErrUnknown {
+		t.Fatal("failed to get the code of the error")
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	for _, test := range []struct {
+		script string
+		result string
+		code   opcodes.ErrorCode
+	}{
+		{"1 IF 2 ELSE 3 ENDIF", "02", opcodes.ScriptErrOk},
+		{"0 IF 2 ELSE 3 ENDIF", "03", opcodes.ScriptErrOk},
+		{"0 NOTIF 2 ELSE 3 ENDIF", "02", opcodes.ScriptErrOk},
+
+		// each OP_ELSE flips the branch being run
+		{"1 IF 2 ELSE 3 ELSE 4 ENDIF", "0204", opcodes.ScriptErrOk},
+		{"0 IF 2 ELSE 3 ELSE 4 ENDIF", "03", opcodes.ScriptErrOk},
+
+		// nested branches are only run when every branch around them is
+		{"1 0 IF IF 2 ELSE 3 ENDIF ELSE 4 ENDIF", "0104", opcodes.ScriptErrOk},
+		{"1 1 IF IF 2 ELSE 3 ENDIF ELSE 4 ENDIF", "02", opcodes.ScriptErrOk},
+		{"0 1 IF IF 2 ELSE 3 ENDIF ELSE 4 ENDIF", "03", opcodes.ScriptErrOk},
+
+		// commands in branches which aren't run can't fail
+		{"0 IF RETURN ADD 0xba ENDIF 1", "01", opcodes.ScriptErrOk},
+		{"1 IF 0xba ENDIF 1", "", opcodes.ScriptErrBadOpcode},
+		{"0 IF VERIF ENDIF 1", "", opcodes.ScriptErrBadOpcode},
+		{"0 IF CAT ENDIF 1", "", opcodes.ScriptErrDisabledOpcode},
+		{"1 IF 0 IF 2MUL ENDIF ENDIF 1", "", opcodes.ScriptErrDisabledOpcode},
+
+		// unbalanced branches
+		{"IF 1 ENDIF", "", opcodes.ScriptErrUnbalancedConditional},
+		{"1 IF 1", "", opcodes.ScriptErrUnbalancedConditional},
+		{"1 ELSE 1", "", opcodes.ScriptErrUnbalancedConditional},
+		{"1 ENDIF", "", opcodes.ScriptErrUnbalancedConditional},
+		{"0 IF ENDIF ENDIF", "", opcodes.ScriptErrUnbalancedConditional},
+	} {
+		s, err := ParseCoreAsm(test.script)
+		if err != nil {
+			t.Fatalf("failed to parse %q because %s", test.script, err.Error())
+		}
+		stack := &opcodes.Stack{}
+		err = EvalScript(stack, s, SCRIPT_VERIFY_NONE, hashChecker{}, SIGVERSION_BASE)
+		if code := ErrorCode(err); code != test.code {
+			t.Fatalf("expected %s from %q, got %v", test.code.Name(), test.script, err)
+		}
+		if err != nil {
+			continue
+		}
+		var result []byte
+		for _, el := range stack.Elements {
+			result = append(result, el.Bytes...)
+		}
+		if hex.EncodeToString(result) != test.result {
+			t.Fatalf("expected %s from %q, got %x", test.result, test.script, result)
+		}
+	}
+
+	// disabled opcodes fail even in branches which aren't taken
+	for _, op := range []uint32{
+		opcodes.OP_CAT, opcodes.OP_SUBSTR, opcodes.OP_LEFT, opcodes.OP_RIGHT,
+		opcodes.OP_INVERT, opcodes.OP_AND, opcodes.OP_OR, opcodes.OP_XOR,
+		opcodes.OP_2MUL, opcodes.OP_2DIV, opcodes.OP_MUL, opcodes.OP_DIV,
+		opcodes.OP_MOD, opcodes.OP_LSHIFT, opcodes.OP_RSHIFT,
+	} {
+		script := mustParseAsm(t, "OP_0 OP_IF "+opcodes.Name(op)+" OP_ENDIF OP_1")
+		err := EvalScript(&opcodes.Stack{}, script, SCRIPT_VERIFY_NONE, hashChecker{}, SIGVERSION_BASE)
+		if !errors.Is(err, opcodes.ScriptErrDisabledOpcode) {
+			t.Errorf("expected %s to be disabled, got %v", opcodes.Name(op), err)
+		}
+	}
+}
+
+// Signs z with the secret, returning the signature with SIGHASH_ALL and the
+// compressed public key
+func mustSign(t *testing.T, secret int64, z *big.Int) (string, string) {
+	key, err := S256.MakePrivateKeyFromBigInt(big.NewInt(secret))
+	if err != nil {
+		t.Fatalf("failed to make the private key because %s", err.Error())
+	}
+	sig, err := key.Sign(z)
+	if err != nil {
+		t.Fatalf("failed to sign because %s", err.Error())
+	}
+	return hex.EncodeToString(append(sig.Der(), SIGHASH_ALL)), hex.EncodeToString(key.Point.Sec(true))
+}
+
+func TestAltStackHtlc(t *testing.T) {
+	z := big.NewInt(0x5eed)
+	sigA, pubA := mustSign(t, 1001, z)
+	sigB, pubB := mustSign(t, 1002, z)
+	preimage := []byte("htlc preimage")
+	hash := sha256.Sum256(preimage)
+
+	// both keys are set aside on the alt stack, each branch takes its own
+	// back for the OP_CHECKSIG they share. A pays with the preimage, B gets
+	// a refund from block 100 (0x64) on
+	scriptPubkey := mustParseAsm(t, pubA+" OP_TOALTSTACK "+pubB+" OP_TOALTSTACK"+
+		" OP_IF OP_SHA256 "+hex.EncodeToString(hash[:])+" OP_EQUALVERIFY OP_FROMALTSTACK OP_DROP OP_FROMALTSTACK"+
+		" OP_ELSE 64 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_FROMALTSTACK OP_FROMALTSTACK OP_DROP"+
+		" OP_ENDIF OP_CHECKSIG")
+	unlocked := hashChecker{LockTimeChecker: LockTimeChecker{LockTime: 100, Sequence: 0xfffffffe}, z: z}
+	locked := hashChecker{LockTimeChecker: LockTimeChecker{LockTime: 99, Sequence: 0xfffffffe}, z: z}
+
+	for _, test := range []struct {
+		scriptSig string
+		checker   hashChecker
+		code      opcodes.ErrorCode
+	}{
+		{sigA + " " + hex.EncodeToString(preimage) + " OP_1", locked, opcodes.ScriptErrOk},
+		{sigA + " 00 OP_1", locked, opcodes.ScriptErrEqualVerify},
+		{sigB + " " + hex.EncodeToString(preimage) + " OP_1", locked, opcodes.ScriptErrEvalFalse},
+		{sigB + " OP_0", unlocked, opcodes.ScriptErrOk},
+		{sigB + " OP_0", locked, opcodes.ScriptErrUnsatisfiedLocktime},
+		{sigA + " OP_0", unlocked, opcodes.ScriptErrEvalFalse},
+	} {
+		err := VerifyScript(mustParseAsm(t, test.scriptSig), scriptPubkey, nil, SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY, test.checker)
+		if code := ErrorCode(err); code != test.code {
+			t.Errorf("expected %s spending with %q, got %v", test.code.Name(), test.scriptSig, err)
+		}
+	}
+}
+
+func TestStackSizeLimit(t *testing.T) {
+	for _, test := range []struct {
+		script string
+		code   opcodes.ErrorCode
+		index  int
+	}{
+		{strings.Repeat("OP_1 ", MAX_STACK_SIZE), opcodes.ScriptErrOk, 0},
+		{strings.Repeat("OP_1 ", MAX_STACK_SIZE+1), opcodes.ScriptErrStackSize, MAX_STACK_SIZE},
+
+		// elements on the alt stack count towards the limit
+		{strings.Repeat("OP_1 ", MAX_STACK_SIZE-1) + "OP_TOALTSTACK OP_1 OP_1", opcodes.ScriptErrStackSize, MAX_STACK_SIZE + 1},
+	} {
+		err := EvalScript(&opcodes.Stack{}, mustParseAsm(t, test.script), SCRIPT_VERIFY_NONE, hashChecker{}, SIGVERSION_BASE)
+		if code := ErrorCode(err); code != test.code {
+			t.Fatalf("expected %s, got %v", test.code.Name(), err)
+		}
+		var scriptErr *ScriptError
+		if err != nil && (!errors.As(err, &scriptErr) || scriptErr.Index != test.index) {
+			t.Fatalf("expected the failure at command %d, got %v", test.index, err)
+		}
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	maxPush := strings.Repeat("42", MAX_SCRIPT_ELEMENT_SIZE)
+	nops := func(n int) string {
+		return strings.Repeat(" OP_NOP", n)
+	}
+	// a multisig without signatures over 20 public keys, which count as
+	// opcodes as well
+	multisig := " OP_0 OP_0" + strings.Repeat(" OP_1", 20) + " 14 OP_CHECKMULTISIG"
+
+	for _, test := range []struct {
+		name   string
+		script string
+		code   opcodes.ErrorCode
+	}{
+		{"520 byte push", maxPush, opcodes.ScriptErrOk},
+		{"521 byte push", maxPush + "42", opcodes.ScriptErrPushSize},
+		{"521 byte push not run", "OP_0 OP_IF " + maxPush + "42 OP_ENDIF OP_1", opcodes.ScriptErrPushSize},
+
+		// 19 pushes of 520 bytes take 9937 bytes with their PUSHDATA2
+		{"10000 byte script", strings.Repeat(maxPush+" ", 19) + strings.Repeat("42", 62), opcodes.ScriptErrOk},
+		{"10001 byte script", strings.Repeat(maxPush+" ", 19) + strings.Repeat("42", 63), opcodes.ScriptErrScriptSize},
+
+		{"201 opcodes", "OP_1" + nops(201), opcodes.ScriptErrOk},
+		{"202 opcodes", "OP_1" + nops(202), opcodes.ScriptErrOpCount},
+		{"opcodes not run", "OP_0 OP_IF" + nops(200) + " OP_ENDIF OP_1", opcodes.ScriptErrOpCount},
+		{"pushes", strings.Repeat("OP_1 ", 300), opcodes.ScriptErrOk},
+		{"multisig keys", nops(180) + multisig, opcodes.ScriptErrOk},
+		{"too many multisig keys", nops(181) + multisig, opcodes.ScriptErrOpCount},
+
+		{"OP_CAT", "OP_1 OP_1 OP_CAT", opcodes.ScriptErrDisabledOpcode},
+	} {
+		err := EvalScript(&opcodes.Stack{}, mustParseAsm(t, test.script), SCRIPT_VERIFY_NONE, hashChecker{}, SIGVERSION_BASE)
+		if code := ErrorCode(err); code != test.code {
+			t.Errorf("expected %s from the %s, got %v", test.code.Name(), test.name, err)
+		}
+	}
+
+	// scripts too big to run fail before their first command in the debugger
+	d := mustParseAsm(t, strings.Repeat(maxPush+" ", 20)).Debug(big.NewInt(0), 0, 0, 1)
+	if !d.Done() || !errors.Is(d.Run(), opcodes.ScriptErrScriptSize) {
+		t.Fatal("expected the debugger to fail the script for its size")
+	}
+}
+
+func TestWitnessItemSize(t *testing.T) {
+	flags := SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_WITNESS
+	p2wsh := func(witnessScript []byte) *Script {
+		program := sha256.Sum256(witnessScript)
+		return mustParseAsm(t, "OP_0 "+hex.EncodeToString(program[:]))
+	}
+
+	// OP_DROP OP_1 drops the item pushed by the witness
+	dropScript := []byte{0x75, 0x51}
+	for _, test := range []struct {
+		size int
+		code opcodes.ErrorCode
+	}{
+		{MAX_SCRIPT_ELEMENT_SIZE, opcodes.ScriptErrOk},
+		{MAX_SCRIPT_ELEMENT_SIZE + 1, opcodes.ScriptErrPushSize},
+	} {
+		witness := [][]byte{make([]byte, test.size), dropScript}
+		err := VerifyScript(mustParseAsm(t, ""), p2wsh(dropScript), witness, flags, hashChecker{})
+		if code := ErrorCode(err); code != test.code {
+			t.Errorf("expected %s for a witness item of %d bytes, got %v", test.code.Name(), test.size, err)
+		}
+	}
+
+	// the witness script itself may be longer
+	longScript := append(append([]byte{0x4d, 0x08, 0x02}, make([]byte, MAX_SCRIPT_ELEMENT_SIZE)...), dropScript...)
+	if err := VerifyScript(mustParseAsm(t, ""), p2wsh(longScript), [][]byte{longScript}, flags, hashChecker{}); err != nil {
+		t.Fatalf("failed to verify the long witness script because %s", err.Error())
+	}
+
+	// so does P2WPKH, before checking the signature
+	p2wpkh := mustParseAsm(t, "OP_0 "+strings.Repeat("11", 20))
+	witness := [][]byte{make([]byte, MAX_SCRIPT_ELEMENT_SIZE+1), make([]byte, 33)}
+	if err := VerifyScript(mustParseAsm(t, ""), p2wpkh, witness, flags, hashChecker{}); !errors.Is(err, opcodes.ScriptErrPushSize) {
+		t.Fatalf("expected PUSH_SIZE for the P2WPKH signature, got %v", err)
+	}
+}
+
+func TestVerifyScriptMinimalData(t *testing.T) {
+	// OP_PUSHDATA1 pushing a single byte
+	scriptSig := mustParseRaw(t, "4c0101")
+	scriptPubkey := mustParseAsm(t, "01 OP_EQUAL")
+
+	if err := VerifyScript(scriptSig, scriptPubkey, nil, SCRIPT_VERIFY_NONE, hashChecker{}); err != nil {
+		t.Fatalf("failed to verify the non minimal push because %s", err.Error())
+	}
+	if err := VerifyScript(scriptSig, scriptPubkey, nil, SCRIPT_VERIFY_MINIMALDATA, hashChecker{}); !errors.Is(err, opcodes.ScriptErrMinimalData) {
+		t.Fatalf("expected MINIMALDATA for the non minimal push, got %v", err)
+	}
+
+	// 0x01 has to be pushed with OP_1
+	if err := VerifyScript(mustParseAsm(t, "01"), mustParseAsm(t, "OP_1 OP_EQUAL"), nil, SCRIPT_VERIFY_MINIMALDATA, hashChecker{}); !errors.Is(err, opcodes.ScriptErrMinimalData) {
+		t.Fatalf("expected MINIMALDATA for a number pushed as data, got %v", err)
+	}
+}
+
+func TestVerifyScriptSignatureEncoding(t *testing.T) {
+	z, _ := new(big.Int).SetString("7c076ff316692a3d7eb3c3bb0f8b1488cf72e1afcd929e29307032997a838a3d", 16)
+	checker := hashChecker{z: z}
+
+	// strict DER, but with a high S value
+	sig := "3045022000eff69ef2b1bd93a66ed5219add4fb51e11a840f404876325a1e8ffe0529a2c022100c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab6"
+	sec := "04887387e452b8eacc4acfde10d9aaf7f6d9a0f975aabb10d006e4da568744d06c61de6d95231cd89026e286df3b6ae4a894a3378e393e93a0f45b666329a0ae34"
+	scriptPubkey := mustParseAsm(t, sec+" OP_CHECKSIG")
+
+	for _, test := range []struct {
+		sig   string
+		flags VerifyFlags
+		code  opcodes.ErrorCode
+	}{
+		{sig + "01", SCRIPT_VERIFY_DERSIG | SCRIPT_VERIFY_STRICTENC, opcodes.ScriptErrOk},
+		{sig + "01", SCRIPT_VERIFY_LOW_S, opcodes.ScriptErrSigHighS},
+
+		// undefined hash types are only rejected by STRICTENC
+		{sig + "05", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{sig + "05", SCRIPT_VERIFY_STRICTENC, opcodes.ScriptErrSigHashType},
+
+		// trailing garbage after the DER signature
+		{sig[:2] + "46" + sig[4:] + "0001", SCRIPT_VERIFY_DERSIG, opcodes.ScriptErrSigDER},
+
+		// consensus only needs lax DER: bytes before the hash type, long
+		// form lengths and padded values
+		{sig[:2] + "46" + sig[4:] + "0001", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"308145" + sig[4:] + "01", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"308145" + sig[4:] + "01", SCRIPT_VERIFY_DERSIG, opcodes.ScriptErrSigDER},
+		{"3046022100" + sig[8:] + "01", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"3046022100" + sig[8:] + "01", SCRIPT_VERIFY_DERSIG, opcodes.ScriptErrSigDER},
+	} {
+		err := VerifyScript(mustParseAsm(t, test.sig), scriptPubkey, nil, test.flags, checker)
+		if code := ErrorCode(err); code != test.code {
+			t.Errorf("expected %s under %s to give %s, got %s", test.sig, test.flags, test.code.Name(), code.Name())
+		}
+	}
+
+	// hybrid keys, 0x06 as y is even, are only rejected by STRICTENC
+	for _, test := range []struct {
+		sec   string
+		flags VerifyFlags
+		code  opcodes.ErrorCode
+	}{
+		{"06" + sec[2:], SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"06" + sec[2:], SCRIPT_VERIFY_STRICTENC, opcodes.ScriptErrPubkeyType},
+		{"07" + sec[2:], SCRIPT_VERIFY_NONE, opcodes.ScriptErrEvalFalse},
+	} {
+		err := VerifyScript(mustParseAsm(t, sig+"01"), mustParseAsm(t, test.sec+" OP_CHECKSIG"), nil, test.flags, checker)
+		if code := ErrorCode(err); code != test.code {
+			t.Errorf("expected key %s under %s to give %s, got %s", test.sec[:2], test.flags, test.code.Name(), code.Name())
+		}
+	}
+
+	// NULLFAIL only allows a failing signature to be empty
+	notChecksig := mustParseAsm(t, sec+" OP_CHECKSIG OP_NOT")
+	if err := VerifyScript(mustParseAsm(t, "<>"), notChecksig, nil, SCRIPT_VERIFY_NULLFAIL, checker); err != nil {
+		t.Fatalf("failed to verify the empty signature because %s", err.Error())
+	}
+	bad := hashChecker{z: big.NewInt(1)}
+	if err := VerifyScript(mustParseAsm(t, sig+"01"), notChecksig, nil, SCRIPT_VERIFY_NONE, bad); err != nil {
+		t.Fatalf("failed to verify the failing signature because %s", err.Error())
+	}
+	if err := VerifyScript(mustParseAsm(t, sig+"01"), notChecksig, nil, SCRIPT_VERIFY_NULLFAIL, bad); !errors.Is(err, opcodes.ScriptErrSigNullFail) {
+		t.Fatalf("expected NULLFAIL for the failing signature, got %v", err)
+	}
+}
+
+func TestVerifyScriptWitness(t *testing.T) {
+	// OP_1 as the witness script of a P2WSH output
+	witnessScript := []byte{0x51}
+	scriptPubkey := mustParseAsm(t, "OP_0 4ae81572f06e1b88fd5ced7a1a000945432e83e1551e6f721ee9c00b8cc33260")
+	flags := SCRIPT_VERIFY_P2SH | SCRIPT_VERIFY_WITNESS | SCRIPT_VERIFY_CLEANSTACK
+
+	if err := VerifyScript(mustParseAsm(t, ""), scriptPubkey, [][]byte{witnessScript}, flags, hashChecker{}); err != nil {
+		t.Fatalf("failed to verify the witness script because %s", err.Error())
+	}
+
+	// the witness script has to hash to the program
+	if err := VerifyScript(mustParseAsm(t, ""), scriptPubkey, [][]byte{{0x52}}, flags, hashChecker{}); !errors.Is(err, opcodes.ScriptErrWitnessProgramMismatch) {
+		t.Fatalf("expected WITNESS_PROGRAM_MISMATCH for the wrong witness script, got %v", err)
+	}
+
+	// witness programs need an empty scriptSig
+	if err := VerifyScript(mustParseAsm(t, "OP_1"), scriptPubkey, [][]byte{witnessScript}, flags, hashChecker{}); !errors.Is(err, opcodes.ScriptErrWitnessMalleated) {
+		t.Fatalf("expected WITNESS_MALLEATED for a witness program with a scriptSig, got %v", err)
+	}
+
+	// and other outputs can't carry a witness
+	if err := VerifyScript(mustParseAsm(t, "OP_1"), mustParseAsm(t, ""), [][]byte{witnessScript}, flags, hashChecker{}); !errors.Is(err, opcodes.ScriptErrWitnessUnexpected) {
+		t.Fatalf("expected WITNESS_UNEXPECTED, got %v", err)
+	}
+
+	// later versions are left for soft forks
+	future := mustParseAsm(t, "OP_1 4ae81572f06e1b88fd5ced7a1a000945432e83e1551e6f721ee9c00b8cc33260")
+	if err := VerifyScript(mustParseAsm(t, ""), future, nil, flags, hashChecker{}); err != nil {
+		t.Fatalf("failed to verify the future witness version because %s", err.Error())
+	}
+	if err := VerifyScript(mustParseAsm(t, ""), future, nil, flags|SCRIPT_VERIFY_DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM, hashChecker{}); !errors.Is(err, opcodes.ScriptErrDiscourageUpgradableWitnessProgram) {
+		t.Fatalf("expected DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM, got %v", err)
+	}
+}
+
+func TestLockTimeChecker(t *testing.T) {
+	checker := LockTimeChecker{LockTime: 100, Sequence: 0xfffffffe, Version: 2}
+
+	scriptSig := mustParseAsm(t, "")
+	for _, test := range []struct {
+		scriptPubkey string
+		valid        bool
+	}{
+		{"64 OP_CHECKLOCKTIMEVERIFY", true},
+		{"65 OP_CHECKLOCKTIMEVERIFY", false},
+
+		// times can't be compared with heights
+		{"0065cd1d OP_CHECKLOCKTIMEVERIFY", false},
+
+		// negative locktimes always fail
+		{"OP_1NEGATE OP_CHECKLOCKTIMEVERIFY", false},
+
+		// the sequence disables relative locktimes
+		{"OP_1 OP_CHECKSEQUENCEVERIFY", false},
+	} {
+		err := VerifyScript(scriptSig, mustParseAsm(t, test.scriptPubkey), nil, SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY|SCRIPT_VERIFY_CHECKSEQUENCEVERIFY, hashChecker{LockTimeChecker: checker})
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("expected %q to be %v", test.scriptPubkey, test.valid)
+		}
+	}
+
+	// locktimes are numbers of up to 5 bytes, to use all 32 bits
+	far := hashChecker{LockTimeChecker: LockTimeChecker{LockTime: 0x80000000, Sequence: 0xfffffffe}}
+	for _, test := range []struct {
+		scriptPubkey string
+		flags        VerifyFlags
+		code         opcodes.ErrorCode
+	}{
+		{"0000008000 OP_CHECKLOCKTIMEVERIFY", SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY, opcodes.ScriptErrOk},
+		{"000000800000 OP_CHECKLOCKTIMEVERIFY", SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY, opcodes.ScriptErrNumOverflow},
+		{"000000800000 OP_CHECKLOCKTIMEVERIFY", SCRIPT_VERIFY_NONE, opcodes.ScriptErrOk},
+		{"0065cd1d00 OP_CHECKLOCKTIMEVERIFY", SCRIPT_VERIFY_CHECKLOCKTIMEVERIFY | SCRIPT_VERIFY_MINIMALDATA, opcodes.ScriptErrNumMinimal},
+	} {
+		err := VerifyScript(scriptSig, mustParseAsm(t, test.scriptPubkey), nil, test.flags, far)
+		if code := ErrorCode(err); code != test.code {
+			t.Errorf("expected %s from %q, got %v", test.code.Name(), test.scriptPubkey, err)
+		}
+	}
+
+	relative := LockTimeChecker{Sequence: 10, Version: 2}
+	if !relative.CheckSequence(10) || relative.CheckSequence(11) {
+		t.Fatal("failed to compare the relative locktime")
+	}
+	if (LockTimeChecker{Sequence: 10, Version: 1}).CheckSequence(10) {
+		t.Fatal("relative locktimes need version 2")
+	}
+	if (LockTimeChecker{LockTime: 100, Sequence: SEQUENCE_FINAL}).CheckLockTime(100) {
+		t.Fatal("a final input disables the locktime")
+	}
+}
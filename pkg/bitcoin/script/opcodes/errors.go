@@ -0,0 +1,145 @@
+package opcodes
+
+// Reason a script failed, following Bitcoin Core's ScriptError_t. Codes are
+// errors themselves so they can be returned by the stack operations and
+// matched with errors.Is once wrapped
+type ErrorCode int
+
+const (
+	ScriptErrOk ErrorCode = iota
+	ScriptErrUnknown
+	ScriptErrEvalFalse
+	ScriptErrOpReturn
+
+	// resource limits
+	ScriptErrScriptSize
+	ScriptErrPushSize
+	ScriptErrOpCount
+	ScriptErrStackSize
+	ScriptErrSigCount
+	ScriptErrPubkeyCount
+
+	// failed verify operations
+	ScriptErrVerify
+	ScriptErrEqualVerify
+	ScriptErrCheckMultisigVerify
+	ScriptErrCheckSigVerify
+	ScriptErrNumEqualVerify
+
+	// logical and stack errors
+	ScriptErrBadOpcode
+	ScriptErrDisabledOpcode
+	ScriptErrStackUnderflow
+	ScriptErrAltStackUnderflow
+	ScriptErrUnbalancedConditional
+	ScriptErrNumOverflow
+	ScriptErrNumMinimal
+
+	// timelocks
+	ScriptErrNegativeLocktime
+	ScriptErrUnsatisfiedLocktime
+
+	// malleability and policy
+	ScriptErrSigHashType
+	ScriptErrSigDER
+	ScriptErrMinimalData
+	ScriptErrSigPushOnly
+	ScriptErrSigHighS
+	ScriptErrSigNullDummy
+	ScriptErrPubkeyType
+	ScriptErrCleanStack
+	ScriptErrMinimalIf
+	ScriptErrSigNullFail
+
+	// soft fork safeness
+	ScriptErrDiscourageUpgradableNops
+	ScriptErrDiscourageUpgradableWitnessProgram
+
+	// segregated witness
+	ScriptErrWitnessProgramWrongLength
+	ScriptErrWitnessProgramWitnessEmpty
+	ScriptErrWitnessProgramMismatch
+	ScriptErrWitnessMalleated
+	ScriptErrWitnessMalleatedP2SH
+	ScriptErrWitnessUnexpected
+	ScriptErrWitnessPubkeyType
+
+	// constant script code
+	ScriptErrOpCodeSeparator
+	ScriptErrSigFindAndDelete
+)
+
+// Name and description of each code. The names are the ones Bitcoin Core
+// uses in its test vectors
+var errorCodes = map[ErrorCode]struct{ name, description string }{
+	ScriptErrOk:                                 {"OK", "no error"},
+	ScriptErrUnknown:                            {"UNKNOWN_ERROR", "unknown error"},
+	ScriptErrEvalFalse:                          {"EVAL_FALSE", "script evaluated without error but finished with a false or empty top stack element"},
+	ScriptErrOpReturn:                           {"OP_RETURN", "OP_RETURN was encountered"},
+	ScriptErrScriptSize:                         {"SCRIPT_SIZE", "script is too big"},
+	ScriptErrPushSize:                           {"PUSH_SIZE", "push value size limit exceeded"},
+	ScriptErrOpCount:                            {"OP_COUNT", "operation limit exceeded"},
+	ScriptErrStackSize:                          {"STACK_SIZE", "stack size limit exceeded"},
+	ScriptErrSigCount:                           {"SIG_COUNT", "signature count negative or greater than the public key count"},
+	ScriptErrPubkeyCount:                        {"PUBKEY_COUNT", "public key count negative or limit exceeded"},
+	ScriptErrVerify:                             {"VERIFY", "script failed an OP_VERIFY operation"},
+	ScriptErrEqualVerify:                        {"EQUALVERIFY", "script failed an OP_EQUALVERIFY operation"},
+	ScriptErrCheckMultisigVerify:                {"CHECKMULTISIGVERIFY", "script failed an OP_CHECKMULTISIGVERIFY operation"},
+	ScriptErrCheckSigVerify:                     {"CHECKSIGVERIFY", "script failed an OP_CHECKSIGVERIFY operation"},
+	ScriptErrNumEqualVerify:                     {"NUMEQUALVERIFY", "script failed an OP_NUMEQUALVERIFY operation"},
+	ScriptErrBadOpcode:                          {"BAD_OPCODE", "opcode missing or not understood"},
+	ScriptErrDisabledOpcode:                     {"DISABLED_OPCODE", "attempted to use a disabled opcode"},
+	ScriptErrStackUnderflow:                     {"INVALID_STACK_OPERATION", "operation not valid with the current stack size"},
+	ScriptErrAltStackUnderflow:                  {"INVALID_ALTSTACK_OPERATION", "operation not valid with the current altstack size"},
+	ScriptErrUnbalancedConditional:              {"UNBALANCED_CONDITIONAL", "invalid OP_IF construction"},
+	ScriptErrNumOverflow:                        {"NUM_OVERFLOW", "number is too long"},
+	ScriptErrNumMinimal:                         {"NUM_MINIMAL", "number is not minimally encoded"},
+	ScriptErrNegativeLocktime:                   {"NEGATIVE_LOCKTIME", "negative locktime"},
+	ScriptErrUnsatisfiedLocktime:                {"UNSATISFIED_LOCKTIME", "locktime requirement not satisfied"},
+	ScriptErrSigHashType:                        {"SIG_HASHTYPE", "signature hash type missing or not understood"},
+	ScriptErrSigDER:                             {"SIG_DER", "non-canonical DER signature"},
+	ScriptErrMinimalData:                        {"MINIMALDATA", "data push larger than necessary"},
+	ScriptErrSigPushOnly:                        {"SIG_PUSHONLY", "only push operators allowed in signatures"},
+	ScriptErrSigHighS:                           {"SIG_HIGH_S", "non-canonical signature, S value is unnecessarily high"},
+	ScriptErrSigNullDummy:                       {"SIG_NULLDUMMY", "dummy OP_CHECKMULTISIG argument must be zero"},
+	ScriptErrPubkeyType:                         {"PUBKEYTYPE", "public key is neither compressed or uncompressed"},
+	ScriptErrCleanStack:                         {"CLEANSTACK", "stack size must be exactly one after execution"},
+	ScriptErrMinimalIf:                          {"MINIMALIF", "OP_IF and OP_NOTIF argument must be minimal"},
+	ScriptErrSigNullFail:                        {"NULLFAIL", "signature must be zero for failed CHECK(MULTI)SIG operation"},
+	ScriptErrDiscourageUpgradableNops:           {"DISCOURAGE_UPGRADABLE_NOPS", "NOPx reserved for soft fork upgrades"},
+	ScriptErrDiscourageUpgradableWitnessProgram: {"DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM", "witness version reserved for soft fork upgrades"},
+	ScriptErrWitnessProgramWrongLength:          {"WITNESS_PROGRAM_WRONG_LENGTH", "witness program has incorrect length"},
+	ScriptErrWitnessProgramWitnessEmpty:         {"WITNESS_PROGRAM_WITNESS_EMPTY", "witness program was passed an empty witness"},
+	ScriptErrWitnessProgramMismatch:             {"WITNESS_PROGRAM_MISMATCH", "witness program hash mismatch"},
+	ScriptErrWitnessMalleated:                   {"WITNESS_MALLEATED", "witness requires empty scriptSig"},
+	ScriptErrWitnessMalleatedP2SH:               {"WITNESS_MALLEATED_P2SH", "witness requires only-redeemscript scriptSig"},
+	ScriptErrWitnessUnexpected:                  {"WITNESS_UNEXPECTED", "witness provided for non-witness script"},
+	ScriptErrWitnessPubkeyType:                  {"WITNESS_PUBKEYTYPE", "using non-compressed keys in segwit"},
+	ScriptErrOpCodeSeparator:                    {"OP_CODESEPARATOR", "using OP_CODESEPARATOR in non-witness script"},
+	ScriptErrSigFindAndDelete:                   {"SIG_FINDANDDELETE", "signature is found in scriptCode"},
+}
+
+func (c ErrorCode) Error() string {
+	if e, ok := errorCodes[c]; ok {
+		return e.description
+	}
+	return errorCodes[ScriptErrUnknown].description
+}
+
+// Returns the name Bitcoin Core gives the code, like EVAL_FALSE
+func (c ErrorCode) Name() string {
+	if e, ok := errorCodes[c]; ok {
+		return e.name
+	}
+	return errorCodes[ScriptErrUnknown].name
+}
+
+// Returns the code with the Bitcoin Core name
+func LookupErrorCode(name string) (ErrorCode, bool) {
+	for c, e := range errorCodes {
+		if e.name == name {
+			return c, true
+		}
+	}
+	return ScriptErrUnknown, false
+}
@@ -1,11 +1,18 @@
 package opcodes
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 const (
 	OP_0                   uint32 = 0
 	OP_PUSHDATA1           uint32 = 76
 	OP_PUSHDATA2           uint32 = 77
 	OP_PUSHDATA4           uint32 = 78
 	OP_1NEGATE             uint32 = 79
+	OP_RESERVED            uint32 = 80
 	OP_1                   uint32 = 81
 	OP_2                   uint32 = 82
 	OP_3                   uint32 = 83
@@ -23,8 +30,11 @@ const (
 	OP_15                  uint32 = 95
 	OP_16                  uint32 = 96
 	OP_NOP                 uint32 = 97
+	OP_VER                 uint32 = 98
 	OP_IF                  uint32 = 99
 	OP_NOTIF               uint32 = 100
+	OP_VERIF               uint32 = 101
+	OP_VERNOTIF            uint32 = 102
 	OP_ELSE                uint32 = 103
 	OP_ENDIF               uint32 = 104
 	OP_VERIFY              uint32 = 105
@@ -48,17 +58,34 @@ const (
 	OP_ROT                 uint32 = 123
 	OP_SWAP                uint32 = 124
 	OP_TUCK                uint32 = 125
+	OP_CAT                 uint32 = 126
+	OP_SUBSTR              uint32 = 127
+	OP_LEFT                uint32 = 128
+	OP_RIGHT               uint32 = 129
 	OP_SIZE                uint32 = 130
+	OP_INVERT              uint32 = 131
+	OP_AND                 uint32 = 132
+	OP_OR                  uint32 = 133
+	OP_XOR                 uint32 = 134
 	OP_EQUAL               uint32 = 135
 	OP_EQUALVERIFY         uint32 = 136
+	OP_RESERVED1           uint32 = 137
+	OP_RESERVED2           uint32 = 138
 	OP_1ADD                uint32 = 139
 	OP_1SUB                uint32 = 140
+	OP_2MUL                uint32 = 141
+	OP_2DIV                uint32 = 142
 	OP_NEGATE              uint32 = 143
 	OP_ABS                 uint32 = 144
 	OP_NOT                 uint32 = 145
 	OP_0NOTEQUAL           uint32 = 146
 	OP_ADD                 uint32 = 147
 	OP_SUB                 uint32 = 148
+	OP_MUL                 uint32 = 149
+	OP_DIV                 uint32 = 150
+	OP_MOD                 uint32 = 151
+	OP_LSHIFT              uint32 = 152
+	OP_RSHIFT              uint32 = 153
 	OP_BOOLAND             uint32 = 154
 	OP_BOOLOR              uint32 = 155
 	OP_NUMEQUAL            uint32 = 156
@@ -91,4 +118,177 @@ const (
 	OP_NOP8                uint32 = 183
 	OP_NOP9                uint32 = 184
 	OP_NOP10               uint32 = 185
+	OP_CHECKSIGADD         uint32 = 186
+	OP_INVALIDOPCODE       uint32 = 255
 )
+
+// Other names some opcodes go by
+const (
+	OP_FALSE = OP_0
+	OP_TRUE  = OP_1
+	OP_NOP2  = OP_CHECKLOCKTIMEVERIFY
+	OP_NOP3  = OP_CHECKSEQUENCEVERIFY
+)
+
+// Name of each opcode, used when disassembling scripts
+var names = map[uint32]string{
+	OP_0:                   "OP_0",
+	OP_PUSHDATA1:           "OP_PUSHDATA1",
+	OP_PUSHDATA2:           "OP_PUSHDATA2",
+	OP_PUSHDATA4:           "OP_PUSHDATA4",
+	OP_1NEGATE:             "OP_1NEGATE",
+	OP_RESERVED:            "OP_RESERVED",
+	OP_1:                   "OP_1",
+	OP_2:                   "OP_2",
+	OP_3:                   "OP_3",
+	OP_4:                   "OP_4",
+	OP_5:                   "OP_5",
+	OP_6:                   "OP_6",
+	OP_7:                   "OP_7",
+	OP_8:                   "OP_8",
+	OP_9:                   "OP_9",
+	OP_10:                  "OP_10",
+	OP_11:                  "OP_11",
+	OP_12:                  "OP_12",
+	OP_13:                  "OP_13",
+	OP_14:                  "OP_14",
+	OP_15:                  "OP_15",
+	OP_16:                  "OP_16",
+	OP_NOP:                 "OP_NOP",
+	OP_VER:                 "OP_VER",
+	OP_IF:                  "OP_IF",
+	OP_NOTIF:               "OP_NOTIF",
+	OP_VERIF:               "OP_VERIF",
+	OP_VERNOTIF:            "OP_VERNOTIF",
+	OP_ELSE:                "OP_ELSE",
+	OP_ENDIF:               "OP_ENDIF",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_TOALTSTACK:          "OP_TOALTSTACK",
+	OP_FROMALTSTACK:        "OP_FROMALTSTACK",
+	OP_2DROP:               "OP_2DROP",
+	OP_2DUP:                "OP_2DUP",
+	OP_3DUP:                "OP_3DUP",
+	OP_2OVER:               "OP_2OVER",
+	OP_2ROT:                "OP_2ROT",
+	OP_2SWAP:               "OP_2SWAP",
+	OP_IFDUP:               "OP_IFDUP",
+	OP_DEPTH:               "OP_DEPTH",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_NIP:                 "OP_NIP",
+	OP_OVER:                "OP_OVER",
+	OP_PICK:                "OP_PICK",
+	OP_ROLL:                "OP_ROLL",
+	OP_ROT:                 "OP_ROT",
+	OP_SWAP:                "OP_SWAP",
+	OP_TUCK:                "OP_TUCK",
+	OP_CAT:                 "OP_CAT",
+	OP_SUBSTR:              "OP_SUBSTR",
+	OP_LEFT:                "OP_LEFT",
+	OP_RIGHT:               "OP_RIGHT",
+	OP_SIZE:                "OP_SIZE",
+	OP_INVERT:              "OP_INVERT",
+	OP_AND:                 "OP_AND",
+	OP_OR:                  "OP_OR",
+	OP_XOR:                 "OP_XOR",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_RESERVED1:           "OP_RESERVED1",
+	OP_RESERVED2:           "OP_RESERVED2",
+	OP_1ADD:                "OP_1ADD",
+	OP_1SUB:                "OP_1SUB",
+	OP_2MUL:                "OP_2MUL",
+	OP_2DIV:                "OP_2DIV",
+	OP_NEGATE:              "OP_NEGATE",
+	OP_ABS:                 "OP_ABS",
+	OP_NOT:                 "OP_NOT",
+	OP_0NOTEQUAL:           "OP_0NOTEQUAL",
+	OP_ADD:                 "OP_ADD",
+	OP_SUB:                 "OP_SUB",
+	OP_MUL:                 "OP_MUL",
+	OP_DIV:                 "OP_DIV",
+	OP_MOD:                 "OP_MOD",
+	OP_LSHIFT:              "OP_LSHIFT",
+	OP_RSHIFT:              "OP_RSHIFT",
+	OP_BOOLAND:             "OP_BOOLAND",
+	OP_BOOLOR:              "OP_BOOLOR",
+	OP_NUMEQUAL:            "OP_NUMEQUAL",
+	OP_NUMEQUALVERIFY:      "OP_NUMEQUALVERIFY",
+	OP_NUMNOTEQUAL:         "OP_NUMNOTEQUAL",
+	OP_LESSTHAN:            "OP_LESSTHAN",
+	OP_GREATERTHAN:         "OP_GREATERTHAN",
+	OP_LESSTHANOREQUAL:     "OP_LESSTHANOREQUAL",
+	OP_GREATERTHANOREQUAL:  "OP_GREATERTHANOREQUAL",
+	OP_MIN:                 "OP_MIN",
+	OP_MAX:                 "OP_MAX",
+	OP_WITHIN:              "OP_WITHIN",
+	OP_RIPEMD160:           "OP_RIPEMD160",
+	OP_SHA1:                "OP_SHA1",
+	OP_SHA256:              "OP_SHA256",
+	OP_HASH160:             "OP_HASH160",
+	OP_HASH256:             "OP_HASH256",
+	OP_CODESEPARATOR:       "OP_CODESEPARATOR",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+	OP_NOP1:                "OP_NOP1",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+	OP_CHECKSEQUENCEVERIFY: "OP_CHECKSEQUENCEVERIFY",
+	OP_NOP4:                "OP_NOP4",
+	OP_NOP5:                "OP_NOP5",
+	OP_NOP6:                "OP_NOP6",
+	OP_NOP7:                "OP_NOP7",
+	OP_NOP8:                "OP_NOP8",
+	OP_NOP9:                "OP_NOP9",
+	OP_NOP10:               "OP_NOP10",
+	OP_CHECKSIGADD:         "OP_CHECKSIGADD",
+	OP_INVALIDOPCODE:       "OP_INVALIDOPCODE",
+}
+
+// Opcode for each name, including the alternative names
+var opcodes = map[string]uint32{
+	"OP_FALSE": OP_FALSE,
+	"OP_TRUE":  OP_TRUE,
+	"OP_NOP2":  OP_NOP2,
+	"OP_NOP3":  OP_NOP3,
+}
+
+// prefix of the names of opcodes which have none
+const unknownPrefix = "OP_UNKNOWN_"
+
+func init() {
+	for op, name := range names {
+		opcodes[name] = op
+	}
+}
+
+// Returns the name of the opcode. Opcodes without a name are called
+// OP_UNKNOWN_ followed by their value in hex, so they can be looked up again
+func Name(op uint32) string {
+	if name, ok := names[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s0x%02x", unknownPrefix, op)
+}
+
+// Returns the opcode with the name. The OP_ prefix and case are optional
+func Lookup(name string) (uint32, bool) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "OP_") {
+		name = "OP_" + name
+	}
+
+	if op, ok := opcodes[name]; ok {
+		return op, true
+	}
+
+	if strings.HasPrefix(name, unknownPrefix) {
+		op, err := strconv.ParseUint(strings.ToLower(name[len(unknownPrefix):]), 0, 8)
+		if err == nil {
+			return uint32(op), true
+		}
+	}
+	return 0, false
+}
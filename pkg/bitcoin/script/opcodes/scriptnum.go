@@ -0,0 +1,129 @@
+package opcodes
+
+import "github.com/ryohare/programming-bitcoin-go/pkg/utils"
+
+// Longest element arithmetic operations take as a number. Results can be
+// longer but can't be used as arguments again
+const MAX_NUM_SIZE = 4
+
+// Longest element OP_CHECKLOCKTIMEVERIFY and OP_CHECKSEQUENCEVERIFY take
+// as a number, so locktimes can use all 32 bits
+const MAX_LOCKTIME_NUM_SIZE = 5
+
+// Number as the script arithmetic sees it. Stack elements hold them little
+// endian with the sign in the top bit of the last byte
+type ScriptNum int64
+
+// Decodes the element as a number of at most maxSize bytes. With minimal
+// set, elements longer than the shortest encoding of the number fail too
+func MakeScriptNum(element []byte, minimal bool, maxSize int) (ScriptNum, error) {
+	if len(element) > maxSize {
+		return 0, ScriptErrNumOverflow
+	}
+	if minimal && !IsMinimalNum(element) {
+		return 0, ScriptErrNumMinimal
+	}
+	return ScriptNum(decode(element)), nil
+}
+
+// Checks the element is the shortest encoding of its number. The last byte
+// may only be a bare sign when the byte before it needs its top bit
+func IsMinimalNum(element []byte) bool {
+	if len(element) == 0 || element[len(element)-1]&0x7f != 0 {
+		return true
+	}
+	return len(element) > 1 && element[len(element)-2]&0x80 != 0
+}
+
+// Encodes the number as a stack element, the shortest encoding
+func (n ScriptNum) Bytes() []byte {
+	return encode(int(n))
+}
+
+// Encodes the number as a stack element, little endian with the sign in the
+// top bit of the last byte
+func EncodeNum(num int) []byte {
+	return encode(num)
+}
+
+// Decodes a stack element encoded by EncodeNum
+func DecodeNum(element []byte) int {
+	return decode(element)
+}
+
+// Abs returns the absolute value of x.
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// encode and int into a byte array
+func encode(num int) []byte {
+
+	// If the num is 0, return an empty byte array
+	if num == 0 {
+		return []byte{}
+	}
+
+	// absolute value of the number
+	absNum := abs(num)
+
+	// flag indicating if the number is negative
+	negative := (num < 0)
+
+	// results array
+	result := []byte{}
+
+	// Shift in the bytes
+	for absNum > 0 {
+		result = append(result, byte(absNum)&0xff)
+		absNum >>= 8
+	}
+
+	// if the top bit is set,
+	// for negative numbers we ensure that the top bit is set
+	// for positive numbers we ensure that the top bit is not set
+	res := result[len(result)-1] & 0x80
+	if res > 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// decode and encoded byte array into and int
+func decode(element []byte) int {
+
+	// check of the byte array is empty
+	// if so the result is 0
+	if len(element) == 0 {
+		return 0
+	}
+
+	// reverse the element to be in big endian
+	// (was previously encoded as little endian)
+	bigEndian := utils.ImmutableReorderBytes(element)
+
+	// top bit being 1 means its negative, the rest of the top byte
+	// is the most significant part of the number
+	negative := bigEndian[0]&0x80 != 0
+	result := uint64(bigEndian[0] & 0x7f)
+
+	// shift in the remaining bytes
+	for _, b := range bigEndian[1:] {
+		result = result<<8 | uint64(b)
+	}
+
+	if negative {
+		return -int(result)
+	} else {
+		return int(result)
+	}
+}
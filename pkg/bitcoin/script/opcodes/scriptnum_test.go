@@ -0,0 +1,60 @@
+package opcodes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMakeScriptNum(t *testing.T) {
+	for _, test := range []struct {
+		element []byte
+		minimal bool
+		maxSize int
+		num     ScriptNum
+		err     error
+	}{
+		{[]byte{}, true, MAX_NUM_SIZE, 0, nil},
+		{[]byte{0x81}, true, MAX_NUM_SIZE, -1, nil},
+		{[]byte{0x80, 0x00}, true, MAX_NUM_SIZE, 128, nil},
+		{[]byte{0xff, 0x80}, true, MAX_NUM_SIZE, -255, nil},
+		{[]byte{0xff, 0xff, 0xff, 0x7f}, true, MAX_NUM_SIZE, 0x7fffffff, nil},
+
+		// longer than the arithmetic takes, but not locktimes
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x00}, true, MAX_NUM_SIZE, 0, ScriptErrNumOverflow},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x00}, true, MAX_LOCKTIME_NUM_SIZE, 0xffffffff, nil},
+		{[]byte{0, 0, 0, 0, 0, 0x01}, false, MAX_LOCKTIME_NUM_SIZE, 0, ScriptErrNumOverflow},
+
+		// zero bytes and negative zero only fail when minimal
+		{[]byte{0x00}, false, MAX_NUM_SIZE, 0, nil},
+		{[]byte{0x00}, true, MAX_NUM_SIZE, 0, ScriptErrNumMinimal},
+		{[]byte{0x80}, true, MAX_NUM_SIZE, 0, ScriptErrNumMinimal},
+		{[]byte{0x01, 0x00}, false, MAX_NUM_SIZE, 1, nil},
+		{[]byte{0x01, 0x00}, true, MAX_NUM_SIZE, 0, ScriptErrNumMinimal},
+		{[]byte{0x01, 0x80}, true, MAX_NUM_SIZE, 0, ScriptErrNumMinimal},
+	} {
+		num, err := MakeScriptNum(test.element, test.minimal, test.maxSize)
+		if !errors.Is(err, test.err) || (err == nil && num != test.num) {
+			t.Fatalf("expected %d, %v from %x, got %d, %v", test.num, test.err, test.element, num, err)
+		}
+		if err == nil && test.minimal && !bytes.Equal(num.Bytes(), test.element) {
+			t.Fatalf("failed to encode %d back to %x, got %x", num, test.element, num.Bytes())
+		}
+	}
+}
+
+func TestRequireMinimal(t *testing.T) {
+	s := &Stack{}
+	s.Push([]byte{0x02, 0x00})
+	s.Push([]byte{0x01})
+	if err := s.OpAdd(); err != nil || decode(s.Elements[0].Bytes) != 3 {
+		t.Fatalf("failed to add the numbers, got %v", err)
+	}
+
+	s = &Stack{RequireMinimal: true}
+	s.Push([]byte{0x02, 0x00})
+	s.Push([]byte{0x01})
+	if err := s.OpAdd(); !errors.Is(err, ScriptErrNumMinimal) {
+		t.Fatalf("expected the padded number to fail, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package opcodes
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Makes a stack of the numbers, the last one on top
+func makeNumStack(nums ...int) *Stack {
+	s := &Stack{}
+	for _, n := range nums {
+		s.Push(encode(n))
+	}
+	return s
+}
+
+// Checks the stack holds the numbers, the last one on top
+func checkNumStack(t *testing.T, name string, s *Stack, nums ...int) {
+	t.Helper()
+	if s.Len() != len(nums) {
+		t.Fatalf("%s left %d elements, expected %d", name, s.Len(), len(nums))
+	}
+	for i, n := range nums {
+		if got := decode(s.Elements[i].Bytes); got != n {
+			t.Fatalf("%s left %d at %d, expected %d", name, got, i, n)
+		}
+	}
+}
+
+func TestStackOperations(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		op     func(s *Stack) error
+		before []int
+		after  []int
+	}{
+		{"OP_SUB", (*Stack).OpSub, []int{2, 1}, []int{1}},
+		{"OP_GREATERTHAN", (*Stack).OpGreaterThan, []int{1, 0}, []int{1}},
+		{"OP_LESSTHAN", (*Stack).OpLessThan, []int{1, 0}, []int{0}},
+		{"OP_GREATERTHANOREQUAL", (*Stack).OpGreaterOrEqualThan, []int{1, 1}, []int{1}},
+		{"OP_LESSTHANOREQUAL", (*Stack).OpLessOrEqualThan, []int{2, 1}, []int{0}},
+		{"OP_WITHIN", (*Stack).OpWithIn, []int{9, 1, 0, 2}, []int{9, 1}},
+		{"OP_WITHIN", (*Stack).OpWithIn, []int{2, 0, 2}, []int{0}},
+		{"OP_3DUP", (*Stack).Op3Dup, []int{1, 2, 3}, []int{1, 2, 3, 1, 2, 3}},
+		{"OP_2ROT", (*Stack).Op2Rot, []int{0, 1, 2, 3, 4, 5, 6}, []int{0, 3, 4, 5, 6, 1, 2}},
+		{"OP_2SWAP", (*Stack).Op2Swap, []int{0, 1, 2, 3, 4}, []int{0, 3, 4, 1, 2}},
+		{"OP_TUCK", (*Stack).OpTuck, []int{1, 2}, []int{2, 1, 2}},
+		{"OP_ROT", (*Stack).OpRot, []int{1, 2, 3}, []int{2, 3, 1}},
+		{"OP_PICK", (*Stack).OpPick, []int{1, 2, 3, 2}, []int{1, 2, 3, 1}},
+		{"OP_ROLL", (*Stack).OpRoll, []int{1, 2, 3, 2}, []int{2, 3, 1}},
+		{"OP_ROLL", (*Stack).OpRoll, []int{1, 2, 0}, []int{1, 2}},
+	} {
+		s := makeNumStack(test.before...)
+		if err := test.op(s); err != nil {
+			t.Fatalf("%s failed because %s", test.name, err.Error())
+		}
+		checkNumStack(t, test.name, s, test.after...)
+	}
+}
+
+func TestStackOperationErrors(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		op    func(s *Stack) error
+		stack *Stack
+		code  ErrorCode
+	}{
+		{"OP_ADD", (*Stack).OpAdd, makeNumStack(1), ScriptErrStackUnderflow},
+		{"OP_PICK", (*Stack).OpPick, makeNumStack(1, 2, 3, -1), ScriptErrStackUnderflow},
+		{"OP_ROLL", (*Stack).OpRoll, makeNumStack(1, 2), ScriptErrStackUnderflow},
+		{"OP_VERIFY", (*Stack).OpVerify, makeNumStack(0), ScriptErrVerify},
+		{"OP_EQUALVERIFY", (*Stack).OpEqualVerify, makeNumStack(1, 2), ScriptErrEqualVerify},
+		{"OP_NUMEQUALVERIFY", (*Stack).OpNumEqualVerify, makeNumStack(1, 2), ScriptErrNumEqualVerify},
+		{"OP_RETURN", (*Stack).OpReturn, makeNumStack(), ScriptErrOpReturn},
+
+		// arithmetic takes numbers of up to 4 bytes
+		{"OP_1ADD", (*Stack).Op1Add, makeNumStack(0x80000000), ScriptErrNumOverflow},
+	} {
+		if err := test.op(test.stack); !errors.Is(err, test.code) {
+			t.Errorf("expected %s from %s, got %v", test.code.Name(), test.name, err)
+		}
+	}
+
+	// results may be longer than the arguments
+	s := makeNumStack(0x7fffffff)
+	if err := s.Op1Add(); err != nil || !bytes.Equal(s.Elements[0].Bytes, []byte{0, 0, 0, 0x80, 0}) {
+		t.Fatalf("failed to add past 4 bytes, got %x %v", s.Elements[0].Bytes, err)
+	}
+}
+
+func TestAltStack(t *testing.T) {
+	s := makeNumStack(1, 2)
+	alt := &Stack{}
+	if err := s.OpToAltStack(alt); err != nil {
+		t.Fatalf("failed to move to the alt stack because %s", err.Error())
+	}
+	checkNumStack(t, "OP_TOALTSTACK", alt, 2)
+	if err := s.OpFromAltStack(alt); err != nil {
+		t.Fatalf("failed to move from the alt stack because %s", err.Error())
+	}
+	checkNumStack(t, "OP_FROMALTSTACK", s, 1, 2)
+	if err := s.OpFromAltStack(alt); !errors.Is(err, ScriptErrAltStackUnderflow) {
+		t.Fatalf("expected an alt stack underflow, got %v", err)
+	}
+}
+
+func TestRipeMd160(t *testing.T) {
+	s := &Stack{}
+	s.Push([]byte("a"))
+	if err := s.OpRipeMd160(); err != nil {
+		t.Fatalf("failed to hash because %s", err.Error())
+	}
+	expected := []byte{0x0b, 0xdc, 0x9d, 0x2d, 0x25, 0x6b, 0x3e, 0xe9, 0xda, 0xae, 0x34, 0x7b, 0xe6, 0xf4, 0xdc, 0x83, 0x5a, 0x46, 0x7f, 0xfe}
+	if !bytes.Equal(s.Elements[0].Bytes, expected) {
+		t.Fatalf("unexpected hash %x", s.Elements[0].Bytes)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	if ScriptErrEvalFalse.Name() != "EVAL_FALSE" || ScriptErrStackUnderflow.Name() != "INVALID_STACK_OPERATION" {
+		t.Fatal("unexpected names of the codes")
+	}
+	if code, ok := LookupErrorCode("SIG_DER"); !ok || code != ScriptErrSigDER {
+		t.Fatal("failed to look up SIG_DER")
+	}
+	if _, ok := LookupErrorCode("NOT_AN_ERROR"); ok {
+		t.Fatal("looked up an unknown name")
+	}
+	if ErrorCode(1000).Name() != "UNKNOWN_ERROR" {
+		t.Fatal("expected codes out of range to be unknown")
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"math/big"
+	"strings"
 	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
 )
 
 func testEq(a, b []byte) bool {
@@ -107,8 +110,8 @@ func TestCombine(t *testing.T) {
 	// 1: Sig
 	// 2: PubKey
 	// 3: 0xac 		// OP_CHECKSIG
-	if !combinedScript.Evaluate(new(big.Int).SetBytes(zBytes), 0, 0, 0, nil) {
-		t.Fatalf("evaulate failed")
+	if err := combinedScript.Evaluate(new(big.Int).SetBytes(zBytes), 0, 0, 0, nil); err != nil {
+		t.Fatalf("evaulate failed because %s", err.Error())
 	}
 }
 
@@ -137,8 +140,8 @@ func TestScriptOpCodes(t *testing.T) {
 	scriptSig.Commands = append(scriptSig.Commands, Command{Bytes: scriptSigBytes})
 	combinedScript := Combine(*scriptPubKey, *scriptSig)
 
-	if !combinedScript.Evaluate(big.NewInt(0), 0, 0, 0, nil) {
-		t.Fatalf("failed to evaulate the script")
+	if err := combinedScript.Evaluate(big.NewInt(0), 0, 0, 0, nil); err != nil {
+		t.Fatalf("failed to evaulate the script because %s", err.Error())
 	}
 }
 
@@ -156,8 +159,8 @@ func TestCollision(t *testing.T) {
 
 	combinedScript := Combine(*scriptPubKey, *scriptSig)
 
-	if !combinedScript.Evaluate(big.NewInt(0), 0, 0, 0, nil) {
-		t.Fatalf("failed to evaluate script")
+	if err := combinedScript.Evaluate(big.NewInt(0), 0, 0, 0, nil); err != nil {
+		t.Fatalf("failed to evaluate script because %s", err.Error())
 	}
 }
 
@@ -172,3 +175,71 @@ func TestMakeP2wpkhSmoke(t *testing.T) {
 func TestP2wpkh(t *testing.T) {
 
 }
+
+func TestParsePushData(t *testing.T) {
+	// OP_PUSHDATA1 of 2 bytes, OP_PUSHDATA2 of 300 bytes and OP_PUSHDATA4
+	// of 1 byte, the non minimal pushes are kept when serializing
+	raw := []byte{0x4c, 0x02, 0xaa, 0xbb, 0x4d, 0x2c, 0x01}
+	raw = append(raw, bytes.Repeat([]byte{0xcc}, 300)...)
+	raw = append(raw, 0x4e, 0x01, 0x00, 0x00, 0x00, 0xdd, 0xac)
+
+	// the script is longer than 0xfc bytes so the length is a 3 byte varint
+	full := append([]byte{0xfd, byte(len(raw)), byte(len(raw) >> 8)}, raw...)
+	script, err := Parse(bytes.NewReader(full))
+	if err != nil {
+		t.Fatalf("failed to parse the script because %s", err.Error())
+	}
+	if len(script.Commands) != 4 || len(script.Commands[1].Bytes) != 300 || script.Commands[2].Bytes[0] != 0xdd || !script.Commands[3].OpCode {
+		t.Fatalf("unexpected commands %s", script.Asm())
+	}
+	if !testEq(script.Serialize(), full) {
+		t.Fatalf("script did not round trip")
+	}
+
+	// elements built in code get the smallest push
+	script.Commands[0].PushOp = 0
+	if serialized, _ := script.RawSerialize(); !testEq(serialized[:3], []byte{0x02, 0xaa, 0xbb}) {
+		t.Fatalf("expected a direct push, got %x", serialized[:3])
+	}
+
+	// a script claiming more bytes than are left is still an error
+	for _, short := range [][]byte{
+		{0x02, 0x4c},
+		{0x03, 0x4d, 0x01},
+		{0x05, 0x51},
+	} {
+		if _, err := Parse(bytes.NewReader(short)); err == nil {
+			t.Fatalf("parsed the script %x longer than the data", short)
+		}
+	}
+}
+
+func TestParseBadPush(t *testing.T) {
+	// pushes running past the end are kept as they are, scripts are only
+	// bytes until they are run
+	for _, bad := range [][]byte{
+		{0x02, 0x02, 0xaa},
+		{0x01, 0x4c},
+		{0x02, 0x4d, 0x01},
+		{0x05, 0x51, 0x4c, 0x05, 0xaa, 0xbb},
+	} {
+		script, err := Parse(bytes.NewReader(bad))
+		if err != nil {
+			t.Fatalf("failed to parse the script %x because %s", bad, err.Error())
+		}
+		last := script.Commands[len(script.Commands)-1]
+		if !last.Unparsed || !strings.HasSuffix(script.Asm(), "[error]") || !testEq(script.Serialize(), bad) || script.IsPushOnly() {
+			t.Fatalf("bad push of %x was not kept, %s", bad, script.Asm())
+		}
+		if _, err := ParseCommands(bad[1:]); err == nil {
+			t.Fatalf("parsed the commands of the bad script %x", bad)
+		}
+	}
+
+	// the bad push fails the script even in a branch which isn't taken
+	script, _ := Parse(bytes.NewReader([]byte{0x04, 0x00, 0x63, 0x02, 0xaa}))
+	err := EvalScript(&opcodes.Stack{}, script, SCRIPT_VERIFY_NONE, nil, SIGVERSION_BASE)
+	if ErrorCode(err) != opcodes.ScriptErrBadOpcode {
+		t.Fatalf("expected the bad push to fail the script, got %v", err)
+	}
+}
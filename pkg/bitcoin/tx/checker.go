@@ -0,0 +1,207 @@
+package tx
+
+import (
+	"math/big"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+	"github.com/ryohare/programming-bitcoin-go/pkg/utils"
+)
+
+// Checks the signatures of an input against the transaction spending it
+type SignatureChecker struct {
+	script.LockTimeChecker
+
+	tx         *Transaction
+	inputIndex int
+
+	// value of the output being spent, signed by witness signatures
+	amount uint64
+}
+
+func MakeSignatureChecker(t *Transaction, inputIndex int, amount uint64) *SignatureChecker {
+	return &SignatureChecker{
+		LockTimeChecker: script.LockTimeChecker{
+			LockTime: uint32(t.Locktime),
+			Sequence: uint32(t.Inputs[inputIndex].Sequence),
+			Version:  uint32(t.Version),
+		},
+		tx:         t,
+		inputIndex: inputIndex,
+		amount:     amount,
+	}
+}
+
+func (c SignatureChecker) CheckSig(sig, pubkey, scriptCode []byte, version script.SigVersion) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	hashType := uint32(sig[len(sig)-1])
+
+	var z *big.Int
+	if version == script.SIGVERSION_WITNESS_V0 {
+		z = c.tx.SignatureHashWitnessV0(c.inputIndex, scriptCode, c.amount, hashType)
+	} else {
+		z = c.tx.SignatureHash(c.inputIndex, scriptCode, hashType)
+	}
+	return script.VerifySignature(sig[:len(sig)-1], pubkey, z)
+}
+
+// Appends the script as a varint length followed by the script
+func appendScript(b, s []byte) []byte {
+	length, _ := utils.EncodeUVarInt(uint64(len(s)))
+	b = append(b, length...)
+	return append(b, s...)
+}
+
+// Appends the outpoint, the previous transaction hash little endian and the index
+func appendOutpoint(b []byte, txIn *TransactionInput) []byte {
+	b = append(b, utils.ImmutableReorderBytes(txIn.PrevTx)...)
+	return append(b, utils.IntToLittleEndianBytes(txIn.PrevIndex)...)
+}
+
+// Removes the OP_CODESEPARATORs from the script code, which are never signed
+// by legacy signatures
+func stripCodeSeparators(scriptCode []byte) []byte {
+	commands, err := script.ParseCommands(scriptCode)
+	if err != nil {
+		return scriptCode
+	}
+
+	kept := commands[:0]
+	for _, c := range commands {
+		if c.OpCode && c.Op() == opcodes.OP_CODESEPARATOR {
+			continue
+		}
+		kept = append(kept, c)
+	}
+
+	raw, err := (&script.Script{Commands: kept}).RawSerialize()
+	if err != nil {
+		return scriptCode
+	}
+	return raw
+}
+
+// Returns the legacy signature hash of the input for the script code and hash
+// type. Follows Bitcoin Core, including signing the hash one when SIGHASH_SINGLE
+// has no output at the index of the input
+func (t Transaction) SignatureHash(inputIndex int, scriptCode []byte, hashType uint32) *big.Int {
+	// the base type is in the low 5 bits, undefined types sign like ALL
+	baseType := byte(hashType) & 0x1f
+	anyoneCanPay := byte(hashType)&script.SIGHASH_ANYONECANPAY != 0
+
+	if inputIndex >= len(t.Inputs) || baseType == script.SIGHASH_SINGLE && inputIndex >= len(t.Outputs) {
+		one := make([]byte, 32)
+		one[0] = 0x01
+		return new(big.Int).SetBytes(one)
+	}
+
+	scriptCode = stripCodeSeparators(scriptCode)
+
+	s := utils.IntToLittleEndianBytes(t.Version)
+
+	// only the input being signed is committed to with ANYONECANPAY
+	inputs := t.Inputs
+	if anyoneCanPay {
+		inputs = t.Inputs[inputIndex : inputIndex+1]
+	}
+	count, _ := utils.EncodeUVarInt(uint64(len(inputs)))
+	s = append(s, count...)
+
+	for _, txIn := range inputs {
+		signing := txIn == t.Inputs[inputIndex]
+
+		s = appendOutpoint(s, txIn)
+
+		// the input being signed carries the script code, the others are blank
+		if signing {
+			s = appendScript(s, scriptCode)
+		} else {
+			s = appendScript(s, nil)
+		}
+
+		// the other inputs may change their sequence with NONE and SINGLE
+		if !signing && (baseType == script.SIGHASH_NONE || baseType == script.SIGHASH_SINGLE) {
+			s = append(s, utils.IntToLittleEndianBytes(0)...)
+		} else {
+			s = append(s, utils.IntToLittleEndianBytes(txIn.Sequence)...)
+		}
+	}
+
+	switch baseType {
+	case script.SIGHASH_NONE:
+		s = append(s, 0x00)
+	case script.SIGHASH_SINGLE:
+		// outputs before the one at the index are blanked out
+		count, _ := utils.EncodeUVarInt(uint64(inputIndex + 1))
+		s = append(s, count...)
+		for i := 0; i < inputIndex; i++ {
+			s = append(s, utils.UInt64ToLittleEndianBytes(0xffffffffffffffff)...)
+			s = appendScript(s, nil)
+		}
+		s = append(s, t.Outputs[inputIndex].Serialize()...)
+	default:
+		count, _ := utils.EncodeUVarInt(uint64(len(t.Outputs)))
+		s = append(s, count...)
+		for _, txOut := range t.Outputs {
+			s = append(s, txOut.Serialize()...)
+		}
+	}
+
+	s = append(s, utils.IntToLittleEndianBytes(t.Locktime)...)
+	s = append(s, utils.IntToLittleEndianBytes(int(hashType))...)
+
+	return new(big.Int).SetBytes(utils.Hash256(s))
+}
+
+// Returns the BIP143 signature hash of the input for version 0 witness
+// programs, which also commits to the amount being spent
+func (t Transaction) SignatureHashWitnessV0(inputIndex int, scriptCode []byte, amount uint64, hashType uint32) *big.Int {
+	baseType := byte(hashType) & 0x1f
+	anyoneCanPay := byte(hashType)&script.SIGHASH_ANYONECANPAY != 0
+
+	hashPrevouts := make([]byte, 32)
+	if !anyoneCanPay {
+		var prevouts []byte
+		for _, txIn := range t.Inputs {
+			prevouts = appendOutpoint(prevouts, txIn)
+		}
+		hashPrevouts = utils.Hash256(prevouts)
+	}
+
+	hashSequence := make([]byte, 32)
+	if !anyoneCanPay && baseType != script.SIGHASH_SINGLE && baseType != script.SIGHASH_NONE {
+		var sequences []byte
+		for _, txIn := range t.Inputs {
+			sequences = append(sequences, utils.IntToLittleEndianBytes(txIn.Sequence)...)
+		}
+		hashSequence = utils.Hash256(sequences)
+	}
+
+	hashOutputs := make([]byte, 32)
+	if baseType != script.SIGHASH_SINGLE && baseType != script.SIGHASH_NONE {
+		var outputs []byte
+		for _, txOut := range t.Outputs {
+			outputs = append(outputs, txOut.Serialize()...)
+		}
+		hashOutputs = utils.Hash256(outputs)
+	} else if baseType == script.SIGHASH_SINGLE && inputIndex < len(t.Outputs) {
+		hashOutputs = utils.Hash256(t.Outputs[inputIndex].Serialize())
+	}
+
+	txIn := t.Inputs[inputIndex]
+
+	s := utils.IntToLittleEndianBytes(t.Version)
+	s = append(s, hashPrevouts...)
+	s = append(s, hashSequence...)
+	s = appendOutpoint(s, txIn)
+	s = appendScript(s, scriptCode)
+	s = append(s, utils.UInt64ToLittleEndianBytes(amount)...)
+	s = append(s, utils.IntToLittleEndianBytes(txIn.Sequence)...)
+	s = append(s, hashOutputs...)
+	s = append(s, utils.IntToLittleEndianBytes(t.Locktime)...)
+	s = append(s, utils.IntToLittleEndianBytes(int(hashType))...)
+
+	return new(big.Int).SetBytes(utils.Hash256(s))
+}
@@ -0,0 +1,78 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+func TestSignatureHashWitnessV0(t *testing.T) {
+	raw, _ := hex.DecodeString(testSegwitTx)
+	tx, err := ParseSegwit(raw)
+	if err != nil {
+		t.Fatalf("failed to parse the transaction because %s", err.Error())
+	}
+
+	// native p2wpkh example of BIP143, spending 6 BTC with input 1
+	scriptCode, _ := hex.DecodeString("76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac")
+	z := tx.SignatureHashWitnessV0(1, scriptCode, 600000000, SIGHASH_ALL)
+	if fmt.Sprintf("%064x", z) != "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670" {
+		t.Fatalf("unexpected signature hash %064x", z)
+	}
+}
+
+func TestVerifyInputScript(t *testing.T) {
+	raw, _ := hex.DecodeString(testSegwitTx)
+	tx, err := ParseSegwit(raw)
+	if err != nil {
+		t.Fatalf("failed to parse the transaction because %s", err.Error())
+	}
+
+	p2pk, _ := hex.DecodeString("232103c9f4836b9a4f77fc0d81f7bcb01b7f1b35916864b9476c241ce9fc198bd25432ac")
+	p2pkScript, _ := script.Parse(bytes.NewReader(p2pk))
+	p2wpkh, _ := hex.DecodeString("1600141d0f172a0ecb48aee1be1f2687d2963ae33f71a1")
+	p2wpkhScript, _ := script.Parse(bytes.NewReader(p2wpkh))
+
+	if err := tx.VerifyInputScript(0, p2pkScript, 625000000, script.CONSENSUS_SCRIPT_VERIFY_FLAGS); err != nil {
+		t.Fatalf("failed to verify the legacy input because %s", err.Error())
+	}
+	if err := tx.VerifyInputScript(1, p2wpkhScript, 600000000, script.CONSENSUS_SCRIPT_VERIFY_FLAGS); err != nil {
+		t.Fatalf("failed to verify the witness input because %s", err.Error())
+	}
+
+	// the witness signature commits to the amount
+	if err := tx.VerifyInputScript(1, p2wpkhScript, 600000001, script.CONSENSUS_SCRIPT_VERIFY_FLAGS); !errors.Is(err, opcodes.ScriptErrEvalFalse) {
+		t.Fatalf("expected EVAL_FALSE for the wrong amount, got %v", err)
+	}
+
+	// without the witness flag the program is anyone can spend
+	if err := tx.VerifyInputScript(1, p2wpkhScript, 0, script.SCRIPT_VERIFY_P2SH); err != nil {
+		t.Fatalf("failed to verify the witness program as anyone can spend because %s", err.Error())
+	}
+
+	// the other input has a scriptSig, which witness programs can't have
+	if err := tx.VerifyInputScript(0, p2wpkhScript, 600000000, script.CONSENSUS_SCRIPT_VERIFY_FLAGS); !errors.Is(err, opcodes.ScriptErrWitnessMalleated) {
+		t.Fatalf("expected WITNESS_MALLEATED for the wrong output, got %v", err)
+	}
+
+	if err := tx.VerifyInputScript(2, p2wpkhScript, 0, script.CONSENSUS_SCRIPT_VERIFY_FLAGS); err == nil {
+		t.Fatal("verified an input which does not exist")
+	}
+}
+
+func TestSignatureHashSingleBug(t *testing.T) {
+	raw, _ := hex.DecodeString(testSegwitTx)
+	tx, _ := ParseSegwit(raw)
+	tx.Outputs = tx.Outputs[:1]
+
+	// SIGHASH_SINGLE without a matching output signs the hash one
+	z := tx.SignatureHash(1, nil, SIGHASH_SINGLE)
+	if fmt.Sprintf("%064x", z) != "01"+fmt.Sprintf("%062x", 0) {
+		t.Fatalf("unexpected signature hash %064x", z)
+	}
+}
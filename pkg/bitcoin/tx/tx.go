@@ -14,10 +14,10 @@ import (
 
 // SIGHASH byte fields
 const (
-	SIGHASH_ALL       uint32 = 1
-	SIGNHASH_NONE     uint32 = 2
-	SIGHASH_SINGLE    uint32 = 3
-	SIGHASH_ANYCANPAY uint32 = 4
+	SIGHASH_ALL          uint32 = 1
+	SIGHASH_NONE         uint32 = 2
+	SIGHASH_SINGLE       uint32 = 3
+	SIGHASH_ANYONECANPAY uint32 = 0x80
 )
 
 type Transaction struct {
@@ -44,15 +44,6 @@ type Transaction struct {
 
 	// Withness programs
 	Witness [][]byte
-
-	// Hash of the outputs for "this" transaction
-	hashOutputs []byte
-
-	// hash of the outputs for the previous transactions (utxos)
-	hashPrevOuts []byte
-
-	// hash of the previous outputs sequences (utxos)
-	hashSequence []byte
 }
 
 func (t Transaction) String() string {
@@ -85,7 +76,8 @@ func (t Transaction) serializeSegwit() []byte {
 
 	// now we encode the number of inputs that are in the transaction
 	// which gets encoded as a varint
-	tx = append(tx, utils.IntToVarintBytes(len(t.Inputs))...)
+	numOfInputs, _ := utils.EncodeUVarInt(uint64(len(t.Inputs)))
+	tx = append(tx, numOfInputs...)
 
 	// loop over all the inputs and append their individual serialiations
 	for _, txin := range t.Inputs {
@@ -94,7 +86,8 @@ func (t Transaction) serializeSegwit() []byte {
 
 	// next are the outputs. Again, like the inputs, firs element is the
 	// length which is encoded as var int
-	tx = append(tx, utils.IntToVarintBytes(len(t.Outputs))...)
+	numOfOutputs, _ := utils.EncodeUVarInt(uint64(len(t.Outputs)))
+	tx = append(tx, numOfOutputs...)
 
 	// serialize each outut
 	for _, txout := range t.Outputs {
@@ -105,26 +98,18 @@ func (t Transaction) serializeSegwit() []byte {
 	// input, which map to prevOuts or Outpoints we are consuming
 	for _, txin := range t.Inputs {
 
-		// first element is the number of witness programs we have.
-		// this is encoded as little endian
-		tx = append(tx, utils.IntToLittleEndianBytes(len(txin.Witness))...)
+		// first element is the number of witness items as a varint
+		numOfItems, _ := utils.EncodeUVarInt(uint64(len(txin.Witness)))
+		tx = append(tx, numOfItems...)
 
-		// iterate over the witness programs and add them into the serialization
-		for _, witness := range t.Witness {
+		// iterate over the witness items and add them into the serialization
+		for _, witness := range txin.Witness {
 
-			// based on the parse function, we check if 0x00 was pushed into the witness array
-			// in the python code, they do this by checking if the datatype is an int which
-			// doesnt fly in golang because everything is a []byte here.
-			// Update: Pulled out the 0x00 check and am just checking if it is of length 1,
-			// indicating it is uint8
-			if len(witness) == 1 /*&& witness[0] == 0x00*/ {
-				tx = append(tx, utils.UInt8ToLittleEndianBytes(uint8(witness[0])))
-			} else {
-				// else its a varint that needs to encoded as the length of the withness program
-				// followed by the program itself
-				tx = append(tx, utils.IntToVarintBytes(len(witness))...)
-				tx = append(tx, witness...)
-			}
+			// a varint that needs to encoded as the length of the withness program
+			// followed by the program itself
+			length, _ := utils.EncodeUVarInt(uint64(len(witness)))
+			tx = append(tx, length...)
+			tx = append(tx, witness...)
 		}
 	}
 
@@ -153,7 +138,8 @@ func (t Transaction) serializeLegacy() []byte {
 	tx = append(tx, utils.IntToLittleEndianBytes(t.Version)...)
 
 	// varint for the length of the inputs
-	tx = append(tx, utils.IntToVarintBytes(len(t.Inputs))...)
+	numOfInputs, _ := utils.EncodeUVarInt(uint64(len(t.Inputs)))
+	tx = append(tx, numOfInputs...)
 
 	// serialize each of the inputs now
 	for _, v := range t.Inputs {
@@ -161,7 +147,8 @@ func (t Transaction) serializeLegacy() []byte {
 	}
 
 	// varint for the length of the outputs
-	tx = append(tx, utils.IntToVarintBytes(len(t.Outputs))...)
+	numOfOutputs, _ := utils.EncodeUVarInt(uint64(len(t.Outputs)))
+	tx = append(tx, numOfOutputs...)
 
 	// serialize each of the outputs now
 	for _, v := range t.Outputs {
@@ -180,6 +167,13 @@ func (t Transaction) Hash() []byte {
 	return utils.MutableReorderBytes(utils.Hash256(serial))
 }
 
+// Return the witness transaction Id (wtxid) of the transaction as a byte array,
+// the hash of the serialization including the witness. The same as the
+// transaction Id for legacy transactions
+func (t Transaction) WitnessHash() []byte {
+	return utils.MutableReorderBytes(utils.Hash256(t.Serialize()))
+}
+
 // Returns the transaction Id as a string
 func (t Transaction) ID() string {
 	return fmt.Sprintf("%x", t.Hash())
@@ -187,31 +181,68 @@ func (t Transaction) ID() string {
 
 // Parse a segwit transaction
 func ParseSegwit(serialization []byte) (*Transaction, error) {
-	t := &Transaction{}
+	// the segwit marker and flag follow the 4 byte version
+	if len(serialization) < 6 || serialization[4] != 0x00 || serialization[5] != 0x01 {
+		return nil, fmt.Errorf("segwit markers are not correct")
+	}
 
-	// create the reader
-	reader := bytes.NewReader(serialization)
+	return ReadTransaction(bytes.NewReader(serialization))
+}
+
+// Parse a transaction from a byte stream
+func ParseTransaction(serialization []byte) (*Transaction, error) {
+	return ReadTransaction(bytes.NewReader(serialization))
+}
+
+// Reads a single transaction, legacy or segwit, off the reader leaving the reader
+// positioned at the byte after the transaction. Used to parse the transactions
+// packed back to back in a block.
+func ReadTransaction(reader *bytes.Reader) (*Transaction, error) {
+	t := &Transaction{}
 
-	// read the segwit version to know what we are dealing with.
-	// version = 0 == normal segwit
-	// version = 1 == taproot - sir not appearing in this picture
+	//
+	// parse the version
+	//
+	if reader.Len() < 4 {
+		return nil, fmt.Errorf("transaction is too short")
+	}
 	t.Version = utils.LittleEndianToInt(reader)
 
-	// read in the segwit marker and flag which are the next 2 bytes
-	segwitMarker, _ := reader.ReadByte()
-	segwitFlag, _ := reader.ReadByte()
-	if segwitMarker != 0x00 && segwitFlag != 0x01 {
-		return nil, fmt.Errorf("segwith markers are not correct. Received %x %x", segwitMarker, segwitFlag)
+	// segwit bolt-on, the 5th byte of a segwit transaction is the segwit
+	// marker 0x00 where a legacy transaction has the number of inputs. A legacy
+	// transaction can never have 0 inputs, so this is unambiguous.
+	marker, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if marker == 0x00 {
+		// read in the segwit flag which must be 0x01
+		flag, _ := reader.ReadByte()
+		if flag != 0x01 {
+			return nil, fmt.Errorf("segwit markers are not correct. Received %x %x", marker, flag)
+		}
+		t.Segwit = true
+	} else {
+		reader.UnreadByte()
 	}
 
 	//
-	// Parse the inputs to the transaction
+	// Parse the inputs
 	//
-	// number of inputs is the next item in the serialization of the transaction on the wire
-	numOfInputs, _ := binary.ReadUvarint(reader)
+	// first is the varint for the length of the inputs
+	numOfInputs := utils.ReadVarIntFromBytes(reader)
+
+	// each input is at least 41 bytes
+	if numOfInputs > uint64(reader.Len()/41) {
+		return nil, fmt.Errorf("transaction claims %d inputs which do not fit the remaining data", numOfInputs)
+	}
+
 	// iterate over the inputs and append them to the inputs list
 	for i := 0; i < int(numOfInputs); i++ {
 		ip := ParseTransactionInput(reader)
+		if ip.ScriptSig == nil {
+			return nil, fmt.Errorf("failed to parse input %d", i)
+		}
 		t.Inputs = append(t.Inputs, ip)
 	}
 
@@ -219,11 +250,19 @@ func ParseSegwit(serialization []byte) (*Transaction, error) {
 	// Parse the outputs
 	//
 	// first is the varint for the length fof the inputs
-	numOfOutputs, _ := binary.ReadUvarint(reader)
+	numOfOutputs := utils.ReadVarIntFromBytes(reader)
+
+	// each output is at least 9 bytes
+	if numOfOutputs > uint64(reader.Len()/9) {
+		return nil, fmt.Errorf("transaction claims %d outputs which do not fit the remaining data", numOfOutputs)
+	}
 
 	// iterate over the outputs and append them to the outputs list
 	for i := 0; i < int(numOfOutputs); i++ {
 		op := ParseTransactionOutput(reader)
+		if op.ScriptPubkey == nil {
+			return nil, fmt.Errorf("failed to parse output %d", i)
+		}
 		t.Outputs = append(t.Outputs, op)
 	}
 
@@ -231,105 +270,50 @@ func ParseSegwit(serialization []byte) (*Transaction, error) {
 	// Parse the witness program
 	//
 	// each input needs a witness in order to spend it in "this" transaction
-	for i, _ := range t.Inputs {
-		// read in the number of witness items
-		// it can be variable in the case of multisig stuff
-		// like a set of signatures, or something else
-		numWitnesses := utils.ReadVarIntFromBytes(reader)
-
-		// witnesses will be an array of byte arrays
-		witnessess := [][]byte{}
-
-		// iterate over the witness programs
-		for i := 0; i < int(numWitnesses); i++ {
-			witnessLength := utils.ReadVarIntFromBytes(reader)
-
-			// if the witness program is nothing, push in a null byte
-			if witnessLength == 0 {
-				witnessess = append(witnessess, []byte{0x00})
+	if t.Segwit {
+		for i := range t.Inputs {
+			// read in the number of witness items
+			// it can be variable in the case of multisig stuff
+			// like a set of signatures, or something else
+			numWitnesses := utils.ReadVarIntFromBytes(reader)
+			if numWitnesses > uint64(reader.Len()) {
+				return nil, fmt.Errorf("input %d claims %d witness items which do not fit the remaining data", i, numWitnesses)
 			}
 
-			// otherwise, read in N bytes as the witness program
-			witnessProgram, _ := ioutil.ReadAll(io.LimitReader(reader, int64(witnessLength)))
-
-			// add in the just parsed withness program
-			witnessess = append(witnessess, witnessProgram)
-		}
-
-		// witness data is coupled with the input
-		t.Inputs[i].Witness = witnessess
-	}
-
-	//
-	// Parse the locktime
-	//
-	t.Locktime = utils.LittleEndianToInt(reader)
-
-	// set the internal segwit flag
-	t.Segwit = true
+			// witnesses will be an array of byte arrays
+			witnessess := [][]byte{}
 
-	return t, nil
-}
+			// iterate over the witness programs
+			for j := 0; j < int(numWitnesses); j++ {
+				witnessLength := utils.ReadVarIntFromBytes(reader)
 
-// Parse a transaction from a byte stream
-func ParseTransaction(serialization []byte) (*Transaction, error) {
-	t := &Transaction{}
+				// an empty item is an empty element on the witness stack
+				if witnessLength == 0 {
+					witnessess = append(witnessess, []byte{})
+					continue
+				}
 
-	// make a reader to easily read in the serialization
-	reader := bytes.NewReader(serialization)
+				// otherwise, read in N bytes as the witness program
+				witnessProgram, _ := ioutil.ReadAll(io.LimitReader(reader, int64(witnessLength)))
+				if uint64(len(witnessProgram)) != witnessLength {
+					return nil, fmt.Errorf("truncated witness item %d of input %d", j, i)
+				}
 
-	// segwith bolt-on, read 4 bytes and ignore and look for
-	// the segwit marker in the 5th byte of the transaction
-	for i := 0; i < 4; i++ {
-		_, err := reader.ReadByte()
+				// add in the just parsed withness program
+				witnessess = append(witnessess, witnessProgram)
+			}
 
-		if err != nil {
-			return nil, err
+			// witness data is coupled with the input
+			t.Inputs[i].Witness = witnessess
 		}
 	}
 
-	// now read for the segwith marker, if we find it, go the special
-	// segwit parser, otherwise, reset the stream and continue with
-	// the originally defined processing path
-	segwit, _ := reader.ReadByte()
-	if segwit == 0x00 {
-		return ParseSegwit(serialization)
-	} else {
-		reader.Reset(serialization)
-	}
-
-	//
-	// parse the version
-	//
-	t.Version = utils.LittleEndianToInt(reader)
-
-	//
-	// Parse the inputs
-	//
-	// first is the varint for the length of the inputs
-	numOfInputs, _ := binary.ReadUvarint(reader)
-
-	// iterate over the inputs and append them to the inputs list
-	for i := 0; i < int(numOfInputs); i++ {
-		ip := ParseTransactionInput(reader)
-		t.Inputs = append(t.Inputs, ip)
-	}
-
-	//
-	// Parse the outputs
-	//
-	// first is the varint for the length fof the inputs
-	numOfOutputs, _ := binary.ReadUvarint(reader)
-
-	// iterate over the outputs and append them to the outputs list
-	for i := 0; i < int(numOfOutputs); i++ {
-		op := ParseTransactionOutput(reader)
-		t.Outputs = append(t.Outputs, op)
-	}
-
 	//
 	// Parse the locktime
 	//
+	if reader.Len() < 4 {
+		return nil, fmt.Errorf("transaction is missing the locktime")
+	}
 	t.Locktime = utils.LittleEndianToInt(reader)
 
 	return t, nil
@@ -458,194 +442,36 @@ func (t Transaction) SigHash(inputIndex int, redeemScript *script.Script, sigHas
 	return new(big.Int).SetBytes(h256), nil
 }
 
-func (t Transaction) HashPrevOuts() []byte {
-	// if we have not set the prevOuts for this transaction, then we need to set them
-	if len(t.hashPrevOuts) == 0 {
-		allPrevOuts := []byte{}
-		allSequence := []byte{}
-
-		// construct a byte array of format
-		// prevHash1 + length1 ... prevHashN + lengthN
-		for _, txin := range t.Inputs {
-			allPrevOuts = append(
-				allPrevOuts,
-				utils.ImmutableReorderBytes(txin.PrevTx)...,
-			)
-			allPrevOuts = append(
-				allPrevOuts,
-				utils.IntToLittleEndianBytes(txin.PrevIndex)...,
-			)
-			allSequence = append(
-				allSequence,
-				utils.IntToLittleEndianBytes(txin.Sequence)...,
-			)
-		}
-
-		// we now have two byte arrays, one with the serialization of all the txins hash + index
-		// and one for all the sequences
-
-		// Set self to the values
-		t.hashPrevOuts = utils.Hash256(allPrevOuts)
-		t.hashSequence = utils.Hash256(allSequence)
-	}
-
-	return t.hashPrevOuts
-}
-
-func (t Transaction) HashOutputs() []byte {
-	if len(t.hashPrevOuts) == 0 {
-		var allOutputs []byte
-		for _, txout := range t.Outputs {
-			allOutputs = append(allOutputs, txout.Serialize()...)
-		}
-		t.hashOutputs = allOutputs
-	}
-
-	return t.hashOutputs
-}
-
-// Returns the big.Int representation of the hash that needs to be signed for the index inputIndex
-// Signing the inputs is unlocking the prevOut from the previous transaction
-func (t Transaction) SigHashSegwit(inputIndex int, redeemScript, witnessScript *script.Script) (*big.Int, error) {
-
-	// TODO check bounds on the inputIndex - Probably should be defensivily programming everywhere
-	txin := t.Inputs[inputIndex]
-
-	// This is all done per BIP143 Spec which I just dupped with the python code is doing
-	var s []byte
-	s = utils.IntToLittleEndianBytes(t.Version)
-	s = append(s, t.hashPrevOuts...)
-	s = append(s, t.hashSequence...)
-	s = append(s, utils.ImmutableReorderBytes(txin.PrevTx)...)
-	s = append(s, utils.IntToLittleEndianBytes(txin.PrevIndex)...)
-
-	// handle the supplied scripts script
-	// TODO - Grok this block
-	var scriptCode []byte
-	if witnessScript != nil {
-
-		// witness sript was supplied, this is a p2wpkh
-		scriptCode = append(scriptCode, witnessScript.Serialize()...)
-	} else if redeemScript != nil {
-		// if there is a redeem script and no witness script, then this is a p2sh-p2wpkh
-		scriptCode = append(
-			scriptCode,
-			// make a p2pkh serialization
-			script.MakeP2pkh(redeemScript.Commands[1].Bytes).Serialize()...,
-		)
-	} else {
-
-		pubkey, err := txin.ScriptPubkey(t.Testnet)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get ScriptPubKey because for input idx %d because %s", inputIndex, err.Error())
-		}
-
-		scriptCode = append(scriptCode,
-			script.Makep2sh(pubkey.Commands[1].Bytes).Serialize()...,
-		)
-	}
-
-	// get the amounts for the utxo's for the previous transaction
-	s = append(s, scriptCode...)
-	val, err := txin.Value(t.Testnet)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get the value for the outputs because %s", err.Error())
-	}
-	s = append(s, utils.UInt64ToLittleEndianBytes(uint64(val))...)
-	s = append(s, utils.IntToLittleEndianBytes(txin.Sequence)...)
-	s = append(s, t.HashOutputs()...)
-	s = append(s, utils.IntToLittleEndianBytes(t.Locktime)...)
-	s = append(s, utils.IntToLittleEndianBytes(int(SIGHASH_ALL))...)
-
-	// now that we have s, which is what is to be signed for the transaction during transaction signing
-	// we calculate the hash. The hash of this "serialization" is what is signed during transaction signing
-	h256 := utils.Hash256(s)
-
-	// make the big int
-	return new(big.Int).SetBytes(h256), nil
-
-}
-
-// Verify the input can be spent by this wallet
+// Verify the input can be spent by this wallet. Fetches the output being spent
+// and checks it against the consensus rules
 func (t Transaction) VerifyInput(inputIndex int) (bool, error) {
-
-	// get the input transaction referenced by the index
 	txIn := t.Inputs[inputIndex]
 
-	// pull off the prevcious output ScriptPubKey. This can be any of the
-	// transaction types
-	scriptPubkey, err := txIn.ScriptPubkey(t.Testnet)
-
+	prevTx, err := txIn.FetchTx(t.Testnet)
 	if err != nil {
 		return false, fmt.Errorf("failed to get ScriptPubKey because %s", err.Error())
 	}
-
-	// check the pubkey type. If it is a P2SH, we need to create
-	// the redeem script to spend the funds.
-	// If it is p2wphk, we need to handle it differently as there
-	// will need to parse the witness program
-	var redeemScript *script.Script
-	z := new(big.Int)
-	var witness [][]byte
-	if scriptPubkey.IsP2shScriptPubkey() {
-		// Get the redeem script off the input, which is the last element
-		cmd := txIn.ScriptSig.Commands[len(txIn.ScriptSig.Commands)-1]
-
-		// Scripts always start with the length of the script, so add in the length
-		// of the script so we can parse it correctly
-		redeemScriptLenBytes := make([]byte, 8)
-		binary.BigEndian.PutUint32(redeemScriptLenBytes, uint32(len(cmd.Bytes)))
-		redeemScriptBytes := append(redeemScriptLenBytes, cmd.Bytes...)
-
-		// Parse the script into a script
-		var err error
-		redeemScript, err = script.Parse(bytes.NewReader(redeemScriptBytes))
-		if err != nil {
-			return false, err
-		}
-
-		// handle p2sh-pwpkh type. This is where the witness signature
-		// was embedded in the redeem script.
-		// The script embedded could be either w p2wpkh or p2wsh type script
-		if redeemScript.IsP2wpkhScriptPubkey() {
-			// calculate Z the special way
-			z, err = t.SigHashSegwit(inputIndex, redeemScript, nil)
-
-			if err != nil {
-				return false, fmt.Errorf("failed to calculate z because %s", err.Error())
-			}
-
-			// since we are segwit aware, assign the witness data field
-			witness = txIn.Witness
-		} else {
-			witness = nil
-		}
-
-	} else if scriptPubkey.IsP2wpkhScriptPubkey() {
-		// handle segwit type Outpoint (prevHash.ScriptPubKey)
-		// most of the processing path is the same as p2sh, we just
-		// need to handle the witness data correctly in this path
-		z, err = t.SigHashSegwit(inputIndex, nil, nil)
-		if err != nil {
-			return false, fmt.Errorf("failed to calculate z because %s", err.Error())
-		}
-		witness = t.Witness
-	} else {
-		z, err = t.SigHash(inputIndex, nil, SIGHASH_ALL, true)
-		if err != nil {
-			return false, fmt.Errorf("failed to calculate z because %s", err.Error())
-		}
-		witness = nil
-		redeemScript = nil
+	if txIn.PrevIndex >= len(prevTx.Outputs) {
+		return false, fmt.Errorf("failed to get ScriptPubKey because output %d does not exist", txIn.PrevIndex)
 	}
+	prevOut := prevTx.Outputs[txIn.PrevIndex]
 
-	// Combine the scripts
-	combinedScript := script.Combine(*scriptPubkey, *txIn.ScriptSig)
+	if err := t.VerifyInputScript(inputIndex, prevOut.ScriptPubkey, prevOut.Amount, script.CONSENSUS_SCRIPT_VERIFY_FLAGS); err != nil {
+		return false, fmt.Errorf("failed to verify input %d because %w", inputIndex, err)
+	}
+	return true, nil
+}
 
-	// valuate the transaction. If it evaluates to true, then the redeem script
-	// or the pub key supplied is valid for the transaction and is allowed
-	// to spend the funds encumbered with this
-	return combinedScript.Evaluate(z, uint64(t.Locktime), uint64(txIn.Sequence), uint64(t.Version), witness), nil
+// Verify the input spends the output with the script pubkey and amount under
+// the flags, without looking the output up. Script failures are returned as
+// a *script.ScriptError
+func (t Transaction) VerifyInputScript(inputIndex int, scriptPubkey *script.Script, amount uint64, flags script.VerifyFlags) error {
+	if inputIndex < 0 || inputIndex >= len(t.Inputs) {
+		return fmt.Errorf("failed to verify input %d because the transaction has %d inputs", inputIndex, len(t.Inputs))
+	}
+	txIn := t.Inputs[inputIndex]
+	checker := MakeSignatureChecker(&t, inputIndex, amount)
+	return script.VerifyScript(txIn.ScriptSig, scriptPubkey, txIn.Witness, flags, checker)
 }
 
 // verify the transaction is valid
@@ -1,6 +1,7 @@
 package tx
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"math/big"
@@ -230,3 +231,59 @@ func TestVerityP2wpkh(t *testing.T) {
 		t.Fatalf("failed to verify tranansaction")
 	}
 }
+
+func TestReadTransaction(t *testing.T) {
+	raw, _ := hex.DecodeString(testTx)
+
+	// two transactions back to back as they are packed in a block
+	reader := bytes.NewReader(append(append([]byte{}, raw...), raw...))
+	for i := 0; i < 2; i++ {
+		tx, err := ReadTransaction(reader)
+		if err != nil {
+			t.Fatalf("failed to read transaction %d because %s", i, err.Error())
+		}
+		if len(tx.Inputs) != 4 || len(tx.Outputs) != 2 {
+			t.Fatalf("transaction %d parsed incorrectly", i)
+		}
+		if !bytes.Equal(tx.Serialize(), raw) {
+			t.Fatalf("transaction %d did not round trip", i)
+		}
+	}
+
+	if reader.Len() != 0 {
+		t.Fatalf("%d bytes left over", reader.Len())
+	}
+
+	if _, err := ReadTransaction(bytes.NewReader(raw[:len(raw)-2])); err == nil {
+		t.Fatal("read a truncated transaction")
+	}
+}
+
+// signed native p2wpkh example from BIP143
+const testSegwitTx = "01000000000102fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f00000000494830450221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ecbab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac000247304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb1366d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c212a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566bb0ad253f62fc70f07aeee635711000000"
+
+func TestSerializeSegwit(t *testing.T) {
+	raw, _ := hex.DecodeString(testSegwitTx)
+
+	tx, err := ParseSegwit(raw)
+	if err != nil {
+		t.Fatalf("failed to parse the transaction because %s", err.Error())
+	}
+	if !bytes.Equal(tx.Serialize(), raw) {
+		t.Fatalf("transaction did not round trip\n%x", tx.Serialize())
+	}
+
+	// the wtxid covers the witness, the txid does not
+	if !bytes.Equal(tx.WitnessHash(), utils.MutableReorderBytes(utils.Hash256(raw))) {
+		t.Fatal("unexpected wtxid")
+	}
+	if bytes.Equal(tx.Hash(), tx.WitnessHash()) {
+		t.Fatal("txid covers the witness")
+	}
+
+	legacy, _ := hex.DecodeString(testTx)
+	tx, _ = ParseTransaction(legacy)
+	if !bytes.Equal(tx.Hash(), tx.WitnessHash()) {
+		t.Fatal("legacy wtxid differs from the txid")
+	}
+}
@@ -0,0 +1,326 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script"
+	"github.com/ryohare/programming-bitcoin-go/pkg/bitcoin/script/opcodes"
+)
+
+// The files in testdata are Bitcoin Core's src/test/data vectors, unmodified
+// from the v0.21.0 tag (commit 95ea54ba089610019a74c1176a2c7c0dba144b1c).
+// tx_valid.json lists the flags to verify with, not the ones to leave out
+// as later versions of the file do.
+
+// Vectors of the files in testdata the interpreter is known to get wrong, by
+// their index in the file. A listed vector which passes fails the test so the
+// list only ever shrinks.
+var knownVectorFailures = map[string][]int{
+	"script_tests.json": {},
+	"tx_valid.json":     {},
+	"tx_invalid.json":   {},
+}
+
+// Upper bound of the money supply in satoshi
+const MAX_MONEY = 21000000 * 100000000
+
+// Loads the vectors of the JSON file in testdata. Vectors of a single string
+// are comments and left out
+func loadVectors(t *testing.T, name string) map[int][]interface{} {
+	raw, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read %s because %s", name, err.Error())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var entries [][]interface{}
+	if err := decoder.Decode(&entries); err != nil {
+		t.Fatalf("failed to decode %s because %s", name, err.Error())
+	}
+
+	vectors := map[int][]interface{}{}
+	for i, entry := range entries {
+		if len(entry) == 1 {
+			if _, ok := entry[0].(string); ok {
+				continue
+			}
+		}
+		vectors[i] = entry
+	}
+	return vectors
+}
+
+// Runs every vector in a subtest. Known failures are skipped when they fail
+// and fail when they pass
+func runVectors(t *testing.T, name string, run func(vector []interface{}) (bool, string)) {
+	vectors := loadVectors(t, name)
+
+	known := map[int]bool{}
+	for _, i := range knownVectorFailures[name] {
+		known[i] = true
+	}
+
+	indexes := make([]int, 0, len(vectors))
+	for i := range vectors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	passed := 0
+	for _, i := range indexes {
+		vector := vectors[i]
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ok, skip := runVector(run, vector)
+			switch {
+			case skip != "":
+				t.Skip(skip)
+			case ok && known[i]:
+				t.Errorf("vector %d %v passes, remove it from the known failures", i, vector)
+			case ok:
+				passed++
+			case known[i]:
+				t.Skipf("known failure %v", vector)
+			default:
+				t.Errorf("vector %d failed %v", i, vector)
+			}
+		})
+	}
+	t.Logf("%s: %d vectors passed", name, passed)
+}
+
+// Runs the vector, a panic failing it
+func runVector(run func(vector []interface{}) (bool, string), vector []interface{}) (ok bool, skip string) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok, skip = false, ""
+		}
+	}()
+	return run(vector)
+}
+
+// Parses the flags of a vector. Vectors using flags the interpreter doesn't
+// have are skipped
+func parseVectorFlags(names string) (script.VerifyFlags, string) {
+	flags, err := script.ParseVerifyFlags(names)
+	if err != nil {
+		return 0, err.Error()
+	}
+	return flags, ""
+}
+
+// Builds the transaction creating the output spent in script_tests.json,
+// the same as Bitcoin Core's BuildCreditingTransaction
+func buildCreditingTransaction(scriptPubkey *script.Script, amount uint64) *Transaction {
+	scriptSig, _ := script.ParseCoreAsm("0 0")
+	return &Transaction{
+		Version: 1,
+		Inputs:  []*TransactionInput{MakeTransactionInput(make([]byte, 32), 0xffffffff, scriptSig, 0xffffffff)},
+		Outputs: []*TransactionOutput{{Amount: amount, ScriptPubkey: scriptPubkey}},
+	}
+}
+
+// Builds the transaction spending the crediting transaction, the same as
+// Bitcoin Core's BuildSpendingTransaction
+func buildSpendingTransaction(scriptSig *script.Script, witness [][]byte, credit *Transaction) *Transaction {
+	txIn := MakeTransactionInput(credit.Hash(), 0, scriptSig, 0xffffffff)
+	txIn.Witness = witness
+	return &Transaction{
+		Version: 1,
+		Inputs:  []*TransactionInput{txIn},
+		Outputs: []*TransactionOutput{{Amount: credit.Outputs[0].Amount, ScriptPubkey: script.MakeScript()}},
+		Segwit:  len(witness) > 0,
+	}
+}
+
+func runScriptVector(vector []interface{}) (bool, string) {
+	var witness [][]byte
+	var amount uint64
+
+	// an optional witness comes first, its last element the amount in BTC
+	if items, ok := vector[0].([]interface{}); ok {
+		for _, item := range items[:len(items)-1] {
+			b, err := hex.DecodeString(item.(string))
+			if err != nil {
+				return false, fmt.Sprintf("bad witness %s", item)
+			}
+			witness = append(witness, b)
+		}
+		btc, _ := items[len(items)-1].(json.Number).Float64()
+		amount = uint64(math.Round(btc * 100000000))
+		vector = vector[1:]
+	}
+	if len(vector) < 4 {
+		return false, "malformed vector"
+	}
+
+	flags, skip := parseVectorFlags(vector[2].(string))
+	if skip != "" {
+		return false, skip
+	}
+	// like Core's test, CLEANSTACK brings the flags it needs with it
+	if flags.Has(script.SCRIPT_VERIFY_CLEANSTACK) {
+		flags |= script.SCRIPT_VERIFY_P2SH | script.SCRIPT_VERIFY_WITNESS
+	}
+	expected := vector[3].(string)
+
+	// scripts which can't be parsed end in a truncated push, which Core
+	// fails on when it's reached
+	scriptSig, err := script.ParseCoreAsm(vector[0].(string))
+	if err != nil {
+		return expected == opcodes.ScriptErrBadOpcode.Name(), ""
+	}
+	scriptPubkey, err := script.ParseCoreAsm(vector[1].(string))
+	if err != nil {
+		return expected == opcodes.ScriptErrBadOpcode.Name(), ""
+	}
+
+	credit := buildCreditingTransaction(scriptPubkey, amount)
+	spend := buildSpendingTransaction(scriptSig, witness, credit)
+
+	err = spend.VerifyInputScript(0, scriptPubkey, amount, flags)
+	return vectorErrorName(script.ErrorCode(err)) == expected, ""
+}
+
+// Returns the name of the code in the vectors. Core reports numbers which
+// are too long or not minimally encoded as an unknown error
+func vectorErrorName(code opcodes.ErrorCode) string {
+	if code == opcodes.ScriptErrNumOverflow || code == opcodes.ScriptErrNumMinimal {
+		return opcodes.ScriptErrUnknown.Name()
+	}
+	return code.Name()
+}
+
+func TestScriptVectors(t *testing.T) {
+	runVectors(t, "script_tests.json", runScriptVector)
+}
+
+// The context free checks of Bitcoin Core's CheckTransaction
+func checkTransaction(t *Transaction) error {
+	if len(t.Inputs) == 0 {
+		return fmt.Errorf("no inputs")
+	}
+	if len(t.Outputs) == 0 {
+		return fmt.Errorf("no outputs")
+	}
+
+	var total uint64
+	for _, txOut := range t.Outputs {
+		if txOut.Amount > MAX_MONEY {
+			return fmt.Errorf("output above the money supply")
+		}
+		total += txOut.Amount
+		if total > MAX_MONEY {
+			return fmt.Errorf("outputs above the money supply")
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, txIn := range t.Inputs {
+		outpoint := fmt.Sprintf("%x:%d", txIn.PrevTx, txIn.PrevIndex)
+		if seen[outpoint] {
+			return fmt.Errorf("duplicate input %s", outpoint)
+		}
+		seen[outpoint] = true
+	}
+
+	if t.IsCoinbase() {
+		if size := len(t.Inputs[0].ScriptSig.Serialize()) - 1; size < 2 || size > 100 {
+			return fmt.Errorf("coinbase scriptSig of %d bytes", size)
+		}
+		return nil
+	}
+	for _, txIn := range t.Inputs {
+		if bytes.Equal(txIn.PrevTx, make([]byte, 32)) && txIn.PrevIndex == 0xffffffff {
+			return fmt.Errorf("null prevout")
+		}
+	}
+	return nil
+}
+
+// Runs a vector of tx_valid.json or tx_invalid.json, returning whether the
+// transaction is valid
+func verifyTxVector(vector []interface{}, valid bool) (bool, string) {
+	if len(vector) < 3 {
+		return false, "malformed vector"
+	}
+
+	type prevout struct {
+		scriptPubkey *script.Script
+		amount       uint64
+	}
+	prevouts := map[string]prevout{}
+	for _, item := range vector[0].([]interface{}) {
+		fields := item.([]interface{})
+		index, _ := fields[1].(json.Number).Int64()
+		scriptPubkey, err := script.ParseCoreAsm(fields[2].(string))
+		if err != nil {
+			return false, fmt.Sprintf("bad prevout script %s", fields[2])
+		}
+		var amount int64
+		if len(fields) > 3 {
+			amount, _ = fields[3].(json.Number).Int64()
+		}
+		prevouts[fmt.Sprintf("%s:%d", fields[0], uint32(index))] = prevout{scriptPubkey, uint64(amount)}
+	}
+
+	raw, err := hex.DecodeString(vector[1].(string))
+	if err != nil {
+		return false, "bad transaction hex"
+	}
+	t, err := ReadTransaction(bytes.NewReader(raw))
+	if err != nil {
+		return false, ""
+	}
+
+	names := vector[2].(string)
+	if names == "BADTX" {
+		return checkTransaction(t) == nil, ""
+	}
+	if checkTransaction(t) != nil {
+		return false, ""
+	}
+
+	flags, skip := parseVectorFlags(names)
+	if skip != "" {
+		return false, skip
+	}
+
+	// Core never runs the scripts of a coinbase
+	if t.IsCoinbase() {
+		return true, ""
+	}
+	for i, txIn := range t.Inputs {
+		prev, ok := prevouts[fmt.Sprintf("%x:%d", txIn.PrevTx, uint32(txIn.PrevIndex))]
+		if !ok {
+			return false, ""
+		}
+		if t.VerifyInputScript(i, prev.scriptPubkey, prev.amount, flags) != nil {
+			return false, ""
+		}
+	}
+	return true, ""
+}
+
+func TestTxValidVectors(t *testing.T) {
+	runVectors(t, "tx_valid.json", func(vector []interface{}) (bool, string) {
+		return verifyTxVector(vector, true)
+	})
+}
+
+func TestTxInvalidVectors(t *testing.T) {
+	runVectors(t, "tx_invalid.json", func(vector []interface{}) (bool, string) {
+		valid, skip := verifyTxVector(vector, false)
+		return !valid, skip
+	})
+}
@@ -0,0 +1,304 @@
+package secp256k1
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// ElligatorSwift encoding of public keys as used by BIP324. A public key is
+// encoded as two field elements (u, t), 64 bytes, which are indistinguishable
+// from uniformly random bytes. Only the x coordinate of the key is encoded.
+
+// Size of an ElligatorSwift encoded public key
+const ELLSWIFT_SIZE = 64
+
+// sqrt(-3) mod p, as (-3)^((p+1)/4)
+var minus3Sqrt = func() *big.Int {
+	p := GetPrime()
+	return fieldSqrt(new(big.Int).Sub(p, big.NewInt(3)))
+}()
+
+// helpers for field arithmetic mod p, all results are reduced
+func fieldMod(a *big.Int) *big.Int {
+	return a.Mod(a, GetPrime())
+}
+
+func fieldInv(a *big.Int) *big.Int {
+	return new(big.Int).ModInverse(a, GetPrime())
+}
+
+// returns the square root of a mod p, or nil if a is not a square
+func fieldSqrt(a *big.Int) *big.Int {
+	p := GetPrime()
+	e := new(big.Int).Add(p, big.NewInt(1))
+	e.Rsh(e, 2)
+
+	r := new(big.Int).Exp(a, e, p)
+	check := new(big.Int).Mul(r, r)
+	if fieldMod(check).Cmp(new(big.Int).Mod(a, p)) != 0 {
+		return nil
+	}
+	return r
+}
+
+// x^3 + 7
+func curveRhs(x *big.Int) *big.Int {
+	r := new(big.Int).Exp(x, big.NewInt(3), GetPrime())
+	return fieldMod(r.Add(r, big.NewInt(B)))
+}
+
+// checks there is a point on the curve with the x coordinate
+func isValidX(x *big.Int) bool {
+	return fieldSqrt(curveRhs(x)) != nil
+}
+
+// Decodes the field elements (u, t) to the x coordinate of a point on the curve
+func XSwiftEC(u, t *big.Int) *big.Int {
+	p := GetPrime()
+	u = new(big.Int).Mod(u, p)
+	t = new(big.Int).Mod(t, p)
+
+	if u.Sign() == 0 {
+		u.SetInt64(1)
+	}
+	if t.Sign() == 0 {
+		t.SetInt64(1)
+	}
+
+	u3 := new(big.Int).Exp(u, big.NewInt(3), p)
+	t2 := new(big.Int).Mul(t, t)
+
+	// u^3 + t^2 + 7 == 0 has no solution, so move t away from it
+	if fieldMod(new(big.Int).Add(new(big.Int).Add(u3, t2), big.NewInt(B))).Sign() == 0 {
+		t = fieldMod(t.Lsh(t, 1))
+		t2 = new(big.Int).Mul(t, t)
+	}
+
+	// X = (u^3 + 7 - t^2) / 2t
+	X := new(big.Int).Add(u3, big.NewInt(B))
+	X.Sub(X, t2)
+	X.Mul(X, fieldInv(fieldMod(new(big.Int).Lsh(t, 1))))
+	fieldMod(X)
+
+	// Y = (X + t) / (sqrt(-3) * u)
+	Y := new(big.Int).Add(X, t)
+	Y.Mul(Y, fieldInv(fieldMod(new(big.Int).Mul(minus3Sqrt, u))))
+	fieldMod(Y)
+
+	// x1 = u + 4Y^2
+	x1 := new(big.Int).Mul(Y, Y)
+	x1.Lsh(x1, 2)
+	x1 = fieldMod(x1.Add(x1, u))
+	if isValidX(x1) {
+		return x1
+	}
+
+	// x2 = (-X/Y - u) / 2, x3 = (X/Y - u) / 2
+	half := fieldInv(big.NewInt(2))
+	XoverY := fieldMod(new(big.Int).Mul(X, fieldInv(Y)))
+
+	x2 := new(big.Int).Neg(XoverY)
+	x2.Sub(x2, u)
+	x2 = fieldMod(x2.Mul(x2, half))
+	if isValidX(x2) {
+		return x2
+	}
+
+	x3 := new(big.Int).Sub(XoverY, u)
+	return fieldMod(x3.Mul(x3, half))
+}
+
+// Finds t such that XSwiftEC(u, t) = x, or nil if there is none for the case.
+// The case, 0 to 7, selects which of the up to 8 preimages to return.
+func XSwiftECInv(x, u *big.Int, c int) *big.Int {
+	p := GetPrime()
+	x = new(big.Int).Mod(x, p)
+	u = new(big.Int).Mod(u, p)
+
+	u2 := new(big.Int).Mul(u, u)
+	u3p7 := fieldMod(new(big.Int).Add(new(big.Int).Mul(u2, u), big.NewInt(B)))
+
+	var s, v *big.Int
+	if c&2 == 0 {
+		// x must not be reachable as the third candidate
+		if isValidX(fieldMod(new(big.Int).Neg(new(big.Int).Add(x, u)))) {
+			return nil
+		}
+
+		// s = -(u^3 + 7) / (u^2 + uv + v^2)
+		v = x
+		d := new(big.Int).Add(u2, new(big.Int).Mul(u, v))
+		d = fieldMod(d.Add(d, new(big.Int).Mul(v, v)))
+		if d.Sign() == 0 {
+			return nil
+		}
+		s = fieldMod(new(big.Int).Mul(new(big.Int).Neg(u3p7), fieldInv(d)))
+	} else {
+		s = fieldMod(new(big.Int).Sub(x, u))
+		if s.Sign() == 0 {
+			return nil
+		}
+
+		// r = sqrt(-s * (4(u^3 + 7) + 3su^2))
+		q := new(big.Int).Lsh(u3p7, 2)
+		q.Add(q, new(big.Int).Mul(big.NewInt(3), new(big.Int).Mul(s, u2)))
+		q = fieldMod(q.Mul(q, new(big.Int).Neg(s)))
+		r := fieldSqrt(q)
+		if r == nil {
+			return nil
+		}
+		// the odd cases only differ in the sign of sqrt(-3) below, so they
+		// would repeat the even case when r is zero
+		if c&1 == 1 && r.Sign() == 0 {
+			return nil
+		}
+
+		// v = (-u + r/s) / 2
+		v = new(big.Int).Mul(r, fieldInv(s))
+		v.Sub(v, u)
+		v = fieldMod(v.Mul(v, fieldInv(big.NewInt(2))))
+	}
+
+	w := fieldSqrt(s)
+	if w == nil {
+		return nil
+	}
+
+	// u * (1 -/+ sqrt(-3)) / 2 + v
+	k := new(big.Int).Set(minus3Sqrt)
+	if c&1 == 0 {
+		k.Neg(k)
+	}
+	k.Add(k, big.NewInt(1))
+	k.Mul(k, u)
+	k.Mul(k, fieldInv(big.NewInt(2)))
+	k.Add(k, v)
+	k = fieldMod(k)
+
+	t := fieldMod(new(big.Int).Mul(w, k))
+	switch c & 5 {
+	case 0, 5:
+		t = fieldMod(t.Neg(t))
+	}
+	return t
+}
+
+// reads a uniformly random non zero field element
+func randomFieldElement(random io.Reader) (*big.Int, error) {
+	p := GetPrime()
+	for {
+		b := make([]byte, 32)
+		if _, err := io.ReadFull(random, b); err != nil {
+			return nil, err
+		}
+		u := new(big.Int).SetBytes(b)
+		if u.Sign() != 0 && u.Cmp(p) < 0 {
+			return u, nil
+		}
+	}
+}
+
+// Encodes the x coordinate as a random ElligatorSwift encoding (u, t), 64
+// bytes, using the randomness from the reader
+func XElligatorSwift(x *big.Int, random io.Reader) ([]byte, error) {
+	if !isValidX(x) {
+		return nil, fmt.Errorf("x is not on the curve")
+	}
+
+	for {
+		u, err := randomFieldElement(random)
+		if err != nil {
+			return nil, err
+		}
+
+		c := make([]byte, 1)
+		if _, err := io.ReadFull(random, c); err != nil {
+			return nil, err
+		}
+
+		t := XSwiftECInv(x, u, int(c[0]&7))
+		if t == nil || XSwiftEC(u, t).Cmp(x) != 0 {
+			continue
+		}
+
+		encoded := make([]byte, ELLSWIFT_SIZE)
+		u.FillBytes(encoded[:32])
+		t.FillBytes(encoded[32:])
+		return encoded, nil
+	}
+}
+
+// Decodes an ElligatorSwift encoding to the x coordinate it encodes
+func EllSwiftDecode(encoded []byte) (*big.Int, error) {
+	if len(encoded) != ELLSWIFT_SIZE {
+		return nil, fmt.Errorf("ellswift encoding must be %d bytes, got %d", ELLSWIFT_SIZE, len(encoded))
+	}
+	u := new(big.Int).SetBytes(encoded[:32])
+	t := new(big.Int).SetBytes(encoded[32:])
+	return XSwiftEC(u, t), nil
+}
+
+// Returns the ElligatorSwift encoding of the public key of the secret. When
+// random is nil crypto/rand is used
+func EllSwiftCreate(secret *big.Int, random io.Reader) ([]byte, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+
+	pub, err := RMultiply(*GetGeneratorPoint(), *secret)
+	if err != nil {
+		return nil, err
+	}
+	return XElligatorSwift(pub.Point.X.Num, random)
+}
+
+// Computes the x coordinate of the secret multiplied by the point encoded by
+// the other party, 32 bytes. Both parties arrive at the same value.
+func EllSwiftECDHXOnly(theirs []byte, secret *big.Int) ([]byte, error) {
+	x, err := EllSwiftDecode(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	// either y works, the x coordinate of the product is the same
+	y := fieldSqrt(curveRhs(x))
+	shared, err := RMultiply(*MakePoint(x, y), *secret)
+	if err != nil {
+		return nil, err
+	}
+	if shared.Point.X == nil {
+		return nil, fmt.Errorf("shared secret is the point at infinity")
+	}
+
+	return shared.Point.X.Num.FillBytes(make([]byte, 32)), nil
+}
+
+// BIP340 style tagged hash, sha256(sha256(tag) || sha256(tag) || msg)
+func TaggedHash(tag string, msg []byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// Derives the BIP324 shared secret from the encodings of both parties, the
+// initiator's first, and our secret
+func EllSwiftSharedSecret(initiator, responder []byte, secret *big.Int, initiating bool) ([]byte, error) {
+	theirs := initiator
+	if initiating {
+		theirs = responder
+	}
+
+	x, err := EllSwiftECDHXOnly(theirs, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := append(append(append([]byte{}, initiator...), responder...), x...)
+	return TaggedHash("bip324_ellswift_xonly_ecdh", msg), nil
+}
@@ -0,0 +1,166 @@
+package secp256k1
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Reads the rows of a BIP324 test vector file as maps keyed by the columns.
+// The files in testdata are unmodified from bip-0324 in the bips repository
+// at commit 9783d61f1b9c81231581fee026c8e8cb9499d265
+func readVectorCSV(t *testing.T, name string) []map[string]string {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to open %s because %s", name, err.Error())
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read %s because %s", name, err.Error())
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, column := range records[0] {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func hexInt(s string) *big.Int {
+	b, _ := hex.DecodeString(s)
+	return new(big.Int).SetBytes(b)
+}
+
+func TestXSwiftECOnCurve(t *testing.T) {
+	// every input decodes to a point on the curve, including the special cases
+	inputs := [][2]*big.Int{
+		{big.NewInt(0), big.NewInt(0)},
+		{big.NewInt(0), big.NewInt(1)},
+		{GetPrime(), big.NewInt(0)},
+	}
+	for i := 0; i < 32; i++ {
+		u, _ := randomFieldElement(rand.Reader)
+		v, _ := randomFieldElement(rand.Reader)
+		inputs = append(inputs, [2]*big.Int{u, v})
+	}
+
+	for _, in := range inputs {
+		x := XSwiftEC(in[0], in[1])
+		if !isValidX(x) {
+			t.Fatalf("decoded (%x, %x) to %x which is not on the curve", in[0], in[1], x)
+		}
+	}
+}
+
+func TestXSwiftECInv(t *testing.T) {
+	found := 0
+	for i := 0; i < 16; i++ {
+		secret, _ := randomFieldElement(rand.Reader)
+		pub, _ := RMultiply(*GetGeneratorPoint(), *secret)
+		x := pub.Point.X.Num
+		u, _ := randomFieldElement(rand.Reader)
+
+		// every preimage found must decode back to x
+		for c := 0; c < 8; c++ {
+			tt := XSwiftECInv(x, u, c)
+			if tt == nil {
+				continue
+			}
+			found++
+			if XSwiftEC(u, tt).Cmp(x) != 0 {
+				t.Fatalf("case %d preimage does not decode to x", c)
+			}
+		}
+	}
+
+	// on average there are two preimages per u
+	if found < 8 {
+		t.Fatalf("only found %d preimages", found)
+	}
+}
+
+func TestEllSwiftECDH(t *testing.T) {
+	a, _ := randomFieldElement(rand.Reader)
+	b, _ := randomFieldElement(rand.Reader)
+
+	ellA, err := EllSwiftCreate(a, nil)
+	if err != nil {
+		t.Fatalf("failed to encode a because %s", err.Error())
+	}
+	ellB, err := EllSwiftCreate(b, nil)
+	if err != nil {
+		t.Fatalf("failed to encode b because %s", err.Error())
+	}
+
+	// the encoding decodes to the public key
+	pubA, _ := RMultiply(*GetGeneratorPoint(), *a)
+	x, _ := EllSwiftDecode(ellA)
+	if x.Cmp(pubA.Point.X.Num) != 0 {
+		t.Fatal("encoding does not decode to the public key")
+	}
+
+	secretA, err := EllSwiftSharedSecret(ellA, ellB, a, true)
+	if err != nil {
+		t.Fatalf("failed to derive the initiator secret because %s", err.Error())
+	}
+	secretB, err := EllSwiftSharedSecret(ellA, ellB, b, false)
+	if err != nil {
+		t.Fatalf("failed to derive the responder secret because %s", err.Error())
+	}
+	if !bytes.Equal(secretA, secretB) {
+		t.Fatal("parties derived different secrets")
+	}
+
+	// swapping the roles changes the secret
+	swapped, _ := EllSwiftSharedSecret(ellB, ellA, a, false)
+	if bytes.Equal(swapped, secretA) {
+		t.Fatal("secret does not depend on the roles")
+	}
+}
+
+func TestEllSwiftDecodeVectors(t *testing.T) {
+	for i, row := range readVectorCSV(t, "ellswift_decode_test_vectors.csv") {
+		encoded, _ := hex.DecodeString(row["ellswift"])
+		x, err := EllSwiftDecode(encoded)
+		if err != nil {
+			t.Fatalf("failed to decode vector %d because %s", i, err.Error())
+		}
+		if hex.EncodeToString(x.FillBytes(make([]byte, 32))) != row["x"] {
+			t.Fatalf("vector %d (%s) decoded to %x", i, row["comment"], x)
+		}
+	}
+}
+
+func TestXSwiftECInvVectors(t *testing.T) {
+	for i, row := range readVectorCSV(t, "xswiftec_inv_test_vectors.csv") {
+		u, x := hexInt(row["u"]), hexInt(row["x"])
+		for c := 0; c < 8; c++ {
+			expected := row[fmt.Sprintf("case%d_t", c)]
+			tt := XSwiftECInv(x, u, c)
+			if tt == nil {
+				if expected != "" {
+					t.Fatalf("vector %d case %d found no preimage, expected %s", i, c, expected)
+				}
+				continue
+			}
+			if hex.EncodeToString(tt.FillBytes(make([]byte, 32))) != expected {
+				t.Fatalf("vector %d case %d found %x, expected %q", i, c, tt, expected)
+			}
+			if XSwiftEC(u, tt).Cmp(x) != 0 {
+				t.Fatalf("vector %d case %d preimage does not decode to x", i, c)
+			}
+		}
+	}
+}
@@ -182,8 +182,6 @@ func Sqrt(fe1 fe.FieldElement) (*fe.FieldElement, error) {
 		return nil, err
 	}
 
-	fmt.Println(res.String())
-
 	return res, nil
 }
 
@@ -192,13 +190,34 @@ func ParseSec(secBin []byte) (*S256Point, error) {
 		return nil, fmt.Errorf("secBin is too short")
 	}
 	if secBin[0] == 0x04 {
+		if len(secBin) != 65 {
+			return nil, fmt.Errorf("uncompressed sec must be 65 bytes, not %d", len(secBin))
+		}
 		x := new(big.Int).SetBytes(secBin[1:33])
 		y := new(big.Int).SetBytes(secBin[33:65])
+
+		// y^2 = x^3 + 7
+		left := new(big.Int).Exp(y, big.NewInt(2), GetPrime())
+		right := new(big.Int).Exp(x, big.NewInt(3), GetPrime())
+		right.Add(right, GetB()).Mod(right, GetPrime())
+		if x.Cmp(GetPrime()) >= 0 || y.Cmp(GetPrime()) >= 0 || left.Cmp(right) != 0 {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
 		return MakePoint(x, y), nil
 	}
 
+	if secBin[0] != 0x02 && secBin[0] != 0x03 {
+		return nil, fmt.Errorf("unknown sec prefix 0x%02x", secBin[0])
+	}
+	if len(secBin) != 33 {
+		return nil, fmt.Errorf("compressed sec must be 33 bytes, not %d", len(secBin))
+	}
+
 	isEven := secBin[0] == 0x02
 	x := &fe.FieldElement{Num: new(big.Int).SetBytes(secBin[1:]), Prime: GetPrime()}
+	if x.Num.Cmp(GetPrime()) >= 0 {
+		return nil, fmt.Errorf("x is not a field element")
+	}
 
 	// # right side of the equation y^2 = x^3 + 7
 	alpha, err := fe.Exponentiate(x, *big.NewInt(3))
@@ -218,11 +237,16 @@ func ParseSec(secBin []byte) (*S256Point, error) {
 		return nil, err
 	}
 
+	// x^3 + 7 has no square root when x is not on the curve
+	if new(big.Int).Exp(beta.Num, big.NewInt(2), GetPrime()).Cmp(alpha.Num) != 0 {
+		return nil, fmt.Errorf("x is not on the curve")
+	}
+
 	p1 := new(big.Int).Sub(GetPrime(), beta.Num)
 
 	var evenBeta *fe.FieldElement
 	var oddBeta *fe.FieldElement
-	if new(big.Int).Mod(beta.Num, big.NewInt(2)).Cmp(big.NewInt(2)) == 0 {
+	if beta.Num.Bit(0) == 0 {
 		evenBeta = beta
 
 		oddBeta = &fe.FieldElement{Num: p1, Prime: GetPrime()}
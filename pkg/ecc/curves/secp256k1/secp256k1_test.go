@@ -262,3 +262,34 @@ func TestAddress(t *testing.T) {
 		t.Error("generated address does not match the expected value (3)")
 	}
 }
+
+func TestParseSec(t *testing.T) {
+	// keys with odd and even y, compressed and uncompressed
+	for _, secret := range []int64{5000, 5001, 5002, 5003} {
+		priv, _ := MakePrivateKeyFromBigInt(big.NewInt(secret))
+		for _, compressed := range []bool{true, false} {
+			point, err := ParseSec(priv.Point.Sec(compressed))
+			if err != nil {
+				t.Fatalf("failed to parse the sec because %s", err.Error())
+			}
+			if point.Point.X.Num.Cmp(priv.Point.Point.X.Num) != 0 || point.Point.Y.Num.Cmp(priv.Point.Point.Y.Num) != 0 {
+				t.Fatalf("parsed the wrong point for secret %d, compressed %v", secret, compressed)
+			}
+		}
+	}
+
+	priv, _ := MakePrivateKeyFromBigInt(big.NewInt(5000))
+	offCurve := priv.Point.Sec(false)
+	offCurve[64] ^= 0x01
+	for _, bad := range [][]byte{
+		{},
+		priv.Point.Sec(true)[:32],
+		priv.Point.Sec(false)[:64],
+		append([]byte{0x05}, priv.Point.Sec(true)[1:]...),
+		offCurve,
+	} {
+		if _, err := ParseSec(bad); err == nil {
+			t.Fatalf("parsed the bad sec %x", bad)
+		}
+	}
+}
@@ -136,3 +136,93 @@ func ParseSignature(sigBin []byte) (*Signature, error) {
 		S: s,
 	}, nil
 }
+
+// Parses a signature as loosely as the consensus rules do, following Bitcoin
+// Core's ecdsa_signature_parse_der_lax. Lengths may use the long form, R and S
+// may be padded or negative, and bytes after S are ignored. Values longer than
+// 32 bytes parse as a zero signature which never verifies
+func ParseSignatureLax(sigBin []byte) (*Signature, error) {
+	pos := 0
+
+	// sequence tag and length, which is skipped over
+	if pos == len(sigBin) || sigBin[pos] != 0x30 {
+		return nil, fmt.Errorf("bad signature, invalid format")
+	}
+	pos++
+	if pos == len(sigBin) {
+		return nil, fmt.Errorf("bad signature length")
+	}
+	lenByte := int(sigBin[pos])
+	pos++
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(sigBin)-pos {
+			return nil, fmt.Errorf("bad signature length")
+		}
+		pos += lenByte
+	}
+
+	// reads the integer tag and length, returning where the value starts
+	readInteger := func(name string) (int, int, error) {
+		if pos == len(sigBin) || sigBin[pos] != 0x02 {
+			return 0, 0, fmt.Errorf("bad signature, missing %s marker", name)
+		}
+		pos++
+		if pos == len(sigBin) {
+			return 0, 0, fmt.Errorf("bad signature, missing %s length", name)
+		}
+		length := int(sigBin[pos])
+		pos++
+		if length&0x80 != 0 {
+			lenBytes := length - 0x80
+			if lenBytes > len(sigBin)-pos {
+				return 0, 0, fmt.Errorf("bad signature, %s length is too long", name)
+			}
+			for lenBytes > 0 && sigBin[pos] == 0 {
+				pos++
+				lenBytes--
+			}
+			if lenBytes >= 4 {
+				return 0, 0, fmt.Errorf("bad signature, %s length is too long", name)
+			}
+			length = 0
+			for ; lenBytes > 0; lenBytes-- {
+				length = length<<8 | int(sigBin[pos])
+				pos++
+			}
+		}
+		if length > len(sigBin)-pos {
+			return 0, 0, fmt.Errorf("bad signature, %s is too long", name)
+		}
+		start := pos
+		pos += length
+		return start, length, nil
+	}
+
+	rPos, rLength, err := readInteger("r")
+	if err != nil {
+		return nil, err
+	}
+	sPos, sLength, err := readInteger("s")
+	if err != nil {
+		return nil, err
+	}
+
+	// leading zeros are dropped and the rest read as unsigned
+	value := func(start, length int) *big.Int {
+		for length > 0 && sigBin[start] == 0 {
+			start++
+			length--
+		}
+		if length > 32 {
+			return nil
+		}
+		return new(big.Int).SetBytes(sigBin[start : start+length])
+	}
+
+	r, s := value(rPos, rLength), value(sPos, sLength)
+	if r == nil || s == nil {
+		return &Signature{R: new(big.Int), S: new(big.Int)}, nil
+	}
+	return &Signature{R: r, S: s}, nil
+}
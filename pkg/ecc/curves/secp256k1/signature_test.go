@@ -1 +1,48 @@
 package secp256k1
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseSignatureLax(t *testing.T) {
+	der := "3045022000eff69ef2b1bd93a66ed5219add4fb51e11a840f404876325a1e8ffe0529a2c022100c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab6"
+	raw, _ := hex.DecodeString(der)
+	strict, err := ParseSignature(raw)
+	if err != nil {
+		t.Fatalf("failed to parse the signature because %s", err.Error())
+	}
+
+	for _, lax := range []string{
+		der,
+		// long form sequence and integer lengths
+		"308145" + "028120" + der[8:],
+		// bytes after S and a wrong sequence length
+		"3010" + der[4:] + "0000",
+		// R padded with zeros
+		"3047022200" + "00" + der[8:],
+	} {
+		raw, _ := hex.DecodeString(lax)
+		sig, err := ParseSignatureLax(raw)
+		if err != nil {
+			t.Fatalf("failed to parse %s because %s", lax, err.Error())
+		}
+		if sig.R.Cmp(strict.R) != 0 || sig.S.Cmp(strict.S) != 0 {
+			t.Fatalf("unexpected values parsing %s", lax)
+		}
+	}
+
+	// values over 32 bytes parse as a zero signature
+	raw, _ = hex.DecodeString("3026022101" + "0000000000000000000000000000000000000000000000000000000000000000" + "020101")
+	sig, err := ParseSignatureLax(raw)
+	if err != nil || sig.R.Sign() != 0 || sig.S.Sign() != 0 {
+		t.Fatalf("expected a zero signature, got %v", err)
+	}
+
+	for _, bad := range []string{"", "31", "30", "3006", "300602", "3006020101", "30060201010301", "3006020101020201"} {
+		raw, _ := hex.DecodeString(bad)
+		if _, err := ParseSignatureLax(raw); err == nil {
+			t.Fatalf("parsed the bad signature %s", bad)
+		}
+	}
+}
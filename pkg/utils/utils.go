@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/big"
+	"math/bits"
 
 	"golang.org/x/crypto/ripemd160"
 )
@@ -104,8 +105,8 @@ func MutableReorderBytes(b []byte) []byte {
 
 func ImmutableReorderBytes(b []byte) []byte {
 	bb := make([]byte, len(b))
-	for i := 0; i < len(b)/2; i++ {
-		bb[i], bb[len(b)-i-1] = b[len(b)-i-1], b[i]
+	for i := range b {
+		bb[i] = b[len(b)-i-1]
 	}
 
 	return bb
@@ -593,8 +594,7 @@ func BitFieldToBytes(bits []byte) ([]byte, error) {
 	if len(bits)%8 != 0 {
 		return nil, fmt.Errorf("bitfield is not divisible by 8")
 	}
-	result := make([]byte, 4)
-	binary.LittleEndian.PutUint32(result, uint32(len(bits)/8))
+	result := make([]byte, len(bits)/8)
 
 	// iterate over the bits
 	for i, bit := range bits {
@@ -627,3 +627,61 @@ func divmod(numerator, denominator int) (quotient, remainder int) {
 	remainder = numerator % denominator
 	return
 }
+
+// one SipRound of the SipHash compression function
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
+
+// SipHash-2-4 of the data with the 128 bit key k0 || k1, where k0 and k1 are
+// the little endian halves of the 16 byte key. Used by the BIP158 compact
+// block filters and the BIP152 short transaction ids.
+func SipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	// compress each full 8 byte little endian word
+	length := len(data)
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+		data = data[8:]
+	}
+
+	// the last word holds the remaining bytes with the length in the top byte
+	m := uint64(length&0xff) << 56
+	for i, b := range data {
+		m |= uint64(b) << (8 * uint(i))
+	}
+	v3 ^= m
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= m
+
+	// finalization
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
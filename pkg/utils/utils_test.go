@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math/big"
@@ -55,6 +56,19 @@ func TestCovertLittleEndian(t *testing.T) {
 	fmt.Println(a)
 }
 
+func TestImmutableReorderBytes(t *testing.T) {
+	b := []byte{0x01, 0x02, 0x03}
+	if hex.EncodeToString(ImmutableReorderBytes(b)) != "030201" {
+		t.Errorf("unexpected reordering %x", ImmutableReorderBytes(b))
+	}
+	if hex.EncodeToString(b) != "010203" {
+		t.Error("the input was modified")
+	}
+	if hex.EncodeToString(ImmutableReorderBytes([]byte{0x01})) != "01" {
+		t.Error("single byte was not kept")
+	}
+}
+
 func TestConvertIntToLittleEndian(t *testing.T) {
 	val := big.NewInt(4022250974)
 	a := ConvertIntToLittleEndian(val)
@@ -194,3 +208,36 @@ func TestMerkleRoot(t *testing.T) {
 		t.Fatalf("byte arrays are not equal %s vs %s", target, root)
 	}
 }
+
+func TestSipHash24(t *testing.T) {
+	// test vectors from the SipHash reference implementation with the
+	// key 00 01 02 ... 0f and the messages 00 01 02 ... of increasing length
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+
+	message := make([]byte, 16)
+	for i := range message {
+		message[i] = byte(i)
+	}
+
+	tests := []struct {
+		length   int
+		expected uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+	}
+
+	for _, test := range tests {
+		result := SipHash24(k0, k1, message[:test.length])
+		if result != test.expected {
+			t.Fatalf("siphash of %d bytes is %x, expected %x", test.length, result, test.expected)
+		}
+	}
+}